@@ -0,0 +1,43 @@
+// Package aot translates modules ahead of time into standalone Go packages, which run trusted guests
+// natively without any interpreter.
+package aot
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/validator"
+)
+
+// ErrUnsupported marks modules using features not translated, such as references, GC, exceptions,
+// atomics, tail calls, memory64, imports other than functions, and multiple memories or tables.
+var ErrUnsupported = errors.New("unsupported")
+
+// Translate translates module into the source of a Go package named pkg, defining
+//   - Module, an instance of the module created by New, whose byte slice Memory is its linear memory
+//   - Imports, an interface of imported functions provided to New
+//   - Trap, the error of traps
+//
+// Exported functions become methods of Module named in Go style, taking and returning int32, int64,
+// float32 and float64, with an error reporting traps. Every other function becomes an unexported
+// method, and local variables stand for operands.
+func Translate(module *wavm.Module, pkg string) ([]byte, error) {
+	heights, err := validator.StackHeights(*module)
+	if err != nil {
+		return nil, fmt.Errorf("invalid module: %w", err)
+	}
+
+	t := &translator{module: module, heights: heights}
+	if err := t.translate(pkg); err != nil {
+		return nil, err
+	}
+
+	out, err := format.Source(t.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format source: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,502 @@
+package aot
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// translator translates a module into the source of a Go package.
+type translator struct {
+	module  *wavm.Module
+	heights [][]int // of codes in the order of tools.WalkInstrs, as recorded by the validator
+	buf     bytes.Buffer
+
+	funcTypes []types.FuncType // by indices of functions, imported ones first
+	imports   []importFunc
+}
+
+// importFunc is an imported function, which is the method of Imports named method.
+type importFunc struct {
+	types.Import
+	method string
+}
+
+// constOperand is an operand of constant expressions, which is v unless it's expr of globals.
+type constOperand struct {
+	v    uint64
+	expr string
+}
+
+func (v constOperand) String() string {
+	if v.expr != "" {
+		return v.expr
+	}
+	return fmt.Sprintf("0x%X", v.v)
+}
+
+func (t *translator) translate(pkg string) error {
+	if err := t.checkModule(); err != nil {
+		return err
+	}
+
+	t.printf("// Code generated by wavm aot. DO NOT EDIT.\n\n")
+	t.printf("package %s\n\n", pkg)
+	t.printf("import (\n\"encoding/binary\"\n\"math\"\n\"math/bits\"\n\"runtime\"\n)\n\n")
+
+	maxPages := uint64(math.MaxUint32+1) / 65536
+	if len(t.module.Memories) > 0 && t.module.Memories[0].HasMax() {
+		maxPages = t.module.Memories[0].Max
+	}
+	t.printf("// maxPages limits the linear memory.\nconst maxPages = %d\n\n", maxPages)
+
+	t.translateImports()
+	t.translateModule()
+	if err := t.translateInit(); err != nil {
+		return err
+	}
+	t.translateExports()
+
+	for i, v := range t.imports {
+		t.translateImportFunc(i, v)
+	}
+	for i, v := range t.module.Codes {
+		if err := t.translateFunc(len(t.imports)+i, v, t.heights[i]); err != nil {
+			return fmt.Errorf("translate %s: %w",
+				t.module.Names.DescribeFunc(uint32(len(t.imports)+i)), err)
+		}
+	}
+
+	t.buf.WriteString(runtimeSource)
+	return nil
+}
+
+// checkModule checks the module uses no unsupported features except in code, and collects types
+// of functions.
+func (t *translator) checkModule() error {
+	methods := make(map[string]bool)
+	for _, v := range t.module.Imports {
+		if v.Description.Tag != types.PortTagFunc {
+			return fmt.Errorf("%w: import %s.%s other than functions", ErrUnsupported, v.Module, v.Name)
+		}
+
+		method := uniqueName(goName(v.Module+"_"+v.Name), methods)
+		t.imports = append(t.imports, importFunc{Import: v, method: method})
+		t.funcTypes = append(t.funcTypes, t.module.Types[v.Description.Func])
+	}
+	for _, v := range t.module.Functions {
+		t.funcTypes = append(t.funcTypes, t.module.Types[v])
+	}
+	for i, v := range t.funcTypes {
+		if !numeric(v.ParamTypes) || !numeric(v.ResultTypes) {
+			return fmt.Errorf("%w: references taken or returned by %s", ErrUnsupported,
+				t.module.Names.DescribeFunc(uint32(i)))
+		}
+	}
+
+	if len(t.module.Memories) > 1 {
+		return fmt.Errorf("%w: multiple memories", ErrUnsupported)
+	} else if len(t.module.Memories) == 1 && t.module.Memories[0].Is64() {
+		return fmt.Errorf("%w: memory64", ErrUnsupported)
+	}
+
+	if len(t.module.Tables) > 1 {
+		return fmt.Errorf("%w: multiple tables", ErrUnsupported)
+	} else if len(t.module.Tables) == 1 && t.module.Tables[0].ElementType != types.FuncRef {
+		return fmt.Errorf("%w: tables of elements other than funcref", ErrUnsupported)
+	}
+
+	for i, v := range t.module.Globals {
+		if !numeric([]types.ValueType{v.Type.ValueType}) {
+			return fmt.Errorf("%w: reference in global[%d]", ErrUnsupported, i)
+		}
+	}
+
+	return nil
+}
+
+func (t *translator) translateImports() {
+	t.printf("// Imports are functions imported by the module, whose errors trap the guest.\n")
+	t.printf("type Imports interface {\n")
+	for _, v := range t.imports {
+		ft := t.module.Types[v.Description.Func]
+		t.printf("// %s is the function %s.%s.\n", v.method, v.Module, v.Name)
+		t.printf("%s(%s) (%s)\n", v.method, goParams(ft.ParamTypes, "", false),
+			goParams(ft.ResultTypes, "", true))
+	}
+	t.printf("}\n\n")
+}
+
+func (t *translator) translateModule() {
+	t.printf(`// Module is an instance of the module, which isn't safe for concurrent use.
+type Module struct {
+	// Memory is the linear memory, which grows by pages of 64KiB.
+	Memory []byte
+
+	imports Imports
+	table   []element
+	data    [][]byte // passive data segments, until dropped
+	depth   int      // of nested calls
+`)
+	for i := range t.module.Globals {
+		t.printf("g%d uint64\n", i)
+	}
+	t.printf("}\n\n")
+}
+
+// translateInit translates the instantiation of the module, initializing its globals, memory and
+// table, then calling the start function.
+func (t *translator) translateInit() error {
+	t.printf(`// New instantiates the module with imports, and calls its start function if any.
+func New(imports Imports) (*Module, error) {
+	m := &Module{imports: imports}
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Module) init() (err error) {
+	defer m.guard(m.depth, &err)
+
+`)
+
+	for i, v := range t.module.Globals {
+		init, err := constExpr(v.Init)
+		if err != nil {
+			return fmt.Errorf("translate init of global[%d]: %w", i, err)
+		}
+		t.printf("m.g%d = %s\n", i, init)
+	}
+
+	if len(t.module.Memories) > 0 {
+		t.printf("m.Memory = make([]byte, %d*pageSize)\n", t.module.Memories[0].Min)
+	}
+	if len(t.module.Tables) > 0 {
+		t.printf("m.table = make([]element, %d)\n", t.module.Tables[0].Limits.Min)
+	}
+
+	for i, v := range t.module.Elements {
+		if len(v.Offset) == 0 {
+			continue
+		}
+		offset, err := constExpr(v.Offset)
+		if err != nil {
+			return fmt.Errorf("translate offset of elem[%d]: %w", i, err)
+		}
+
+		elems := make([]string, len(v.Init))
+		for j, f := range v.Init {
+			elems[j] = fmt.Sprintf("{%d, m.f%d}", t.typeIdx(t.funcTypes[f]), f)
+		}
+		t.printf("copy(m.table[uint32(%s):][:%d], []element{%s})\n", offset, len(elems),
+			strings.Join(elems, ", "))
+	}
+
+	if len(t.module.Data) > 0 {
+		t.printf("m.data = make([][]byte, %d)\n", len(t.module.Data))
+	}
+	for i, v := range t.module.Data {
+		if v.Passive {
+			t.printf("m.data[%d] = []byte(%q)\n", i, v.Init)
+			continue
+		}
+
+		offset, err := constExpr(v.Offset)
+		if err != nil {
+			return fmt.Errorf("translate offset of data[%d]: %w", i, err)
+		}
+		t.printf("copy(m.Memory[uint32(%s):][:%d], %q)\n", offset, len(v.Init), v.Init)
+	}
+
+	if t.module.Start != nil {
+		t.printf("m.f%d()\n", *t.module.Start)
+	}
+	t.printf("return nil\n}\n\n")
+	return nil
+}
+
+// translateExports translates exported functions into methods named in Go style.
+func (t *translator) translateExports() {
+	// fields and methods share names
+	methods := map[string]bool{"Memory": true}
+	for _, v := range t.module.Exports {
+		if v.Description.Tag != types.PortTagFunc {
+			continue
+		}
+		ft := t.funcTypes[v.Description.Idx]
+		method := uniqueName(goName(v.Name), methods)
+
+		results := goParams(ft.ResultTypes, "r", true)
+		if len(ft.ResultTypes) == 0 {
+			results = "err error"
+		}
+		t.printf("// %s calls the exported function %q.\n", method, v.Name)
+		t.printf("func (m *Module) %s(%s) (%s) {\n", method, goParams(ft.ParamTypes, "p", false),
+			results)
+		t.printf("defer m.guard(m.depth, &err)\n\n")
+
+		args := make([]string, len(ft.ParamTypes))
+		for i, p := range ft.ParamTypes {
+			args[i] = toSlot(p, fmt.Sprintf("p%d", i))
+		}
+		call := fmt.Sprintf("m.f%d(%s)", v.Description.Idx, strings.Join(args, ", "))
+		if len(ft.ResultTypes) == 0 {
+			t.printf("%s\nreturn nil\n}\n\n", call)
+			continue
+		}
+
+		vals, out := make([]string, len(ft.ResultTypes)), make([]string, len(ft.ResultTypes))
+		for i, r := range ft.ResultTypes {
+			vals[i], out[i] = fmt.Sprintf("v%d", i), fromSlot(r, fmt.Sprintf("v%d", i))
+		}
+		t.printf("%s := %s\n", strings.Join(vals, ", "), call)
+		t.printf("return %s, nil\n}\n\n", strings.Join(out, ", "))
+	}
+}
+
+// translateImportFunc translates the idx-th function, which is imported as v, into a method calling
+// imports.
+func (t *translator) translateImportFunc(idx int, v importFunc) {
+	ft := t.funcTypes[idx]
+
+	params, args := make([]string, len(ft.ParamTypes)), make([]string, len(ft.ParamTypes))
+	for i, p := range ft.ParamTypes {
+		params[i], args[i] = fmt.Sprintf("l%d", i), fromSlot(p, fmt.Sprintf("l%d", i))
+	}
+	t.printf("func (m *Module) f%d(%s) (%s) {\n", idx, typedList(params), slotList(len(ft.ResultTypes)))
+
+	call := fmt.Sprintf("m.imports.%s(%s)", v.method, strings.Join(args, ", "))
+	reason := fmt.Sprintf("%q + err.Error()", v.Module+"."+v.Name+": ")
+	if len(ft.ResultTypes) == 0 {
+		t.printf("if err := %s; err != nil {\n", call)
+		t.printf("panic(&Trap{Reason: %s, Err: err})\n}\n}\n\n", reason)
+		return
+	}
+
+	vals, out := make([]string, len(ft.ResultTypes)), make([]string, len(ft.ResultTypes))
+	for i, r := range ft.ResultTypes {
+		vals[i], out[i] = fmt.Sprintf("r%d", i), toSlot(r, fmt.Sprintf("r%d", i))
+	}
+	t.printf("%s, err := %s\n", strings.Join(vals, ", "), call)
+	t.printf("if err != nil {\npanic(&Trap{Reason: %s, Err: err})\n}\n", reason)
+	t.printf("return %s\n}\n\n", strings.Join(out, ", "))
+}
+
+func (t *translator) printf(format string, a ...interface{}) {
+	fmt.Fprintf(&t.buf, format, a...)
+}
+
+// typeIdx returns the index of the 1st function type equivalent to ft, which identifies funcs in
+// the table.
+func (t *translator) typeIdx(ft types.FuncType) int {
+	for i, v := range t.module.Types {
+		if types.EqualValueTypes(v.ParamTypes, ft.ParamTypes) &&
+			types.EqualValueTypes(v.ResultTypes, ft.ResultTypes) {
+			return i
+		}
+	}
+	return -1
+}
+
+// constExpr translates a constant expression into an expression of uint64, where the extended
+// arithmetic over constants is folded, since constants overflowing don't compile in Go.
+func constExpr(expr types.Expr) (string, error) {
+	var stack []constOperand
+	for i, v := range expr {
+		switch v.Opcode {
+		case types.OpcodeI32Const:
+			stack = append(stack, constOperand{v: uint64(uint32(v.Args.(int32)))})
+		case types.OpcodeI64Const:
+			stack = append(stack, constOperand{v: uint64(v.Args.(int64))})
+		case types.OpcodeF32Const:
+			stack = append(stack, constOperand{v: uint64(math.Float32bits(v.Args.(float32)))})
+		case types.OpcodeF64Const:
+			stack = append(stack, constOperand{v: math.Float64bits(v.Args.(float64))})
+		case types.OpcodeGlobalGet:
+			stack = append(stack, constOperand{expr: fmt.Sprintf("m.g%d", v.Args.(uint32))})
+		case types.OpcodeI32Add, types.OpcodeI32Sub, types.OpcodeI32Mul, types.OpcodeI64Add,
+			types.OpcodeI64Sub, types.OpcodeI64Mul:
+			n := len(stack)
+			if n < 2 {
+				return "", fmt.Errorf("%d-th instruction misses operands", i)
+			}
+			stack = append(stack[:n-2], constBinaryOp(v.Opcode, stack[n-2], stack[n-1]))
+		default:
+			return "", fmt.Errorf("%w: constant %s", ErrUnsupported, v.GetOpname())
+		}
+	}
+
+	if len(stack) != 1 {
+		return "", fmt.Errorf("expect 1 value, got %d", len(stack))
+	}
+	return stack[0].String(), nil
+}
+
+// constBinaryOp translates the arithmetic instruction of opcode over a and b.
+func constBinaryOp(opcode byte, a, b constOperand) constOperand {
+	if a.expr == "" && b.expr == "" {
+		return constOperand{v: foldConstBinaryOp(opcode, a.v, b.v)}
+	}
+
+	var op string
+	switch opcode {
+	case types.OpcodeI32Add, types.OpcodeI64Add:
+		op = "+"
+	case types.OpcodeI32Sub, types.OpcodeI64Sub:
+		op = "-"
+	default:
+		op = "*"
+	}
+
+	switch opcode {
+	case types.OpcodeI32Add, types.OpcodeI32Sub, types.OpcodeI32Mul:
+		return constOperand{expr: fmt.Sprintf("uint64(uint32(%s) %s uint32(%s))", a, op, b)}
+	default:
+	}
+	return constOperand{expr: fmt.Sprintf("(%s %s %s)", a, op, b)}
+}
+
+// foldConstBinaryOp evaluates the arithmetic instruction of opcode over constants.
+func foldConstBinaryOp(opcode byte, a, b uint64) uint64 {
+	switch opcode {
+	case types.OpcodeI32Add:
+		return uint64(uint32(a) + uint32(b))
+	case types.OpcodeI32Sub:
+		return uint64(uint32(a) - uint32(b))
+	case types.OpcodeI32Mul:
+		return uint64(uint32(a) * uint32(b))
+	case types.OpcodeI64Add:
+		return a + b
+	case types.OpcodeI64Sub:
+		return a - b
+	default:
+	}
+
+	return a * b
+}
+
+// goName converts name into an exported Go identifier in camel case.
+func goName(name string) string {
+	var out strings.Builder
+	upper := true
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z':
+			if upper {
+				c -= 'a' - 'A'
+			}
+		case c >= 'A' && c <= 'Z' || c >= '0' && c <= '9':
+		default:
+			upper = true
+			continue
+		}
+
+		out.WriteRune(c)
+		upper = false
+	}
+
+	if s := out.String(); s != "" && (s[0] < '0' || s[0] > '9') {
+		return s
+	}
+	return "X" + out.String()
+}
+
+// uniqueName returns name suffixed if it's in names already, and adds it into names.
+func uniqueName(name string, names map[string]bool) string {
+	out := name
+	for i := 2; names[out]; i++ {
+		out = fmt.Sprintf("%s%d", name, i)
+	}
+
+	names[out] = true
+	return out
+}
+
+func numeric(vts []types.ValueType) bool {
+	for _, v := range vts {
+		switch v {
+		case types.ValueTypeI32, types.ValueTypeI64, types.ValueTypeF32, types.ValueTypeF64:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// goType returns the Go type of values of the numeric type vt.
+func goType(vt types.ValueType) string {
+	switch vt {
+	case types.ValueTypeI32:
+		return "int32"
+	case types.ValueTypeI64:
+		return "int64"
+	case types.ValueTypeF32:
+		return "float32"
+	}
+	return "float64"
+}
+
+// goParams lists values typed vts, named by prefix if not empty, and suffixed by an error named err
+// too if withErr.
+func goParams(vts []types.ValueType, prefix string, withErr bool) string {
+	out := make([]string, 0, len(vts)+1)
+	for i, v := range vts {
+		if prefix == "" {
+			out = append(out, goType(v))
+		} else {
+			out = append(out, fmt.Sprintf("%s%d %s", prefix, i, goType(v)))
+		}
+	}
+
+	if withErr && prefix == "" {
+		out = append(out, "error")
+	} else if withErr {
+		out = append(out, "err error")
+	}
+	return strings.Join(out, ", ")
+}
+
+// toSlot converts v of the Go type of vt into uint64.
+func toSlot(vt types.ValueType, v string) string {
+	switch vt {
+	case types.ValueTypeI32:
+		return fmt.Sprintf("uint64(uint32(%s))", v)
+	case types.ValueTypeI64:
+		return fmt.Sprintf("uint64(%s)", v)
+	case types.ValueTypeF32:
+		return fmt.Sprintf("fromF32(%s)", v)
+	}
+	return fmt.Sprintf("fromF64(%s)", v)
+}
+
+// fromSlot converts v of uint64 into the Go type of vt.
+func fromSlot(vt types.ValueType, v string) string {
+	switch vt {
+	case types.ValueTypeI32:
+		return fmt.Sprintf("int32(%s)", v)
+	case types.ValueTypeI64:
+		return fmt.Sprintf("int64(%s)", v)
+	case types.ValueTypeF32:
+		return fmt.Sprintf("f32(%s)", v)
+	}
+	return fmt.Sprintf("f64(%s)", v)
+}
+
+// typedList declares names as uint64.
+func typedList(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.Join(names, ", ") + " uint64"
+}
+
+// slotList lists n uint64.
+func slotList(n int) string {
+	return strings.TrimSuffix(strings.Repeat("uint64, ", n), ", ")
+}
@@ -0,0 +1,305 @@
+package aot_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/aot"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/linker/native"
+	"github.com/sammyne/mastering-wasm/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+type aotTestCall struct {
+	export string
+	args   []int32
+}
+
+// TestTranslate builds translations of modules into a program running calls to their exports,
+// whose output must match the tree engine running the same calls.
+func TestTranslate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip building translations in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go tool to build translations")
+	}
+
+	testVector := []struct {
+		pkg    string
+		module *wavm.Module
+		calls  []aotTestCall
+	}{
+		{"block", decodeTestdata(t, "chapter03-block.wasm"), nil},
+		{"hello", decodeTestdata(t, "hello-world.wasm"), []aotTestCall{{"main", nil}}},
+		{"reenter", decodeReenterTestModule(t), []aotTestCall{
+			{"outer", []int32{5}},
+			{"outer", []int32{0}},
+			{"outer", []int32{7}},
+			{"wide", nil},
+		}},
+		{"mem", decodeMemoryTestModule(t), []aotTestCall{
+			{"load", []int32{16}},
+			{"store", []int32{8, 0x12345678}},
+			{"load", []int32{8}},
+			{"load", []int32{10}},
+			{"load", []int32{65533}},
+			{"store", []int32{65536, 1}},
+			{"div", []int32{7, -2}},
+			{"div", []int32{1, 0}},
+			{"div", []int32{-1 << 31, -1}},
+			{"size", nil},
+			{"grow", []int32{1}},
+			{"store", []int32{65536, 1}},
+			{"load", []int32{65536}},
+			{"grow", []int32{1}},
+			{"size", nil},
+		}},
+	}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), []byte("module aottest\n\ngo 1.17\n"))
+
+	var expect bytes.Buffer
+	main := []string{"package main\n\nimport (\n\t\"fmt\"\n"}
+	var calls []string
+	for i, c := range testVector {
+		// offsets of instructions matter not, which modules built in Go leave 0
+		for _, v := range c.module.Codes {
+			tools.WalkInstrs(v.Expr, func(instr *types.Instruction) { instr.Offset = 0 })
+		}
+
+		src, err := aot.Translate(c.module, c.pkg)
+		if err != nil {
+			t.Fatalf("%s: translate: %v", c.pkg, err)
+		}
+		writeFile(t, filepath.Join(dir, c.pkg, c.pkg+".go"), src)
+
+		main = append(main, fmt.Sprintf("\t%q\n", "aottest/"+c.pkg))
+		instance, assign := fmt.Sprintf("m%d", i), ":="
+		if len(c.calls) == 0 {
+			instance, assign = "_", "="
+		}
+		calls = append(calls, fmt.Sprintf("\t%s, err %s %s.New(env{})\n\tcheck(err)\n", instance,
+			assign, c.pkg))
+		if c.pkg == "reenter" {
+			// calls back inner, and checks no depth of calls is left once outer returns
+			writeFile(t, filepath.Join(dir, c.pkg, "depth.go"),
+				[]byte("package reenter\n\nfunc (m *Module) Depth() int {\n\treturn m.depth\n}\n"))
+			calls = append(calls, fmt.Sprintf("\tinner = %s.Inner\n", instance))
+		}
+		for _, v := range c.calls {
+			args := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(v.args)), ", "), "[]")
+			calls = append(calls, fmt.Sprintf("\tshow(%s.%s(%s))\n", instance,
+				strings.Title(v.export), args))
+		}
+
+		runTree(t, &expect, c.module, c.calls)
+		if c.pkg == "reenter" {
+			calls = append(calls, fmt.Sprintf("\tfmt.Println(%s.Depth())\n", instance))
+			expect.WriteString("0\n")
+		}
+	}
+
+	main = append(main, ")\n\n", aotTestMain, "\nfunc main() {\n\tvar err error\n")
+	main = append(append(main, calls...), "}\n")
+	writeFile(t, filepath.Join(dir, "main.go"), []byte(strings.Join(main, "")))
+
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	got, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run translations: %v\n%s", err, got)
+	}
+	if string(got) != expect.String() {
+		t.Fatalf("expect output\n%s\ngot\n%s", expect.String(), got)
+	}
+}
+
+// aotTestMain is the runtime of programs running translations, whose env writes to stdout, and
+// results of calls are shown one per line, or as "trap". Its env calls inner back, which is -1 if it
+// traps.
+const aotTestMain = `type env struct{}
+
+// inner is called back by EnvReenter.
+var inner func(int32) (int32, error)
+
+func (env) EnvPrintChar(c int32) error {
+	fmt.Printf("%c", c)
+	return nil
+}
+
+func (env) EnvReenter(n int32) (int32, error) {
+	if v, err := inner(n); err == nil {
+		return v, nil
+	}
+	return -1, nil
+}
+
+func check(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func show(v ...interface{}) {
+	if v[len(v)-1] != nil {
+		fmt.Println("trap")
+	} else if len(v) == 1 {
+		fmt.Println("ok")
+	} else {
+		fmt.Println(v[0])
+	}
+}
+`
+
+// runTree runs calls on the tree engine, showing their results into out as aotTestMain does.
+func runTree(t *testing.T, out *bytes.Buffer, module *wavm.Module, calls []aotTestCall) {
+	var m linker.Module
+	env := native.NewModule()
+	env.RegisterFunc("print_char(i32)->()", func(args []types.WasmVal) ([]types.WasmVal, error) {
+		fmt.Fprintf(out, "%c", args[0].(int32))
+		return nil, nil
+	})
+	env.RegisterFunc("reenter(i32)->(i32)", func(args []types.WasmVal) ([]types.WasmVal, error) {
+		if results, err := m.InvokeFunc("inner", args...); err == nil {
+			return results, nil
+		}
+		return []types.WasmVal{int32(-1)}, nil
+	})
+
+	m, err := vm.NewVM(module, map[string]linker.Module{"env": env})
+	if err != nil {
+		t.Fatalf("new VM: %v", err)
+	}
+
+	for _, c := range calls {
+		var args []types.WasmVal
+		for _, v := range c.args {
+			args = append(args, v)
+		}
+
+		results, err := m.InvokeFunc(c.export, args...)
+		switch {
+		case err != nil:
+			fmt.Fprintln(out, "trap")
+		case len(results) == 0:
+			fmt.Fprintln(out, "ok")
+		default:
+			fmt.Fprintln(out, results[0])
+		}
+	}
+}
+
+func decodeTestdata(t *testing.T, name string) *wavm.Module {
+	out, err := wavm.DecodeModuleFromFile(filepath.Join("..", "cmd", "wavm", "testdata", name))
+	if err != nil {
+		t.Fatalf("decode %s: %v", name, err)
+	}
+	return out
+}
+
+// decodeMemoryTestModule decodes a module exporting funcs on a memory of 1 page growing up to 2,
+// which holds "wasm" at 16.
+func decodeMemoryTestModule(t *testing.T) *wavm.Module {
+	const i32 = 0x7F
+	funcs := []struct {
+		name string
+		typ  byte
+		body []byte
+	}{
+		{"store", 0, []byte{0x20, 0, 0x20, 1, 0x36, 2, 0}},
+		{"load", 1, []byte{0x20, 0, 0x28, 2, 0}},
+		{"div", 2, []byte{0x20, 0, 0x20, 1, 0x6D}},
+		{"grow", 1, []byte{0x20, 0, 0x40, 0}},
+		{"size", 3, []byte{0x3F, 0}},
+	}
+
+	var funcSec, exportSec, codeSec [][]byte
+	for i, v := range funcs {
+		funcSec = append(funcSec, []byte{v.typ})
+		exportSec = append(exportSec, wasmtest.Export(v.name, 0, uint32(i)))
+		codeSec = append(codeSec, wasmtest.Code(nil, v.body...))
+	}
+
+	buf := wasmtest.Module(
+		wasmtest.Section(1, wasmtest.Vec(
+			wasmtest.FuncType([]byte{i32, i32}, nil),
+			wasmtest.FuncType([]byte{i32}, []byte{i32}),
+			wasmtest.FuncType([]byte{i32, i32}, []byte{i32}),
+			wasmtest.FuncType(nil, []byte{i32}))),
+		wasmtest.Section(3, wasmtest.Vec(funcSec...)),
+		wasmtest.Section(5, wasmtest.Vec([]byte{0x01, 1, 2})),
+		wasmtest.Section(7, wasmtest.Vec(exportSec...)),
+		wasmtest.Section(10, wasmtest.Vec(codeSec...)),
+		wasmtest.Section(11, wasmtest.Vec(
+			append([]byte{0x00, 0x41, 16, 0x0B}, wasmtest.Name("wasm")...))),
+	)
+
+	out, err := wavm.NewDecoder(buf).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode memory test module: %v", err)
+	}
+	return out
+}
+
+// decodeReenterTestModule decodes a module whose outer func adds global 1 to what the imported
+// env.reenter returns, which calls back its inner func trapping on 0. Globals are initialized by
+// extended constant expressions overflowing.
+func decodeReenterTestModule(t *testing.T) *wavm.Module {
+	const i32, i64 = 0x7F, 0x7E
+
+	maxI32 := wasmtest.Sleb(0x7FFFFFFF)
+	global := func(t byte, expr ...[]byte) []byte {
+		return wasmtest.Concat([]byte{t, 0}, wasmtest.Concat(expr...), []byte{0x0B})
+	}
+
+	buf := wasmtest.Module(
+		wasmtest.Section(1, wasmtest.Vec(wasmtest.FuncType([]byte{i32}, []byte{i32}),
+			wasmtest.FuncType(nil, []byte{i64}))),
+		wasmtest.Section(2, wasmtest.Vec(wasmtest.Concat(wasmtest.Name("env"),
+			wasmtest.Name("reenter"), []byte{0, 0}))),
+		wasmtest.Section(3, wasmtest.Vec([]byte{0}, []byte{0}, []byte{1})),
+		wasmtest.Section(6, wasmtest.Vec(
+			// 0x7FFFFFFF
+			global(i32, []byte{0x41}, maxI32),
+			// global 0*2 + (0x7FFFFFFF+3)
+			global(i32, []byte{0x23, 0, 0x41, 2, 0x6C, 0x41}, maxI32, []byte{0x41, 3, 0x6A, 0x6A}),
+			// -1*2
+			global(i64, []byte{0x42}, wasmtest.Sleb(-1), []byte{0x42, 2, 0x7E}),
+			global(i64, []byte{0x42, 5}),
+			// global 3*(-1)
+			global(i64, []byte{0x23, 3, 0x42}, wasmtest.Sleb(-1), []byte{0x7E}))),
+		wasmtest.Section(7, wasmtest.Vec(wasmtest.Export("outer", 0, 1),
+			wasmtest.Export("inner", 0, 2), wasmtest.Export("wide", 0, 3))),
+		wasmtest.Section(10, wasmtest.Vec(
+			wasmtest.Code(nil, 0x20, 0, 0x10, 0, 0x23, 1, 0x6A),
+			wasmtest.Code(nil, 0x20, 0, 0x45, 0x04, 0x40, 0x00, 0x0B, 0x20, 0),
+			wasmtest.Code(nil, 0x23, 2, 0x23, 4, 0x7C))),
+	)
+
+	out, err := wavm.NewDecoder(buf).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode reenter test module: %v", err)
+	}
+	return out
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("make dir of %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,565 @@
+package aot
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/sammyne/mastering-wasm/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// funcTranslator translates the code of a function into a method, where l0, l1... are locals and
+// s0, s1... are operands by their heights.
+type funcTranslator struct {
+	t       *translator
+	heights map[*types.Instruction]int // as recorded by the validator
+	buf     *bytes.Buffer              // of the block being translated
+	labels  []label                    // innermost last
+	nLabels int
+
+	// reachable tells if the next instruction is reachable, past which the block is skipped
+	reachable bool
+	// terminating tells if the last statement is terminating in Go, which checks function ends
+	terminating bool
+	reads, sets map[string]bool
+	nOperands   int // max height of operands used
+}
+
+// label is a label of a block, which is that of a Go for statement named name if used.
+type label struct {
+	name          string
+	loop          bool
+	fn            bool // the function body, branching to which returns
+	height, arity int  // of operands kept on branching
+	used          bool
+}
+
+// unaryOps maps instructions replacing the top operand to their expressions of it.
+var unaryOps = map[byte]string{
+	types.OpcodeI32Eqz:            "fromBool(uint32(%s) == 0)",
+	types.OpcodeI64Eqz:            "fromBool(%s == 0)",
+	types.OpcodeI32Clz:            "clz32(%s)",
+	types.OpcodeI32Ctz:            "ctz32(%s)",
+	types.OpcodeI32PopCnt:         "popcnt32(%s)",
+	types.OpcodeI64Clz:            "clz64(%s)",
+	types.OpcodeI64Ctz:            "ctz64(%s)",
+	types.OpcodeI64PopCnt:         "popcnt64(%s)",
+	types.OpcodeF32Abs:            "%s &^ 0x80000000",
+	types.OpcodeF32Neg:            "%s ^ 0x80000000",
+	types.OpcodeF32Ceil:           "unaryF32(%s, math.Ceil)",
+	types.OpcodeF32Floor:          "unaryF32(%s, math.Floor)",
+	types.OpcodeF32Trunc:          "unaryF32(%s, math.Trunc)",
+	types.OpcodeF32Nearest:        "unaryF32(%s, math.RoundToEven)",
+	types.OpcodeF32Sqrt:           "unaryF32(%s, math.Sqrt)",
+	types.OpcodeF64Abs:            "%s &^ (1 << 63)",
+	types.OpcodeF64Neg:            "%s ^ (1 << 63)",
+	types.OpcodeF64Ceil:           "fromF64(math.Ceil(f64(%s)))",
+	types.OpcodeF64Floor:          "fromF64(math.Floor(f64(%s)))",
+	types.OpcodeF64Trunc:          "fromF64(math.Trunc(f64(%s)))",
+	types.OpcodeF64Nearest:        "fromF64(math.RoundToEven(f64(%s)))",
+	types.OpcodeF64Sqrt:           "fromF64(math.Sqrt(f64(%s)))",
+	types.OpcodeI32WrapI64:        "uint64(uint32(%s))",
+	types.OpcodeI32TruncF32S:      "truncS32(float64(f32(%s)))",
+	types.OpcodeI32TruncF32U:      "truncU32(float64(f32(%s)))",
+	types.OpcodeI32TruncF64S:      "truncS32(f64(%s))",
+	types.OpcodeI32TruncF64U:      "truncU32(f64(%s))",
+	types.OpcodeI64ExtendI32S:     "uint64(int32(%s))",
+	types.OpcodeI64ExtendI32U:     "uint64(uint32(%s))",
+	types.OpcodeI64TruncF32S:      "truncS64(float64(f32(%s)))",
+	types.OpcodeI64TruncF32U:      "truncU64(float64(f32(%s)))",
+	types.OpcodeI64TruncF64S:      "truncS64(f64(%s))",
+	types.OpcodeI64TruncF64U:      "truncU64(f64(%s))",
+	types.OpcodeF32ConvertI32S:    "fromF32(float32(int32(%s)))",
+	types.OpcodeF32ConvertI32U:    "fromF32(float32(uint32(%s)))",
+	types.OpcodeF32ConvertI64S:    "fromF32(float32(int64(%s)))",
+	types.OpcodeF32ConvertI64U:    "fromF32(float32(%s))",
+	types.OpcodeF32DemoteF64:      "fromF32(float32(f64(%s)))",
+	types.OpcodeF64ConvertI32S:    "fromF64(float64(int32(%s)))",
+	types.OpcodeF64ConvertI32U:    "fromF64(float64(uint32(%s)))",
+	types.OpcodeF64ConvertI64S:    "fromF64(float64(int64(%s)))",
+	types.OpcodeF64ConvertI64U:    "fromF64(float64(%s))",
+	types.OpcodeF64PromoteF32:     "fromF64(float64(f32(%s)))",
+	types.OpcodeI32ReinterpretF32: "%s",
+	types.OpcodeI64ReinterpretF64: "%s",
+	types.OpcodeF32ReinterpretI32: "%s",
+	types.OpcodeF64ReinterpretI64: "%s",
+	types.OpcodeI32Extend8S:       "uint64(uint32(int8(%s)))",
+	types.OpcodeI32Extend16S:      "uint64(uint32(int16(%s)))",
+	types.OpcodeI64Extend8S:       "uint64(int8(%s))",
+	types.OpcodeI64Extend16S:      "uint64(int16(%s))",
+	types.OpcodeI64Extend32S:      "uint64(int32(%s))",
+}
+
+// truncSatOps maps sub-opcodes of saturating truncation to their expressions of the operand.
+var truncSatOps = []string{
+	"satS32(float64(f32(%s)))",
+	"satU32(float64(f32(%s)))",
+	"satS32(f64(%s))",
+	"satU32(f64(%s))",
+	"satS64(float64(f32(%s)))",
+	"satU64(float64(f32(%s)))",
+	"satS64(f64(%s))",
+	"satU64(f64(%s))",
+}
+
+// binaryOps maps instructions replacing the top 2 operands to their expressions of them.
+var binaryOps = map[byte]string{
+	types.OpcodeI32Eq:       "fromBool(uint32(%[1]s) == uint32(%[2]s))",
+	types.OpcodeI32Ne:       "fromBool(uint32(%[1]s) != uint32(%[2]s))",
+	types.OpcodeI32LtS:      "fromBool(int32(%[1]s) < int32(%[2]s))",
+	types.OpcodeI32LtU:      "fromBool(uint32(%[1]s) < uint32(%[2]s))",
+	types.OpcodeI32GtS:      "fromBool(int32(%[1]s) > int32(%[2]s))",
+	types.OpcodeI32GtU:      "fromBool(uint32(%[1]s) > uint32(%[2]s))",
+	types.OpcodeI32LeS:      "fromBool(int32(%[1]s) <= int32(%[2]s))",
+	types.OpcodeI32LeU:      "fromBool(uint32(%[1]s) <= uint32(%[2]s))",
+	types.OpcodeI32GeS:      "fromBool(int32(%[1]s) >= int32(%[2]s))",
+	types.OpcodeI32GeU:      "fromBool(uint32(%[1]s) >= uint32(%[2]s))",
+	types.OpcodeI64Eq:       "fromBool(%[1]s == %[2]s)",
+	types.OpcodeI64Ne:       "fromBool(%[1]s != %[2]s)",
+	types.OpcodeI64LtS:      "fromBool(int64(%[1]s) < int64(%[2]s))",
+	types.OpcodeI64LtU:      "fromBool(%[1]s < %[2]s)",
+	types.OpcodeI64GtS:      "fromBool(int64(%[1]s) > int64(%[2]s))",
+	types.OpcodeI64GtU:      "fromBool(%[1]s > %[2]s)",
+	types.OpcodeI64LeS:      "fromBool(int64(%[1]s) <= int64(%[2]s))",
+	types.OpcodeI64LeU:      "fromBool(%[1]s <= %[2]s)",
+	types.OpcodeI64GeS:      "fromBool(int64(%[1]s) >= int64(%[2]s))",
+	types.OpcodeI64GeU:      "fromBool(%[1]s >= %[2]s)",
+	types.OpcodeF32Eq:       "fromBool(f32(%[1]s) == f32(%[2]s))",
+	types.OpcodeF32Ne:       "fromBool(f32(%[1]s) != f32(%[2]s))",
+	types.OpcodeF32Lt:       "fromBool(f32(%[1]s) < f32(%[2]s))",
+	types.OpcodeF32Gt:       "fromBool(f32(%[1]s) > f32(%[2]s))",
+	types.OpcodeF32Le:       "fromBool(f32(%[1]s) <= f32(%[2]s))",
+	types.OpcodeF32Ge:       "fromBool(f32(%[1]s) >= f32(%[2]s))",
+	types.OpcodeF64Eq:       "fromBool(f64(%[1]s) == f64(%[2]s))",
+	types.OpcodeF64Ne:       "fromBool(f64(%[1]s) != f64(%[2]s))",
+	types.OpcodeF64Lt:       "fromBool(f64(%[1]s) < f64(%[2]s))",
+	types.OpcodeF64Gt:       "fromBool(f64(%[1]s) > f64(%[2]s))",
+	types.OpcodeF64Le:       "fromBool(f64(%[1]s) <= f64(%[2]s))",
+	types.OpcodeF64Ge:       "fromBool(f64(%[1]s) >= f64(%[2]s))",
+	types.OpcodeI32Add:      "uint64(uint32(%[1]s) + uint32(%[2]s))",
+	types.OpcodeI32Sub:      "uint64(uint32(%[1]s) - uint32(%[2]s))",
+	types.OpcodeI32Mul:      "uint64(uint32(%[1]s) * uint32(%[2]s))",
+	types.OpcodeI32DivS:     "divS32(%[1]s, %[2]s)",
+	types.OpcodeI32DivU:     "divU32(%[1]s, %[2]s)",
+	types.OpcodeI32RemS:     "remS32(%[1]s, %[2]s)",
+	types.OpcodeI32RemU:     "remU32(%[1]s, %[2]s)",
+	types.OpcodeI32And:      "%[1]s & %[2]s",
+	types.OpcodeI32Or:       "%[1]s | %[2]s",
+	types.OpcodeI32Xor:      "%[1]s ^ %[2]s",
+	types.OpcodeI32Shl:      "uint64(uint32(%[1]s) << (%[2]s & 31))",
+	types.OpcodeI32ShrS:     "uint64(uint32(int32(%[1]s) >> (%[2]s & 31)))",
+	types.OpcodeI32ShrU:     "uint64(uint32(%[1]s) >> (%[2]s & 31))",
+	types.OpcodeI32Rotl:     "rotl32(%[1]s, %[2]s)",
+	types.OpcodeI32Rotr:     "rotr32(%[1]s, %[2]s)",
+	types.OpcodeI64Add:      "%[1]s + %[2]s",
+	types.OpcodeI64Sub:      "%[1]s - %[2]s",
+	types.OpcodeI64Mul:      "%[1]s * %[2]s",
+	types.OpcodeI64DivS:     "divS64(%[1]s, %[2]s)",
+	types.OpcodeI64DivU:     "divU64(%[1]s, %[2]s)",
+	types.OpcodeI64RemS:     "remS64(%[1]s, %[2]s)",
+	types.OpcodeI64RemU:     "remU64(%[1]s, %[2]s)",
+	types.OpcodeI64And:      "%[1]s & %[2]s",
+	types.OpcodeI64Or:       "%[1]s | %[2]s",
+	types.OpcodeI64Xor:      "%[1]s ^ %[2]s",
+	types.OpcodeI64Shl:      "%[1]s << (%[2]s & 63)",
+	types.OpcodeI64ShrS:     "uint64(int64(%[1]s) >> (%[2]s & 63))",
+	types.OpcodeI64ShrU:     "%[1]s >> (%[2]s & 63)",
+	types.OpcodeI64Rotl:     "rotl64(%[1]s, %[2]s)",
+	types.OpcodeI64Rotr:     "rotr64(%[1]s, %[2]s)",
+	types.OpcodeF32Add:      "fromF32(f32(%[1]s) + f32(%[2]s))",
+	types.OpcodeF32Sub:      "fromF32(f32(%[1]s) - f32(%[2]s))",
+	types.OpcodeF32Mul:      "fromF32(f32(%[1]s) * f32(%[2]s))",
+	types.OpcodeF32Div:      "fromF32(f32(%[1]s) / f32(%[2]s))",
+	types.OpcodeF32Min:      "minF32(%[1]s, %[2]s)",
+	types.OpcodeF32Max:      "maxF32(%[1]s, %[2]s)",
+	types.OpcodeF32CopySign: "%[1]s&0x7FFFFFFF | %[2]s&0x80000000",
+	types.OpcodeF64Add:      "fromF64(f64(%[1]s) + f64(%[2]s))",
+	types.OpcodeF64Sub:      "fromF64(f64(%[1]s) - f64(%[2]s))",
+	types.OpcodeF64Mul:      "fromF64(f64(%[1]s) * f64(%[2]s))",
+	types.OpcodeF64Div:      "fromF64(f64(%[1]s) / f64(%[2]s))",
+	types.OpcodeF64Min:      "minF64(%[1]s, %[2]s)",
+	types.OpcodeF64Max:      "maxF64(%[1]s, %[2]s)",
+	types.OpcodeF64CopySign: "%[1]s&^(1<<63) | %[2]s&(1<<63)",
+}
+
+// loadOps maps loads to their expressions of the address.
+var loadOps = map[byte]string{
+	types.OpcodeI32Load:    "m.load32(%s)",
+	types.OpcodeI64Load:    "m.load64(%s)",
+	types.OpcodeF32Load:    "m.load32(%s)",
+	types.OpcodeF64Load:    "m.load64(%s)",
+	types.OpcodeI32Load8S:  "uint64(uint32(int8(m.load8(%s))))",
+	types.OpcodeI32Load8U:  "m.load8(%s)",
+	types.OpcodeI32Load16S: "uint64(uint32(int16(m.load16(%s))))",
+	types.OpcodeI32Load16U: "m.load16(%s)",
+	types.OpcodeI64Load8S:  "uint64(int8(m.load8(%s)))",
+	types.OpcodeI64Load8U:  "m.load8(%s)",
+	types.OpcodeI64Load16S: "uint64(int16(m.load16(%s)))",
+	types.OpcodeI64Load16U: "m.load16(%s)",
+	types.OpcodeI64Load32S: "uint64(int32(m.load32(%s)))",
+	types.OpcodeI64Load32U: "m.load32(%s)",
+}
+
+// storeOps maps stores to their helpers.
+var storeOps = map[byte]string{
+	types.OpcodeI32Store:   "store32",
+	types.OpcodeI64Store:   "store64",
+	types.OpcodeF32Store:   "store32",
+	types.OpcodeF64Store:   "store64",
+	types.OpcodeI32Store8:  "store8",
+	types.OpcodeI32Store16: "store16",
+	types.OpcodeI64Store8:  "store8",
+	types.OpcodeI64Store16: "store16",
+	types.OpcodeI64Store32: "store32",
+}
+
+// translateFunc translates code of the idx-th function into the method f<idx>.
+func (t *translator) translateFunc(idx int, code types.Code, heights []int) error {
+	ft := t.funcTypes[idx]
+	f := &funcTranslator{
+		t:         t,
+		heights:   make(map[*types.Instruction]int, len(heights)),
+		buf:       new(bytes.Buffer),
+		labels:    []label{{fn: true, arity: len(ft.ResultTypes)}},
+		reachable: true,
+		reads:     make(map[string]bool),
+		sets:      make(map[string]bool),
+	}
+	tools.WalkInstrs(code.Expr, func(v *types.Instruction) { f.heights[v] = heights[len(f.heights)] })
+
+	if err := f.translateExpr(code.Expr); err != nil {
+		return err
+	}
+	if f.reachable && len(ft.ResultTypes) == 0 {
+		f.printf("m.depth--\n")
+	} else if f.reachable {
+		f.branch(len(ft.ResultTypes), 0)
+	} else if !f.terminating {
+		// Go can't tell some ends are unreachable, e.g. those after br_table
+		f.printf("panic(\"unreachable\")\n")
+	}
+
+	nParams, nLocals := len(ft.ParamTypes), len(ft.ParamTypes)+int(tools.CountLocals(code.Locals))
+	params := make([]string, nParams)
+	for i := range params {
+		params[i] = fmt.Sprintf("l%d", i)
+	}
+
+	// locals and operands used, where those never read are discarded for the compiler
+	var vars, unread []string
+	add := func(name string) {
+		if f.reads[name] || f.sets[name] {
+			vars = append(vars, name)
+		}
+		if f.sets[name] && !f.reads[name] {
+			unread = append(unread, name)
+		}
+	}
+	for i := nParams; i < nLocals; i++ {
+		add(fmt.Sprintf("l%d", i))
+	}
+	for i := 0; i < f.nOperands; i++ {
+		add(fmt.Sprintf("s%d", i))
+	}
+
+	t.printf("func (m *Module) f%d(%s) (%s) {\n", idx, typedList(params),
+		slotList(len(ft.ResultTypes)))
+	if len(vars) > 0 {
+		t.printf("var %s\n", typedList(vars))
+	}
+	for _, v := range unread {
+		t.printf("_ = %s\n", v)
+	}
+	t.printf("m.enter()\n\n")
+	t.buf.Write(f.buf.Bytes())
+	t.printf("}\n\n")
+	return nil
+}
+
+func (f *funcTranslator) translateExpr(expr types.Expr) error {
+	for i := range expr {
+		if !f.reachable {
+			break
+		}
+		if err := f.translateInstr(&expr[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *funcTranslator) translateInstr(instr *types.Instruction) error {
+	h := f.heights[instr]
+
+	switch op := instr.Opcode; op {
+	case types.OpcodeUnreachable:
+		f.printf("panic(&Trap{Reason: \"unreachable\"})\n")
+		f.reachable, f.terminating = false, true
+	case types.OpcodeNop:
+	case types.OpcodeBlock, types.OpcodeLoop:
+		b := instr.Args.(*types.Block)
+		bt := tools.ParseBlockSig(b.BlockType, f.t.module.Types)
+		l := label{height: h - len(bt.ParamTypes), arity: len(bt.ResultTypes)}
+		if op == types.OpcodeLoop {
+			l.loop, l.arity = true, len(bt.ParamTypes)
+		}
+		return f.translateBlock(l, "", b.Instructions, nil)
+	case types.OpcodeIf:
+		b := instr.Args.(*types.BlockIf)
+		bt := tools.ParseBlockSig(b.BlockType, f.t.module.Types)
+		l := label{height: h - 1 - len(bt.ParamTypes), arity: len(bt.ResultTypes)}
+		cond := fmt.Sprintf("uint32(%s) != 0", f.get(h-1))
+		return f.translateBlock(l, cond, b.Instructions1, b.Instructions2)
+	case types.OpcodeBr:
+		f.branch(h, instr.Args.(uint32))
+	case types.OpcodeBrIf:
+		f.printf("if uint32(%s) != 0 {\n", f.get(h-1))
+		f.branch(h-1, instr.Args.(uint32))
+		f.printf("}\n")
+		f.reachable = true
+	case types.OpcodeBrTable:
+		table := instr.Args.(*types.BreakTable)
+		f.printf("switch uint32(%s) {\n", f.get(h-1))
+		for i, v := range table.Labels {
+			f.printf("case %d:\n", i)
+			f.branch(h-1, v)
+		}
+		f.printf("default:\n")
+		f.branch(h-1, table.Default)
+		f.printf("}\n")
+	case types.OpcodeReturn:
+		f.branch(h, uint32(len(f.labels)-1))
+	case types.OpcodeCall:
+		idx := instr.Args.(uint32)
+		f.call(h, f.t.funcTypes[idx], fmt.Sprintf("m.f%d", idx))
+	case types.OpcodeCallIndirect:
+		ft := f.t.module.Types[instr.Args.(uint32)]
+		if !numeric(ft.ParamTypes) || !numeric(ft.ResultTypes) {
+			return fmt.Errorf("%w: references in indirect calls", ErrUnsupported)
+		}
+		fn := fmt.Sprintf("m.call(uint64(uint32(%s)), %d).(func(%s) (%s))", f.get(h-1),
+			f.t.typeIdx(ft), slotList(len(ft.ParamTypes)), slotList(len(ft.ResultTypes)))
+		f.call(h-1, ft, fn)
+	case types.OpcodeDrop:
+	case types.OpcodeSelect:
+		f.printf("if uint32(%s) == 0 {\n%s = %s\n}\n", f.get(h-1), f.set(h-3), f.get(h-2))
+	case types.OpcodeLocalGet:
+		f.printf("%s = %s\n", f.set(h), f.local(instr.Args.(uint32), false))
+	case types.OpcodeLocalSet, types.OpcodeLocalTee:
+		f.printf("%s = %s\n", f.local(instr.Args.(uint32), true), f.get(h-1))
+	case types.OpcodeGlobalGet:
+		f.printf("%s = m.g%d\n", f.set(h), instr.Args.(uint32))
+	case types.OpcodeGlobalSet:
+		f.printf("m.g%d = %s\n", instr.Args.(uint32), f.get(h-1))
+	case types.OpcodeMemorySize:
+		f.printf("%s = uint64(len(m.Memory) / pageSize)\n", f.set(h))
+	case types.OpcodeMemoryGrow:
+		f.printf("%s = m.grow(uint64(uint32(%s)))\n", f.set(h-1), f.get(h-1))
+	case types.OpcodeI32Const:
+		f.printf("%s = 0x%X\n", f.set(h), uint32(instr.Args.(int32)))
+	case types.OpcodeI64Const:
+		f.printf("%s = 0x%X\n", f.set(h), uint64(instr.Args.(int64)))
+	case types.OpcodeF32Const:
+		f.printf("%s = 0x%X\n", f.set(h), math.Float32bits(instr.Args.(float32)))
+	case types.OpcodeF64Const:
+		f.printf("%s = 0x%X\n", f.set(h), math.Float64bits(instr.Args.(float64)))
+	case types.OpcodeTruncSat:
+		return f.translateTruncSat(h, instr.Args)
+	default:
+		if expr, ok := unaryOps[op]; ok {
+			if expr != "%s" {
+				f.printf("%s = %s\n", f.set(h-1), fmt.Sprintf(expr, f.get(h-1)))
+			}
+		} else if expr, ok := binaryOps[op]; ok {
+			f.printf("%s = %s\n", f.set(h-2), fmt.Sprintf(expr, f.get(h-2), f.get(h-1)))
+		} else if expr, ok := loadOps[op]; ok {
+			addr := f.address(h-1, instr.Args.(types.MemoryArg))
+			f.printf("%s = %s\n", f.set(h-1), fmt.Sprintf(expr, addr))
+		} else if store, ok := storeOps[op]; ok {
+			addr := f.address(h-2, instr.Args.(types.MemoryArg))
+			f.printf("m.%s(%s, %s)\n", store, addr, f.get(h-1))
+		} else {
+			return fmt.Errorf("%w: instruction %s", ErrUnsupported, instr.GetOpname())
+		}
+	}
+
+	return nil
+}
+
+// translateBlock translates a block labeled l, which is an if taking the else branch unless cond
+// holds if cond isn't empty.
+func (f *funcTranslator) translateBlock(l label, cond string, body, elseBody types.Expr) error {
+	outer := f.buf
+	f.nLabels++
+	l.name = fmt.Sprintf("L%d", f.nLabels)
+	f.labels = append(f.labels, l)
+
+	f.buf, f.terminating = new(bytes.Buffer), false
+	if err := f.translateExpr(body); err != nil {
+		return err
+	}
+	then, end, terminating := f.buf.String(), f.reachable, f.terminating
+
+	var els string
+	if cond != "" && len(elseBody) > 0 {
+		f.buf, f.reachable, f.terminating = new(bytes.Buffer), true, false
+		if err := f.translateExpr(elseBody); err != nil {
+			return err
+		}
+		els, end, terminating = f.buf.String(), end || f.reachable, terminating && f.terminating
+	} else if cond != "" {
+		end, terminating = true, false
+	}
+
+	l = f.labels[len(f.labels)-1]
+	f.labels = f.labels[:len(f.labels)-1]
+	f.buf = outer
+
+	if l.used {
+		f.printf("%s:\nfor {\n", l.name)
+	}
+	if cond == "" {
+		f.buf.WriteString(then)
+	} else if els == "" {
+		f.printf("if %s {\n%s}\n", cond, then)
+	} else {
+		f.printf("if %s {\n%s} else {\n%s}\n", cond, then, els)
+	}
+	if l.used && end {
+		f.printf("break %s\n", l.name)
+	}
+	if l.used {
+		f.printf("}\n")
+	}
+
+	// loops are left only at their ends, and those left by no breaks never terminate
+	f.reachable = end || l.used && !l.loop
+	if l.used {
+		f.terminating = l.loop && !end
+	} else {
+		f.terminating = terminating
+	}
+	return nil
+}
+
+func (f *funcTranslator) translateTruncSat(h int, args interface{}) error {
+	if sub, ok := args.(byte); ok {
+		if int(sub) >= len(truncSatOps) {
+			return fmt.Errorf("%w: sub-opcode 0x%X of 0xFC", ErrUnsupported, sub)
+		}
+		f.printf("%s = %s\n", f.set(h-1), fmt.Sprintf(truncSatOps[sub], f.get(h-1)))
+		return nil
+	}
+
+	arg := args.(types.BulkArg)
+	switch arg.SubOpcode {
+	case types.BulkMemoryInit:
+		f.printf("m.initMemory(%d, %s, %s, %s)\n", arg.DataIdx, f.address(h-3, types.MemoryArg{}),
+			f.address(h-2, types.MemoryArg{}), f.address(h-1, types.MemoryArg{}))
+	case types.BulkDataDrop:
+		f.printf("m.data[%d] = nil\n", arg.DataIdx)
+	case types.BulkMemoryCopy:
+		f.printf("m.copyMemory(%s, %s, %s)\n", f.address(h-3, types.MemoryArg{}),
+			f.address(h-2, types.MemoryArg{}), f.address(h-1, types.MemoryArg{}))
+	case types.BulkMemoryFill:
+		f.printf("m.fillMemory(%s, %s, %s)\n", f.address(h-3, types.MemoryArg{}), f.get(h-2),
+			f.address(h-1, types.MemoryArg{}))
+	default:
+		return fmt.Errorf("%w: sub-opcode 0x%X of 0xFC", ErrUnsupported, arg.SubOpcode)
+	}
+	return nil
+}
+
+// branch branches from height h to the label at depth, returning if it's the function body.
+func (f *funcTranslator) branch(h int, depth uint32) {
+	l := &f.labels[len(f.labels)-1-int(depth)]
+	f.reachable = false
+
+	if l.fn {
+		results := make([]string, l.arity)
+		for i := range results {
+			results[i] = f.get(h - l.arity + i)
+		}
+		f.printf("m.depth--\nreturn %s\n", strings.Join(results, ", "))
+		f.terminating = true
+		return
+	}
+
+	for i := 0; i < l.arity; i++ {
+		if dst, src := l.height+i, h-l.arity+i; dst != src {
+			f.printf("%s = %s\n", f.set(dst), f.get(src))
+		}
+	}
+
+	l.used = true
+	if l.loop {
+		f.printf("continue %s\n", l.name)
+	} else {
+		f.printf("break %s\n", l.name)
+	}
+}
+
+// call calls fn typed ft with operands below height h.
+func (f *funcTranslator) call(h int, ft types.FuncType, fn string) {
+	base := h - len(ft.ParamTypes)
+	args := make([]string, len(ft.ParamTypes))
+	for i := range args {
+		args[i] = f.get(base + i)
+	}
+	results := make([]string, len(ft.ResultTypes))
+	for i := range results {
+		results[i] = f.set(base + i)
+	}
+
+	if len(results) == 0 {
+		f.printf("%s(%s)\n", fn, strings.Join(args, ", "))
+	} else {
+		f.printf("%s = %s(%s)\n", strings.Join(results, ", "), fn, strings.Join(args, ", "))
+	}
+}
+
+// address returns the effective address of the operand at height h, offset by arg.
+func (f *funcTranslator) address(h int, arg types.MemoryArg) string {
+	if arg.Offset == 0 {
+		return fmt.Sprintf("uint64(uint32(%s))", f.get(h))
+	}
+	return fmt.Sprintf("uint64(uint32(%s))+%d", f.get(h), arg.Offset)
+}
+
+// get reads the operand at height h.
+func (f *funcTranslator) get(h int) string {
+	name := f.operand(h)
+	f.reads[name] = true
+	return name
+}
+
+// set writes the operand at height h.
+func (f *funcTranslator) set(h int) string {
+	name := f.operand(h)
+	f.sets[name] = true
+	return name
+}
+
+func (f *funcTranslator) operand(h int) string {
+	if h >= f.nOperands {
+		f.nOperands = h + 1
+	}
+	return fmt.Sprintf("s%d", h)
+}
+
+func (f *funcTranslator) local(idx uint32, set bool) string {
+	name := fmt.Sprintf("l%d", idx)
+	if set {
+		f.sets[name] = true
+	} else {
+		f.reads[name] = true
+	}
+	return name
+}
+
+func (f *funcTranslator) printf(format string, a ...interface{}) {
+	fmt.Fprintf(f.buf, format, a...)
+	f.terminating = false
+}
@@ -0,0 +1,384 @@
+package aot
+
+// runtimeSource is the runtime of generated packages, where values of any type are kept in uint64,
+// with i32 and f32 zero-extended.
+const runtimeSource = `
+const pageSize = 65536
+
+// maxCallDepth limits nested calls, beyond which the call stack is exhausted.
+const maxCallDepth = 1 << 16
+
+// Trap is an error trapping the guest, which is caused by Err if returned by imports.
+type Trap struct {
+	Reason string
+	Err    error
+}
+
+func (t *Trap) Error() string {
+	return "trap: " + t.Reason
+}
+
+func (t *Trap) Unwrap() error {
+	return t.Err
+}
+
+func trap(reason string) {
+	panic(&Trap{Reason: reason})
+}
+
+// guard recovers traps into err, as well as runtime errors such as accesses out of bounds, and
+// restores the depth of calls on entry, which is non-zero if imports call back.
+func (m *Module) guard(depth int, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	m.depth = depth
+	switch v := r.(type) {
+	case *Trap:
+		*err = v
+	case runtime.Error:
+		*err = &Trap{Reason: v.Error(), Err: v}
+	default:
+		panic(r)
+	}
+}
+
+func (m *Module) enter() {
+	if m.depth++; m.depth > maxCallDepth {
+		trap("call stack exhausted")
+	}
+}
+
+func (m *Module) grow(n uint64) uint64 {
+	old := uint64(len(m.Memory) / pageSize)
+	if n > maxPages-old {
+		return 0xFFFFFFFF
+	}
+
+	m.Memory = append(m.Memory, make([]byte, n*pageSize)...)
+	return old
+}
+
+func (m *Module) load8(addr uint64) uint64 {
+	return uint64(m.Memory[addr])
+}
+
+func (m *Module) load16(addr uint64) uint64 {
+	return uint64(binary.LittleEndian.Uint16(m.Memory[addr : addr+2]))
+}
+
+func (m *Module) load32(addr uint64) uint64 {
+	return uint64(binary.LittleEndian.Uint32(m.Memory[addr : addr+4]))
+}
+
+func (m *Module) load64(addr uint64) uint64 {
+	return binary.LittleEndian.Uint64(m.Memory[addr : addr+8])
+}
+
+func (m *Module) store8(addr, v uint64) {
+	m.Memory[addr] = byte(v)
+}
+
+func (m *Module) store16(addr, v uint64) {
+	binary.LittleEndian.PutUint16(m.Memory[addr:addr+2], uint16(v))
+}
+
+func (m *Module) store32(addr, v uint64) {
+	binary.LittleEndian.PutUint32(m.Memory[addr:addr+4], uint32(v))
+}
+
+func (m *Module) store64(addr, v uint64) {
+	binary.LittleEndian.PutUint64(m.Memory[addr:addr+8], v)
+}
+
+func (m *Module) copyMemory(dst, src, n uint64) {
+	copy(m.Memory[dst:dst+n], m.Memory[src:src+n])
+}
+
+func (m *Module) fillMemory(dst, v, n uint64) {
+	b := m.Memory[dst : dst+n]
+	for i := range b {
+		b[i] = byte(v)
+	}
+}
+
+func (m *Module) initMemory(seg, dst, src, n uint64) {
+	copy(m.Memory[dst:dst+n], m.data[seg][src:src+n])
+}
+
+// element is an element of the table, whose func is of the type indexed typ.
+type element struct {
+	typ int
+	fn  interface{}
+}
+
+// call returns the func of the i-th element of the table, which must be of the type indexed typ.
+func (m *Module) call(i uint64, typ int) interface{} {
+	if i >= uint64(len(m.table)) {
+		trap("undefined element")
+	}
+
+	e := m.table[i]
+	if e.fn == nil {
+		trap("uninitialized element")
+	} else if e.typ != typ {
+		trap("indirect call type mismatch")
+	}
+	return e.fn
+}
+
+func f32(v uint64) float32 {
+	return math.Float32frombits(uint32(v))
+}
+
+func f64(v uint64) float64 {
+	return math.Float64frombits(v)
+}
+
+func fromF32(v float32) uint64 {
+	return uint64(math.Float32bits(v))
+}
+
+func fromF64(v float64) uint64 {
+	return math.Float64bits(v)
+}
+
+func fromBool(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func divS32(a, b uint64) uint64 {
+	x, y := int32(a), int32(b)
+	if y == 0 {
+		trap("integer divide by zero")
+	} else if x == math.MinInt32 && y == -1 {
+		trap("integer overflow")
+	}
+	return uint64(uint32(x / y))
+}
+
+func divU32(a, b uint64) uint64 {
+	if uint32(b) == 0 {
+		trap("integer divide by zero")
+	}
+	return uint64(uint32(a) / uint32(b))
+}
+
+func remS32(a, b uint64) uint64 {
+	x, y := int32(a), int32(b)
+	if y == 0 {
+		trap("integer divide by zero")
+	} else if y == -1 {
+		return 0
+	}
+	return uint64(uint32(x % y))
+}
+
+func remU32(a, b uint64) uint64 {
+	if uint32(b) == 0 {
+		trap("integer divide by zero")
+	}
+	return uint64(uint32(a) % uint32(b))
+}
+
+func divS64(a, b uint64) uint64 {
+	x, y := int64(a), int64(b)
+	if y == 0 {
+		trap("integer divide by zero")
+	} else if x == math.MinInt64 && y == -1 {
+		trap("integer overflow")
+	}
+	return uint64(x / y)
+}
+
+func divU64(a, b uint64) uint64 {
+	if b == 0 {
+		trap("integer divide by zero")
+	}
+	return a / b
+}
+
+func remS64(a, b uint64) uint64 {
+	x, y := int64(a), int64(b)
+	if y == 0 {
+		trap("integer divide by zero")
+	} else if y == -1 {
+		return 0
+	}
+	return uint64(x % y)
+}
+
+func remU64(a, b uint64) uint64 {
+	if b == 0 {
+		trap("integer divide by zero")
+	}
+	return a % b
+}
+
+// truncate truncates z into an integer within [min, max), trapping if it isn't.
+func truncate(z, min, max float64) float64 {
+	if z != z {
+		trap("invalid conversion to integer")
+	}
+	if z = math.Trunc(z); z < min || z >= max {
+		trap("integer overflow")
+	}
+	return z
+}
+
+func truncS32(z float64) uint64 {
+	return uint64(uint32(int32(truncate(z, -1<<31, 1<<31))))
+}
+
+func truncU32(z float64) uint64 {
+	return uint64(uint32(truncate(z, 0, 1<<32)))
+}
+
+func truncS64(z float64) uint64 {
+	return uint64(int64(truncate(z, -1<<63, 1<<63)))
+}
+
+func truncU64(z float64) uint64 {
+	return uint64(truncate(z, 0, 1<<64))
+}
+
+func satS32(z float64) uint64 {
+	switch {
+	case z != z:
+		return 0
+	case z <= -1<<31:
+		return 1 << 31
+	case z >= 1<<31:
+		return 1<<31 - 1
+	}
+	return uint64(uint32(int32(z)))
+}
+
+func satU32(z float64) uint64 {
+	switch {
+	case z != z || z <= -1:
+		return 0
+	case z >= 1<<32:
+		return 1<<32 - 1
+	}
+	return uint64(uint32(z))
+}
+
+func satS64(z float64) uint64 {
+	switch {
+	case z != z:
+		return 0
+	case z <= -1<<63:
+		return 1 << 63
+	case z >= 1<<63:
+		return 1<<63 - 1
+	}
+	return uint64(int64(z))
+}
+
+func satU64(z float64) uint64 {
+	switch {
+	case z != z || z <= -1:
+		return 0
+	case z >= 1<<64:
+		return 1<<64 - 1
+	}
+	return uint64(z)
+}
+
+func fmin(a, b float64) float64 {
+	switch {
+	case a != a || b != b:
+		return math.NaN()
+	case a == 0 && b == 0:
+		if math.Signbit(a) {
+			return a
+		}
+		return b
+	case a < b:
+		return a
+	}
+	return b
+}
+
+func fmax(a, b float64) float64 {
+	switch {
+	case a != a || b != b:
+		return math.NaN()
+	case a == 0 && b == 0:
+		if math.Signbit(a) {
+			return b
+		}
+		return a
+	case a > b:
+		return a
+	}
+	return b
+}
+
+func minF32(a, b uint64) uint64 {
+	return fromF32(float32(fmin(float64(f32(a)), float64(f32(b)))))
+}
+
+func maxF32(a, b uint64) uint64 {
+	return fromF32(float32(fmax(float64(f32(a)), float64(f32(b)))))
+}
+
+func minF64(a, b uint64) uint64 {
+	return fromF64(fmin(f64(a), f64(b)))
+}
+
+func maxF64(a, b uint64) uint64 {
+	return fromF64(fmax(f64(a), f64(b)))
+}
+
+// unaryF32 applies f to v of f32, which is exact in float64.
+func unaryF32(v uint64, f func(float64) float64) uint64 {
+	return fromF32(float32(f(float64(f32(v)))))
+}
+
+func clz32(v uint64) uint64 {
+	return uint64(bits.LeadingZeros32(uint32(v)))
+}
+
+func ctz32(v uint64) uint64 {
+	return uint64(bits.TrailingZeros32(uint32(v)))
+}
+
+func popcnt32(v uint64) uint64 {
+	return uint64(bits.OnesCount32(uint32(v)))
+}
+
+func rotl32(a, b uint64) uint64 {
+	return uint64(bits.RotateLeft32(uint32(a), int(b&31)))
+}
+
+func rotr32(a, b uint64) uint64 {
+	return uint64(bits.RotateLeft32(uint32(a), -int(b&31)))
+}
+
+func clz64(v uint64) uint64 {
+	return uint64(bits.LeadingZeros64(v))
+}
+
+func ctz64(v uint64) uint64 {
+	return uint64(bits.TrailingZeros64(v))
+}
+
+func popcnt64(v uint64) uint64 {
+	return uint64(bits.OnesCount64(v))
+}
+
+func rotl64(a, b uint64) uint64 {
+	return bits.RotateLeft64(a, int(b&63))
+}
+
+func rotr64(a, b uint64) uint64 {
+	return bits.RotateLeft64(a, -int(b&63))
+}
+`
@@ -1,35 +1,80 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	wasmer "github.com/sammyne/mastering-wasm/wavm"
 	"github.com/sammyne/mastering-wasm/wavm/cmd/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
 
 	flag "github.com/spf13/pflag"
 )
 
+// Subcommands preceding the module, which is run by default. "debug" debugs it interactively unless
+// by --dap or --gdb, and "aot" translates it into a Go package rather than run it.
+const (
+	cmdAOT   = "aot"
+	cmdDebug = "debug"
+	cmdRun   = "run"
+)
+
 var (
-	dump  bool
-	check bool
+	dump     bool
+	check    bool
+	debug    bool
+	dapAddr  string
+	gdbAddr  string
+	features string
+	engine   string
+
+	tracePath   string
+	traceFormat string
+	traceFuncs  []uint
+	traceMax    int
+
+	profilePath     string
+	foldedPath      string
+	profileInterval time.Duration
+
+	coveragePath   string
+	coverageFormat string
+
+	outputDir string
 )
 
 func main() {
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	cmd, path := cmdRun, flag.Arg(0)
+	if flag.NArg() == 2 && (flag.Arg(0) == cmdAOT || flag.Arg(0) == cmdDebug || flag.Arg(0) == cmdRun) {
+		cmd, path = flag.Arg(0), flag.Arg(1)
+	} else if flag.NArg() != 1 {
 		flag.PrintDefaults()
-		fmt.Println("only 1 positional argument is allowed")
+		fmt.Printf("only 1 positional argument is allowed, or 2 as '<%s|%s|%s> <module>'\n", cmdAOT,
+			cmdDebug, cmdRun)
 		os.Exit(-1)
 	}
 
-	module, err := wasmer.DecodeModuleFromFile(flag.Arg(0))
+	module, err := wasmer.DecodeModuleFromFile(path)
 	if err != nil {
 		panic(err)
 	}
 
-	if dump {
+	enabled, err := getFeatures(module)
+	if err != nil {
+		panicf("get features: %v", err)
+	}
+	module.DisabledFeatures = wasmer.FeaturesAll &^ enabled
+
+	if cmd == cmdAOT {
+		if err := tools.CompileAOT(module, outputDir); err != nil {
+			panicf("fail to compile ahead of time: %v", err)
+		}
+		return
+	} else if dump {
 		if err := tools.Dump(module); err != nil {
 			panicf("fail to dump: %v", err)
 		}
@@ -42,7 +87,33 @@ func main() {
 		return
 	}
 
-	if err := tools.InstantiateAndExecMainFunc(module); err != nil {
+	e, err := vm.ParseEngine(engine)
+	if err != nil {
+		panicf("parse engine: %v", err)
+	}
+	opts := vm.Options{Engine: e}
+
+	if dapAddr != "" {
+		err = tools.ServeDAP(module, opts, dapAddr)
+	} else if gdbAddr != "" {
+		err = tools.ServeGDB(module, opts, path, gdbAddr)
+	} else if coveragePath != "" {
+		err = tools.CoverMainFunc(module, opts, coveragePath, coverageFormat)
+	} else if profilePath != "" {
+		profileOpts := vm.ProfileOptions{SampleInterval: profileInterval}
+		err = tools.ProfileMainFunc(module, opts, profilePath, foldedPath, profileOpts)
+	} else if tracePath != "" {
+		err = traceMainFunc(module, opts)
+	} else if debug || cmd == cmdDebug {
+		err = tools.DebugMainFunc(module, opts, os.Stdin, os.Stdout)
+	} else {
+		err = tools.InstantiateAndExecMainFunc(module, opts)
+	}
+	if err != nil {
+		var trap *vm.Trap
+		if errors.As(err, &trap) {
+			fmt.Fprintln(os.Stderr, tools.FormatBacktrace(module, trap))
+		}
 		panicf("instantiate and exec main func: %v", err)
 	}
 
@@ -54,6 +125,71 @@ func main() {
 func init() {
 	flag.BoolVarP(&dump, "dump", "d", false, "")
 	flag.BoolVarP(&check, "check", "c", false, "check wasm file")
+	flag.BoolVarP(&debug, "debug", "g", false, "debug the main func interactively")
+	flag.StringVar(&dapAddr, "dap", "",
+		`debug the main func by the Debug Adapter Protocol over "stdio" or a TCP address`)
+	flag.Lookup("dap").NoOptDefVal = tools.DAPStdio
+	flag.StringVar(&gdbAddr, "gdb", "", "debug the main func by the GDB remote protocol at a TCP address")
+	flag.Lookup("gdb").NoOptDefVal = tools.GDBDefaultAddr
+	flag.StringVarP(&features, "features", "f", "all",
+		`comma separated features to enable, or "target" for ones in the target_features section`)
+	flag.StringVar(&engine, "engine", vm.EngineTree.String(),
+		fmt.Sprintf(`engine to run the main func, "%s", "%s", "%s", "%s" or "%s" (amd64 Linux only)`,
+			vm.EngineTree, vm.EngineBytecode, vm.EngineRegister, vm.EngineClosure, vm.EngineJIT))
+
+	flag.StringVar(&tracePath, "trace", "", `trace executed instructions into a file, or stderr if "-"`)
+	flag.StringVar(&traceFormat, "trace-format", vm.TraceFormatJSONLines.String(),
+		fmt.Sprintf(`format of traces, "%s" or "%s"`, vm.TraceFormatJSONLines, vm.TraceFormatBinary))
+	flag.UintSliceVar(&traceFuncs, "trace-funcs", nil, "indices of funcs to trace, all if empty")
+	flag.IntVar(&traceMax, "trace-max", 0, "max records to trace, unlimited if 0")
+
+	flag.StringVar(&profilePath, "profile", "", "profile the main func into a gzipped pprof file")
+	flag.StringVar(&foldedPath, "profile-folded", "", "also write profiled stacks folded for flame graphs")
+	flag.DurationVar(&profileInterval, "profile-interval", time.Millisecond,
+		"interval of wall-clock samples, off if 0")
+
+	flag.StringVar(&coveragePath, "coverage", "", "record coverage of the main func into a report file")
+	flag.StringVar(&coverageFormat, "coverage-format", tools.CoverageFormatLCOV,
+		fmt.Sprintf(`format of coverage reports, "%s" or "%s"`, tools.CoverageFormatLCOV,
+			tools.CoverageFormatHTML))
+
+	flag.StringVarP(&outputDir, "output", "o", ".",
+		"directory of the Go package translated by 'aot', which is named after it")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [%s|%s|%s] [flags] <module>\n", os.Args[0], cmdAOT, cmdDebug,
+			cmdRun)
+		flag.PrintDefaults()
+	}
+}
+
+func getFeatures(m *wasmer.Module) (wasmer.Features, error) {
+	if features != "target" {
+		return wasmer.ParseFeatures(features)
+	}
+
+	out, ok, err := m.GetTargetFeatures()
+	if err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, fmt.Errorf("no %s section", wasmer.TargetFeaturesSectionName)
+	}
+
+	return out, nil
+}
+
+func traceMainFunc(m *wasmer.Module, vmOpts vm.Options) error {
+	format, err := vm.ParseTraceFormat(traceFormat)
+	if err != nil {
+		return err
+	}
+
+	opts := vm.TraceOptions{Format: format, MaxRecords: traceMax}
+	for _, v := range traceFuncs {
+		opts.Funcs = append(opts.Funcs, uint32(v))
+	}
+
+	return tools.TraceMainFunc(m, vmOpts, tracePath, opts)
 }
 
 func panicf(format string, args ...interface{}) {
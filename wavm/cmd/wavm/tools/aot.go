@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/aot"
+)
+
+// CompileAOT translates module ahead of time into a Go package in dir, which is named after dir and
+// created if missing.
+func CompileAOT(module *wavm.Module, dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("resolve output dir: %w", err)
+	}
+	pkg := packageName(filepath.Base(abs))
+
+	src, err := aot.Translate(module, pkg)
+	if err != nil {
+		return fmt.Errorf("translate: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("make output dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, pkg+".go"), src, 0644); err != nil {
+		return fmt.Errorf("write package: %w", err)
+	}
+
+	return nil
+}
+
+// packageName makes a Go package name of name, keeping its lowercased letters, digits and
+// underscores.
+func packageName(name string) string {
+	out := strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_':
+			return c
+		case c >= 'A' && c <= 'Z':
+			return c - 'A' + 'a'
+		}
+		return -1
+	}, name)
+
+	if out == "" || out[0] >= '0' && out[0] <= '9' {
+		return "guest" + out
+	}
+	return out
+}
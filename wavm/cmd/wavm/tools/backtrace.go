@@ -0,0 +1,28 @@
+package tools
+
+import (
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/debuginfo"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// FormatBacktrace formats the backtrace of trap, with source locations of frames if m carries
+// DWARF.
+func FormatBacktrace(m *wavm.Module, trap *vm.Trap) string {
+	info, err := debuginfo.New(m)
+	if err != nil {
+		return trap.Backtrace()
+	}
+
+	return trap.FormatBacktrace(func(f vm.StackFrame) []string {
+		if f.External {
+			return nil
+		}
+
+		var out []string
+		for _, v := range info.Lookup(f.Offset) {
+			out = append(out, "at "+v.String())
+		}
+		return out
+	})
+}
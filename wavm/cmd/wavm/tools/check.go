@@ -6,5 +6,5 @@ import (
 )
 
 func Check(m *wavm.Module) error {
-	return validator.Validate(*m)
+	return validator.ValidateAll(*m)
 }
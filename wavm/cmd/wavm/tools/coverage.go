@@ -0,0 +1,60 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/coverage"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// Formats of coverage reports.
+const (
+	CoverageFormatLCOV = "lcov"
+	CoverageFormatHTML = "html"
+)
+
+// CoverMainFunc runs the main func of module as InstantiateAndExecMainFunc, and writes the report
+// of its coverage in format to path. Without DWARF, lcov reports refer to the instruction listing
+// written to path+".lst".
+func CoverMainFunc(module *wavm.Module, vmOpts vm.Options, path, format string) error {
+	if format != CoverageFormatLCOV && format != CoverageFormatHTML {
+		return fmt.Errorf("unknown coverage format '%s'", format)
+	}
+
+	externalModules := map[string]linker.Module{"env": fakeEnv(os.Stdout)}
+	m, err := vm.NewVMWithOptions(module, externalModules, vmOpts)
+	if err != nil {
+		return fmt.Errorf("build VM: %w", err)
+	}
+	defer m.(*vm.VM).Close()
+
+	c := vm.NewCoverage(m.(*vm.VM))
+	_, err = m.InvokeFunc("main")
+	c.Detach()
+
+	report, reportErr := coverage.NewReport(module, c)
+	if reportErr != nil {
+		return fmt.Errorf("make coverage report: %w", reportErr)
+	}
+
+	if format == CoverageFormatHTML {
+		reportErr = writeProfile(path, report.WriteHTML)
+	} else {
+		reportErr = writeProfile(path, func(w io.Writer) error { return report.WriteLCOV(w, path+".lst") })
+	}
+	if reportErr == nil && format == CoverageFormatLCOV && !report.HasDebugInfo() {
+		reportErr = writeProfile(path+".lst", report.WriteListing)
+	}
+	if reportErr != nil {
+		return fmt.Errorf("write coverage report: %w", reportErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invoke func 'main': %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/dap"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// DAPStdio is the address serving the Debug Adapter Protocol over stdin and stdout.
+const DAPStdio = "stdio"
+
+// ServeDAP debugs the main func of module by the Debug Adapter Protocol over stdio if addr is
+// DAPStdio, or over the 1st TCP connection accepted at addr otherwise.
+func ServeDAP(module *wavm.Module, vmOpts vm.Options, addr string) error {
+	s, err := dap.NewServer(module)
+	if err != nil {
+		return fmt.Errorf("new server: %w", err)
+	}
+
+	externalModules := map[string]linker.Module{"env": fakeEnv(s.Output())}
+	m, err := vm.NewVMWithOptions(module, externalModules, vmOpts)
+	if err != nil {
+		return fmt.Errorf("build VM: %w", err)
+	}
+	defer m.(*vm.VM).Close()
+
+	if addr == DAPStdio {
+		rw := struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}
+		return s.Serve(rw, m.(*vm.VM), "main")
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "DAP server listening at %s\n", l.Addr())
+
+	conn, err := l.Accept()
+	if err != nil {
+		return fmt.Errorf("accept: %w", err)
+	}
+	defer conn.Close()
+
+	return s.Serve(conn, m.(*vm.VM), "main")
+}
@@ -0,0 +1,135 @@
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+const debugHelp = `commands:
+  b <func> <offset>     set breakpoint
+  d <func> <offset>     delete breakpoint
+  c                     continue
+  s | n | o             step into, over or out
+  bt                    backtrace
+  l                     locals
+  st                    operand stack
+  g <idx>               global
+  m <idx> <offset> <n>  read memory
+  q                     quit`
+
+// DebugMainFunc runs the main func of module as InstantiateAndExecMainFunc, but stops before the
+// 1st instruction for debugging commands read from in.
+func DebugMainFunc(module *wavm.Module, vmOpts vm.Options, in io.Reader, out io.Writer) error {
+	externalModules := map[string]linker.Module{"env": fakeEnv(out)}
+
+	m, err := vm.NewVMWithOptions(module, externalModules, vmOpts)
+	if err != nil {
+		return fmt.Errorf("build VM: %w", err)
+	}
+	defer m.(*vm.VM).Close()
+
+	scanner := bufio.NewScanner(in)
+	d := vm.NewDebugger(m.(*vm.VM), func(d *vm.Debugger, s vm.Stop) vm.Action {
+		fmt.Fprintf(out, "%s at 0x%x of %s: %s\n", s.Reason, s.Instruction.Offset,
+			module.Names.DescribeFunc(s.FuncIdx), s.Instruction.GetOpname())
+		return debugREPL(d, scanner, out)
+	})
+	d.Pause()
+
+	if _, err := m.InvokeFunc("main"); err != nil {
+		return fmt.Errorf("invoke func 'main': %w", err)
+	}
+
+	return nil
+}
+
+// debugREPL runs commands until one resumes execution.
+func debugREPL(d *vm.Debugger, scanner *bufio.Scanner, out io.Writer) vm.Action {
+	for {
+		fmt.Fprint(out, "(wavm) ")
+		if !scanner.Scan() {
+			return vm.ActionAbort
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		args, err := parseDebugArgs(fields[1:])
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+
+		switch cmd := fields[0]; {
+		case cmd == "c":
+			return vm.ActionContinue
+		case cmd == "s":
+			return vm.ActionStepInto
+		case cmd == "n":
+			return vm.ActionStepOver
+		case cmd == "o":
+			return vm.ActionStepOut
+		case cmd == "q":
+			return vm.ActionAbort
+		case (cmd == "b" || cmd == "d") && len(args) == 2:
+			b := vm.Breakpoint{FuncIdx: uint32(args[0]), Offset: uint32(args[1])}
+			if cmd == "d" {
+				d.ClearBreakpoint(b)
+			} else if err := d.SetBreakpoint(b); err != nil {
+				fmt.Fprintln(out, err)
+			}
+		case cmd == "bt":
+			for i, v := range d.Frames() {
+				fmt.Fprintf(out, "%3d: %s\n", i, v)
+			}
+		case cmd == "l":
+			locals, err := d.GetLocals()
+			if err != nil {
+				fmt.Fprintln(out, err)
+				break
+			}
+			for i, v := range locals {
+				fmt.Fprintf(out, "local[%d] = %v\n", i, v)
+			}
+		case cmd == "st":
+			fmt.Fprintln(out, d.GetOperands())
+		case cmd == "g" && len(args) == 1:
+			v, err := d.GetGlobal(uint32(args[0]))
+			if err != nil {
+				fmt.Fprintln(out, err)
+				break
+			}
+			fmt.Fprintf(out, "global[%d] = %v\n", args[0], v)
+		case cmd == "m" && len(args) == 3:
+			buf := make([]byte, args[2])
+			if err := d.ReadMemory(uint32(args[0]), args[1], buf); err != nil {
+				fmt.Fprintln(out, err)
+				break
+			}
+			fmt.Fprintf(out, "% x\n", buf)
+		default:
+			fmt.Fprintln(out, debugHelp)
+		}
+	}
+}
+
+func parseDebugArgs(fields []string) ([]uint64, error) {
+	out := make([]uint64, len(fields))
+	for i, v := range fields {
+		var err error
+		if out[i], err = strconv.ParseUint(v, 0, 64); err != nil {
+			return nil, fmt.Errorf("bad argument %s: %w", v, err)
+		}
+	}
+
+	return out, nil
+}
@@ -13,36 +13,42 @@ type ImportCounts struct {
 	Table  int
 	Memory int
 	Global int
+	Tag    int
 }
 
 func Dump(m *wasmer.Module) error {
 	fmt.Printf("Version: 0x%02x\n", m.Version)
+	if m.Names.Module != "" {
+		fmt.Printf("Name: %s\n", m.Names.Module)
+	}
 
-	dumpTypes(m.Types)
+	dumpTypes(m.Types, m.Names.Types)
 
-	importCounts, err := dumpImports(m.Imports)
+	importCounts, err := dumpImports(m.Imports, m.Names)
 	if err != nil {
 		return fmt.Errorf("bad imports: %w", err)
 	}
 
-	dumpFunctions(m.Functions, importCounts.Func)
-	dumpTables(m.Tables, importCounts.Table)
-	dumpMemories(m.Memories, importCounts.Memory)
-	dumpGlobals(m.Globals, importCounts.Global)
-	dumpExports(m.Exports)
-	dumpStart(m.Start)
-	dumpElements(m.Elements)
-	dumpCodes(m.Codes, m.Types, importCounts.Func)
-	dumpData(m.Data)
+	dumpFunctions(m.Functions, importCounts.Func, m.Names.Functions)
+	dumpTables(m.Tables, importCounts.Table, m.Names.Tables)
+	dumpMemories(m.Memories, importCounts.Memory, m.Names.Memories)
+	dumpTags(m.Tags, importCounts.Tag)
+	dumpGlobals(m.Globals, importCounts.Global, m.Names.Globals)
+	dumpExports(m.Exports, m.Names)
+	dumpStart(m.Start, m.Names.Functions)
+	dumpElements(m.Elements, m.Names.Elements)
+	dumpCodes(m.Codes, m.Types, importCounts.Func, m.Names)
+	dumpData(m.Data, m.Names.Data)
 	dumpCustoms(m.Customs)
 
 	return nil
 }
 
-func dumpCodes(codes []types.Code, types_ []types.FuncType, offset int) {
+func dumpCodes(codes []types.Code, types_ []types.FuncType, offset int, names types.Names) {
 	fmt.Printf("Code[%d]:\n", len(codes))
 	for i, code := range codes {
-		fmt.Printf("  func[%d]: locals=[", offset+i) // TODO
+		funcIdx := uint32(offset + i)
+		fmt.Printf("  %s: locals=[", names.DescribeFunc(funcIdx)) // TODO
 		if len(code.Locals) > 0 {
 			for i, locals := range code.Locals {
 				if i > 0 {
@@ -52,7 +58,7 @@ func dumpCodes(codes []types.Code, types_ []types.FuncType, offset int) {
 			}
 		}
 		fmt.Println("]")
-		dumpExpr("    ", types_, code.Expr)
+		dumpExpr("    ", types_, code.Expr, names.Locals[funcIdx])
 	}
 }
 
@@ -63,53 +69,71 @@ func dumpCustoms(customs []types.Custom) {
 	}
 }
 
-func dumpData(data []types.Data) {
+func dumpData(data []types.Data, names types.NameMap) {
 	fmt.Printf("Data[%d]:\n", len(data))
 	for i, v := range data {
-		fmt.Printf("  data[%d]: mem=%d\n", i, v.MemoryIdx) // TODO
+		fmt.Printf("  %s: mem=%d\n", types.DescribeIdx("data", uint32(i), names), v.MemoryIdx) // TODO
 	}
 }
 
-func dumpElements(elements []types.Element) {
+func dumpElements(elements []types.Element, names types.NameMap) {
 	fmt.Printf("Element[%d]:\n", len(elements))
 	for i, elem := range elements {
-		fmt.Printf("  elem[%d]: table=%d\n", i, elem.TableIdx) // TODO
+		elemName := types.DescribeIdx("elem", uint32(i), names)
+		fmt.Printf("  %s: table=%d\n", elemName, elem.TableIdx) // TODO
 	}
 }
 
-func dumpExports(exports []types.Export) {
+func dumpExports(exports []types.Export, names types.Names) {
 	fmt.Printf("Export[%d]:\n", len(exports))
 	for _, v := range exports {
+		idx := v.Description.Idx
 		switch v.Description.Tag {
 		case types.PortTagFunc:
-			fmt.Printf("  func[%d]: name=%s\n", int(v.Description.Idx), v.Name)
+			fmt.Printf("  %s: name=%s\n", names.DescribeFunc(idx), v.Name)
 		case types.PortTagTable:
-			fmt.Printf("  table[%d]: name=%s\n", int(v.Description.Idx), v.Name)
+			fmt.Printf("  %s: name=%s\n", types.DescribeIdx("table", idx, names.Tables), v.Name)
 		case types.PortTagMemory:
-			fmt.Printf("  memory[%d]: name=%s\n", int(v.Description.Idx), v.Name)
+			fmt.Printf("  %s: name=%s\n", types.DescribeIdx("memory", idx, names.Memories), v.Name)
 		case types.PortTagGlobal:
-			fmt.Printf("  global[%d]: name=%s\n", int(v.Description.Idx), v.Name)
+			fmt.Printf("  %s: name=%s\n", names.DescribeGlobal(idx), v.Name)
+		case types.PortTagTag:
+			fmt.Printf("  tag[%d]: name=%s\n", int(v.Description.Idx), v.Name)
 		}
 	}
 }
 
-func dumpExpr(indent string, types_ []types.FuncType, expr types.Expr) {
+// dumpExpr dumps expr indented by indent, where locals names locals of the function expr belongs to.
+func dumpExpr(indent string, types_ []types.FuncType, expr types.Expr, locals types.NameMap) {
 	for _, v := range expr {
 		switch v.Opcode {
 		case types.OpcodeBlock, types.OpcodeLoop:
 			block := v.Args.(*types.Block)
 			blockType := tools.ParseBlockSig(block.BlockType, types_)
 			fmt.Printf("%s%s %s\n", indent, v.GetOpname(), blockType)
-			dumpExpr(indent+"  ", types_, block.Instructions)
+			dumpExpr(indent+"  ", types_, block.Instructions, locals)
 			fmt.Printf("%send\n", indent)
 		case types.OpcodeIf:
 			blockIf := v.Args.(*types.BlockIf)
 			blockType := tools.ParseBlockSig(blockIf.BlockType, types_)
 			fmt.Printf("%sif %s\n", indent, blockType)
-			dumpExpr(indent+"  ", types_, blockIf.Instructions1)
+			dumpExpr(indent+"  ", types_, blockIf.Instructions1, locals)
 			fmt.Printf("%selse\n", indent)
-			dumpExpr(indent+"  ", types_, blockIf.Instructions2)
+			dumpExpr(indent+"  ", types_, blockIf.Instructions2, locals)
+			fmt.Printf("%send\n", indent)
+		case types.OpcodeTryTable:
+			tryTable := v.Args.(*types.TryTable)
+			blockType := tools.ParseBlockSig(tryTable.BlockType, types_)
+			fmt.Printf("%stry_table %s %v\n", indent, blockType, tryTable.Catches)
+			dumpExpr(indent+"  ", types_, tryTable.Instructions, locals)
 			fmt.Printf("%send\n", indent)
+		case types.OpcodeLocalGet, types.OpcodeLocalSet, types.OpcodeLocalTee:
+			idx := v.Args.(uint32)
+			if name, ok := locals[idx]; ok {
+				fmt.Printf("%s%s %d <%s>\n", indent, v.GetOpname(), idx, name)
+			} else {
+				fmt.Printf("%s%s %d\n", indent, v.GetOpname(), idx)
+			}
 		default:
 			if v.Args != nil {
 				fmt.Printf("%s%s %v\n", indent, v.GetOpname(), v.Args)
@@ -118,39 +142,45 @@ func dumpExpr(indent string, types_ []types.FuncType, expr types.Expr) {
 	}
 }
 
-func dumpFunctions(funcs []types.TypeIdx, offset int) {
+func dumpFunctions(funcs []types.TypeIdx, offset int, names types.NameMap) {
 	fmt.Printf("Function[%d]:\n", len(funcs))
 	for i, v := range funcs {
-		fmt.Printf("  func[%d]: sig=%d\n", offset+i, v)
+		fmt.Printf("  %s: sig=%d\n", types.DescribeIdx("func", uint32(offset+i), names), v)
 	}
 }
 
-func dumpGlobals(globals []types.Global, offset int) {
+func dumpGlobals(globals []types.Global, offset int, names types.NameMap) {
 	fmt.Printf("Global[%d]:\n", len(globals))
 	for i, g := range globals {
-		fmt.Printf("  global[%d]: %s\n", offset+i, g.Type)
+		fmt.Printf("  %s: %s\n", types.DescribeIdx("global", uint32(offset+i), names), g.Type)
 	}
 }
 
-func dumpImports(imports []types.Import) (*ImportCounts, error) {
+func dumpImports(imports []types.Import, names types.Names) (*ImportCounts, error) {
 	fmt.Printf("Import[%d]:\n", len(imports))
 
 	var out ImportCounts
 	for _, v := range imports {
 		switch v.Description.Tag {
 		case types.PortTagFunc:
-			fmt.Printf("  func[%d]: %s.%s, sig=%d\n", out.Func, v.Module, v.Name, v.Description.Func)
+			fmt.Printf("  %s: %s.%s, sig=%d\n",
+				names.DescribeFunc(uint32(out.Func)), v.Module, v.Name, v.Description.Func)
 			out.Func++
 		case types.PortTagTable:
-			fmt.Printf("  table[%d]: %s.%s, %s\n",
-				out.Table, v.Module, v.Name, v.Description.Table.Limits)
+			fmt.Printf("  %s: %s.%s, %s\n", types.DescribeIdx("table", uint32(out.Table), names.Tables),
+				v.Module, v.Name, v.Description.Table.Limits)
 			out.Table++
 		case types.PortTagMemory:
-			fmt.Printf("  memory[%d]: %s.%s, %s\n", out.Memory, v.Module, v.Name, v.Description.Memory)
+			fmt.Printf("  %s: %s.%s, %s\n", types.DescribeIdx("memory", uint32(out.Memory), names.Memories),
+				v.Module, v.Name, v.Description.Memory)
 			out.Memory++
 		case types.PortTagGlobal:
-			fmt.Printf("  global[%d]: %s.%s, %s\n", out.Global, v.Module, v.Name, v.Description.Global)
+			fmt.Printf("  %s: %s.%s, %s\n",
+				names.DescribeGlobal(uint32(out.Global)), v.Module, v.Name, v.Description.Global)
 			out.Global++
+		case types.PortTagTag:
+			fmt.Printf("  tag[%d]: %s.%s, sig=%d\n", out.Tag, v.Module, v.Name, v.Description.TagType.Type)
+			out.Tag++
 		default:
 			return nil, fmt.Errorf("unknown tag: %02x", v.Description.Tag)
 		}
@@ -159,30 +189,37 @@ func dumpImports(imports []types.Import) (*ImportCounts, error) {
 	return &out, nil
 }
 
-func dumpMemories(memories []types.Memory, offset int) {
+func dumpMemories(memories []types.Memory, offset int, names types.NameMap) {
 	fmt.Printf("Memory[%d]:\n", len(memories))
 	for i, limits := range memories {
-		fmt.Printf("  memory[%d]: %s\n", offset+i, limits)
+		fmt.Printf("  %s: %s\n", types.DescribeIdx("memory", uint32(offset+i), names), limits)
 	}
 }
 
-func dumpStart(start *uint32) {
+func dumpStart(start *uint32, names types.NameMap) {
 	fmt.Printf("Start:\n")
 	if start != nil {
-		fmt.Printf("  func=%d\n", start)
+		fmt.Printf("  %s\n", types.DescribeIdx("func", *start, names))
 	}
 }
 
-func dumpTables(tables []types.Table, offset int) {
+func dumpTables(tables []types.Table, offset int, names types.NameMap) {
 	fmt.Printf("Table[%d]:\n", len(tables))
 	for i, t := range tables {
-		fmt.Printf("  table[%d]: %s\n", offset+i, t.Limits)
+		fmt.Printf("  %s: %s\n", types.DescribeIdx("table", uint32(offset+i), names), t.Limits)
+	}
+}
+
+func dumpTags(tags []types.Tag, offset int) {
+	fmt.Printf("Tag[%d]:\n", len(tags))
+	for i, v := range tags {
+		fmt.Printf("  tag[%d]: sig=%d\n", offset+i, v.Type)
 	}
 }
 
-func dumpTypes(types []types.FuncType) {
-	fmt.Printf("Type[%d]:\n", len(types))
-	for i, ft := range types {
-		fmt.Printf("  type[%d]: %s\n", i, ft)
+func dumpTypes(types_ []types.FuncType, names types.NameMap) {
+	fmt.Printf("Type[%d]:\n", len(types_))
+	for i, ft := range types_ {
+		fmt.Printf("  %s: %s\n", types.DescribeIdx("type", uint32(i), names), ft)
 	}
 }
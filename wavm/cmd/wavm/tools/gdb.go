@@ -0,0 +1,46 @@
+package tools
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/gdbstub"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// GDBDefaultAddr is the default local address serving the GDB remote protocol.
+const GDBDefaultAddr = "127.0.0.1:1234"
+
+// ServeGDB debugs the main func of module decoded from path by the GDB remote protocol over the
+// 1st TCP connection accepted at addr.
+func ServeGDB(module *wavm.Module, vmOpts vm.Options, path, addr string) error {
+	binary, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read module: %w", err)
+	}
+
+	externalModules := map[string]linker.Module{"env": fakeEnv(os.Stdout)}
+	m, err := vm.NewVMWithOptions(module, externalModules, vmOpts)
+	if err != nil {
+		return fmt.Errorf("build VM: %w", err)
+	}
+	defer m.(*vm.VM).Close()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "GDB server listening at %s\n", l.Addr())
+
+	conn, err := l.Accept()
+	if err != nil {
+		return fmt.Errorf("accept: %w", err)
+	}
+	defer conn.Close()
+
+	return gdbstub.NewServer(module, binary).Serve(conn, m.(*vm.VM), "main")
+}
@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// ProfileMainFunc runs the main func of module as InstantiateAndExecMainFunc, and writes its
+// profile in pprof to pprofPath, and as folded stacks to foldedPath if not empty.
+func ProfileMainFunc(module *wavm.Module, vmOpts vm.Options, pprofPath, foldedPath string,
+	opts vm.ProfileOptions) error {
+	externalModules := map[string]linker.Module{"env": fakeEnv(os.Stdout)}
+	m, err := vm.NewVMWithOptions(module, externalModules, vmOpts)
+	if err != nil {
+		return fmt.Errorf("build VM: %w", err)
+	}
+	defer m.(*vm.VM).Close()
+
+	p := vm.NewProfiler(m.(*vm.VM), opts)
+	_, err = m.InvokeFunc("main")
+	p.Stop()
+
+	if err := writeProfile(pprofPath, p.WritePprof); err != nil {
+		return fmt.Errorf("write pprof profile: %w", err)
+	}
+	if foldedPath != "" {
+		if err := writeProfile(foldedPath, p.WriteFolded); err != nil {
+			return fmt.Errorf("write folded stacks: %w", err)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("invoke func 'main': %w", err)
+	}
+	return nil
+}
+
+func writeProfile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
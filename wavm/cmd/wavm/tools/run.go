@@ -2,6 +2,8 @@ package tools
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/sammyne/mastering-wasm/wavm"
 	"github.com/sammyne/mastering-wasm/wavm/linker"
@@ -9,13 +11,14 @@ import (
 	"github.com/sammyne/mastering-wasm/wavm/vm"
 )
 
-func InstantiateAndExecMainFunc(module *wavm.Module) error {
-	externalModules := map[string]linker.Module{"env": fakeEnv()}
+func InstantiateAndExecMainFunc(module *wavm.Module, opts vm.Options) error {
+	externalModules := map[string]linker.Module{"env": fakeEnv(os.Stdout)}
 
-	m, err := vm.NewVM(module, externalModules)
+	m, err := vm.NewVMWithOptions(module, externalModules, opts)
 	if err != nil {
 		return fmt.Errorf("build VM: %w", err)
 	}
+	defer m.(*vm.VM).Close()
 
 	if _, err := m.InvokeFunc("main"); err != nil {
 		return fmt.Errorf("invoke func 'main': %w", err)
@@ -24,9 +27,10 @@ func InstantiateAndExecMainFunc(module *wavm.Module) error {
 	return nil
 }
 
-func fakeEnv() linker.Module {
+// fakeEnv makes the env module, where print_char writes to stdout.
+func fakeEnv(stdout io.Writer) linker.Module {
 	env := native.NewModule()
-	env.RegisterFunc("print_char(i32)->()", printChar)
+	env.RegisterFunc("print_char(i32)->()", newPrintChar(stdout))
 	env.RegisterFunc("assert_true(i32)->()", assertTrue)
 	env.RegisterFunc("assert_false(i32)->()", assertFalse)
 	env.RegisterFunc("assert_eq_i32(i32,i32)->()", assertEqI32)
@@ -36,9 +40,11 @@ func fakeEnv() linker.Module {
 	return env
 }
 
-func printChar(args []interface{}) ([]interface{}, error) {
-	fmt.Printf("%c", args[0].(int32))
-	return nil, nil
+func newPrintChar(stdout io.Writer) native.GoFunc {
+	return func(args []interface{}) ([]interface{}, error) {
+		fmt.Fprintf(stdout, "%c", args[0].(int32))
+		return nil, nil
+	}
 }
 
 func assertTrue(args []interface{}) ([]interface{}, error) {
@@ -0,0 +1,44 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// TraceMainFunc runs the main func of module as InstantiateAndExecMainFunc, and writes a record of
+// every executed instruction to the file at path, or stderr if path is "-".
+func TraceMainFunc(module *wavm.Module, vmOpts vm.Options, path string,
+	opts vm.TraceOptions) (err error) {
+	out := os.Stderr
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return fmt.Errorf("create trace file: %w", err)
+		}
+		defer func() {
+			if closeErr := out.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("close trace file: %w", closeErr)
+			}
+		}()
+	}
+
+	externalModules := map[string]linker.Module{"env": fakeEnv(os.Stdout)}
+	m, err := vm.NewVMWithOptions(module, externalModules, vmOpts)
+	if err != nil {
+		return fmt.Errorf("build VM: %w", err)
+	}
+	defer m.(*vm.VM).Close()
+
+	t := vm.NewTracer(m.(*vm.VM), out, opts)
+	_, err = m.InvokeFunc("main")
+	if flushErr := t.Detach(); flushErr != nil && err == nil {
+		return fmt.Errorf("write trace: %w", flushErr)
+	} else if err != nil {
+		return fmt.Errorf("invoke func 'main': %w", err)
+	}
+
+	return nil
+}
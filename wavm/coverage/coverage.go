@@ -0,0 +1,203 @@
+// Package coverage reports coverage recorded by vm.Coverage as lcov or HTML.
+package coverage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/debuginfo"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// Report is coverage of functions defined by a module, whose lines are of sources by DWARF if the
+// module carries it, or of the instruction listing of the module otherwise.
+type Report struct {
+	module   *wavm.Module
+	info     *debuginfo.Info // nil if the module carries no DWARF
+	counts   map[types.FuncIdx]map[uint32]uint64
+	branches map[types.FuncIdx]map[uint32][]uint64
+	listing  *listing
+}
+
+// HasDebugInfo tells whether lines are of sources by DWARF.
+func (r *Report) HasDebugInfo() bool {
+	return r.info != nil
+}
+
+// WriteHTML writes the instruction listing as a HTML page, where instructions are marked executed or not
+// with their counts and outcomes of branches.
+func (r *Report) WriteHTML(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	title := html.EscapeString(describeModule(r.module))
+	fmt.Fprintf(bw, htmlHeader, title, title)
+
+	var funcs, funcsHit, instrs, instrsHit, branches, branchesHit int
+	for _, v := range r.listing.funcs {
+		funcs++
+		if r.counts[v.idx] != nil {
+			funcsHit++
+		}
+	}
+	for _, v := range r.listing.lines {
+		if v.instr == nil {
+			continue
+		}
+		instrs++
+		if r.count(v) > 0 {
+			instrsHit++
+		}
+		for _, n := range r.outcomes(v) {
+			branches++
+			if n > 0 {
+				branchesHit++
+			}
+		}
+	}
+	fmt.Fprintf(bw, "<p>functions: %s, instructions: %s, branches: %s</p>\n<pre>\n",
+		formatRatio(funcsHit, funcs), formatRatio(instrsHit, instrs), formatRatio(branchesHit, branches))
+
+	for _, v := range r.listing.lines {
+		class, count := "none", ""
+		if v.instr != nil {
+			n := r.count(v)
+			class, count = "miss", fmt.Sprint(n)
+			if n > 0 {
+				class = "hit"
+			}
+		}
+
+		text := html.EscapeString(v.text)
+		if outcomes := r.outcomes(v); outcomes != nil {
+			text += fmt.Sprintf(` <span class="branch">;; outcomes %v</span>`, outcomes)
+		}
+		fmt.Fprintf(bw, "<span class=\"%s\">%10s  %s</span>\n", class, count, text)
+	}
+	bw.WriteString(htmlFooter)
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// WriteLCOV writes the report as lcov tracefiles, where lines without DWARF are of the instruction
+// listing at listingPath.
+func (r *Report) WriteLCOV(w io.Writer, listingPath string) error {
+	files := make(map[string]*lcovFile)
+	getFile := func(path string) *lcovFile {
+		if files[path] == nil {
+			files[path] = &lcovFile{lines: make(map[int]uint64)}
+		}
+		return files[path]
+	}
+
+	for _, fn := range r.listing.funcs {
+		var fnFile string
+		var fnLine int
+		var fnCount uint64
+		for i := fn.start; i < fn.end; i++ {
+			v := r.listing.lines[i]
+			if v.instr == nil {
+				continue
+			}
+
+			path, line, fnPath, fnL, ok := r.locate(v, i+1, listingPath)
+			if !ok {
+				continue
+			}
+			if fnFile == "" {
+				fnFile, fnLine, fnCount = fnPath, fnL, r.count(v)
+			}
+
+			f := getFile(path)
+			if n, seen := f.lines[line]; !seen || r.count(v) > n {
+				f.lines[line] = r.count(v)
+			}
+			if outcomes := r.outcomes(v); outcomes != nil {
+				f.branches = append(f.branches, lcovBranch{line: line, executed: r.count(v) > 0,
+					outcomes: outcomes})
+			}
+		}
+
+		if fnFile != "" {
+			f := getFile(fnFile)
+			f.funcs = append(f.funcs, lcovFunc{name: r.module.Names.DescribeFunc(fn.idx), line: fnLine,
+				count: fnCount})
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for v := range files {
+		paths = append(paths, v)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	for _, v := range paths {
+		files[v].write(bw, v)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// WriteListing writes the instruction listing, whose lines are referred by lcov without DWARF. It's
+// annotated text resembling WAT, which isn't meant to be parsed as such.
+func (r *Report) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, v := range r.listing.lines {
+		bw.WriteString(v.text)
+		bw.WriteByte('\n')
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+// NewReport makes the report of c recorded for a VM of module, which fails on malformed DWARF.
+func NewReport(module *wavm.Module, c *vm.Coverage) (*Report, error) {
+	info, err := debuginfo.New(module)
+	if errors.Is(err, debuginfo.ErrNoDebugInfo) {
+		info = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load debug info: %w", err)
+	}
+
+	out := &Report{
+		module:   module,
+		info:     info,
+		counts:   c.Counts(),
+		branches: c.Branches(),
+		listing:  newListing(module),
+	}
+	return out, nil
+}
+
+func describeModule(m *wavm.Module) string {
+	if m.Names.Module != "" {
+		return m.Names.Module
+	}
+
+	return "module"
+}
+
+func formatRatio(hit, total int) string {
+	if total == 0 {
+		return "0/0"
+	}
+
+	return fmt.Sprintf("%d/%d (%.1f%%)", hit, total, 100*float64(hit)/float64(total))
+}
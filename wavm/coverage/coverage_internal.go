@@ -0,0 +1,256 @@
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+const htmlHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>coverage of %s</title>
+<style>
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+.none { color: #888; }
+.branch { color: #55a; }
+</style>
+</head>
+<body>
+<h1>coverage of %s</h1>
+`
+
+const htmlFooter = `</pre>
+</body>
+</html>
+`
+
+type lcovBranch struct {
+	line     int
+	executed bool // false if the branch instruction never executes
+	outcomes []uint64
+}
+
+// lcovFile is coverage of a source file, where lines are executions of instrumented lines.
+type lcovFile struct {
+	lines    map[int]uint64
+	funcs    []lcovFunc
+	branches []lcovBranch
+}
+
+type lcovFunc struct {
+	name  string
+	line  int
+	count uint64 // calls
+}
+
+// listing is the instruction listing of functions defined by a module, one instruction per line in
+// the text format under headers annotating the functions.
+type listing struct {
+	lines []listingLine
+	funcs []listingFunc
+}
+
+// listingFunc is a function of a listing, whose lines are [start, end) of the listing.
+type listingFunc struct {
+	idx        types.FuncIdx
+	start, end int
+}
+
+// listingLine is a line of a listing, where instr is nil for lines not of instructions.
+type listingLine struct {
+	text    string
+	funcIdx types.FuncIdx
+	instr   *types.Instruction
+}
+
+func (f *lcovFile) write(w io.Writer, path string) {
+	fmt.Fprintf(w, "TN:\nSF:%s\n", path)
+
+	var funcsHit int
+	for _, v := range f.funcs {
+		fmt.Fprintf(w, "FN:%d,%s\n", v.line, v.name)
+	}
+	for _, v := range f.funcs {
+		fmt.Fprintf(w, "FNDA:%d,%s\n", v.count, v.name)
+		if v.count > 0 {
+			funcsHit++
+		}
+	}
+	fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(f.funcs), funcsHit)
+
+	var branches, branchesHit int
+	blocks := make(map[int]int) // branch instructions seen by lines
+	for _, v := range f.branches {
+		block := blocks[v.line]
+		blocks[v.line]++
+		for i, n := range v.outcomes {
+			taken := "-"
+			if v.executed {
+				taken = fmt.Sprint(n)
+			}
+			fmt.Fprintf(w, "BRDA:%d,%d,%d,%s\n", v.line, block, i, taken)
+
+			branches++
+			if n > 0 {
+				branchesHit++
+			}
+		}
+	}
+	fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", branches, branchesHit)
+
+	lines := make([]int, 0, len(f.lines))
+	for v := range f.lines {
+		lines = append(lines, v)
+	}
+	sort.Ints(lines)
+
+	var linesHit int
+	for _, v := range lines {
+		fmt.Fprintf(w, "DA:%d,%d\n", v, f.lines[v])
+		if f.lines[v] > 0 {
+			linesHit++
+		}
+	}
+	fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lines), linesHit)
+}
+
+func (l *listing) addExpr(m *wavm.Module, funcIdx types.FuncIdx, indent string, expr types.Expr) {
+	for i := range expr {
+		instr := &expr[i]
+
+		var nested []types.Expr
+		var text string
+		switch args := instr.Args.(type) {
+		case *types.Block:
+			text, nested = formatBlockType(m, args.BlockType), []types.Expr{args.Instructions}
+		case *types.BlockIf:
+			text, nested = formatBlockType(m, args.BlockType), []types.Expr{args.Instructions1}
+			if len(args.Instructions2) > 0 {
+				nested = append(nested, args.Instructions2)
+			}
+		case *types.TryTable:
+			text = fmt.Sprintf("%s %v", formatBlockType(m, args.BlockType), args.Catches)
+			nested = []types.Expr{args.Instructions}
+		case *types.BreakTable:
+			text = fmt.Sprintf("%v %d", args.Labels, args.Default)
+		case nil:
+		default:
+			text = fmt.Sprint(args)
+		}
+		if text != "" {
+			text = " " + text
+		}
+
+		l.lines = append(l.lines,
+			listingLine{text: indent + instr.GetOpname() + text, funcIdx: funcIdx, instr: instr})
+		if instr.Opcode == types.OpcodeLocalGet || instr.Opcode == types.OpcodeLocalSet ||
+			instr.Opcode == types.OpcodeLocalTee {
+			if idx, ok := instr.Args.(uint32); ok {
+				l.lines[len(l.lines)-1].text += " ;; " + m.Names.DescribeLocal(funcIdx, idx)
+			}
+		}
+
+		if nested == nil {
+			continue
+		}
+		for j, v := range nested {
+			if j > 0 {
+				l.lines = append(l.lines, listingLine{text: indent + "else", funcIdx: funcIdx})
+			}
+			l.addExpr(m, funcIdx, indent+"  ", v)
+		}
+		l.lines = append(l.lines, listingLine{text: indent + "end", funcIdx: funcIdx})
+	}
+}
+
+func (r *Report) count(l listingLine) uint64 {
+	return r.counts[l.funcIdx][l.instr.Offset]
+}
+
+// locate locates the instruction of the listing line at listingLine in sources by DWARF, or in the
+// listing at listingPath without DWARF, which returns paths and lines of the instruction, then
+// those of the function it's compiled into. The instruction is unknown to DWARF if not ok.
+func (r *Report) locate(l listingLine, listingLine int,
+	listingPath string) (string, int, string, int, bool) {
+	if r.info == nil {
+		return listingPath, listingLine, listingPath, listingLine, true
+	}
+
+	locs := r.info.Lookup(l.instr.Offset)
+	if len(locs) == 0 {
+		return "", 0, "", 0, false
+	}
+
+	last := locs[len(locs)-1]
+	return locs[0].File, locs[0].Line, last.File, last.Line, true
+}
+
+// outcomes returns counts of outcomes if l is of a branch instruction, which are all 0 if it never
+// executes.
+func (r *Report) outcomes(l listingLine) []uint64 {
+	if l.instr == nil {
+		return nil
+	}
+
+	var n int
+	switch l.instr.Opcode {
+	case types.OpcodeIf, types.OpcodeBrIf:
+		n = 2
+	case types.OpcodeBrTable:
+		table, ok := l.instr.Args.(*types.BreakTable)
+		if !ok {
+			return nil
+		}
+		n = len(table.Labels) + 1
+	default:
+		return nil
+	}
+
+	if out := r.branches[l.funcIdx][l.instr.Offset]; out != nil {
+		return out
+	}
+	return make([]uint64, n)
+}
+
+func formatBlockType(m *wavm.Module, t types.BlockType) string {
+	if t == types.BlockTypeEmpty {
+		return ""
+	}
+
+	return tools.ParseBlockSig(t, m.Types).String()
+}
+
+func newListing(m *wavm.Module) *listing {
+	var nImportedFuncs int
+	for _, v := range m.Imports {
+		if v.Description.Tag == types.PortTagFunc {
+			nImportedFuncs++
+		}
+	}
+
+	out := &listing{}
+	for i, v := range m.Codes {
+		funcIdx := types.FuncIdx(nImportedFuncs + i)
+		f := listingFunc{idx: funcIdx, start: len(out.lines)}
+
+		header := fmt.Sprintf("(func %s", m.Names.DescribeFunc(funcIdx))
+		if i < len(m.Functions) {
+			header += fmt.Sprintf(" (type %d)", m.Functions[i])
+		}
+		out.lines = append(out.lines, listingLine{text: header, funcIdx: funcIdx})
+		out.addExpr(m, funcIdx, "  ", v.Expr)
+		out.lines = append(out.lines, listingLine{text: ")", funcIdx: funcIdx})
+
+		f.end = len(out.lines)
+		out.funcs = append(out.funcs, f)
+	}
+
+	return out
+}
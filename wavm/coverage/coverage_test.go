@@ -0,0 +1,135 @@
+package coverage_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/coverage"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestReport(t *testing.T) {
+	r := newTestReport(t)
+	if r.HasDebugInfo() {
+		t.Fatal("expect no debug info")
+	}
+
+	var listing bytes.Buffer
+	if err := r.WriteListing(&listing); err != nil {
+		t.Fatalf("write listing: %v", err)
+	}
+	expect := strings.Join([]string{
+		"(func func[0] (type 0)",
+		"  local.get 0 ;; local[0]",
+		"  i32.const 0",
+		"  i32.lt_s",
+		"  if ()->(i32)",
+		"    i32.const 0",
+		"    local.get 0 ;; local[0]",
+		"    i32.sub",
+		"  else",
+		"    local.get 0 ;; local[0]",
+		"  end",
+		")",
+		"(func func[1] (type 1)",
+		"  nop",
+		")",
+		"",
+	}, "\n")
+	if listing.String() != expect {
+		t.Fatalf("expect listing\n%s\ngot\n%s", expect, listing.String())
+	}
+
+	// lines are of the listing
+	var lcov bytes.Buffer
+	if err := r.WriteLCOV(&lcov, "abs.wat"); err != nil {
+		t.Fatalf("write lcov: %v", err)
+	}
+	expect = strings.Join([]string{
+		"TN:",
+		"SF:abs.wat",
+		"FN:2,func[0]",
+		"FN:14,func[1]",
+		"FNDA:2,func[0]",
+		"FNDA:0,func[1]",
+		"FNF:2",
+		"FNH:1",
+		"BRDA:5,0,0,0",
+		"BRDA:5,0,1,2",
+		"BRF:2",
+		"BRH:1",
+		"DA:2,2",
+		"DA:3,2",
+		"DA:4,2",
+		"DA:5,2",
+		"DA:6,0",
+		"DA:7,0",
+		"DA:8,0",
+		"DA:10,2",
+		"DA:14,0",
+		"LF:9",
+		"LH:5",
+		"end_of_record",
+		"",
+	}, "\n")
+	if lcov.String() != expect {
+		t.Fatalf("expect lcov\n%s\ngot\n%s", expect, lcov.String())
+	}
+
+	var html bytes.Buffer
+	if err := r.WriteHTML(&html); err != nil {
+		t.Fatalf("write html: %v", err)
+	}
+	for _, v := range []string{
+		"<p>functions: 1/2 (50.0%), instructions: 5/9 (55.6%), branches: 1/2 (50.0%)</p>",
+		`<span class="hit">         2    if ()-&gt;(i32) <span class="branch">;; outcomes [0 2]</span></span>`,
+		`<span class="miss">         0      i32.sub</span>`,
+	} {
+		if !strings.Contains(html.String(), v) {
+			t.Fatalf("expect '%s' in\n%s", v, html.String())
+		}
+	}
+}
+
+// newTestReport reports coverage of abs(4) and abs(5), leaving nop uncalled.
+func newTestReport(t *testing.T) *coverage.Report {
+	t.Helper()
+
+	const i32 = 0x7F
+	buf := wasmtest.Module(
+		wasmtest.Section(1, wasmtest.Vec(wasmtest.FuncType([]byte{i32}, []byte{i32}),
+			wasmtest.FuncType(nil, nil))),
+		wasmtest.Section(3, wasmtest.Vec([]byte{0}, []byte{1})),
+		wasmtest.Section(7, wasmtest.Vec(wasmtest.Export("abs", 0, 0))),
+		wasmtest.Section(10, wasmtest.Vec(
+			wasmtest.Code(nil, 0x20, 0, 0x41, 0, 0x48, 0x04, i32, 0x41, 0, 0x20, 0, 0x6B, 0x05, 0x20, 0,
+				0x0B),
+			wasmtest.Code(nil, 0x01))),
+	)
+
+	module, err := wavm.NewDecoder(buf).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	m, err := vm.NewVM(module, nil)
+	if err != nil {
+		t.Fatalf("new VM: %v", err)
+	}
+
+	c := vm.NewCoverage(m.(*vm.VM))
+	for _, v := range []int32{4, 5} {
+		if got, err := m.InvokeFunc("abs", v); err != nil || got[0] != v {
+			t.Fatalf("abs(%d): expect %d, got %v, %v", v, v, got, err)
+		}
+	}
+
+	out, err := coverage.NewReport(module, c)
+	if err != nil {
+		t.Fatalf("new report: %v", err)
+	}
+
+	return out
+}
@@ -0,0 +1,116 @@
+// Package dap implements a server of the Debug Adapter Protocol for debugging wasm guests, see
+// https://microsoft.github.io/debug-adapter-protocol/specification.
+package dap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/debuginfo"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// Server serves a session debugging the entry func of a module on a VM as its only thread, which
+// sets source breakpoints, steps by lines and reads source variables if the module carries DWARF.
+type Server struct {
+	module *wavm.Module
+	info   *debuginfo.Info // nil if the module carries no DWARF
+
+	wMu sync.Mutex // guards w and seq
+	w   io.Writer
+	seq int
+
+	vm          *vm.VM
+	entry       string
+	debugger    *vm.Debugger
+	resume      chan vm.Action
+	done        chan struct{} // closed after the entry func returns
+	stopOnEntry bool
+	// deferred are called after responding to the current request.
+	deferred []func()
+	// frames are of the current stop, which are built on demand.
+	frames []frame
+
+	// breakpoints of the debugger, which are only changed through withDebugger.
+	breakpoints            map[vm.Breakpoint]struct{}
+	sourceBreakpoints      map[string][]vm.Breakpoint // by paths of sources
+	instructionBreakpoints []vm.Breakpoint
+
+	mu             sync.Mutex // guards fields below
+	running        bool
+	stopped        bool
+	pending        []func() // to call on the goroutine running the VM on its next stop
+	pauseRequested bool
+	aborted        bool
+
+	// owned by the goroutine running the VM, or by the one resuming it
+	action vm.Action
+	step   *lineStep
+}
+
+// Output returns a writer sending output of the guest to the client, which is discarded until
+// serving.
+func (s *Server) Output() io.Writer {
+	return outputWriter{s: s}
+}
+
+// Serve serves a session over rw, which runs the entry func of m once the client finishes
+// configuration, and returns after the client disconnects. The guest is aborted if running then.
+func (s *Server) Serve(rw io.ReadWriter, m *vm.VM, entry string) error {
+	s.wMu.Lock()
+	s.w = rw
+	s.wMu.Unlock()
+
+	s.vm, s.entry = m, entry
+	s.debugger = vm.NewDebugger(m, s.onStop)
+	defer s.abort()
+
+	r := bufio.NewReader(rw)
+	for {
+		req, err := readRequest(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read request: %w", err)
+		}
+
+		body, err := s.handle(req)
+		if err := s.respond(req, body, err); err != nil {
+			return fmt.Errorf("respond %s: %w", req.Command, err)
+		}
+
+		deferred := s.deferred
+		s.deferred = nil
+		for _, f := range deferred {
+			f()
+		}
+
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+// NewServer creates a server debugging module, which fails on malformed DWARF.
+func NewServer(module *wavm.Module) (*Server, error) {
+	info, err := debuginfo.New(module)
+	if errors.Is(err, debuginfo.ErrNoDebugInfo) {
+		info = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load debug info: %w", err)
+	}
+
+	out := &Server{
+		module:            module,
+		info:              info,
+		resume:            make(chan vm.Action),
+		done:              make(chan struct{}),
+		breakpoints:       make(map[vm.Breakpoint]struct{}),
+		sourceBreakpoints: make(map[string][]vm.Breakpoint),
+	}
+	return out, nil
+}
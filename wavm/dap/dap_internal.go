@@ -0,0 +1,740 @@
+package dap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sammyne/mastering-wasm/wavm/debuginfo"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// threadID is of the only thread running the entry func.
+const threadID = 1
+
+// Kinds of scopes of frames, whose variables references are frame IDs times scopesPerFrame plus
+// kinds.
+const (
+	scopeLocals = iota
+	scopeSourceVariables
+	scopeOperands
+	scopeGlobals
+	scopesPerFrame
+)
+
+var (
+	errNotStopped         = errors.New("not stopped")
+	errUnsupportedCommand = errors.New("unsupported command")
+)
+
+// frame is a frame of the stack trace, which is an inlined function of a wasm frame if inlined > 0.
+type frame struct {
+	wasmIdx int // index of the wasm frame as vm.Debugger.Frames
+	wasm    vm.StackFrame
+	inlined int
+	loc     debuginfo.Location // empty without DWARF
+}
+
+// frameState adapts a wasm frame stopped in a debugger to evaluate locations of variables.
+type frameState struct {
+	d      *vm.Debugger
+	locals []types.WasmVal
+}
+
+// lineStep steps instructions repeatedly until leaving the source line where it starts.
+type lineStep struct {
+	loc   debuginfo.Location
+	depth int // number of wasm frames where it starts
+}
+
+// outputWriter sends written bytes as output events.
+type outputWriter struct {
+	s *Server
+}
+
+func (s frameState) GetGlobal(idx uint32) (uint64, error) {
+	v, err := s.d.GetGlobal(idx)
+	if err != nil {
+		return 0, err
+	}
+
+	return unwrapValue(v)
+}
+
+func (s frameState) GetLocal(idx uint32) (uint64, error) {
+	if int(idx) >= len(s.locals) {
+		return 0, fmt.Errorf("local(%d): %w", idx, vm.ErrIndexOutOfBound)
+	}
+
+	return unwrapValue(s.locals[idx])
+}
+
+func (s frameState) ReadMemory(offset uint64, buf []byte) error {
+	return s.d.ReadMemory(0, offset, buf)
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.sendEvent("output", outputBody{Category: "stdout", Output: string(p)})
+	return len(p), nil
+}
+
+// abort aborts the guest if running, and waits until it returns.
+func (s *Server) abort() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.aborted = true
+	stopped := s.stopped
+	s.stopped = false
+	s.mu.Unlock()
+
+	if stopped {
+		s.resume <- vm.ActionAbort
+	} else {
+		s.debugger.Pause()
+	}
+	<-s.done
+}
+
+func (s *Server) checkStopped() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running && !s.stopped {
+		return errNotStopped
+	}
+
+	return nil
+}
+
+func (s *Server) describeError(err error) string {
+	var trap *vm.Trap
+	if !errors.As(err, &trap) {
+		return err.Error()
+	} else if s.info == nil {
+		return err.Error() + "\n" + trap.Backtrace()
+	}
+
+	return err.Error() + "\n" + trap.FormatBacktrace(func(f vm.StackFrame) []string {
+		var out []string
+		if !f.External {
+			for _, v := range s.info.Lookup(f.Offset) {
+				out = append(out, "at "+v.String())
+			}
+		}
+		return out
+	})
+}
+
+// getFrame gets the frame of id in the current stop.
+func (s *Server) getFrame(id int) (frame, error) {
+	if err := s.checkStopped(); err != nil {
+		return frame{}, err
+	}
+
+	frames := s.getFrames()
+	if id < 1 || id > len(frames) {
+		return frame{}, fmt.Errorf("unknown frame %d", id)
+	}
+
+	return frames[id-1], nil
+}
+
+// getFrames builds frames of the current stop if not yet.
+func (s *Server) getFrames() []frame {
+	if s.frames != nil {
+		return s.frames
+	}
+
+	s.frames = []frame{}
+	for i, v := range s.debugger.Frames() {
+		var locs []debuginfo.Location
+		if s.info != nil && !v.External {
+			locs = s.info.Lookup(v.Offset)
+		}
+		if len(locs) == 0 {
+			s.frames = append(s.frames, frame{wasmIdx: i, wasm: v})
+		}
+		for j, loc := range locs {
+			s.frames = append(s.frames, frame{wasmIdx: i, wasm: v, inlined: j, loc: loc})
+		}
+	}
+
+	return s.frames
+}
+
+func (s *Server) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return s.handleInitialize()
+	case "launch", "attach":
+		return s.handleLaunch(req.Arguments)
+	case "configurationDone":
+		return s.handleConfigurationDone()
+	case "setBreakpoints":
+		return s.handleSetBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		return s.handleSetInstructionBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		return breakpointsBody{Breakpoints: []breakpoint{}}, nil
+	case "threads":
+		return threadsBody{Threads: []thread{{ID: threadID, Name: s.entry}}}, nil
+	case "stackTrace":
+		return s.handleStackTrace(req.Arguments)
+	case "scopes":
+		return s.handleScopes(req.Arguments)
+	case "variables":
+		return s.handleVariables(req.Arguments)
+	case "readMemory":
+		return s.handleReadMemory(req.Arguments)
+	case "continue":
+		return nil, s.resumeWith(vm.ActionContinue, nil)
+	case "next", "stepIn", "stepOut":
+		return s.handleStep(req.Command, req.Arguments)
+	case "pause":
+		return nil, s.pause()
+	case "disconnect", "terminate":
+		s.deferred = append(s.deferred, s.abort)
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", req.Command, errUnsupportedCommand)
+	}
+}
+
+func (s *Server) handleConfigurationDone() (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return nil, errors.New("already running")
+	}
+	s.running = true
+	if s.stopOnEntry {
+		s.pauseRequested = true
+		s.debugger.Pause()
+	}
+
+	s.deferred = append(s.deferred, func() { go s.run() })
+	return nil, nil
+}
+
+func (s *Server) handleInitialize() (interface{}, error) {
+	s.deferred = append(s.deferred, func() { s.sendEvent("initialized", nil) })
+
+	out := capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsInstructionBreakpoints:   true,
+		SupportsReadMemoryRequest:        true,
+		SupportsSteppingGranularity:      true,
+	}
+	return out, nil
+}
+
+func (s *Server) handleLaunch(args json.RawMessage) (interface{}, error) {
+	var a launchArguments
+	if err := unmarshalArguments(args, &a); err != nil {
+		return nil, err
+	}
+
+	s.stopOnEntry = a.StopOnEntry
+	return nil, nil
+}
+
+func (s *Server) handleReadMemory(args json.RawMessage) (interface{}, error) {
+	var a readMemoryArguments
+	if err := unmarshalArguments(args, &a); err != nil {
+		return nil, err
+	}
+
+	base, err := strconv.ParseUint(a.MemoryReference, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad memory reference '%s': %w", a.MemoryReference, err)
+	} else if a.Count < 0 {
+		return nil, fmt.Errorf("bad count %d", a.Count)
+	}
+	addr := base + uint64(a.Offset)
+
+	if err := s.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	out := readMemoryBody{Address: fmt.Sprintf("0x%x", addr)}
+	buf := make([]byte, a.Count)
+	if err := s.debugger.ReadMemory(0, addr, buf); err != nil {
+		out.UnreadableBytes = a.Count
+		return out, nil
+	}
+	out.Data = base64.StdEncoding.EncodeToString(buf)
+
+	return out, nil
+}
+
+func (s *Server) handleScopes(args json.RawMessage) (interface{}, error) {
+	var a scopesArguments
+	if err := unmarshalArguments(args, &a); err != nil {
+		return nil, err
+	}
+
+	f, err := s.getFrame(a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := a.FrameID * scopesPerFrame
+	out := scopesBody{Scopes: []scope{
+		{Name: "Locals", PresentationHint: "locals", VariablesReference: ref + scopeLocals},
+	}}
+	if s.info != nil && f.inlined == 0 && len(s.info.GetVariables(f.wasm.Offset)) > 0 {
+		out.Scopes = append(out.Scopes,
+			scope{Name: "Source Variables", VariablesReference: ref + scopeSourceVariables})
+	}
+	if f.wasmIdx == 0 {
+		out.Scopes = append(out.Scopes,
+			scope{Name: "Operand Stack", VariablesReference: ref + scopeOperands})
+	}
+	out.Scopes = append(out.Scopes,
+		scope{Name: "Globals", VariablesReference: ref + scopeGlobals, Expensive: true})
+
+	return out, nil
+}
+
+func (s *Server) handleSetBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a setBreakpointsArguments
+	if err := unmarshalArguments(args, &a); err != nil {
+		return nil, err
+	}
+
+	out := breakpointsBody{Breakpoints: make([]breakpoint, len(a.Breakpoints))}
+	var bps []vm.Breakpoint
+	var owners []int // indices of source breakpoints owning bps
+	for i, v := range a.Breakpoints {
+		out.Breakpoints[i] = breakpoint{Source: &a.Source, Line: v.Line, Message: "no code at the line"}
+		if s.info == nil {
+			out.Breakpoints[i].Message = debuginfo.ErrNoDebugInfo.Error()
+			continue
+		}
+
+		for _, offset := range s.info.LookupLine(a.Source.Path, v.Line) {
+			if funcIdx, ok := s.module.GetFuncIdxByOffset(offset); ok {
+				bps = append(bps, vm.Breakpoint{FuncIdx: funcIdx, Offset: offset})
+				owners = append(owners, i)
+			}
+		}
+	}
+
+	errs := s.replaceBreakpoints(s.sourceBreakpoints[a.Source.Path], bps)
+	s.sourceBreakpoints[a.Source.Path] = nil
+	for i, err := range errs {
+		if err != nil {
+			continue
+		}
+		s.sourceBreakpoints[a.Source.Path] = append(s.sourceBreakpoints[a.Source.Path], bps[i])
+		out.Breakpoints[owners[i]].Verified, out.Breakpoints[owners[i]].Message = true, ""
+	}
+
+	return out, nil
+}
+
+func (s *Server) handleSetInstructionBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a setInstructionBreakpointsArguments
+	if err := unmarshalArguments(args, &a); err != nil {
+		return nil, err
+	}
+
+	out := breakpointsBody{Breakpoints: make([]breakpoint, len(a.Breakpoints))}
+	var bps []vm.Breakpoint
+	var owners []int
+	for i, v := range a.Breakpoints {
+		out.Breakpoints[i].InstructionReference = v.InstructionReference
+
+		base, err := strconv.ParseUint(v.InstructionReference, 0, 32)
+		if err != nil {
+			out.Breakpoints[i].Message = fmt.Sprintf("bad instruction reference: %v", err)
+			continue
+		}
+		offset := uint32(int64(base) + v.Offset)
+
+		funcIdx, ok := s.module.GetFuncIdxByOffset(offset)
+		if !ok {
+			out.Breakpoints[i].Message = fmt.Sprintf("no code at 0x%x", offset)
+			continue
+		}
+		bps = append(bps, vm.Breakpoint{FuncIdx: funcIdx, Offset: offset})
+		owners = append(owners, i)
+	}
+
+	errs := s.replaceBreakpoints(s.instructionBreakpoints, bps)
+	s.instructionBreakpoints = nil
+	for i, err := range errs {
+		if err != nil {
+			out.Breakpoints[owners[i]].Message = err.Error()
+			continue
+		}
+		s.instructionBreakpoints = append(s.instructionBreakpoints, bps[i])
+		out.Breakpoints[owners[i]].Verified = true
+	}
+
+	return out, nil
+}
+
+func (s *Server) handleStackTrace(args json.RawMessage) (interface{}, error) {
+	var a stackTraceArguments
+	if err := unmarshalArguments(args, &a); err != nil {
+		return nil, err
+	}
+	if err := s.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	frames := s.getFrames()
+	out := stackTraceBody{StackFrames: []stackFrame{}, TotalFrames: len(frames)}
+	for i := a.StartFrame; i < len(frames) && (a.Levels <= 0 || i < a.StartFrame+a.Levels); i++ {
+		f := frames[i]
+		if f.wasm.External {
+			out.StackFrames = append(out.StackFrames,
+				stackFrame{ID: i + 1, Name: f.wasm.String(), PresentationHint: "label"})
+			continue
+		}
+
+		v := stackFrame{
+			ID:                          i + 1,
+			Name:                        f.loc.Func,
+			Line:                        f.loc.Line,
+			Column:                      f.loc.Column,
+			InstructionPointerReference: fmt.Sprintf("0x%x", f.wasm.Offset),
+		}
+		if v.Name == "" {
+			v.Name = s.module.Names.DescribeFunc(f.wasm.FuncIdx)
+		}
+		if f.loc.File != "" {
+			v.Source = &source{Name: filepath.Base(f.loc.File), Path: f.loc.File}
+		}
+		out.StackFrames = append(out.StackFrames, v)
+	}
+
+	return out, nil
+}
+
+func (s *Server) handleStep(command string, args json.RawMessage) (interface{}, error) {
+	var a stepArguments
+	if err := unmarshalArguments(args, &a); err != nil {
+		return nil, err
+	}
+	if err := s.checkStopped(); err != nil {
+		return nil, err
+	}
+
+	action := map[string]vm.Action{
+		"next":    vm.ActionStepOver,
+		"stepIn":  vm.ActionStepInto,
+		"stepOut": vm.ActionStepOut,
+	}[command]
+
+	var step *lineStep
+	if frames := s.getFrames(); s.info != nil && a.Granularity != "instruction" &&
+		action != vm.ActionStepOut && len(frames) > 0 && frames[0].loc.File != "" {
+		step = &lineStep{loc: frames[0].loc, depth: len(s.debugger.Frames())}
+	}
+
+	return nil, s.resumeWith(action, step)
+}
+
+func (s *Server) handleVariables(args json.RawMessage) (interface{}, error) {
+	var a variablesArguments
+	if err := unmarshalArguments(args, &a); err != nil {
+		return nil, err
+	}
+
+	f, err := s.getFrame(a.VariablesReference / scopesPerFrame)
+	if err != nil {
+		return nil, err
+	}
+
+	out := variablesBody{Variables: []variable{}}
+	switch a.VariablesReference % scopesPerFrame {
+	case scopeLocals:
+		locals, err := s.debugger.GetFrameLocals(f.wasmIdx)
+		if err != nil {
+			return nil, fmt.Errorf("get locals: %w", err)
+		}
+		for i, v := range locals {
+			name := s.module.Names.DescribeLocal(f.wasm.FuncIdx, uint32(i))
+			out.Variables = append(out.Variables, newVariable(name, v))
+		}
+	case scopeSourceVariables:
+		locals, err := s.debugger.GetFrameLocals(f.wasmIdx)
+		if err != nil {
+			return nil, fmt.Errorf("get locals: %w", err)
+		}
+		state := frameState{d: s.debugger, locals: locals}
+		for _, v := range s.info.GetVariables(f.wasm.Offset) {
+			value := "<unavailable>"
+			if b, err := v.Read(state); err == nil {
+				value = v.Format(b)
+			} else if errors.Is(err, debuginfo.ErrOptimizedOut) {
+				value = "<optimized out>"
+			}
+			out.Variables = append(out.Variables, variable{Name: v.Name, Value: value, Type: v.Type})
+		}
+	case scopeOperands:
+		for i, v := range s.debugger.GetOperands() {
+			out.Variables = append(out.Variables,
+				variable{Name: fmt.Sprintf("[%d]", i), Value: fmt.Sprintf("0x%x", v)})
+		}
+	case scopeGlobals:
+		for i := uint32(0); ; i++ {
+			v, err := s.debugger.GetGlobal(i)
+			if err != nil {
+				break
+			}
+			out.Variables = append(out.Variables, newVariable(s.module.Names.DescribeGlobal(i), v))
+		}
+	}
+
+	return out, nil
+}
+
+// isStepDone tells whether the line step has left its line at stop.
+func (s *Server) isStepDone(stop vm.Stop) bool {
+	if depth := len(s.debugger.Frames()); depth != s.step.depth {
+		return true
+	}
+
+	locs := s.info.Lookup(stop.Instruction.Offset)
+	if len(locs) == 0 {
+		return false
+	}
+
+	return locs[0].File != s.step.loc.File || locs[0].Line != s.step.loc.Line
+}
+
+// onStop is called on the goroutine running the VM, which reports stops to the client and blocks
+// until resumed, except those requested by the server itself and those of unfinished line steps.
+func (s *Server) onStop(d *vm.Debugger, stop vm.Stop) vm.Action {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	aborted := s.aborted
+	requested := s.pauseRequested
+	if stop.Reason == vm.StopReasonPause {
+		s.pauseRequested = false
+	}
+	s.mu.Unlock()
+
+	for _, f := range pending {
+		f()
+	}
+
+	// breakpoints at instructions where pause requests stop are missed by the debugger
+	_, atBreakpoint := s.breakpoints[vm.Breakpoint{FuncIdx: stop.FuncIdx, Offset: stop.Instruction.Offset}]
+
+	reason := stop.Reason.String()
+	switch {
+	case aborted:
+		return vm.ActionAbort
+	case stop.Reason == vm.StopReasonPause && !requested && !atBreakpoint:
+		return s.action
+	case stop.Reason == vm.StopReasonPause && !requested:
+		reason = vm.StopReasonBreakpoint.String()
+	case stop.Reason == vm.StopReasonPause && s.stopOnEntry:
+		reason, s.stopOnEntry = "entry", false
+	case stop.Reason == vm.StopReasonStep && s.step != nil && !s.isStepDone(stop):
+		return s.action
+	}
+	s.step = nil
+
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	s.sendEvent("stopped", stoppedBody{
+		Reason:            reason,
+		Description:       fmt.Sprintf("%s at 0x%x", reason, stop.Instruction.Offset),
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+	})
+
+	s.action = <-s.resume
+	return s.action
+}
+
+func (s *Server) pause() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return errors.New("not running")
+	}
+	s.pauseRequested = true
+	s.debugger.Pause()
+
+	return nil
+}
+
+// replaceBreakpoints replaces prev breakpoints with next ones, and returns errors setting each one.
+func (s *Server) replaceBreakpoints(prev, next []vm.Breakpoint) []error {
+	out := make([]error, len(next))
+	s.withDebugger(func() {
+		for _, v := range prev {
+			s.debugger.ClearBreakpoint(v)
+			delete(s.breakpoints, v)
+		}
+		for i, v := range next {
+			if out[i] = s.debugger.SetBreakpoint(v); out[i] == nil {
+				s.breakpoints[v] = struct{}{}
+			}
+		}
+	})
+
+	return out
+}
+
+func (s *Server) respond(req *request, body interface{}, err error) error {
+	s.wMu.Lock()
+	defer s.wMu.Unlock()
+
+	s.seq++
+	out := response{
+		Seq:        s.seq,
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		out.Message, out.Body = err.Error(), nil
+	}
+
+	return writeMessage(s.w, out)
+}
+
+// resumeWith resumes the stopped VM by action after responding, which steps by lines if step isn't
+// nil.
+func (s *Server) resumeWith(action vm.Action, step *lineStep) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running || !s.stopped {
+		return errNotStopped
+	}
+	s.stopped = false
+	s.frames = nil
+
+	s.deferred = append(s.deferred, func() {
+		s.step = step
+		s.resume <- action
+	})
+	return nil
+}
+
+// run runs the entry func, and reports its end to the client.
+func (s *Server) run() {
+	_, err := s.vm.InvokeFunc(s.entry)
+
+	s.mu.Lock()
+	s.running = false
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	for _, f := range pending {
+		f()
+	}
+
+	var exitCode int
+	if err != nil {
+		exitCode = 1
+		s.sendEvent("output", outputBody{Category: "stderr", Output: s.describeError(err) + "\n"})
+	}
+	s.sendEvent("exited", exitedBody{ExitCode: exitCode})
+	s.sendEvent("terminated", nil)
+
+	close(s.done)
+}
+
+func (s *Server) sendEvent(name string, body interface{}) {
+	s.wMu.Lock()
+	defer s.wMu.Unlock()
+
+	if s.w == nil {
+		return
+	}
+	s.seq++
+	writeMessage(s.w, event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// withDebugger calls f when the debugger is safe to change, which is right away if the VM isn't
+// running or stops, or on its next stop requested by the server otherwise.
+func (s *Server) withDebugger(f func()) {
+	s.mu.Lock()
+	if !s.running || s.stopped {
+		s.mu.Unlock()
+		f()
+		return
+	}
+
+	done := make(chan struct{})
+	s.pending = append(s.pending, func() {
+		f()
+		close(done)
+	})
+	s.mu.Unlock()
+
+	s.debugger.Pause()
+	<-done
+}
+
+func newVariable(name string, v types.WasmVal) variable {
+	out := variable{Name: name, Value: fmt.Sprint(v)}
+	switch vv := v.(type) {
+	case int32:
+		out.Type, out.MemoryReference = "i32", fmt.Sprintf("0x%x", uint32(vv))
+	case int64:
+		out.Type = "i64"
+	case float32:
+		out.Type = "f32"
+	case float64:
+		out.Type = "f64"
+	default:
+		out.Value = fmt.Sprintf("0x%x", v)
+	}
+
+	return out
+}
+
+func unmarshalArguments(args json.RawMessage, out interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(args, out); err != nil {
+		return fmt.Errorf("unmarshal arguments: %w", err)
+	}
+
+	return nil
+}
+
+func unwrapValue(v types.WasmVal) (uint64, error) {
+	switch vv := v.(type) {
+	case int32:
+		return uint64(uint32(vv)), nil
+	case int64:
+		return uint64(vv), nil
+	case float32:
+		return uint64(math.Float32bits(vv)), nil
+	case float64:
+		return math.Float64bits(vv), nil
+	case uint64:
+		return vv, nil
+	default:
+		return 0, fmt.Errorf("unknown value %v", v)
+	}
+}
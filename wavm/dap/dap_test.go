@@ -0,0 +1,298 @@
+package dap_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/dap"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+type testMessage struct {
+	Type    string          `json:"type"`
+	Command string          `json:"command"`
+	Event   string          `json:"event"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// testClient sends requests and receives messages in order, which fails the test on errors.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	seq  int
+}
+
+func TestServer(t *testing.T) {
+	module := decodeTestModule(t)
+	sq := module.Codes[1].Expr
+
+	c, done := newTestSession(t, module)
+	c.call("initialize", nil, nil)
+	c.expectEvent("initialized", nil)
+	c.call("launch", map[string]interface{}{"stopOnEntry": false}, nil)
+
+	var bps struct {
+		Breakpoints []struct {
+			Verified bool `json:"verified"`
+		} `json:"breakpoints"`
+	}
+	c.call("setInstructionBreakpoints", map[string]interface{}{"breakpoints": []interface{}{
+		map[string]interface{}{"instructionReference": fmt.Sprintf("0x%x", sq[0].Offset)},
+		map[string]interface{}{"instructionReference": "0x0"},
+	}}, &bps)
+	if len(bps.Breakpoints) != 2 || !bps.Breakpoints[0].Verified || bps.Breakpoints[1].Verified {
+		t.Fatalf("expect only the 1st breakpoint verified, got %+v", bps.Breakpoints)
+	}
+
+	// the request is rejected since the guest isn't running
+	c.send("continue", nil)
+	if msg := c.expect("response", "continue"); msg.Success {
+		t.Fatal("expect continuing to fail before running")
+	}
+
+	c.call("configurationDone", nil, nil)
+	c.expectStopped("breakpoint")
+	c.expectFrames([]string{"func[1]", "func[0]"}, sq[0].Offset)
+
+	var scopes struct {
+		Scopes []struct {
+			Name               string `json:"name"`
+			VariablesReference int    `json:"variablesReference"`
+		} `json:"scopes"`
+	}
+	c.call("scopes", map[string]interface{}{"frameId": 1}, &scopes)
+	var names []string
+	for _, v := range scopes.Scopes {
+		names = append(names, v.Name)
+	}
+	if expect := []string{"Locals", "Operand Stack", "Globals"}; !reflect.DeepEqual(expect, names) {
+		t.Fatalf("expect scopes %v, got %v", expect, names)
+	}
+
+	type variable struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+		Type  string `json:"type"`
+	}
+	expectVars := [][]variable{
+		{{"local[0]", "6", "i32"}},
+		{{"[0]", "0x6", ""}}, // the whole stack is the local of sq
+		{{"global[0]", "7", "i32"}},
+	}
+	for i, v := range scopes.Scopes {
+		var vars struct {
+			Variables []variable `json:"variables"`
+		}
+		c.call("variables", map[string]interface{}{"variablesReference": v.VariablesReference}, &vars)
+		if !reflect.DeepEqual(expectVars[i], vars.Variables) {
+			t.Fatalf("%s: expect %v, got %v", v.Name, expectVars[i], vars.Variables)
+		}
+	}
+
+	var mem struct {
+		Address string `json:"address"`
+		Data    []byte `json:"data"`
+	}
+	c.call("readMemory", map[string]interface{}{"memoryReference": "0x0", "offset": 1, "count": 2},
+		&mem)
+	if mem.Address != "0x1" || string(mem.Data) != "ii" {
+		t.Fatalf("expect 'ii' at 0x1, got '%s' at %s", mem.Data, mem.Address)
+	}
+
+	c.call("stepIn", map[string]interface{}{"granularity": "instruction"}, nil)
+	c.expectStopped("step")
+	c.expectFrames([]string{"func[1]", "func[0]"}, sq[1].Offset)
+
+	c.call("continue", nil, nil)
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	if c.expectEvent("exited", &exited); exited.ExitCode != 0 {
+		t.Fatalf("expect exit code 0, got %d", exited.ExitCode)
+	}
+	c.expectEvent("terminated", nil)
+
+	c.call("disconnect", nil, nil)
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+func TestServerDisconnectWhenStopped(t *testing.T) {
+	c, done := newTestSession(t, decodeTestModule(t))
+	c.call("initialize", nil, nil)
+	c.expectEvent("initialized", nil)
+	c.call("launch", map[string]interface{}{"stopOnEntry": true}, nil)
+	c.call("configurationDone", nil, nil)
+	c.expectStopped("entry")
+	c.expectFrames([]string{"func[0]"}, decodeTestModule(t).Codes[0].Expr[0].Offset)
+
+	// the aborted guest exits before the server returns
+	c.send("disconnect", nil)
+	c.expect("response", "disconnect")
+	c.expectEvent("output", nil)
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	if c.expectEvent("exited", &exited); exited.ExitCode != 1 {
+		t.Fatalf("expect exit code 1, got %d", exited.ExitCode)
+	}
+	c.expectEvent("terminated", nil)
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+// call sends the request and expects its success, whose body is unmarshalled into out if not nil.
+func (c *testClient) call(command string, args, out interface{}) {
+	c.t.Helper()
+
+	c.send(command, args)
+	msg := c.expect("response", command)
+	if !msg.Success {
+		c.t.Fatalf("%s: %s", command, msg.Message)
+	}
+	if out != nil {
+		if err := json.Unmarshal(msg.Body, out); err != nil {
+			c.t.Fatalf("%s: unmarshal body: %v", command, err)
+		}
+	}
+}
+
+// expect receives the next message, which must be of typ, and of name as its command or event.
+func (c *testClient) expect(typ, name string) testMessage {
+	c.t.Helper()
+
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("read header: %v", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatalf("bad Content-Length: %v", err)
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(c.r, content); err != nil {
+		c.t.Fatalf("read content: %v", err)
+	}
+
+	var out testMessage
+	if err := json.Unmarshal(content, &out); err != nil {
+		c.t.Fatalf("unmarshal content: %v", err)
+	}
+	if got := out.Command + out.Event; out.Type != typ || got != name {
+		c.t.Fatalf("expect %s %s, got %s", typ, name, content)
+	}
+
+	return out
+}
+
+func (c *testClient) expectEvent(name string, out interface{}) {
+	c.t.Helper()
+
+	msg := c.expect("event", name)
+	if out != nil {
+		if err := json.Unmarshal(msg.Body, out); err != nil {
+			c.t.Fatalf("%s: unmarshal body: %v", name, err)
+		}
+	}
+}
+
+// expectFrames expects the stack trace of frames by names, with the innermost one at offset.
+func (c *testClient) expectFrames(names []string, offset uint32) {
+	c.t.Helper()
+
+	var trace struct {
+		StackFrames []struct {
+			Name                        string `json:"name"`
+			InstructionPointerReference string `json:"instructionPointerReference"`
+		} `json:"stackFrames"`
+	}
+	c.call("stackTrace", nil, &trace)
+
+	var got []string
+	for _, v := range trace.StackFrames {
+		got = append(got, v.Name)
+	}
+	if !reflect.DeepEqual(names, got) {
+		c.t.Fatalf("expect frames %v, got %v", names, got)
+	}
+	if pc, got := fmt.Sprintf("0x%x", offset), trace.StackFrames[0].InstructionPointerReference; got != pc {
+		c.t.Fatalf("expect the frame at %s, got %s", pc, got)
+	}
+}
+
+func (c *testClient) expectStopped(reason string) {
+	c.t.Helper()
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if c.expectEvent("stopped", &body); body.Reason != reason {
+		c.t.Fatalf("expect stopped by %s, got %s", reason, body.Reason)
+	}
+}
+
+func (c *testClient) send(command string, args interface{}) {
+	c.t.Helper()
+
+	c.seq++
+	content, err := json.Marshal(map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": args,
+	})
+	if err != nil {
+		c.t.Fatalf("marshal: %v", err)
+	}
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(content), content); err != nil {
+		c.t.Fatalf("send %s: %v", command, err)
+	}
+}
+
+// decodeTestModule decodes a module whose main func calls sq(6) and drops the result, with a global
+// of 7 and memory starting with "hii".
+func decodeTestModule(t *testing.T) *wavm.Module {
+	t.Helper()
+
+	out, err := wavm.NewDecoder(wasmtest.SquareModule()).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	return out
+}
+
+// newTestSession serves module in the background, and returns the client and the channel of the
+// error serving.
+func newTestSession(t *testing.T, module *wavm.Module) (*testClient, <-chan error) {
+	s, err := dap.NewServer(module)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	m, err := vm.NewVM(module, nil)
+	if err != nil {
+		t.Fatalf("new VM: %v", err)
+	}
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(server, m.(*vm.VM), "main")
+		server.Close()
+	}()
+
+	return &testClient{t: t, conn: client, r: bufio.NewReader(client)}, done
+}
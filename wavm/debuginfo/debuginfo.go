@@ -0,0 +1,271 @@
+package debuginfo
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+var (
+	// ErrNoDebugInfo tells the module carries no DWARF sections.
+	ErrNoDebugInfo         = errors.New("no debug info")
+	ErrOptimizedOut        = errors.New("optimized out")
+	ErrUnsupportedLocation = errors.New("unsupported location")
+)
+
+// Info maps offsets of instructions in the decoded module to source locations by DWARF from custom
+// sections, whose addresses are offsets into the payload of the code section.
+type Info struct {
+	codeOffset uint32
+	lines      []lineRange // sorted by lo
+	scopes     []scope
+}
+
+// Location is a location in source files.
+type Location struct {
+	Func   string // name of the function including the location, empty if unknown
+	File   string
+	Line   int
+	Column int // 0 if unknown
+}
+
+// State is the state of a wasm call frame where locations of variables are evaluated.
+type State interface {
+	GetLocal(idx uint32) (uint64, error)
+	GetGlobal(idx uint32) (uint64, error)
+	ReadMemory(offset uint64, buf []byte) error
+}
+
+// Variable is a parameter or a local variable of a source function.
+type Variable struct {
+	Name string
+	Type string // empty if unknown
+	Size int64  // byte size of the type, -1 if unknown
+
+	typ       dwarf.Type
+	location  []byte
+	frameBase []byte
+}
+
+// GetVariables returns variables of the innermost function including offset, which are of the
+// inlined function if offset is in one.
+func (info *Info) GetVariables(offset uint32) []Variable {
+	if offset < info.codeOffset {
+		return nil
+	}
+	pc := uint64(offset - info.codeOffset)
+
+	var out []Variable
+	depth := -1
+	for _, v := range info.scopes {
+		if v.depth > depth && v.contains(pc) {
+			out, depth = v.vars, v.depth
+		}
+	}
+
+	return out
+}
+
+// Lookup maps offset to locations of the inlined functions including it innermost first, ending
+// with the location in the function it's compiled into. It's empty if the offset is unknown to
+// DWARF.
+func (info *Info) Lookup(offset uint32) []Location {
+	if offset < info.codeOffset {
+		return nil
+	}
+	pc := uint64(offset - info.codeOffset)
+
+	var loc Location
+	j := sort.Search(len(info.lines), func(i int) bool { return info.lines[i].lo > pc }) - 1
+	if j >= 0 && pc < info.lines[j].hi {
+		loc = info.lines[j].loc
+	}
+
+	var chain []scope
+	for _, v := range info.scopes {
+		if v.contains(pc) {
+			chain = append(chain, v)
+		}
+	}
+	sort.SliceStable(chain, func(i, j int) bool { return chain[i].depth > chain[j].depth })
+
+	if len(chain) == 0 {
+		if loc.File == "" {
+			return nil
+		}
+		return []Location{loc}
+	}
+
+	out := make([]Location, 0, len(chain))
+	for _, v := range chain {
+		loc.Func = v.name
+		out = append(out, loc)
+		if !v.inlined {
+			break
+		}
+		loc = v.call
+	}
+
+	return out
+}
+
+// LookupLine maps the line of file to offsets of instructions recommended for breakpoints, where
+// file matches paths in DWARF ending with it and vice versa.
+func (info *Info) LookupLine(file string, line int) []uint32 {
+	var out []uint32
+	for i, v := range info.lines {
+		if !v.isStmt || v.loc.Line != line || !matchPath(v.loc.File, file) {
+			continue
+		}
+
+		// skip rows continuing the previous row of the same line
+		if i > 0 {
+			prev := info.lines[i-1]
+			if prev.hi == v.lo && prev.loc.Line == line && prev.loc.File == v.loc.File {
+				continue
+			}
+		}
+		out = append(out, info.codeOffset+uint32(v.lo))
+	}
+
+	return out
+}
+
+func (l Location) String() string {
+	out := fmt.Sprintf("%s:%d", l.File, l.Line)
+	if l.Column != 0 {
+		out += fmt.Sprintf(":%d", l.Column)
+	}
+
+	if l.Func != "" {
+		return l.Func + " at " + out
+	}
+
+	return out
+}
+
+// Format formats value read by Read according to the type of v.
+func (v Variable) Format(value []byte) string {
+	t := v.typ
+	for {
+		if q, ok := t.(*dwarf.QualType); ok {
+			t = q.Type
+		} else if d, ok := t.(*dwarf.TypedefType); ok {
+			t = d.Type
+		} else {
+			break
+		}
+	}
+
+	var u uint64
+	if len(value) <= 8 {
+		var b [8]byte
+		copy(b[:], value)
+		u = binary.LittleEndian.Uint64(b[:])
+	}
+	bits := uint(8 * len(value))
+
+	switch t.(type) {
+	case *dwarf.BoolType:
+		if len(value) <= 8 {
+			return fmt.Sprint(u != 0)
+		}
+	case *dwarf.CharType, *dwarf.EnumType, *dwarf.IntType:
+		if len(value) > 0 && len(value) <= 8 {
+			return fmt.Sprint(int64(u<<(64-bits)) >> (64 - bits))
+		}
+	case *dwarf.FloatType:
+		switch len(value) {
+		case 4:
+			return fmt.Sprint(math.Float32frombits(uint32(u)))
+		case 8:
+			return fmt.Sprint(math.Float64frombits(u))
+		}
+	case *dwarf.PtrType:
+		if len(value) <= 8 {
+			return fmt.Sprintf("0x%x", u)
+		}
+	case *dwarf.UcharType, *dwarf.UintType:
+		if len(value) <= 8 {
+			return fmt.Sprint(u)
+		}
+	}
+
+	return fmt.Sprintf("[% x]", value)
+}
+
+// Read reads the value of v in little endian from the memory or the wasm local or global holding
+// it in s.
+func (v Variable) Read(s State) ([]byte, error) {
+	if len(v.location) == 0 {
+		return nil, ErrOptimizedOut
+	} else if v.Size < 0 {
+		return nil, fmt.Errorf("unknown size of %s: %w", v.Name, ErrUnsupportedLocation)
+	}
+
+	loc, err := evaluate(v.location, v.frameBase, s)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate location of %s: %w", v.Name, err)
+	}
+
+	out := make([]byte, v.Size)
+	if !loc.isValue {
+		if err := s.ReadMemory(loc.v, out); err != nil {
+			return nil, fmt.Errorf("read %s at 0x%x: %w", v.Name, loc.v, err)
+		}
+		return out, nil
+	}
+
+	if v.Size > 8 {
+		return nil, fmt.Errorf("%s of %d bytes as a value: %w", v.Name, v.Size, ErrUnsupportedLocation)
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], loc.v)
+	copy(out, b[:])
+	return out, nil
+}
+
+// New parses DWARF from .debug_* custom sections of m, which fails with ErrNoDebugInfo if there is
+// no .debug_info section.
+func New(m *wavm.Module) (*Info, error) {
+	sections := make(map[string][]byte)
+	for _, v := range m.Customs {
+		sections[v.Name] = v.Bytes
+	}
+	if sections[".debug_info"] == nil {
+		return nil, ErrNoDebugInfo
+	}
+
+	data, err := dwarf.New(sections[".debug_abbrev"], sections[".debug_aranges"],
+		sections[".debug_frame"], sections[".debug_info"], sections[".debug_line"],
+		sections[".debug_pubnames"], sections[".debug_ranges"], sections[".debug_str"])
+	if err != nil {
+		return nil, fmt.Errorf("parse DWARF: %w", err)
+	}
+	for _, name := range dwarf5SectionNames {
+		if b, ok := sections[name]; ok {
+			if err := data.AddSection(name, b); err != nil {
+				return nil, fmt.Errorf("add section %s: %w", name, err)
+			}
+		}
+	}
+
+	out := &Info{}
+	for _, v := range m.Sections {
+		if v.ID == types.SectionIDCode {
+			out.codeOffset = v.Offset
+		}
+	}
+
+	if err := out.load(data); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
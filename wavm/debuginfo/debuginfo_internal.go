@@ -0,0 +1,340 @@
+package debuginfo
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	localBinaryPkg "github.com/sammyne/mastering-wasm/wavm/encoding/binary"
+)
+
+// DWARF expression operations supported in locations of variables.
+const (
+	opAddr         = 0x03
+	opPlusUconst   = 0x23
+	opFbreg        = 0x91
+	opStackValue   = 0x9f
+	opWasmLocation = 0xed // extension for wasm by LLVM
+)
+
+// Kinds of locations of DW_OP_WASM_location.
+const (
+	wasmLocationLocal = iota
+	wasmLocationGlobal
+	wasmLocationStack
+	wasmLocationGlobalU32 // global index encoded as fixed 4 bytes
+)
+
+// tombstone is the least address marking code dropped by linkers.
+const tombstone = 0xfffffffe
+
+// dwarf5SectionNames are sections added by DWARF 5, which are optional for dwarf.New.
+var dwarf5SectionNames = []string{
+	".debug_addr", ".debug_line_str", ".debug_loclists", ".debug_rnglists", ".debug_str_offsets",
+}
+
+// lineRange is a row of the line table spanning [lo, hi).
+type lineRange struct {
+	lo, hi uint64
+	loc    Location
+	isStmt bool // recommended for breakpoints
+}
+
+// openScope is a scope whose DIE has children pending, where idx is into Info.scopes or -1 if the
+// scope is skipped for having no code.
+type openScope struct {
+	idx   int
+	depth int
+}
+
+// scope is a subprogram or an inlined subroutine, where depth is of its DIE.
+type scope struct {
+	ranges  [][2]uint64
+	depth   int
+	name    string
+	inlined bool
+	call    Location // only for inlined subroutines
+	vars    []Variable
+	// frameBase evaluates the frame base of variables, which is inherited from the enclosing
+	// subprogram for inlined subroutines.
+	frameBase []byte
+}
+
+// value is the result of a DWARF expression, which is the address of a variable in the memory, or
+// its value if isValue.
+type value struct {
+	v       uint64
+	isValue bool
+}
+
+func (info *Info) load(data *dwarf.Data) error {
+	var files []*dwarf.LineFile
+	var open []openScope
+
+	r := data.Reader()
+	for depth := 0; ; {
+		e, err := r.Next()
+		if err != nil {
+			return fmt.Errorf("read DIE: %w", err)
+		} else if e == nil {
+			break
+		} else if e.Tag == 0 {
+			depth--
+			continue
+		}
+
+		for len(open) > 0 && open[len(open)-1].depth >= depth {
+			open = open[:len(open)-1]
+		}
+		parent := -1
+		if len(open) > 0 {
+			parent = open[len(open)-1].idx
+		}
+
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			if files, err = info.loadLines(data, e); err != nil {
+				return fmt.Errorf("load lines: %w", err)
+			}
+		case dwarf.TagSubprogram, dwarf.TagInlinedSubroutine:
+			s, err := newScope(data, e, depth, files)
+			if err != nil {
+				return fmt.Errorf("load scope at 0x%x: %w", e.Offset, err)
+			}
+			if s.frameBase == nil && parent >= 0 {
+				s.frameBase = info.scopes[parent].frameBase
+			}
+
+			idx := -1
+			if len(s.ranges) > 0 {
+				idx = len(info.scopes)
+				info.scopes = append(info.scopes, s)
+			}
+			if e.Children {
+				open = append(open, openScope{idx: idx, depth: depth})
+			}
+		case dwarf.TagFormalParameter, dwarf.TagVariable:
+			if parent >= 0 {
+				v := newVariable(data, e)
+				v.frameBase = info.scopes[parent].frameBase
+				info.scopes[parent].vars = append(info.scopes[parent].vars, v)
+			}
+		default:
+		}
+
+		if e.Children {
+			depth++
+		}
+	}
+
+	sort.Slice(info.lines, func(i, j int) bool { return info.lines[i].lo < info.lines[j].lo })
+	return nil
+}
+
+// loadLines loads the line table of the compilation unit cu, and returns its file table.
+func (info *Info) loadLines(data *dwarf.Data, cu *dwarf.Entry) ([]*dwarf.LineFile, error) {
+	lr, err := data.LineReader(cu)
+	if err != nil {
+		return nil, err
+	} else if lr == nil {
+		return nil, nil
+	}
+
+	var prev, entry dwarf.LineEntry
+	prev.EndSequence = true
+	for {
+		if err := lr.Next(&entry); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !prev.EndSequence && prev.File != nil && prev.Address < entry.Address &&
+			prev.Address < tombstone {
+			loc := Location{File: prev.File.Name, Line: prev.Line, Column: prev.Column}
+			info.lines = append(info.lines,
+				lineRange{lo: prev.Address, hi: entry.Address, loc: loc, isStmt: prev.IsStmt})
+		}
+		prev = entry
+	}
+
+	return lr.Files(), nil
+}
+
+func (s scope) contains(pc uint64) bool {
+	for _, v := range s.ranges {
+		if v[0] <= pc && pc < v[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evaluate evaluates the DWARF expression expr of a location, where DW_OP_fbreg is relative to the
+// value of frameBase.
+func evaluate(expr, frameBase []byte, s State) (value, error) {
+	var stack []uint64
+	var isValue bool
+
+	r := bytes.NewReader(expr)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+
+		var v uint64
+		switch op {
+		case opAddr:
+			var addr [4]byte
+			if _, err := io.ReadFull(r, addr[:]); err != nil {
+				return value{}, fmt.Errorf("read address: %w", err)
+			}
+			v = uint64(binary.LittleEndian.Uint32(addr[:]))
+		case opFbreg:
+			offset, err := localBinaryPkg.ReadVarint(r, localBinaryPkg.BitsLen64)
+			if err != nil {
+				return value{}, fmt.Errorf("read offset of DW_OP_fbreg: %w", err)
+			}
+			base, err := evaluate(frameBase, nil, s)
+			if err != nil {
+				return value{}, fmt.Errorf("evaluate frame base: %w", err)
+			} else if !base.isValue {
+				return value{}, fmt.Errorf("frame base in memory: %w", ErrUnsupportedLocation)
+			}
+			v = base.v + uint64(offset)
+		case opPlusUconst:
+			n, err := localBinaryPkg.ReadUvarint(r, localBinaryPkg.BitsLen64)
+			if err != nil {
+				return value{}, fmt.Errorf("read DW_OP_plus_uconst: %w", err)
+			} else if len(stack) == 0 {
+				return value{}, errors.New("DW_OP_plus_uconst on empty stack")
+			}
+			stack[len(stack)-1] += n
+			continue
+		case opStackValue:
+			isValue = true
+			continue
+		case opWasmLocation:
+			var err error
+			if v, err = evaluateWasmLocation(r, s); err != nil {
+				return value{}, err
+			}
+			isValue = true
+		default:
+			return value{}, fmt.Errorf("op 0x%02x: %w", op, ErrUnsupportedLocation)
+		}
+		stack = append(stack, v)
+	}
+
+	if len(stack) == 0 {
+		return value{}, ErrOptimizedOut
+	}
+
+	return value{v: stack[len(stack)-1], isValue: isValue}, nil
+}
+
+// evaluateWasmLocation reads the value of the local or global named by the operands of
+// DW_OP_WASM_location from r.
+func evaluateWasmLocation(r *bytes.Reader, s State) (uint64, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("read kind of DW_OP_WASM_location: %w", err)
+	}
+
+	var idx uint64
+	if kind == wasmLocationGlobalU32 {
+		var b [4]byte
+		if _, err = io.ReadFull(r, b[:]); err == nil {
+			idx = uint64(binary.LittleEndian.Uint32(b[:]))
+		}
+	} else {
+		idx, err = localBinaryPkg.ReadUvarint(r, localBinaryPkg.BitsLen32)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read index of DW_OP_WASM_location: %w", err)
+	}
+
+	switch kind {
+	case wasmLocationLocal:
+		return s.GetLocal(uint32(idx))
+	case wasmLocationGlobal, wasmLocationGlobalU32:
+		return s.GetGlobal(uint32(idx))
+	default:
+		return 0, fmt.Errorf("DW_OP_WASM_location of kind %d: %w", kind, ErrUnsupportedLocation)
+	}
+}
+
+// getName gets the name of e, following its abstract origin or specification if unnamed.
+func getName(data *dwarf.Data, e *dwarf.Entry) string {
+	for i := 0; i < 8 && e != nil; i++ {
+		if name, ok := e.Val(dwarf.AttrName).(string); ok {
+			return name
+		}
+
+		off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			if off, ok = e.Val(dwarf.AttrSpecification).(dwarf.Offset); !ok {
+				return ""
+			}
+		}
+
+		r := data.Reader()
+		r.Seek(off)
+		e, _ = r.Next()
+	}
+
+	return ""
+}
+
+// matchPath tells whether paths a and b are equal, or one ends with the other.
+func matchPath(a, b string) bool {
+	return a == b || strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
+
+func newScope(data *dwarf.Data, e *dwarf.Entry, depth int, files []*dwarf.LineFile) (scope, error) {
+	ranges, err := data.Ranges(e)
+	if err != nil {
+		return scope{}, fmt.Errorf("get ranges: %w", err)
+	}
+
+	out := scope{depth: depth, name: getName(data, e)}
+	out.frameBase, _ = e.Val(dwarf.AttrFrameBase).([]byte)
+	for _, v := range ranges {
+		if v[0] < v[1] && v[0] < tombstone {
+			out.ranges = append(out.ranges, v)
+		}
+	}
+
+	if e.Tag == dwarf.TagInlinedSubroutine {
+		out.inlined = true
+		if idx, ok := e.Val(dwarf.AttrCallFile).(int64); ok && idx >= 0 && int(idx) < len(files) &&
+			files[idx] != nil {
+			out.call.File = files[idx].Name
+		}
+		if line, ok := e.Val(dwarf.AttrCallLine).(int64); ok {
+			out.call.Line = int(line)
+		}
+		if column, ok := e.Val(dwarf.AttrCallColumn).(int64); ok {
+			out.call.Column = int(column)
+		}
+	}
+
+	return out, nil
+}
+
+func newVariable(data *dwarf.Data, e *dwarf.Entry) Variable {
+	out := Variable{Name: getName(data, e), Size: -1}
+	out.location, _ = e.Val(dwarf.AttrLocation).([]byte)
+
+	if off, ok := e.Val(dwarf.AttrType).(dwarf.Offset); ok {
+		if t, err := data.Type(off); err == nil {
+			out.typ, out.Type, out.Size = t, t.String(), t.Size()
+		}
+	}
+
+	return out
+}
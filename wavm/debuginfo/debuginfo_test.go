@@ -0,0 +1,263 @@
+package debuginfo_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/debuginfo"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+)
+
+type testState struct {
+	locals []uint64
+	memory []byte
+}
+
+func (s testState) GetLocal(idx uint32) (uint64, error) {
+	return s.locals[idx], nil
+}
+
+func (s testState) GetGlobal(idx uint32) (uint64, error) {
+	return 0, errors.New("no globals")
+}
+
+func (s testState) ReadMemory(offset uint64, buf []byte) error {
+	copy(buf, s.memory[offset:])
+	return nil
+}
+
+func TestLookup(t *testing.T) {
+	m, codeOffset := decodeTestModule(t, true)
+	info, err := debuginfo.New(m)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	main := debuginfo.Location{Func: "main", File: "main.c", Line: 3}
+	testVector := []struct {
+		pc     uint32
+		expect []debuginfo.Location
+	}{
+		{0x10, []debuginfo.Location{main}},
+		{0x1F, []debuginfo.Location{main}},
+		// inlined square is called at line 7 of main
+		{0x22, []debuginfo.Location{
+			{Func: "square", File: "main.c", Line: 2, Column: 9},
+			{Func: "main", File: "main.c", Line: 7, Column: 3},
+		}},
+		{0x2C, []debuginfo.Location{{Func: "main", File: "main.c", Line: 8}}},
+		{0x50, nil},
+	}
+	for _, c := range testVector {
+		if got := info.Lookup(codeOffset + c.pc); !reflect.DeepEqual(c.expect, got) {
+			t.Fatalf("0x%x: expect %v, got %v", c.pc, c.expect, got)
+		}
+	}
+	if got := info.Lookup(codeOffset - 1); got != nil {
+		t.Fatalf("expect nothing before the code, got %v", got)
+	}
+
+	if got := info.Lookup(codeOffset + 0x22)[0].String(); got != "square at main.c:2:9" {
+		t.Fatalf("bad location: %s", got)
+	}
+
+	lines := []struct {
+		file   string
+		line   int
+		expect []uint32
+	}{
+		{"main.c", 3, []uint32{codeOffset + 0x10}},
+		{"/src/main.c", 2, []uint32{codeOffset + 0x20}},
+		// the row going on line 8 isn't for breakpoints
+		{"main.c", 8, []uint32{codeOffset + 0x28}},
+		{"main.c", 7, nil},
+		{"other.c", 3, nil},
+	}
+	for _, c := range lines {
+		if got := info.LookupLine(c.file, c.line); !reflect.DeepEqual(c.expect, got) {
+			t.Fatalf("%s:%d: expect %v, got %v", c.file, c.line, c.expect, got)
+		}
+	}
+}
+
+func TestGetVariables(t *testing.T) {
+	m, codeOffset := decodeTestModule(t, true)
+	info, err := debuginfo.New(m)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	// n is in local 0, and buf is 8 bytes above the frame base in local 1
+	s := testState{locals: []uint64{42, 0x10}, memory: make([]byte, 0x20)}
+	binary.LittleEndian.PutUint32(s.memory[0x18:], 0xFFFFFFFE)
+
+	vars := info.GetVariables(codeOffset + 0x12)
+	expect := []struct {
+		name, value string
+	}{
+		{"n", "42"},
+		{"buf", "-2"},
+	}
+	if len(vars) != len(expect) {
+		t.Fatalf("expect %d variables, got %v", len(expect), vars)
+	}
+	for i, c := range expect {
+		v := vars[i]
+		if v.Name != c.name || v.Type != "int" || v.Size != 4 {
+			t.Fatalf("#%d: expect int %s, got %s %s of %d bytes", i, c.name, v.Type, v.Name, v.Size)
+		}
+
+		value, err := v.Read(s)
+		if err != nil {
+			t.Fatalf("read %s: %v", v.Name, err)
+		}
+		if got := v.Format(value); got != c.value {
+			t.Fatalf("%s: expect %s, got %s", v.Name, c.value, got)
+		}
+	}
+
+	// variables of the inlined function
+	vars = info.GetVariables(codeOffset + 0x22)
+	if len(vars) != 1 || vars[0].Name != "x" {
+		t.Fatalf("expect x, got %v", vars)
+	}
+	if _, err := vars[0].Read(s); !errors.Is(err, debuginfo.ErrOptimizedOut) {
+		t.Fatalf("expect %v, got %v", debuginfo.ErrOptimizedOut, err)
+	}
+
+	if vars := info.GetVariables(codeOffset + 0x50); vars != nil {
+		t.Fatalf("expect no variables out of functions, got %v", vars)
+	}
+}
+
+func TestNoDebugInfo(t *testing.T) {
+	m, _ := decodeTestModule(t, false)
+	if _, err := debuginfo.New(m); !errors.Is(err, debuginfo.ErrNoDebugInfo) {
+		t.Fatalf("expect %v, got %v", debuginfo.ErrNoDebugInfo, err)
+	}
+}
+
+// decodeTestModule decodes a module of a func, with DWARF of main.c if withDWARF, and returns the
+// offset of the code section where DWARF addresses start.
+//
+// The code of main spans [0x10, 0x50), and lines are
+//
+//	[0x10, 0x20) line 3
+//	[0x20, 0x28) line 2, column 9, in square inlined at line 7, column 3
+//	[0x28, 0x2C) line 8
+//	[0x2C, 0x50) line 8, not for breakpoints
+func decodeTestModule(t *testing.T, withDWARF bool) (*wavm.Module, uint32) {
+	buf := wasmtest.Module(
+		wasmtest.Section(1, wasmtest.Vec(wasmtest.FuncType(nil, nil))),
+		wasmtest.Section(3, wasmtest.Vec([]byte{0})),
+		wasmtest.Section(10, wasmtest.Vec(wasmtest.Code(nil))),
+	)
+	if withDWARF {
+		buf = append(buf, wasmtest.CustomSection(".debug_abbrev", testAbbrev())...)
+		buf = append(buf, wasmtest.CustomSection(".debug_info", testInfo())...)
+		buf = append(buf, wasmtest.CustomSection(".debug_line", testLine())...)
+	}
+
+	out, err := wavm.NewDecoder(buf).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	// the payload of the code section follows its ID and size
+	return out, 8 + 6 + 4 + 2
+}
+
+// abbreviations of DIEs
+const (
+	abbrevCU = iota + 1
+	abbrevBaseType
+	abbrevSubprogram
+	abbrevParam
+	abbrevInlined
+	abbrevAbstract
+	abbrevVariable
+)
+
+func testAbbrev() []byte {
+	// attributes and forms
+	const (
+		name, stmtList, lowPC, highPC, frameBase = 0x03, 0x10, 0x11, 0x12, 0x40
+		typ, location, encoding, byteSize        = 0x49, 0x02, 0x3E, 0x0B
+		origin, inline                           = 0x31, 0x20
+		callFile, callLine, callColumn           = 0x58, 0x59, 0x57
+
+		addr, data1, data4, str, ref4, secOffset, exprloc = 0x01, 0x0B, 0x06, 0x08, 0x13, 0x17, 0x18
+	)
+
+	abbrev := func(code, tag, children byte, attrs ...byte) []byte {
+		return append(append([]byte{code, tag, children}, attrs...), 0, 0)
+	}
+	return wasmtest.Concat(
+		abbrev(abbrevCU, 0x11, 1, name, str, stmtList, secOffset, lowPC, addr, highPC, data4),
+		abbrev(abbrevBaseType, 0x24, 0, name, str, encoding, data1, byteSize, data1),
+		abbrev(abbrevSubprogram, 0x2E, 1, name, str, lowPC, addr, highPC, data4, frameBase, exprloc),
+		abbrev(abbrevParam, 0x05, 0, name, str, typ, ref4, location, exprloc),
+		abbrev(abbrevInlined, 0x1D, 1, origin, ref4, lowPC, addr, highPC, data4, callFile, data1,
+			callLine, data1, callColumn, data1),
+		abbrev(abbrevAbstract, 0x2E, 0, name, str, inline, data1),
+		abbrev(abbrevVariable, 0x34, 0, name, str, typ, ref4, location, exprloc),
+		[]byte{0},
+	)
+}
+
+func testInfo() []byte {
+	cstr := func(s string) []byte {
+		return append([]byte(s), 0)
+	}
+	// the header goes before DIEs, whose offsets are relative to the unit
+	const headerSize = 11
+
+	cu := wasmtest.Concat([]byte{abbrevCU}, cstr("main.c"), u32(0), u32(0), u32(0x100))
+	intType := headerSize + len(cu)
+	baseType := wasmtest.Concat([]byte{abbrevBaseType}, cstr("int"), []byte{0x05, 4})
+	square := intType + len(baseType)
+	abstract := wasmtest.Concat([]byte{abbrevAbstract}, cstr("square"), []byte{1})
+
+	// the frame base is local 1
+	main := wasmtest.Concat([]byte{abbrevSubprogram}, cstr("main"), u32(0x10), u32(0x40), []byte{3, 0xED, 0, 1})
+	n := wasmtest.Concat([]byte{abbrevParam}, cstr("n"), u32(uint32(intType)), []byte{3, 0xED, 0, 0})
+	bufVar := wasmtest.Concat([]byte{abbrevVariable}, cstr("buf"), u32(uint32(intType)), []byte{2, 0x91, 8})
+	inlined := wasmtest.Concat([]byte{abbrevInlined}, u32(uint32(square)), u32(0x20), u32(8), []byte{1, 7, 3})
+	x := wasmtest.Concat([]byte{abbrevVariable}, cstr("x"), u32(uint32(intType)), []byte{0})
+
+	dies := wasmtest.Concat(cu, baseType, abstract, main, n, bufVar, inlined, x, []byte{0, 0, 0})
+	header := wasmtest.Concat(u32(uint32(headerSize-4+len(dies))), []byte{4, 0}, u32(0), []byte{4})
+	return append(header, dies...)
+}
+
+func testLine() []byte {
+	const (
+		copy_, advancePC, advanceLine, setColumn, negateStmt = 0x01, 0x02, 0x03, 0x05, 0x06
+	)
+
+	// minimum instruction length, maximum operations per instruction, default is_stmt, line base,
+	// line range, opcode base and lengths of standard opcodes, then no include directories and the
+	// file main.c
+	header := wasmtest.Concat([]byte{1, 1, 1, 0xFB, 14, 13, 0, 1, 1, 1, 1, 0, 0, 0, 1, 0, 0, 1, 0},
+		[]byte("main.c"), []byte{0, 0, 0, 0, 0})
+	program := []byte{
+		0x00, 5, 0x02, 0x10, 0, 0, 0, // DW_LNE_set_address 0x10
+		advanceLine, 2, copy_,
+		advancePC, 0x10, advanceLine, 0x7F, setColumn, 9, copy_,
+		advancePC, 8, setColumn, 0, advanceLine, 6, copy_,
+		advancePC, 4, negateStmt, copy_,
+		advancePC, 0x24, 0x00, 1, 0x01, // DW_LNE_end_sequence
+	}
+
+	unit := wasmtest.Concat([]byte{4, 0}, u32(uint32(len(header))), header, program)
+	return append(u32(uint32(len(unit))), unit...)
+}
+
+func u32(v uint32) []byte {
+	var out [4]byte
+	binary.LittleEndian.PutUint32(out[:], v)
+	return out[:]
+}
@@ -3,6 +3,7 @@ package wavm
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -13,6 +14,9 @@ import (
 
 type Decoder struct {
 	*bytes.Reader
+
+	// DisabledFeatures are post-MVP features disallowed in decoded modules, which are none unless set.
+	DisabledFeatures Features
 }
 
 func (d *Decoder) DecodeBytes() ([]byte, error) {
@@ -47,53 +51,72 @@ func (d *Decoder) DecodeFloat64() (float64, error) {
 	return math.Float64frombits(out), nil
 }
 
+// DecodeModule decodes a module, failing with *DecodeError.
 func (d *Decoder) DecodeModule() (*Module, error) {
 	magic, err := d.DecodeUint32()
 	if err != nil {
-		return nil, fmt.Errorf("decode magic: %w", err)
+		return nil, d.newDecodeError(SectionIDPreamble, fmt.Errorf("decode magic: %w", err))
+	} else if magic != types.Magic {
+		return nil, d.newDecodeError(SectionIDPreamble, fmt.Errorf("bad magic: 0x%08x", magic))
 	}
 
 	version, err := d.DecodeUint32()
 	if err != nil {
-		return nil, fmt.Errorf("decode version: %w", err)
+		return nil, d.newDecodeError(SectionIDPreamble, fmt.Errorf("decode version: %w", err))
+	} else if version != types.Version {
+		return nil, d.newDecodeError(SectionIDPreamble, fmt.Errorf("unsupported version: %d", version))
 	}
 
 	// sections
-	out := &Module{Magic: magic, Version: version}
+	out := &Module{Magic: magic, Version: version, DisabledFeatures: d.DisabledFeatures}
 
-	var prevSectionID byte
+	var prevSectionOrder int
 	for d.Len() > 0 {
 		sectionID, err := d.ReadByte()
 		if err != nil {
-			return nil, fmt.Errorf("read section ID: %w", err)
+			return nil, d.newDecodeError(SectionIDPreamble, fmt.Errorf("read section ID: %w", err))
 		}
 
-		if sectionID == types.SectionIDCustom {
-			var c types.Custom
-			if err := d.decodeCustom(&c); err != nil {
-				return nil, fmt.Errorf("decode custom section: %w", err)
+		if sectionID != types.SectionIDCustom {
+			order, ok := sectionOrders[sectionID]
+			if !ok || order <= prevSectionOrder {
+				return nil, d.newDecodeError(int(sectionID), errors.New("malformed section ID"))
 			}
-			out.Customs = append(out.Customs, c)
-			continue
+			prevSectionOrder = order
 		}
 
-		if sectionID <= prevSectionID {
-			return nil, fmt.Errorf("malformed section ID: %v", sectionID)
+		switch sectionID {
+		case types.SectionIDTag:
+			err = d.features().Require(FeatureExceptionHandling, "tag section")
+		case types.SectionIDDataCount:
+			err = d.features().Require(FeatureBulkMemory, "data count section")
+		default:
+		}
+		if err != nil {
+			return nil, d.newDecodeError(int(sectionID), err)
 		}
-		prevSectionID = sectionID
 
 		sectionLen, err := d.DecodeUvarint32()
 		if err != nil {
-			return nil, fmt.Errorf("decode section byte count: %w", err)
+			err = fmt.Errorf("decode section byte count: %w", err)
+			return nil, d.newDecodeError(int(sectionID), err)
 		}
-		ell := d.Len()
+		section := types.Section{ID: sectionID, Offset: d.offset(), Size: sectionLen}
+		out.Sections = append(out.Sections, section)
 
-		if err := d.decodeNonCustomSectionIntoModule(sectionID, out); err != nil {
-			return nil, fmt.Errorf("bad section(%d): %w", sectionID, err)
+		if sectionID == types.SectionIDCustom {
+			err = d.decodeCustomSectionIntoModule(sectionLen, out)
+		} else {
+			err = d.decodeNonCustomSectionIntoModule(sectionID, out)
+		}
+		if err != nil {
+			return nil, d.newDecodeError(int(sectionID), err)
 		}
 
-		if d.Len()+int(sectionLen) != ell {
-			return nil, fmt.Errorf("section(%d) size mismatch: %w", sectionID, err)
+		if d.offset() != section.Offset+sectionLen {
+			err = fmt.Errorf("size mismatch: %d bytes decoded out of %d", d.offset()-section.Offset,
+				sectionLen)
+			return nil, d.newDecodeError(int(sectionID), err)
 		}
 	}
 
@@ -123,6 +146,10 @@ func (d *Decoder) DecodeUvarint32() (uint32, error) {
 	return uint32(out), err
 }
 
+func (d *Decoder) DecodeUvarint64() (uint64, error) {
+	return localBinaryPkg.ReadUvarint(d.Reader, localBinaryPkg.BitsLen64)
+}
+
 func (d *Decoder) DecodeVarint32() (int32, error) {
 	out, err := localBinaryPkg.ReadVarint(d.Reader, localBinaryPkg.BitsLen32)
 	return int32(out), err
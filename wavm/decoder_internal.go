@@ -9,11 +9,36 @@ import (
 	"github.com/sammyne/mastering-wasm/wavm/types"
 )
 
+// sectionOrders maps IDs of non-custom sections to the order they must appear in, which differs
+// from the ID order since later proposals squeeze new sections in between.
+var sectionOrders = map[byte]int{
+	types.SectionIDType:      1,
+	types.SectionIDImport:    2,
+	types.SectionIDFunc:      3,
+	types.SectionIDTable:     4,
+	types.SectionIDMemory:    5,
+	types.SectionIDTag:       6,
+	types.SectionIDGlobal:    7,
+	types.SectionIDExport:    8,
+	types.SectionIDStart:     9,
+	types.SectionIDElement:   10,
+	types.SectionIDDataCount: 11,
+	types.SectionIDCode:      12,
+	types.SectionIDData:      13,
+}
+
+// features returns post-MVP features allowed in decoded modules.
+func (d *Decoder) features() Features {
+	return FeaturesAll &^ d.DisabledFeatures
+}
+
 func (d *Decoder) decodeArgs(opcode byte) (interface{}, error) {
 	var out interface{}
 	var err error
 
 	switch opcode {
+	case types.OpcodeAtomic:
+		out, err = d.decodeAtomicArg()
 	case types.OpcodeBlock, types.OpcodeLoop:
 		out, err = d.decodeBlock()
 	case types.OpcodeIf:
@@ -22,16 +47,20 @@ func (d *Decoder) decodeArgs(opcode byte) (interface{}, error) {
 		out, err = d.DecodeUvarint32()
 	case types.OpcodeBrTable:
 		out, err = d.decodeBreakTable()
-	case types.OpcodeCall:
+	case types.OpcodeCall, types.OpcodeReturnCall, types.OpcodeCallRef, types.OpcodeReturnCallRef:
+		out, err = d.DecodeUvarint32()
+	case types.OpcodeBrOnNull, types.OpcodeBrOnNonNull, types.OpcodeRefFunc:
 		out, err = d.DecodeUvarint32()
-	case types.OpcodeCallIndirect:
+	case types.OpcodeRefNull:
+		out, err = d.decodeHeapType()
+	case types.OpcodeCallIndirect, types.OpcodeReturnCallIndirect:
 		out, err = d.decodeCallIndirectArgs()
 	case types.OpcodeLocalGet, types.OpcodeLocalSet, types.OpcodeLocalTee:
 		out, err = d.DecodeUvarint32()
 	case types.OpcodeGlobalGet, types.OpcodeGlobalSet:
 		out, err = d.DecodeUvarint32()
 	case types.OpcodeMemorySize, types.OpcodeMemoryGrow:
-		out, err = 0, d.decodeZero()
+		out, err = d.DecodeUvarint32()
 	case types.OpcodeI32Const:
 		out, err = d.DecodeVarint32()
 	case types.OpcodeI64Const:
@@ -40,8 +69,14 @@ func (d *Decoder) decodeArgs(opcode byte) (interface{}, error) {
 		out, err = d.DecodeFloat32()
 	case types.OpcodeF64Const:
 		out, err = d.DecodeFloat64()
+	case types.OpcodeGC:
+		out, err = d.decodeGCArg()
 	case types.OpcodeTruncSat:
-		out, err = d.ReadByte()
+		out, err = d.decodeTruncSatArgs()
+	case types.OpcodeThrow:
+		out, err = d.DecodeUvarint32()
+	case types.OpcodeTryTable:
+		out, err = d.decodeTryTable()
 	default:
 		if opcode >= types.OpcodeI32Load && opcode <= types.OpcodeI64Store32 {
 			out, err = d.decodeMemoryArg()
@@ -51,6 +86,31 @@ func (d *Decoder) decodeArgs(opcode byte) (interface{}, error) {
 	return out, err
 }
 
+func (d *Decoder) decodeAtomicArg() (types.AtomicArg, error) {
+	subOpcode, err := d.DecodeUvarint32()
+	if err != nil {
+		return types.AtomicArg{}, fmt.Errorf("decode sub-opcode: %w", err)
+	} else if subOpcode > 0xff {
+		return types.AtomicArg{}, fmt.Errorf("unknown atomic sub-opcode: %x", subOpcode)
+	} else if _, ok := types.GetAtomicOpname(byte(subOpcode)); !ok {
+		return types.AtomicArg{}, fmt.Errorf("unknown atomic sub-opcode: %02x", subOpcode)
+	}
+
+	out := types.AtomicArg{SubOpcode: byte(subOpcode)}
+	if subOpcode == types.AtomicFence {
+		if err := d.decodeZero(); err != nil {
+			return types.AtomicArg{}, fmt.Errorf("decode fence flags: %w", err)
+		}
+		return out, nil
+	}
+
+	if out.MemoryArg, err = d.decodeMemoryArg(); err != nil {
+		return types.AtomicArg{}, fmt.Errorf("decode memory arg: %w", err)
+	}
+
+	return out, nil
+}
+
 func (d *Decoder) decodeBlock() (*types.Block, error) {
 	blockType, err := d.decodeBlockType()
 	if err != nil {
@@ -130,6 +190,36 @@ func (d *Decoder) decodeBreakTable() (*types.BreakTable, error) {
 	return out, nil
 }
 
+// decodeBrOnCastArg decodes the flags, label and heap types of br_on_cast(_fail) into out, where
+// the 1st and 2nd bit of flags tell the source and target types are nullable respectively.
+func (d *Decoder) decodeBrOnCastArg(out *types.GCArg) error {
+	flags, err := d.ReadByte()
+	if err != nil {
+		return fmt.Errorf("read flags: %w", err)
+	} else if flags > 0x03 {
+		return fmt.Errorf("bad flags: %02x", flags)
+	}
+
+	if out.Label, err = d.DecodeUvarint32(); err != nil {
+		return fmt.Errorf("decode label: %w", err)
+	}
+
+	srcHeapType, err := d.decodeHeapType()
+	if err != nil {
+		return fmt.Errorf("decode source heap type: %w", err)
+	}
+
+	dstHeapType, err := d.decodeHeapType()
+	if err != nil {
+		return fmt.Errorf("decode target heap type: %w", err)
+	}
+
+	out.SrcType = types.NewRefType(flags&0x01 != 0, srcHeapType)
+	out.DstType = types.NewRefType(flags&0x02 != 0, dstHeapType)
+
+	return nil
+}
+
 func (d *Decoder) decodeCallIndirectArgs() (uint32, error) {
 	typeIdx, err := d.DecodeUvarint32()
 	if err != nil {
@@ -143,7 +233,33 @@ func (d *Decoder) decodeCallIndirectArgs() (uint32, error) {
 	return typeIdx, nil
 }
 
+func (d *Decoder) decodeCatch(out *types.Catch) error {
+	kind, err := d.ReadByte()
+	if err != nil {
+		return fmt.Errorf("decode kind: %w", err)
+	}
+
+	switch kind {
+	case types.CatchKindTag, types.CatchKindTagRef:
+		if out.TagIdx, err = d.DecodeUvarint32(); err != nil {
+			return fmt.Errorf("decode tag idx: %w", err)
+		}
+	case types.CatchKindAll, types.CatchKindAllRef:
+	default:
+		return fmt.Errorf("bad kind: %02x", kind)
+	}
+
+	if out.Label, err = d.DecodeUvarint32(); err != nil {
+		return fmt.Errorf("decode label: %w", err)
+	}
+
+	out.Kind = kind
+	return nil
+}
+
 func (d *Decoder) decodeCode(out *types.Code) error {
+	offset := d.offset()
+
 	n, err := d.DecodeUvarint32()
 	if err != nil {
 		return fmt.Errorf("decode byte count: %w", err)
@@ -167,7 +283,7 @@ func (d *Decoder) decodeCode(out *types.Code) error {
 		return errors.New("invalid code length")
 	}
 
-	out.Locals, out.Expr = locals, expr
+	out.Offset, out.Locals, out.Expr = offset, locals, expr
 	return nil
 }
 
@@ -187,12 +303,15 @@ func (d *Decoder) decodeCodes() ([]types.Code, error) {
 	return out, nil
 }
 
-func (d *Decoder) decodeCustom(out *types.Custom) error {
-	buf, err := d.DecodeBytes()
-	if err != nil {
-		return fmt.Errorf("decode bytes: %w", err)
+// decodeCustom decodes the custom section of n bytes into out.
+func (d *Decoder) decodeCustom(out *types.Custom, n uint32) error {
+	if int(n) > d.Len() {
+		return fmt.Errorf("byte count(%d) exceeds the remaining %d", n, d.Len())
 	}
 
+	buf := make([]byte, n)
+	_, _ = d.Read(buf)
+
 	dd := NewDecoder(buf)
 	name, err := dd.DecodeName()
 	if err != nil {
@@ -206,6 +325,24 @@ func (d *Decoder) decodeCustom(out *types.Custom) error {
 	return nil
 }
 
+func (d *Decoder) decodeCustomSectionIntoModule(n uint32, m *Module) error {
+	var c types.Custom
+	if err := d.decodeCustom(&c, n); err != nil {
+		return err
+	}
+	m.Customs = append(m.Customs, c)
+
+	// malformed names are merely unavailable, since custom sections never invalidate modules
+	if c.Name == types.CustomNameSection {
+		var names types.Names
+		if err := NewDecoder(c.Bytes).decodeNames(&names); err == nil {
+			m.Names = names
+		}
+	}
+
+	return nil
+}
+
 func (d *Decoder) decodeData() ([]types.Data, error) {
 	n, err := d.DecodeUvarint32()
 	if err != nil {
@@ -223,14 +360,36 @@ func (d *Decoder) decodeData() ([]types.Data, error) {
 }
 
 func (d *Decoder) decodeDatum(out *types.Data) error {
-	memoryIdx, err := d.DecodeUvarint32()
+	tag, err := d.DecodeUvarint32()
 	if err != nil {
-		return fmt.Errorf("decode memory idx: %w", err)
+		return fmt.Errorf("decode tag: %w", err)
+	}
+
+	var memoryIdx uint32
+	switch tag {
+	case types.DataTagActive, types.DataTagPassive:
+	case types.DataTagActiveExplicit:
+		if memoryIdx, err = d.DecodeUvarint32(); err != nil {
+			return fmt.Errorf("decode memory idx: %w", err)
+		}
+	default:
+		return fmt.Errorf("bad tag: %d", tag)
+	}
+
+	if tag == types.DataTagPassive {
+		err = d.features().Require(FeatureBulkMemory, "passive data")
+	} else {
+		err = d.features().Require(getMemoryIdxFeatures(memoryIdx), "data of non-zero memory")
+	}
+	if err != nil {
+		return err
 	}
 
 	var offset types.Expr
-	if err := d.decodeExpr(&offset); err != nil {
-		return fmt.Errorf("decode expr: %w", err)
+	if tag != types.DataTagPassive {
+		if err := d.decodeExpr(&offset); err != nil {
+			return fmt.Errorf("decode expr: %w", err)
+		}
 	}
 
 	init, err := d.DecodeBytes()
@@ -238,7 +397,8 @@ func (d *Decoder) decodeDatum(out *types.Data) error {
 		return fmt.Errorf("decode init: %w", err)
 	}
 
-	out.MemoryIdx, out.Offset, out.Init = memoryIdx, offset, init
+	out.Passive, out.MemoryIdx = tag == types.DataTagPassive, memoryIdx
+	out.Offset, out.Init = offset, init
 	return nil
 }
 
@@ -301,6 +461,10 @@ func (d *Decoder) decodeExportDescription() (*types.ExportDescription, error) {
 
 	switch tag {
 	case types.PortTagFunc, types.PortTagTable, types.PortTagMemory, types.PortTagGlobal:
+	case types.PortTagTag:
+		if err := d.features().Require(FeatureExceptionHandling, "tag export"); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("invalid tag: %02x", tag)
 	}
@@ -344,23 +508,107 @@ func (d *Decoder) decodeExpr(out *types.Expr) error {
 	return nil
 }
 
-func (d *Decoder) decodeFuncType() (*types.FuncType, error) {
-	tag, err := d.ReadByte()
+func (d *Decoder) decodeFieldType(out *types.FieldType) error {
+	t, err := d.decodeStorageType()
 	if err != nil {
-		return nil, fmt.Errorf("read tag: %w", err)
+		return fmt.Errorf("decode storage type: %w", err)
 	}
 
-	paramTypes, err := d.decodeValueTypes()
+	mutable, err := d.ReadByte()
 	if err != nil {
+		return fmt.Errorf("read mut: %w", err)
+	}
+	switch mutable {
+	case types.MutConst, types.MutVar:
+	default:
+		return fmt.Errorf("bad mutability: %d", mutable)
+	}
+
+	out.StorageType, out.Mutable = t, mutable
+	return nil
+}
+
+// decodeFuncType decodes the composite type tagged as tag, which is a struct or array type rather
+// than a function type if tag tells so.
+func (d *Decoder) decodeFuncType(tag byte) (*types.FuncType, error) {
+	out := &types.FuncType{Tag: tag, Final: true}
+
+	switch tag {
+	case types.TypeTagFunc:
+	case types.TypeTagStruct:
+		n, err := d.DecodeUvarint32()
+		if err != nil {
+			return nil, fmt.Errorf("decode #(fields): %w", err)
+		}
+		out.Fields = make([]types.FieldType, n)
+		for i := range out.Fields {
+			if err := d.decodeFieldType(&out.Fields[i]); err != nil {
+				return nil, fmt.Errorf("decode %d-th field: %w", i, err)
+			}
+		}
+		return out, nil
+	case types.TypeTagArray:
+		out.Fields = make([]types.FieldType, 1)
+		if err := d.decodeFieldType(&out.Fields[0]); err != nil {
+			return nil, fmt.Errorf("decode element: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("bad type tag: %02x", tag)
+	}
+
+	var err error
+	if out.ParamTypes, err = d.decodeValueTypes(); err != nil {
 		return nil, fmt.Errorf("decode parameter types: %w", err)
 	}
 
-	resultTypes, err := d.decodeValueTypes()
-	if err != nil {
+	if out.ResultTypes, err = d.decodeValueTypes(); err != nil {
 		return nil, fmt.Errorf("decode result types: %w", err)
 	}
 
-	out := &types.FuncType{Tag: tag, ParamTypes: paramTypes, ResultTypes: resultTypes}
+	return out, nil
+}
+
+func (d *Decoder) decodeGCArg() (types.GCArg, error) {
+	subOpcode, err := d.DecodeUvarint32()
+	if err != nil {
+		return types.GCArg{}, fmt.Errorf("decode sub-opcode: %w", err)
+	} else if subOpcode > 0xff {
+		return types.GCArg{}, fmt.Errorf("unknown gc sub-opcode: %x", subOpcode)
+	} else if _, ok := types.GetGCOpname(byte(subOpcode)); !ok {
+		return types.GCArg{}, fmt.Errorf("unknown gc sub-opcode: %02x", subOpcode)
+	}
+
+	out := types.GCArg{SubOpcode: byte(subOpcode)}
+	switch out.SubOpcode {
+	case types.GCStructNew, types.GCStructNewDefault, types.GCArrayNew, types.GCArrayNewDefault,
+		types.GCArrayGet, types.GCArrayGetS, types.GCArrayGetU, types.GCArraySet, types.GCArrayFill:
+		if out.TypeIdx, err = d.DecodeUvarint32(); err != nil {
+			return types.GCArg{}, fmt.Errorf("decode type idx: %w", err)
+		}
+	case types.GCStructGet, types.GCStructGetS, types.GCStructGetU, types.GCStructSet,
+		types.GCArrayNewFixed, types.GCArrayNewData, types.GCArrayNewElem, types.GCArrayCopy,
+		types.GCArrayInitData, types.GCArrayInitElem:
+		if out.TypeIdx, err = d.DecodeUvarint32(); err != nil {
+			return types.GCArg{}, fmt.Errorf("decode type idx: %w", err)
+		}
+		if out.Idx, err = d.DecodeUvarint32(); err != nil {
+			return types.GCArg{}, fmt.Errorf("decode 2nd idx: %w", err)
+		}
+	case types.GCRefTest, types.GCRefTestNull, types.GCRefCast, types.GCRefCastNull:
+		ht, err := d.decodeHeapType()
+		if err != nil {
+			return types.GCArg{}, fmt.Errorf("decode heap type: %w", err)
+		}
+		nullable := out.SubOpcode == types.GCRefTestNull || out.SubOpcode == types.GCRefCastNull
+		out.DstType = types.NewRefType(nullable, ht)
+	case types.GCBrOnCast, types.GCBrOnCastFail:
+		if err := d.decodeBrOnCastArg(&out); err != nil {
+			return types.GCArg{}, err
+		}
+	default:
+	}
+
 	return out, nil
 }
 
@@ -404,6 +652,22 @@ func (d *Decoder) decodeGlobals() ([]types.Global, error) {
 	return out, nil
 }
 
+func (d *Decoder) decodeHeapType() (types.HeapType, error) {
+	ht, err := d.DecodeVarint64()
+	if err != nil {
+		return 0, fmt.Errorf("decode s33: %w", err)
+	}
+
+	switch {
+	case ht >= int64(types.HeapTypeExn) && ht <= int64(types.HeapTypeNoExn):
+	case ht < 0, ht > math.MaxInt32:
+		return 0, fmt.Errorf("bad heap type: %d", ht)
+	default:
+	}
+
+	return types.HeapType(ht), nil
+}
+
 func (d *Decoder) decodeImport(out *types.Import) error {
 	module, err := d.DecodeName()
 	if err != nil {
@@ -439,7 +703,13 @@ func (d *Decoder) decodeImportDescription() (*types.ImportDescription, error) {
 	case types.PortTagMemory:
 		err = d.decodeLimits(&out.Memory)
 	case types.PortTagGlobal:
-		err = d.decodeGlobalType(&out.Global)
+		if err = d.decodeGlobalType(&out.Global); err == nil && out.Global.Mutable == types.MutVar {
+			err = d.features().Require(FeatureMutableGlobals, "mutable global import")
+		}
+	case types.PortTagTag:
+		if err = d.decodeTag(&out.TagType); err == nil {
+			err = d.features().Require(FeatureExceptionHandling, "tag import")
+		}
 	default:
 		return nil, fmt.Errorf("bad tag: %d", tag)
 	}
@@ -480,7 +750,30 @@ func (d *Decoder) decodeIndices() ([]uint32, error) {
 	return out, nil
 }
 
+func (d *Decoder) decodeIndirectNameMap() (types.IndirectNameMap, error) {
+	n, err := d.DecodeUvarint32()
+	if err != nil {
+		return nil, fmt.Errorf("decode #(name map): %w", err)
+	}
+
+	out := make(types.IndirectNameMap, n)
+	for i := uint32(0); i < n; i++ {
+		idx, err := d.DecodeUvarint32()
+		if err != nil {
+			return nil, fmt.Errorf("decode index of %d-th name map: %w", i, err)
+		}
+
+		if out[idx], err = d.decodeNameMap(); err != nil {
+			return nil, fmt.Errorf("%d-th name map: %w", i, err)
+		}
+	}
+
+	return out, nil
+}
+
 func (d *Decoder) decodeInstruction(out *types.Instruction) error {
+	offset := d.offset()
+
 	opcode, err := d.ReadByte()
 	if err != nil {
 		return fmt.Errorf("decode opcode: %w", err)
@@ -493,8 +786,8 @@ func (d *Decoder) decodeInstruction(out *types.Instruction) error {
 		return fmt.Errorf("decode args: %w", err)
 	}
 
-	out.Opcode, out.Args = opcode, args
-	return nil
+	out.Opcode, out.Args, out.Offset = opcode, args, offset
+	return d.features().Require(GetInstructionFeatures(*out), out.GetOpname())
 }
 
 func (d *Decoder) decodeInstructions() ([]types.Instruction, byte, error) {
@@ -518,22 +811,32 @@ func (d *Decoder) decodeLimits(out *types.Limits) error {
 	tag, err := d.ReadByte()
 	if err != nil {
 		return fmt.Errorf("decode tag: %w", err)
+	} else if tag > types.LimitsTagSharedMinMax64 {
+		return fmt.Errorf("bad limits tag: %02x", tag)
 	}
 
-	min, err := d.DecodeUvarint32()
+	decodeBound := d.DecodeUvarint64
+	if tag&types.LimitsTagMin64 == 0 {
+		decodeBound = func() (uint64, error) {
+			v, err := d.DecodeUvarint32()
+			return uint64(v), err
+		}
+	}
+
+	min, err := decodeBound()
 	if err != nil {
 		return fmt.Errorf("decode min: %w", err)
 	}
 
-	var max uint32
-	if tag == 1 {
-		if max, err = d.DecodeUvarint32(); err != nil {
+	var max uint64
+	if tag&types.LimitsTagMinMax != 0 {
+		if max, err = decodeBound(); err != nil {
 			return fmt.Errorf("decode max: %w", err)
 		}
 	}
 
 	out.Tag, out.Min, out.Max = tag, min, max
-	return nil
+	return d.features().Require(GetLimitsFeatures(*out), fmt.Sprintf("limits %s", out))
 }
 
 func (d *Decoder) decodeLocals(out *types.Locals) error {
@@ -573,6 +876,12 @@ func (d *Decoder) decodeMemories() ([]types.Memory, error) {
 		return nil, fmt.Errorf("decode #(memory): %w", err)
 	}
 
+	if n > 1 {
+		if err := d.features().Require(FeatureMultiMemory, "multiple memories"); err != nil {
+			return nil, err
+		}
+	}
+
 	out := make([]types.Memory, n)
 	for i := range out {
 		if err := d.decodeLimits(&out[i]); err != nil {
@@ -589,15 +898,95 @@ func (d *Decoder) decodeMemoryArg() (types.MemoryArg, error) {
 		return types.MemoryArg{}, fmt.Errorf("decode align: %w", err)
 	}
 
-	offset, err := d.DecodeUvarint32()
+	// bit 6 of the alignment flags an explicit memory index following it
+	var memoryIdx uint32
+	if align&0x40 != 0 {
+		if memoryIdx, err = d.DecodeUvarint32(); err != nil {
+			return types.MemoryArg{}, fmt.Errorf("decode memory idx: %w", err)
+		}
+		align &^= 0x40
+	}
+
+	offset, err := d.DecodeUvarint64()
 	if err != nil {
 		return types.MemoryArg{}, fmt.Errorf("decode offset: %w", err)
 	}
 
-	out := types.MemoryArg{Align: align, Offset: offset}
+	out := types.MemoryArg{Align: align, MemoryIdx: memoryIdx, Offset: offset}
+	return out, nil
+}
+
+func (d *Decoder) decodeNameMap() (types.NameMap, error) {
+	n, err := d.DecodeUvarint32()
+	if err != nil {
+		return nil, fmt.Errorf("decode #(name): %w", err)
+	}
+
+	out := make(types.NameMap, n)
+	for i := uint32(0); i < n; i++ {
+		idx, err := d.DecodeUvarint32()
+		if err != nil {
+			return nil, fmt.Errorf("decode index of %d-th name: %w", i, err)
+		}
+
+		if out[idx], err = d.DecodeName(); err != nil {
+			return nil, fmt.Errorf("%d-th name: %w", i, err)
+		}
+	}
+
 	return out, nil
 }
 
+// decodeNames decodes the payload of the name section, skipping subsections unknown so far.
+func (d *Decoder) decodeNames(out *types.Names) error {
+	for d.Len() > 0 {
+		ID, err := d.ReadByte()
+		if err != nil {
+			return fmt.Errorf("read subsection ID: %w", err)
+		}
+
+		buf, err := d.DecodeBytes()
+		if err != nil {
+			return fmt.Errorf("decode subsection(%d): %w", ID, err)
+		}
+
+		dd := NewDecoder(buf)
+		switch ID {
+		case types.NameSubsectionIDModule:
+			out.Module, err = dd.DecodeName()
+		case types.NameSubsectionIDFunc:
+			out.Functions, err = dd.decodeNameMap()
+		case types.NameSubsectionIDLocal:
+			out.Locals, err = dd.decodeIndirectNameMap()
+		case types.NameSubsectionIDLabel:
+			out.Labels, err = dd.decodeIndirectNameMap()
+		case types.NameSubsectionIDType:
+			out.Types, err = dd.decodeNameMap()
+		case types.NameSubsectionIDTable:
+			out.Tables, err = dd.decodeNameMap()
+		case types.NameSubsectionIDMemory:
+			out.Memories, err = dd.decodeNameMap()
+		case types.NameSubsectionIDGlobal:
+			out.Globals, err = dd.decodeNameMap()
+		case types.NameSubsectionIDElement:
+			out.Elements, err = dd.decodeNameMap()
+		case types.NameSubsectionIDData:
+			out.Data, err = dd.decodeNameMap()
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("bad subsection(%d): %w", ID, err)
+		}
+
+		if dd.Len() != 0 {
+			return fmt.Errorf("subsection(%d) has %d trailing bytes", ID, dd.Len())
+		}
+	}
+
+	return nil
+}
+
 func (d *Decoder) decodeNonCustomSectionIntoModule(ID byte, m *Module) error {
 	var err error
 
@@ -628,6 +1017,13 @@ func (d *Decoder) decodeNonCustomSectionIntoModule(ID byte, m *Module) error {
 		m.Codes, err = d.decodeCodes()
 	case types.SectionIDData:
 		m.Data, err = d.decodeData()
+	case types.SectionIDTag:
+		m.Tags, err = d.decodeTags()
+	case types.SectionIDDataCount:
+		var n uint32
+		if n, err = d.DecodeUvarint32(); err == nil {
+			m.DataCount = &n
+		}
 	default:
 		err = fmt.Errorf("invalid section ID(%v)", ID)
 	}
@@ -644,6 +1040,50 @@ func (d *Decoder) decodeStart() (uint32, error) {
 	return funcIdx, err
 }
 
+// decodeStorageType decodes the storage type of fields, which is a packed type or value type.
+func (d *Decoder) decodeStorageType() (types.ValueType, error) {
+	t, err := d.ReadByte()
+	if err != nil {
+		return types.ValueTypeUnknown, fmt.Errorf("read type: %w", err)
+	}
+
+	if vt := types.ValueType(t); types.IsPackedType(vt) {
+		return vt, nil
+	}
+
+	if err := d.UnreadByte(); err != nil {
+		return types.ValueTypeUnknown, fmt.Errorf("unread type: %w", err)
+	}
+
+	return d.decodeValueType()
+}
+
+// decodeSubType decodes a composite type optionally wrapped as sub type, whose first byte tag is
+// read already.
+func (d *Decoder) decodeSubType(tag byte) (*types.FuncType, error) {
+	if tag != types.TypeTagSub && tag != types.TypeTagSubFinal {
+		return d.decodeFuncType(tag)
+	}
+
+	superTypes, err := d.decodeIndices()
+	if err != nil {
+		return nil, fmt.Errorf("decode super types: %w", err)
+	}
+
+	compositeTag, err := d.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("read tag: %w", err)
+	}
+
+	out, err := d.decodeFuncType(compositeTag)
+	if err != nil {
+		return nil, err
+	}
+	out.Final, out.SuperTypes = tag == types.TypeTagSubFinal, superTypes
+
+	return out, nil
+}
+
 func (d *Decoder) decodeTable(t *types.Table) error {
 	elemType, err := d.ReadByte()
 	if err != nil {
@@ -677,19 +1117,145 @@ func (d *Decoder) decodeTables() ([]types.Table, error) {
 	return out, nil
 }
 
+func (d *Decoder) decodeTag(out *types.Tag) error {
+	attribute, err := d.ReadByte()
+	if err != nil {
+		return fmt.Errorf("decode attribute: %w", err)
+	} else if attribute != types.TagAttributeException {
+		return fmt.Errorf("bad attribute: %02x", attribute)
+	}
+
+	typeIdx, err := d.DecodeUvarint32()
+	if err != nil {
+		return fmt.Errorf("decode type idx: %w", err)
+	}
+
+	out.Attribute, out.Type = attribute, typeIdx
+	return nil
+}
+
+func (d *Decoder) decodeTags() ([]types.Tag, error) {
+	n, err := d.DecodeUvarint32()
+	if err != nil {
+		return nil, fmt.Errorf("decode #(tag): %w", err)
+	}
+
+	out := make([]types.Tag, n)
+	for i := range out {
+		if err := d.decodeTag(&out[i]); err != nil {
+			return nil, fmt.Errorf("decode %d-th tag: %w", i, err)
+		}
+	}
+
+	return out, nil
+}
+
+func (d *Decoder) decodeTruncSatArgs() (interface{}, error) {
+	subOpcode, err := d.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("read sub-opcode: %w", err)
+	}
+
+	out := types.BulkArg{SubOpcode: subOpcode}
+	switch subOpcode {
+	case types.BulkMemoryInit:
+		if out.DataIdx, err = d.DecodeUvarint32(); err != nil {
+			return nil, fmt.Errorf("decode data idx: %w", err)
+		}
+		out.MemoryIdx, err = d.DecodeUvarint32()
+	case types.BulkDataDrop:
+		out.DataIdx, err = d.DecodeUvarint32()
+	case types.BulkMemoryCopy:
+		if out.MemoryIdx, err = d.DecodeUvarint32(); err != nil {
+			return nil, fmt.Errorf("decode destination memory idx: %w", err)
+		}
+		out.SrcMemoryIdx, err = d.DecodeUvarint32()
+	case types.BulkMemoryFill:
+		out.MemoryIdx, err = d.DecodeUvarint32()
+	default:
+		// saturating truncations take no immediates, while table instructions are unsupported
+		if subOpcode > types.BulkMemoryInit {
+			return nil, fmt.Errorf("unsupported 0xfc sub-opcode: %02x", subOpcode)
+		}
+		return subOpcode, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("decode args of sub-opcode %d: %w", subOpcode, err)
+	}
+
+	return out, nil
+}
+
+func (d *Decoder) decodeTryTable() (*types.TryTable, error) {
+	blockType, err := d.decodeBlockType()
+	if err != nil {
+		return nil, fmt.Errorf("decode block type: %w", err)
+	}
+
+	n, err := d.DecodeUvarint32()
+	if err != nil {
+		return nil, fmt.Errorf("decode #(catch): %w", err)
+	}
+
+	catches := make([]types.Catch, n)
+	for i := range catches {
+		if err := d.decodeCatch(&catches[i]); err != nil {
+			return nil, fmt.Errorf("decode %d-th catch: %w", i, err)
+		}
+	}
+
+	instructions, endOpcode, err := d.decodeInstructions()
+	if err != nil {
+		return nil, fmt.Errorf("decode instructions: %w", err)
+	} else if endOpcode != types.OpcodeEnd {
+		return nil, fmt.Errorf("invalid end opcode: %v", endOpcode)
+	}
+
+	out := &types.TryTable{BlockType: blockType, Catches: catches, Instructions: instructions}
+	return out, nil
+}
+
+// decodeTypes decodes the type section, flattening types in rec groups into the type index space.
 func (r *Decoder) decodeTypes() ([]types.FuncType, error) {
 	n, err := r.DecodeVarint32()
 	if err != nil {
 		return nil, fmt.Errorf("read #(types): %w", err)
 	}
 
-	out := make([]types.FuncType, n)
-	for i := range out {
-		v, err := r.decodeFuncType()
+	out := make([]types.FuncType, 0, n)
+	for i := 0; i < int(n); i++ {
+		tag, err := r.ReadByte()
 		if err != nil {
-			return nil, fmt.Errorf("decode %d-th func type: %w", i, err)
+			return nil, fmt.Errorf("read %d-th type's tag: %w", i, err)
+		}
+
+		m := uint32(1)
+		if tag == types.TypeTagRec {
+			if err := r.features().Require(FeatureGC, "rec group"); err != nil {
+				return nil, err
+			}
+			if m, err = r.DecodeUvarint32(); err != nil {
+				return nil, fmt.Errorf("decode #(types) of %d-th rec group: %w", i, err)
+			}
+		}
+
+		for j := uint32(0); j < m; j++ {
+			subTag := tag
+			if tag == types.TypeTagRec {
+				if subTag, err = r.ReadByte(); err != nil {
+					return nil, fmt.Errorf("read tag of type %d: %w", len(out), err)
+				}
+			}
+
+			v, err := r.decodeSubType(subTag)
+			if err != nil {
+				return nil, fmt.Errorf("decode type %d: %w", len(out), err)
+			} else if err := r.features().Require(GetTypeFeatures(*v), "type "+v.String()); err != nil {
+				return nil, err
+			}
+			out = append(out, *v)
 		}
-		out[i] = *v
 	}
 
 	return out, nil
@@ -701,13 +1267,31 @@ func (d *Decoder) decodeValueType() (types.ValueType, error) {
 		return types.ValueTypeUnknown, fmt.Errorf("decode type: %w", err)
 	}
 
-	switch t {
+	var out types.ValueType
+	switch vt := types.ValueType(t); vt {
 	case types.ValueTypeI32, types.ValueTypeI64, types.ValueTypeF32, types.ValueTypeF64:
+		return vt, nil
+	case types.ValueTypeRef, types.ValueTypeRefNull:
+		ht, err := d.decodeHeapType()
+		if err != nil {
+			return types.ValueTypeUnknown, fmt.Errorf("decode heap type: %w", err)
+		}
+		out = types.NewRefType(vt == types.ValueTypeRefNull, ht)
 	default:
-		return types.ValueTypeUnknown, fmt.Errorf("invalid type: %02x-%02x", t, types.ValueTypeI32)
+		// abbreviations are abstract heap types taken as nullable references
+		ht := types.HeapType(int8(t<<1) >> 1)
+		if ht < types.HeapTypeExn || ht > types.HeapTypeNoExn {
+			return types.ValueTypeUnknown, fmt.Errorf("invalid type: %02x", t)
+		}
+		out = types.NewRefType(true, ht)
 	}
 
-	return t, nil
+	what := types.StringifyValueType(out)
+	if err := d.features().Require(GetValueTypeFeatures(out), what); err != nil {
+		return types.ValueTypeUnknown, err
+	}
+
+	return out, nil
 }
 
 func (d *Decoder) decodeValueTypes() ([]types.ValueType, error) {
@@ -736,3 +1320,12 @@ func (d *Decoder) decodeZero() error {
 
 	return nil
 }
+
+func (d *Decoder) newDecodeError(sectionID int, err error) *DecodeError {
+	return &DecodeError{SectionID: sectionID, Offset: d.offset(), Err: err}
+}
+
+// offset tells the count of bytes decoded so far.
+func (d *Decoder) offset() uint32 {
+	return uint32(d.Size()) - uint32(d.Len())
+}
@@ -0,0 +1,161 @@
+package wavm_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/validator"
+)
+
+func TestDecodeTruncSatArgs(t *testing.T) {
+	testVector := []struct {
+		body   []byte
+		expect interface{}
+		err    string
+	}{
+		{[]byte{0x43, 0, 0, 0, 0, 0xFC, 0x00, 0x1A}, byte(0x00), ""},
+		{[]byte{0x44, 0, 0, 0, 0, 0, 0, 0, 0, 0xFC, 0x07, 0x1A}, byte(0x07), ""},
+		{[]byte{0x41, 0, 0x41, 0, 0x41, 0, 0xFC, 0x0B, 0x00},
+			types.BulkArg{SubOpcode: types.BulkMemoryFill}, ""},
+		{[]byte{0x41, 0, 0x41, 0, 0x41, 0, 0xFC, 0x0C, 0x00, 0x00}, nil, "sub-opcode: 0c"},
+		{[]byte{0xFC, 0x10, 0x00, 0x1A}, nil, "sub-opcode: 10"},
+		{[]byte{0xFC, 0x11, 0x00}, nil, "sub-opcode: 11"},
+	}
+
+	for i, c := range testVector {
+		m, err := wavm.NewDecoder(wasmtest.Module(testFuncSections(c.body)...)).DecodeModule()
+		if c.err != "" {
+			var decodeErr *wavm.DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.SectionID != types.SectionIDCode ||
+				!strings.Contains(err.Error(), c.err) {
+				t.Fatalf("#%d: expect error of code section containing %q, got %v", i, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d: decode: %v", i, err)
+		}
+
+		var got interface{}
+		for _, v := range m.Codes[0].Expr {
+			if v.Opcode == types.OpcodeTruncSat {
+				got = v.Args
+			}
+		}
+		if got != c.expect {
+			t.Fatalf("#%d: expect args %v, got %v", i, c.expect, got)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	testVector := []struct {
+		buf       []byte
+		sectionID int
+		offset    uint32
+		err       string
+	}{
+		{[]byte{0x00, 'a', 's'}, wavm.SectionIDPreamble, 3, "decode magic"},
+		{[]byte{0x00, 'a', 's', 'x', 1, 0, 0, 0}, wavm.SectionIDPreamble, 4, "bad magic"},
+		{[]byte{0x00, 'a', 's', 'm', 2, 0, 0, 0}, wavm.SectionIDPreamble, 8, "unsupported version"},
+		// the type section is cut short
+		{wasmtest.Module([]byte{types.SectionIDType, 5, 1}), types.SectionIDType, 11, "EOF"},
+		// the type section follows the func section
+		{wasmtest.Module([]byte{types.SectionIDFunc, 1, 0, types.SectionIDType, 1, 0}),
+			types.SectionIDType, 12, "malformed section ID"},
+		// the opcode 0x27 is reserved
+		{wasmtest.Module(testFuncSections([]byte{0x41, 5, 0x27})...), types.SectionIDCode, 31,
+			"unknown opcode"},
+	}
+
+	for i, c := range testVector {
+		_, err := wavm.NewDecoder(c.buf).DecodeModule()
+		var decodeErr *wavm.DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("#%d: expect *DecodeError, got %v", i, err)
+		}
+		if decodeErr.SectionID != c.sectionID || decodeErr.Offset != c.offset ||
+			!strings.Contains(err.Error(), c.err) {
+			t.Fatalf("#%d: expect error of section(%d) at 0x%x containing %q, got %v", i,
+				c.sectionID, c.offset, c.err, err)
+		}
+	}
+}
+
+func TestDecodeOffsets(t *testing.T) {
+	m, err := wavm.NewDecoder(wasmtest.Module(testFuncSections([]byte{0x41, 5, 0x1A})...)).
+		DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	// the code goes after the preamble of 8 bytes, types of 6, funcs of 4, memories of 5, then the
+	// section ID, size, #(code), body size and #(locals)
+	for i, expect := range []uint32{28, 30} {
+		if got := m.Codes[0].Expr[i].Offset; got != expect {
+			t.Fatalf("%d-th instruction: expect offset 0x%x, got 0x%x", i, expect, got)
+		}
+	}
+}
+
+func TestDecodeNames(t *testing.T) {
+	names := wasmtest.Concat(
+		wasmtest.Section(types.NameSubsectionIDModule, wasmtest.Name("demo")),
+		wasmtest.Section(types.NameSubsectionIDFunc, []byte{1, 0}, wasmtest.Name("main")),
+		wasmtest.Section(types.NameSubsectionIDLocal, []byte{1, 0, 1, 0}, wasmtest.Name("x")),
+		// unknown subsections are skipped
+		wasmtest.Section(0x7F, []byte{1, 2, 3}),
+		wasmtest.Section(types.NameSubsectionIDGlobal, []byte{1, 0}, wasmtest.Name("answer")),
+	)
+
+	// global.set of the immutable global 0
+	sections := testFuncSections([]byte{0x41, 42, 0x24, 0})
+	sections = append(sections[:3], append([][]byte{wasmtest.Section(types.SectionIDGlobal,
+		wasmtest.Vec([]byte{0x7F, 0, 0x41, 42, 0x0B}))}, sections[3:]...)...)
+
+	m, err := wavm.NewDecoder(wasmtest.Module(append(sections,
+		wasmtest.CustomSection(types.CustomNameSection, names))...)).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if m.Names.Module != "demo" || m.Names.Functions[0] != "main" || m.Names.Locals[0][0] != "x" ||
+		m.Names.Globals[0] != "answer" {
+		t.Fatalf("bad names: %+v", m.Names)
+	}
+	if got := m.Names.DescribeFunc(1); got != "func[1]" {
+		t.Fatalf("expect func[1] unnamed, got %s", got)
+	}
+
+	// names go into diagnostics
+	err = validator.Validate(*m)
+	if err == nil || !strings.Contains(err.Error(), "global[0] <answer>") ||
+		!strings.Contains(err.Error(), "main") {
+		t.Fatalf("expect error of global[0] <answer> in main, got %v", err)
+	}
+
+	// malformed names are dropped rather than failing the module
+	m, err = wavm.NewDecoder(wasmtest.Module(append(sections,
+		wasmtest.CustomSection(types.CustomNameSection, names[:len(names)-1]))...)).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode malformed names: %v", err)
+	}
+	if m.Names.Module != "" || m.Names.Functions != nil {
+		t.Fatalf("expect malformed names dropped, got %+v", m.Names)
+	}
+}
+
+// testFuncSections returns sections defining a func of no params and results with body, along
+// with a page of memory.
+func testFuncSections(body []byte) [][]byte {
+	return [][]byte{
+		wasmtest.Section(types.SectionIDType, wasmtest.Vec(wasmtest.FuncType(nil, nil))),
+		wasmtest.Section(types.SectionIDFunc, wasmtest.Vec([]byte{0})),
+		wasmtest.Section(types.SectionIDMemory, wasmtest.Vec([]byte{0, 1})),
+		wasmtest.Section(types.SectionIDCode, wasmtest.Vec(wasmtest.Code(nil, body...))),
+	}
+}
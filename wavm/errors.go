@@ -0,0 +1,26 @@
+package wavm
+
+import "fmt"
+
+// SectionIDPreamble stands for the magic and version preceding sections in DecodeError.
+const SectionIDPreamble = -1
+
+// DecodeError is an error decoding a module, where Offset is where decoding stopped counting from
+// the beginning of the decoded bytes, which is the file offset for modules decoded from files.
+type DecodeError struct {
+	SectionID int // SectionIDPreamble if failing before any section
+	Offset    uint32
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	if e.SectionID == SectionIDPreamble {
+		return fmt.Sprintf("bad preamble at 0x%x: %v", e.Offset, e.Err)
+	}
+
+	return fmt.Sprintf("bad section(%d) at 0x%x: %v", e.SectionID, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
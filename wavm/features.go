@@ -0,0 +1,296 @@
+package wavm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// Features is a set of post-MVP proposals, each of which is a bit. Modules may use features except
+// those in their DisabledFeatures, which the decoder sets to its own.
+type Features uint64
+
+const (
+	FeatureMutableGlobals Features = 1 << iota
+	FeatureNonTrappingFPToInt
+	FeatureSignExt
+	FeatureMultiValue
+	FeatureBulkMemory
+	FeatureReferenceTypes
+	FeatureThreads
+	FeatureMemory64
+	FeatureMultiMemory
+	FeatureTailCall
+	FeatureExceptionHandling
+	FeatureExtendedConst
+	FeatureTypedFunctionReferences
+	FeatureGC
+
+	FeaturesMVP Features = 0
+	FeaturesAll          = FeatureGC<<1 - 1
+)
+
+// TargetFeaturesSectionName names the custom section where toolchains list features of modules.
+const TargetFeaturesSectionName = "target_features"
+
+var ErrFeatureDisabled = errors.New("feature disabled")
+
+// featureNames follows names of features in target_features sections, except
+// typed-function-references, which toolchains never list but take as part of gc.
+var featureNames = []string{
+	"mutable-globals",
+	"nontrapping-fptoint",
+	"sign-ext",
+	"multivalue",
+	"bulk-memory",
+	"reference-types",
+	"atomics",
+	"memory64",
+	"multimemory",
+	"tail-call",
+	"exception-handling",
+	"extended-const",
+	"typed-function-references",
+	"gc",
+}
+
+// GetFeatureByName returns the feature named as name, as listed by Features.String.
+func GetFeatureByName(name string) (Features, bool) {
+	for i, v := range featureNames {
+		if v == name {
+			return 1 << i, true
+		}
+	}
+
+	return 0, false
+}
+
+// GetInstructionFeatures returns features required by instr, excluding those of its nested
+// instructions.
+func GetInstructionFeatures(instr types.Instruction) Features {
+	var out Features
+
+	switch instr.Opcode {
+	case types.OpcodeThrow, types.OpcodeThrowRef, types.OpcodeTryTable:
+		out = FeatureExceptionHandling
+	case types.OpcodeReturnCall, types.OpcodeReturnCallIndirect:
+		out = FeatureTailCall
+	case types.OpcodeCallRef, types.OpcodeRefAsNonNull, types.OpcodeBrOnNull, types.OpcodeBrOnNonNull:
+		out = FeatureTypedFunctionReferences
+	case types.OpcodeReturnCallRef:
+		out = FeatureTailCall | FeatureTypedFunctionReferences
+	case types.OpcodeRefNull:
+		out = GetValueTypeFeatures(types.NewRefType(true, instr.Args.(types.HeapType)))
+	case types.OpcodeRefIsNull, types.OpcodeRefFunc:
+		out = FeatureReferenceTypes
+	case types.OpcodeRefEq, types.OpcodeGC:
+		out = FeatureGC
+	case types.OpcodeAtomic:
+		out = FeatureThreads
+	case types.OpcodeMemorySize, types.OpcodeMemoryGrow:
+		if instr.Args.(uint32) != 0 {
+			out = FeatureMultiMemory
+		}
+	case types.OpcodeTruncSat:
+		out = FeatureNonTrappingFPToInt
+	default:
+		if instr.Opcode >= types.OpcodeI32Extend8S && instr.Opcode <= types.OpcodeI64Extend32S {
+			out = FeatureSignExt
+		}
+	}
+
+	switch v := instr.Args.(type) {
+	case *types.Block:
+		out |= getBlockTypeFeatures(v.BlockType)
+	case *types.BlockIf:
+		out |= getBlockTypeFeatures(v.BlockType)
+	case *types.TryTable:
+		out |= getBlockTypeFeatures(v.BlockType)
+	case types.MemoryArg:
+		out |= getMemoryIdxFeatures(v.MemoryIdx)
+	case types.AtomicArg:
+		out |= getMemoryIdxFeatures(v.MemoryArg.MemoryIdx)
+	case types.BulkArg:
+		out = FeatureBulkMemory | getMemoryIdxFeatures(v.MemoryIdx) |
+			getMemoryIdxFeatures(v.SrcMemoryIdx)
+	default:
+	}
+
+	return out
+}
+
+// GetLimitsFeatures returns features required by memories or tables limited by l.
+func GetLimitsFeatures(l types.Limits) Features {
+	var out Features
+	if l.Shared() {
+		out |= FeatureThreads
+	}
+	if l.Is64() {
+		out |= FeatureMemory64
+	}
+
+	return out
+}
+
+// GetTypeFeatures returns features required by the entry t of the type section.
+func GetTypeFeatures(t types.FuncType) Features {
+	var out Features
+	if !t.IsFunc() || len(t.SuperTypes) > 0 || !t.Final {
+		out |= FeatureGC
+	}
+	if len(t.ResultTypes) > 1 {
+		out |= FeatureMultiValue
+	}
+
+	for _, v := range t.ParamTypes {
+		out |= GetValueTypeFeatures(v)
+	}
+	for _, v := range t.ResultTypes {
+		out |= GetValueTypeFeatures(v)
+	}
+	for _, v := range t.Fields {
+		out |= GetValueTypeFeatures(v.StorageType)
+	}
+
+	return out
+}
+
+// GetValueTypeFeatures returns features required by values of type t.
+func GetValueTypeFeatures(t types.ValueType) Features {
+	if !types.IsRefType(t) {
+		return FeaturesMVP
+	}
+
+	var out Features
+	switch ht := types.GetHeapType(t); {
+	case ht == types.HeapTypeFunc, ht == types.HeapTypeExtern:
+		out = FeatureReferenceTypes
+	case ht == types.HeapTypeExn, ht == types.HeapTypeNoExn:
+		out = FeatureExceptionHandling
+	case ht < 0:
+		out = FeatureGC
+	default:
+		out = FeatureTypedFunctionReferences
+	}
+
+	if !types.IsNullable(t) {
+		out |= FeatureTypedFunctionReferences
+	}
+
+	return out
+}
+
+// ParseFeatures parses the comma separated names of features, where "all" and "mvp" stand for
+// FeaturesAll and FeaturesMVP.
+func ParseFeatures(s string) (Features, error) {
+	var out Features
+	for _, name := range strings.Split(s, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+		case "all":
+			out |= FeaturesAll
+		case "mvp":
+		default:
+			f, ok := GetFeatureByName(name)
+			if !ok {
+				return 0, fmt.Errorf("unknown feature: %s", name)
+			}
+			out |= f
+		}
+	}
+
+	return out, nil
+}
+
+// ParseTargetFeatures parses the payload of a target_features custom section, which lists features
+// prefixed by '+' (used), '=' (required) or '-' (disallowed). Used and required features known to
+// wavm are enabled, and the others are ignored. gc enables typed function references as well.
+func ParseTargetFeatures(payload []byte) (Features, error) {
+	d := NewDecoder(payload)
+
+	n, err := d.DecodeUvarint32()
+	if err != nil {
+		return 0, fmt.Errorf("decode features count: %w", err)
+	}
+
+	var out Features
+	for i := uint32(0); i < n; i++ {
+		prefix, err := d.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("read prefix of %d-th feature: %w", i, err)
+		}
+
+		name, err := d.DecodeName()
+		if err != nil {
+			return 0, fmt.Errorf("decode name of %d-th feature: %w", i, err)
+		}
+
+		switch prefix {
+		case '+', '=':
+			switch f, ok := GetFeatureByName(name); {
+			case !ok || f == FeatureTypedFunctionReferences:
+			case f == FeatureGC:
+				out |= FeatureGC | FeatureTypedFunctionReferences
+			default:
+				out |= f
+			}
+		case '-':
+		default:
+			return 0, fmt.Errorf("invalid prefix of feature %s: %q", name, prefix)
+		}
+	}
+
+	if d.Len() != 0 {
+		return 0, fmt.Errorf("%d trailing bytes", d.Len())
+	}
+
+	return out, nil
+}
+
+// Has tells whether all features in required are enabled.
+func (f Features) Has(required Features) bool {
+	return f&required == required
+}
+
+// Require checks features in required are all enabled, or else errs with the missing ones, where
+// what describes the thing requiring them.
+func (f Features) Require(required Features, what string) error {
+	if missing := required &^ f; missing != 0 {
+		return fmt.Errorf("%s requires %s: %w", what, missing, ErrFeatureDisabled)
+	}
+
+	return nil
+}
+
+func (f Features) String() string {
+	var names []string
+	for i, v := range featureNames {
+		if f&(1<<i) != 0 {
+			names = append(names, v)
+		}
+	}
+
+	if len(names) == 0 {
+		return "mvp"
+	}
+
+	return strings.Join(names, ",")
+}
+
+func getBlockTypeFeatures(t types.BlockType) Features {
+	if t >= 0 {
+		return FeatureMultiValue
+	}
+
+	return FeaturesMVP
+}
+
+func getMemoryIdxFeatures(idx types.MemoryIdx) Features {
+	if idx != 0 {
+		return FeatureMultiMemory
+	}
+
+	return FeaturesMVP
+}
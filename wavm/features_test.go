@@ -0,0 +1,148 @@
+package wavm_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/validator"
+)
+
+func TestDisabledFeatures(t *testing.T) {
+	// return_call 0
+	buf := wasmtest.Module(testFuncSections([]byte{0x12, 0})...)
+
+	d := &wavm.Decoder{Reader: bytes.NewReader(buf)}
+	m, err := d.DecodeModule()
+	if err != nil {
+		t.Fatalf("decode by the zero decoder: %v", err)
+	}
+	if err := validator.Validate(*m); err != nil {
+		t.Fatalf("validate with no features disabled: %v", err)
+	}
+
+	m.DisabledFeatures = wavm.FeatureTailCall
+	if err := validator.Validate(*m); !errors.Is(err, wavm.ErrFeatureDisabled) {
+		t.Fatalf("validate without tail calls: expect %v, got %v", wavm.ErrFeatureDisabled, err)
+	}
+
+	d = wavm.NewDecoder(buf)
+	d.DisabledFeatures = wavm.FeaturesAll
+	if _, err := d.DecodeModule(); !errors.Is(err, wavm.ErrFeatureDisabled) {
+		t.Fatalf("decode as MVP: expect %v, got %v", wavm.ErrFeatureDisabled, err)
+	}
+}
+
+func TestFeatureGates(t *testing.T) {
+	withMemory := func(memories ...byte) [][]byte {
+		out := testFuncSections(nil)
+		out[2] = wasmtest.Section(types.SectionIDMemory, memories)
+		return out
+	}
+	withGlobal := func(expr ...byte) [][]byte {
+		global := wasmtest.Section(types.SectionIDGlobal, wasmtest.Vec(
+			wasmtest.Concat([]byte{0x7F, 0}, expr, []byte{0x0B})))
+		out := testFuncSections(nil)
+		return append(out[:3], global, out[3])
+	}
+
+	testVector := []struct {
+		feature  wavm.Features
+		sections [][]byte
+	}{
+		{wavm.FeatureSignExt, testFuncSections([]byte{0x41, 0, 0xC0, 0x1A})},
+		{wavm.FeatureBulkMemory, testFuncSections([]byte{0x41, 0, 0x41, 0, 0x41, 0, 0xFC, 0x0B, 0})},
+		{wavm.FeatureThreads, testFuncSections([]byte{0x41, 0, 0xFE, 0x10, 2, 0, 0x1A})},
+		{wavm.FeatureThreads, withMemory(1, 0x03, 1, 1)},
+		{wavm.FeatureMemory64, withMemory(1, 0x04, 1)},
+		{wavm.FeatureMultiMemory, withMemory(2, 0x00, 1, 0x00, 1)},
+		{wavm.FeatureTailCall, testFuncSections([]byte{0x12, 0})},
+		{wavm.FeatureExceptionHandling, testFuncSections([]byte{0x1F, 0x40, 0, 0x0B})},
+		{wavm.FeatureExtendedConst, withGlobal(0x41, 1, 0x41, 2, 0x6A)},
+		{wavm.FeatureTypedFunctionReferences, testFuncSections([]byte{0xD0, 0x70, 0xD4, 0x1A})},
+		{wavm.FeatureGC, testFuncSections([]byte{0x41, 1, 0xFB, 0x1C, 0x1A})},
+	}
+
+	for _, c := range testVector {
+		buf := wasmtest.Module(c.sections...)
+
+		m, err := wavm.NewDecoder(buf).DecodeModule()
+		if err != nil {
+			t.Fatalf("%s: decode: %v", c.feature, err)
+		}
+		if err := validator.Validate(*m); err != nil {
+			t.Fatalf("%s: validate: %v", c.feature, err)
+		}
+
+		// the feature is required by either the decoder or the validator
+		d := wavm.NewDecoder(buf)
+		d.DisabledFeatures = c.feature
+		if m, err = d.DecodeModule(); err == nil {
+			err = validator.Validate(*m)
+		}
+		if !errors.Is(err, wavm.ErrFeatureDisabled) {
+			t.Fatalf("%s: expect %v, got %v", c.feature, wavm.ErrFeatureDisabled, err)
+		}
+	}
+}
+
+func TestParseFeatures(t *testing.T) {
+	testVector := []struct {
+		s      string
+		expect wavm.Features
+	}{
+		{"mvp", wavm.FeaturesMVP},
+		{"all", wavm.FeaturesAll},
+		{"tail-call, gc", wavm.FeatureTailCall | wavm.FeatureGC},
+		{"mvp,atomics", wavm.FeatureThreads},
+	}
+
+	for _, c := range testVector {
+		got, err := wavm.ParseFeatures(c.s)
+		if err != nil {
+			t.Fatalf("%q: %v", c.s, err)
+		}
+		if got != c.expect {
+			t.Fatalf("%q: expect %s, got %s", c.s, c.expect, got)
+		}
+	}
+
+	if _, err := wavm.ParseFeatures("simd128"); err == nil {
+		t.Fatal("expect unknown features to fail")
+	}
+}
+
+func TestParseTargetFeatures(t *testing.T) {
+	testVector := []struct {
+		features []string
+		expect   wavm.Features
+	}{
+		{nil, wavm.FeaturesMVP},
+		{[]string{"+sign-ext", "=mutable-globals", "-atomics", "+simd128"},
+			wavm.FeatureSignExt | wavm.FeatureMutableGlobals},
+		{[]string{"+gc"}, wavm.FeatureGC | wavm.FeatureTypedFunctionReferences},
+		{[]string{"+typed-function-references"}, wavm.FeaturesMVP},
+		{[]string{"+multimemory", "+memory64", "+tail-call", "+exception-handling"},
+			wavm.FeatureMultiMemory | wavm.FeatureMemory64 | wavm.FeatureTailCall |
+				wavm.FeatureExceptionHandling},
+	}
+
+	for _, c := range testVector {
+		var entries [][]byte
+		for _, v := range c.features {
+			entries = append(entries, append([]byte{v[0]}, wasmtest.Name(v[1:])...))
+		}
+		payload := wasmtest.Vec(entries...)
+
+		got, err := wavm.ParseTargetFeatures(payload)
+		if err != nil {
+			t.Fatalf("%v: %v", c.features, err)
+		}
+		if got != c.expect {
+			t.Fatalf("%v: expect %s, got %s", c.features, c.expect, got)
+		}
+	}
+}
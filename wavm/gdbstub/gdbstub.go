@@ -0,0 +1,90 @@
+// Package gdbstub implements a stub of the GDB remote serial protocol for debugging wasm guests,
+// following extensions of LLDB for wasm where applicable, see
+// https://sourceware.org/gdb/onlinedocs/gdb/Remote-Protocol.html and
+// https://lldb.llvm.org/resources/lldbgdbremote.html.
+package gdbstub
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// Server serves a session debugging the entry func of a module on a VM as its only thread, which
+// stops before the 1st instruction of the func. Addresses follow LLDB, where those of code are
+// offsets into the module binary tagged by 0x4000000000000000, and others are offsets into the
+// 1st linear memory.
+type Server struct {
+	module *wavm.Module
+	binary []byte // nil if unknown
+
+	w        io.Writer
+	noAck    bool
+	features map[string]bool // supported by the client as told by qSupported
+	packets  <-chan packet   // nil once the client disconnects
+	queued   []packet        // received while the guest runs, which are served once it stops
+	quit     chan struct{}   // closed after serving
+	// finished tells the client detaches or kills the guest.
+	finished bool
+
+	vm       *vm.VM
+	entry    string
+	debugger *vm.Debugger
+	stops    chan vm.Stop
+	resume   chan vm.Action
+	done     chan error // receives the error returned by the entry func
+	stop     *vm.Stop   // nil if running or exited
+	exited   bool
+	// lastReply is the reply to the latest stop or exit.
+	lastReply string
+}
+
+// Serve serves a session over rw, which starts the entry func of m right away and returns after
+// the client detaches, kills the guest or disconnects. The guest is aborted if running then.
+func (s *Server) Serve(rw io.ReadWriter, m *vm.VM, entry string) error {
+	s.w = rw
+	s.vm, s.entry = m, entry
+	s.debugger = vm.NewDebugger(m, s.onStop)
+	defer s.abort()
+
+	packets := make(chan packet)
+	s.packets = packets
+	defer close(s.quit)
+	go readPackets(bufio.NewReader(rw), packets, s.quit)
+
+	s.debugger.Pause()
+	go s.run()
+	if err := s.wait(); err != nil {
+		return err
+	}
+
+	for !s.finished && s.packets != nil {
+		p, ok := s.nextPacket()
+		if !ok {
+			return nil
+		}
+
+		if err := s.serve(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewServer creates a server debugging module, where binary is the encoded module read by the
+// client as code if not nil.
+func NewServer(module *wavm.Module, binary []byte) *Server {
+	out := &Server{
+		module:   module,
+		binary:   binary,
+		features: make(map[string]bool),
+		quit:     make(chan struct{}),
+		stops:    make(chan vm.Stop),
+		resume:   make(chan vm.Action),
+		done:     make(chan error, 1),
+	}
+	return out
+}
@@ -0,0 +1,604 @@
+package gdbstub
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// threadID is of the only thread running the entry func.
+const threadID = 1
+
+// Types of addresses tagged at their 2 highest bits, as LLDB for wasm.
+const (
+	addrTypeMemory = 0
+	addrTypeCode   = 1
+)
+
+// Signals reported by stop replies.
+const (
+	sigint  = 2
+	sigtrap = 5
+	sigkill = 9
+)
+
+const (
+	// maxPacketSize is told to the client, which limits memory transferred by a packet.
+	maxPacketSize = 0x4000
+	// defaultModuleName names modules without names in the name section.
+	defaultModuleName = "module.wasm"
+	// triple is the target triple of guests.
+	triple = "wasm32-unknown-unknown-wasm"
+)
+
+// pcRegisterInfo describes the only register, the PC, to LLDB.
+const pcRegisterInfo = "name:pc;alt-name:pc;bitsize:64;offset:0;encoding:uint;format:hex;" +
+	"set:General Purpose Registers;gcc:16;dwarf:16;generic:pc;"
+
+var (
+	errExited         = errors.New("exited")
+	errUnknownAddress = errors.New("unknown address")
+)
+
+// abort aborts the guest if stopped, and waits until it returns.
+func (s *Server) abort() {
+	if s.exited {
+		return
+	}
+
+	if s.stop != nil {
+		s.resume <- vm.ActionAbort
+	}
+	<-s.done
+	s.exited = true
+}
+
+func (s *Server) checkStopped() error {
+	if s.exited {
+		return errExited
+	}
+
+	return nil
+}
+
+// detach detaches the debugger, and resumes the guest to run until it returns.
+func (s *Server) detach() {
+	s.finished = true
+	if s.exited {
+		return
+	}
+
+	s.debugger.Detach()
+	s.stop = nil
+	s.resume <- vm.ActionContinue
+}
+
+func (s *Server) errorReply(err error) string {
+	if s.features["error-message"] {
+		return "E." + err.Error()
+	}
+
+	return "E01"
+}
+
+func (s *Server) handle(data string) (string, error) {
+	cmd, args := splitPacket(data)
+	switch cmd {
+	case "?":
+		return s.lastReply, nil
+	case "c":
+		return s.resumeWith(vm.ActionContinue)
+	case "s":
+		return s.resumeWith(vm.ActionStepInto)
+	case "D":
+		s.detach()
+		return "OK", nil
+	case "k":
+		s.finished = true
+		return fmt.Sprintf("X%02x", sigkill), nil
+	case "g":
+		return s.readPC()
+	case "p":
+		return s.handleReadRegister(args)
+	case "H", "T":
+		return "OK", nil
+	case "m":
+		return s.handleReadMemory(args)
+	case "M":
+		return s.handleWriteMemory(args, false)
+	case "X":
+		return s.handleWriteMemory(args, true)
+	case "Z":
+		return s.handleBreakpoint(args, true)
+	case "z":
+		return s.handleBreakpoint(args, false)
+	case "qSupported":
+		return s.handleSupported(args), nil
+	case "QStartNoAckMode":
+		s.noAck = true
+		return "OK", nil
+	case "qAttached":
+		return "0", nil
+	case "qC":
+		return fmt.Sprintf("QC%x", threadID), nil
+	case "qfThreadInfo":
+		return fmt.Sprintf("m%x", threadID), nil
+	case "qsThreadInfo":
+		return "l", nil
+	case "qHostInfo":
+		return fmt.Sprintf("triple:%s;ptrsize:4;endian:little;", hex.EncodeToString([]byte(triple))), nil
+	case "qProcessInfo":
+		return fmt.Sprintf("pid:1;triple:%s;ptrsize:4;endian:little;",
+			hex.EncodeToString([]byte(triple))), nil
+	case "qXfer":
+		return s.handleXfer(args)
+	case "qWasmCallStack":
+		return s.handleWasmCallStack()
+	case "qWasmGlobal":
+		return s.handleWasmGlobal(args)
+	case "qWasmLocal":
+		return s.handleWasmLocal(args)
+	case "qWasmMem":
+		return s.handleWasmMem(args)
+	case "vCont?":
+		return "vCont;c;C;s;S", nil
+	case "vCont":
+		return s.handleCont(args)
+	default:
+	}
+
+	if n := strings.TrimPrefix(cmd, "qRegisterInfo"); n != cmd {
+		if n != "0" {
+			return "E45", nil // no more registers for LLDB
+		}
+		return pcRegisterInfo, nil
+	}
+
+	return "", nil // unsupported
+}
+
+// handleBreakpoint sets or clears a software breakpoint of args "type,addr,kind", which accepts
+// code addresses tagged or not.
+func (s *Server) handleBreakpoint(args string, set bool) (string, error) {
+	parts := strings.Split(args, ",")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("bad breakpoint '%s'", args)
+	} else if parts[0] != "0" {
+		return "", nil // only software breakpoints are supported
+	}
+
+	addr, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return "", fmt.Errorf("bad address '%s': %w", parts[1], err)
+	}
+	offset := uint32(addr)
+
+	funcIdx, ok := s.module.GetFuncIdxByOffset(offset)
+	if !ok {
+		return "", fmt.Errorf("no code at 0x%x: %w", offset, errUnknownAddress)
+	}
+
+	b := vm.Breakpoint{FuncIdx: funcIdx, Offset: offset}
+	if !set {
+		s.debugger.ClearBreakpoint(b)
+	} else if err := s.debugger.SetBreakpoint(b); err != nil {
+		return "", err
+	}
+
+	return "OK", nil
+}
+
+// handleCont resumes the guest by the 1st action of args, which applies to the only thread.
+func (s *Server) handleCont(args string) (string, error) {
+	action := strings.SplitN(args, ";", 2)[0]
+	switch strings.SplitN(action, ":", 2)[0] {
+	case "c", "C":
+		return s.resumeWith(vm.ActionContinue)
+	case "s", "S":
+		return s.resumeWith(vm.ActionStepInto)
+	default:
+		return "", fmt.Errorf("unsupported action '%s'", action)
+	}
+}
+
+func (s *Server) handleReadMemory(args string) (string, error) {
+	addr, n, err := parseRange(args)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, n)
+	if err := s.readMemory(addr, buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func (s *Server) handleReadRegister(args string) (string, error) {
+	n, err := strconv.ParseUint(args, 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("bad register '%s': %w", args, err)
+	} else if n != 0 {
+		return "", fmt.Errorf("unknown register %d", n)
+	}
+
+	return s.readPC()
+}
+
+// handleSupported records features supported by the client, and replies ones of the server.
+func (s *Server) handleSupported(args string) string {
+	for _, v := range strings.Split(args, ";") {
+		if strings.HasSuffix(v, "+") {
+			s.features[strings.TrimSuffix(v, "+")] = true
+		}
+	}
+
+	return fmt.Sprintf("PacketSize=%x;QStartNoAckMode+;qXfer:libraries:read+;swbreak+;error-message+",
+		maxPacketSize)
+}
+
+// handleWasmCallStack replies PCs of call frames innermost first as 64-bit little endian numbers.
+func (s *Server) handleWasmCallStack() (string, error) {
+	if err := s.checkStopped(); err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for _, v := range s.debugger.Frames() {
+		out.WriteString(encodeUint64(codeAddress(v.Offset)))
+	}
+
+	return out.String(), nil
+}
+
+// handleWasmGlobal replies the global of args "frame;index".
+func (s *Server) handleWasmGlobal(args string) (string, error) {
+	if err := s.checkStopped(); err != nil {
+		return "", err
+	}
+
+	_, idx, err := parseFrameAndIndex(args)
+	if err != nil {
+		return "", err
+	}
+
+	v, err := s.debugger.GetGlobal(idx)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeValue(v)
+}
+
+// handleWasmLocal replies the local of args "frame;index".
+func (s *Server) handleWasmLocal(args string) (string, error) {
+	if err := s.checkStopped(); err != nil {
+		return "", err
+	}
+
+	frame, idx, err := parseFrameAndIndex(args)
+	if err != nil {
+		return "", err
+	}
+
+	locals, err := s.debugger.GetFrameLocals(frame)
+	if err != nil {
+		return "", err
+	} else if int(idx) >= len(locals) {
+		return "", fmt.Errorf("local(%d): %w", idx, vm.ErrIndexOutOfBound)
+	}
+
+	return encodeValue(locals[idx])
+}
+
+// handleWasmMem replies the memory of args "frame;offset;length".
+func (s *Server) handleWasmMem(args string) (string, error) {
+	parts := strings.Split(args, ";")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("bad arguments '%s'", args)
+	}
+
+	return s.handleReadMemory(parts[1] + "," + parts[2])
+}
+
+func (s *Server) handleWriteMemory(args string, isBinary bool) (string, error) {
+	i := strings.IndexByte(args, ':')
+	if i < 0 {
+		return "", fmt.Errorf("no data in '%s'", args)
+	}
+
+	addr, n, err := parseRange(args[:i])
+	if err != nil {
+		return "", err
+	}
+
+	var data []byte
+	if isBinary {
+		data = unescape(args[i+1:])
+	} else if data, err = hex.DecodeString(args[i+1:]); err != nil {
+		return "", fmt.Errorf("decode data: %w", err)
+	}
+	if len(data) != n {
+		return "", fmt.Errorf("%d bytes of data for length %d", len(data), n)
+	}
+
+	if err := s.checkStopped(); err != nil {
+		return "", err
+	}
+
+	typ, offset := splitAddress(addr)
+	if typ != addrTypeMemory {
+		return "", fmt.Errorf("write to 0x%x: %w", addr, errUnknownAddress)
+	} else if err := s.debugger.WriteMemory(0, offset, data); err != nil {
+		return "", err
+	}
+
+	return "OK", nil
+}
+
+// handleXfer replies a chunk of the library list for args "libraries:read::offset,length", which
+// lists the module as the only library at the code address 0.
+func (s *Server) handleXfer(args string) (string, error) {
+	parts := strings.SplitN(args, ":", 4)
+	if len(parts) != 4 || parts[0] != "libraries" || parts[1] != "read" {
+		return "", nil
+	}
+
+	name := s.module.Names.Module
+	if name == "" {
+		name = defaultModuleName
+	}
+	doc := fmt.Sprintf(`<library-list><library name="%s"><section address="0x%x"/></library></library-list>`,
+		name, codeAddress(0))
+
+	offset, n, err := parseRange(parts[3])
+	if err != nil {
+		return "", err
+	} else if offset > uint64(len(doc)) {
+		return "", fmt.Errorf("offset %d beyond %d bytes", offset, len(doc))
+	}
+
+	chunk := doc[offset:]
+	if len(chunk) > n {
+		return "m" + chunk[:n], nil
+	}
+	return "l" + chunk, nil
+}
+
+// readMemory reads the module binary for code addresses, or the 1st memory otherwise.
+func (s *Server) readMemory(addr uint64, buf []byte) error {
+	if err := s.checkStopped(); err != nil {
+		return err
+	}
+
+	typ, offset := splitAddress(addr)
+	switch typ {
+	case addrTypeCode:
+		if s.binary == nil || offset+uint64(len(buf)) > uint64(len(s.binary)) {
+			return fmt.Errorf("read 0x%x: %w", addr, errUnknownAddress)
+		}
+		copy(buf, s.binary[offset:])
+		return nil
+	case addrTypeMemory:
+		return s.debugger.ReadMemory(0, offset, buf)
+	default:
+		return fmt.Errorf("read 0x%x: %w", addr, errUnknownAddress)
+	}
+}
+
+func (s *Server) readPC() (string, error) {
+	if err := s.checkStopped(); err != nil {
+		return "", err
+	}
+
+	return encodeUint64(codeAddress(s.stop.Instruction.Offset)), nil
+}
+
+// resumeWith resumes the stopped guest by action, and replies its next stop or exit.
+func (s *Server) resumeWith(action vm.Action) (string, error) {
+	if err := s.checkStopped(); err != nil {
+		return "", err
+	}
+
+	s.stop = nil
+	s.resume <- action
+	if err := s.wait(); err != nil {
+		return "", err
+	}
+
+	return s.lastReply, nil
+}
+
+// run runs the entry func, and reports its end.
+func (s *Server) run() {
+	_, err := s.vm.InvokeFunc(s.entry)
+	s.done <- err
+}
+
+// serve acks p and replies to it.
+func (s *Server) serve(p packet) error {
+	if p.data == string(rune(interrupt)) {
+		return nil // the guest is stopped already
+	}
+
+	if !s.noAck {
+		ack := "+"
+		if !p.ok {
+			ack = "-"
+		}
+		if _, err := fmt.Fprint(s.w, ack); err != nil {
+			return fmt.Errorf("ack: %w", err)
+		}
+	}
+	if !p.ok {
+		return nil
+	}
+
+	reply, err := s.handle(p.data)
+	if err != nil {
+		reply = s.errorReply(err)
+	}
+	if s.packets == nil {
+		return nil // disconnected while running
+	}
+
+	if err := writePacket(s.w, reply); err != nil {
+		return fmt.Errorf("reply: %w", err)
+	}
+
+	return nil
+}
+
+// nextPacket takes the earliest packet queued while the guest runs, or receives one otherwise.
+func (s *Server) nextPacket() (packet, bool) {
+	if len(s.queued) > 0 {
+		p := s.queued[0]
+		s.queued = s.queued[1:]
+		return p, true
+	}
+
+	p, ok := <-s.packets
+	return p, ok
+}
+
+// onStop is called on the goroutine running the guest, which blocks until resumed.
+func (s *Server) onStop(d *vm.Debugger, stop vm.Stop) vm.Action {
+	s.stops <- stop
+	return <-s.resume
+}
+
+// wait waits until the guest stops or exits, and records the reply telling it. The guest is
+// interrupted on requests of the client or once the client disconnects.
+func (s *Server) wait() error {
+	var interrupted bool
+	for {
+		select {
+		case stop := <-s.stops:
+			s.stop = &stop
+			sig := sigtrap
+			if stop.Reason == vm.StopReasonPause && interrupted {
+				sig = sigint
+			}
+			s.lastReply = fmt.Sprintf("T%02xthread:%x;", sig, threadID)
+			if stop.Reason == vm.StopReasonBreakpoint && s.features["swbreak"] {
+				s.lastReply += "swbreak:;"
+			}
+			return nil
+		case err := <-s.done:
+			s.exited = true
+			if err == nil {
+				s.lastReply = "W00"
+				return nil
+			}
+
+			s.lastReply = "W01"
+			if s.packets == nil {
+				return nil
+			}
+			return writePacket(s.w, "O"+hex.EncodeToString([]byte(describeError(err)+"\n")))
+		case p, ok := <-s.packets:
+			if !ok {
+				s.packets = nil
+			}
+			if !ok || p.data == string(rune(interrupt)) {
+				interrupted = true
+				s.debugger.Pause()
+			} else {
+				s.queued = append(s.queued, p)
+			}
+		}
+	}
+}
+
+func codeAddress(offset uint32) uint64 {
+	return addrTypeCode<<62 | uint64(offset)
+}
+
+func describeError(err error) string {
+	var trap *vm.Trap
+	if !errors.As(err, &trap) {
+		return err.Error()
+	}
+
+	return err.Error() + "\n" + trap.Backtrace()
+}
+
+func encodeUint64(v uint64) string {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return hex.EncodeToString(b[:])
+}
+
+// encodeValue encodes v in little endian by its size.
+func encodeValue(v types.WasmVal) (string, error) {
+	var b [8]byte
+	switch vv := v.(type) {
+	case int32:
+		binary.LittleEndian.PutUint32(b[:], uint32(vv))
+		return hex.EncodeToString(b[:4]), nil
+	case float32:
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(vv))
+		return hex.EncodeToString(b[:4]), nil
+	case int64:
+		return encodeUint64(uint64(vv)), nil
+	case float64:
+		return encodeUint64(math.Float64bits(vv)), nil
+	case uint64:
+		return encodeUint64(vv), nil
+	default:
+		return "", fmt.Errorf("unknown value %v", v)
+	}
+}
+
+// parseFrameAndIndex parses args "frame;index" in decimal.
+func parseFrameAndIndex(args string) (int, uint32, error) {
+	parts := strings.Split(args, ";")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("bad arguments '%s'", args)
+	}
+
+	frame, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad frame '%s': %w", parts[0], err)
+	}
+	idx, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad index '%s': %w", parts[1], err)
+	}
+
+	return frame, uint32(idx), nil
+}
+
+// parseRange parses args "addr,length" in hex, where length is limited by maxPacketSize.
+func parseRange(args string) (uint64, int, error) {
+	parts := strings.Split(args, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("bad range '%s'", args)
+	}
+
+	addr, err := strconv.ParseUint(parts[0], 16, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad address '%s': %w", parts[0], err)
+	}
+	n, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad length '%s': %w", parts[1], err)
+	} else if n > maxPacketSize {
+		return 0, 0, fmt.Errorf("length %d over %d", n, maxPacketSize)
+	}
+
+	return addr, int(n), nil
+}
+
+// splitAddress splits addr into its type and offset.
+func splitAddress(addr uint64) (int, uint64) {
+	return int(addr >> 62), addr & (1<<32 - 1)
+}
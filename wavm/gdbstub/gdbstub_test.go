@@ -0,0 +1,175 @@
+package gdbstub_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/gdbstub"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// testClient sends packets and receives replies in order, which fails the test on errors.
+type testClient struct {
+	t     *testing.T
+	conn  net.Conn
+	r     *bufio.Reader
+	noAck bool
+}
+
+func TestServer(t *testing.T) {
+	module, bin := decodeTestModule(t)
+	main, sq := module.Codes[0].Expr, module.Codes[1].Expr
+
+	c, done := newTestSession(t, module, bin)
+
+	// the guest stops before its 1st instruction
+	c.expect("?", "T05thread:1;")
+	c.expect("qSupported:swbreak+;error-message+",
+		"PacketSize=4000;QStartNoAckMode+;qXfer:libraries:read+;swbreak+;error-message+")
+	c.expect("QStartNoAckMode", "OK")
+	c.noAck = true
+	c.expect("g", pc(main[0].Offset))
+
+	c.expect("Z0,0,1", "E.no code at 0x0: unknown address")
+	c.expect(fmt.Sprintf("Z0,%x,1", sq[0].Offset), "OK")
+	c.expect("c", "T05thread:1;swbreak:;")
+	c.expect("qWasmCallStack", pc(sq[0].Offset)+pc(main[1].Offset))
+	c.expect("qWasmLocal:0;0", "06000000")
+	c.expect("qWasmLocal:1;0", "E.local(0): index out of bound")
+	c.expect("qWasmLocal:2;0", "E.miss call frame")
+	c.expect("qWasmGlobal:0;0", "07000000")
+
+	c.expect("m0,3", hex.EncodeToString([]byte("hii")))
+	c.expect("M1,2:6f6f", "OK")
+	c.expect("m0,3", hex.EncodeToString([]byte("hoo")))
+	c.expect("m4000000000000000,4", hex.EncodeToString(bin[:4]))
+	c.expect("qXfer:libraries:read::0,20", `m<library-list><library name="mod`)
+
+	c.expect("s", "T05thread:1;")
+	c.expect("p0", pc(sq[1].Offset))
+
+	c.expect(fmt.Sprintf("z0,%x,1", sq[0].Offset), "OK")
+	c.expect("c", "W00")
+	c.expect("qWasmCallStack", "E.exited")
+	c.expect("k", "X09")
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+func TestServerDetach(t *testing.T) {
+	module, bin := decodeTestModule(t)
+	c, done := newTestSession(t, module, bin)
+
+	// the detached guest runs to its end before the server returns
+	c.expect("D", "OK")
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+// expect sends the packet of data, and expects the reply.
+func (c *testClient) expect(data, reply string) {
+	c.t.Helper()
+
+	c.send(data)
+	c.expectReply(reply)
+}
+
+// expectReply receives the next reply and checks its data, after the ack unless acks are off.
+func (c *testClient) expectReply(expect string) {
+	c.t.Helper()
+
+	if !c.noAck {
+		if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+			c.t.Fatalf("expect ack, got '%c', %v", ack, err)
+		}
+	}
+
+	if b, err := c.r.ReadByte(); err != nil || b != '$' {
+		c.t.Fatalf("expect a packet, got '%c', %v", b, err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatalf("read packet: %v", err)
+	}
+	data = data[:len(data)-1]
+
+	var sum [2]byte
+	if _, err := io.ReadFull(c.r, sum[:]); err != nil {
+		c.t.Fatalf("read checksum: %v", err)
+	}
+	if v, err := strconv.ParseUint(string(sum[:]), 16, 8); err != nil || uint8(v) != checksum(data) {
+		c.t.Fatalf("bad checksum '%s' of '%s'", sum, data)
+	}
+
+	if data != expect {
+		c.t.Fatalf("expect '%s', got '%s'", expect, data)
+	}
+}
+
+func (c *testClient) send(data string) {
+	c.t.Helper()
+
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", data, checksum(data)); err != nil {
+		c.t.Fatalf("send '%s': %v", data, err)
+	}
+}
+
+func checksum(data string) uint8 {
+	var out uint8
+	for i := 0; i < len(data); i++ {
+		out += data[i]
+	}
+
+	return out
+}
+
+// decodeTestModule decodes a module whose main func calls sq(6) and drops the result, with a global
+// of 7 and memory starting with "hii", and returns the binary too.
+func decodeTestModule(t *testing.T) (*wavm.Module, []byte) {
+	t.Helper()
+
+	buf := wasmtest.SquareModule()
+	out, err := wavm.NewDecoder(buf).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	return out, buf
+}
+
+// newTestSession serves module in the background, and returns the client and the channel of the
+// error serving.
+func newTestSession(t *testing.T, module *wavm.Module, bin []byte) (*testClient, <-chan error) {
+	m, err := vm.NewVM(module, nil)
+	if err != nil {
+		t.Fatalf("new VM: %v", err)
+	}
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		done <- gdbstub.NewServer(module, bin).Serve(server, m.(*vm.VM), "main")
+		server.Close()
+	}()
+
+	return &testClient{t: t, conn: client, r: bufio.NewReader(client)}, done
+}
+
+// pc encodes the code address of offset as replies of PCs.
+func pc(offset uint32) string {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], 1<<62|uint64(offset))
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,124 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// interrupt is the byte sent out of packets to interrupt the running guest.
+	interrupt = 0x03
+	// escape escapes the following byte xor-ed by escapeXor in binary data.
+	escape    = '}'
+	escapeXor = 0x20
+)
+
+// packet is a packet received from the client, or an interrupt if data is "\x03".
+type packet struct {
+	data string
+	ok   bool // false if the checksum mismatches
+}
+
+func checksum(data string) uint8 {
+	var out uint8
+	for i := 0; i < len(data); i++ {
+		out += data[i]
+	}
+
+	return out
+}
+
+// readPackets reads packets from r into out until r fails or quit is closed, skipping acks.
+func readPackets(r *bufio.Reader, out chan<- packet, quit <-chan struct{}) {
+	defer close(out)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		var p packet
+		switch b {
+		case interrupt:
+			p = packet{data: string(rune(interrupt)), ok: true}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+
+			var sum [2]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				return
+			}
+			v, err := strconv.ParseUint(string(sum[:]), 16, 8)
+			p = packet{data: data, ok: err == nil && uint8(v) == checksum(data)}
+		default:
+			continue
+		}
+
+		select {
+		case out <- p:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// splitPacket splits data of a packet into its command and arguments, where commands of general
+// queries and multi-letter packets end before the 1st ':', ';' or ','.
+func splitPacket(data string) (string, string) {
+	if data == "" {
+		return "", ""
+	}
+
+	switch data[0] {
+	case 'q', 'Q', 'v':
+	default:
+		return data[:1], data[1:]
+	}
+
+	if i := strings.IndexAny(data, ":;,"); i >= 0 {
+		return data[:i], data[i+1:]
+	}
+	return data, ""
+}
+
+// unescape decodes escaped binary data.
+func unescape(data string) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == escape && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^escapeXor)
+		} else {
+			out = append(out, data[i])
+		}
+	}
+
+	return out
+}
+
+// writePacket writes data as a packet, escaping bytes special to framing.
+func writePacket(w io.Writer, data string) error {
+	var b strings.Builder
+	b.Grow(len(data) + 4)
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '$', '#', '*', escape:
+			b.WriteByte(escape)
+			b.WriteByte(c ^ escapeXor)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	content := b.String()
+
+	_, err := fmt.Fprintf(w, "$%s#%02x", content, checksum(content))
+	return err
+}
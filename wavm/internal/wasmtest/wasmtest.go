@@ -0,0 +1,95 @@
+// Package wasmtest encodes binary modules by hand for tests.
+package wasmtest
+
+// preamble is the magic and version every module starts with.
+var preamble = []byte{0x00, 'a', 's', 'm', 1, 0, 0, 0}
+
+// ByteVec encodes bytes prefixed by their count, such as value types.
+func ByteVec(items []byte) []byte {
+	return append(Uleb(uint64(len(items))), items...)
+}
+
+// Code encodes the entry of the code section whose locals are one of each type in locals, and whose
+// body is ended by end.
+func Code(locals []byte, body ...byte) []byte {
+	var decls [][]byte
+	for _, v := range locals {
+		decls = append(decls, []byte{1, v})
+	}
+	out := Concat(Vec(decls...), body, []byte{0x0B})
+	return append(Uleb(uint64(len(out))), out...)
+}
+
+func Concat(items ...[]byte) []byte {
+	var out []byte
+	for _, v := range items {
+		out = append(out, v...)
+	}
+	return out
+}
+
+// CustomSection encodes the custom section of name.
+func CustomSection(name string, payload ...[]byte) []byte {
+	return Section(0, append([][]byte{Name(name)}, payload...)...)
+}
+
+// Export encodes the entry of the export section.
+func Export(name string, kind byte, idx uint32) []byte {
+	return Concat(Name(name), []byte{kind}, Uleb(uint64(idx)))
+}
+
+func FuncType(params, results []byte) []byte {
+	return Concat([]byte{0x60}, ByteVec(params), ByteVec(results))
+}
+
+// Module encodes the module of sections after the preamble.
+func Module(sections ...[]byte) []byte {
+	return Concat(append([][]byte{preamble}, sections...)...)
+}
+
+func Name(s string) []byte {
+	return append(Uleb(uint64(len(s))), s...)
+}
+
+// Section encodes the section of id, whose payload is prefixed by its size. It encodes subsections
+// of custom sections too.
+func Section(id byte, payload ...[]byte) []byte {
+	out := Concat(payload...)
+	return Concat([]byte{id}, Uleb(uint64(len(out))), out)
+}
+
+func Sleb(v int64) []byte {
+	var out []byte
+	for ; v < -64 || v >= 64; v >>= 7 {
+		out = append(out, byte(v)|0x80)
+	}
+	return append(out, byte(v)&0x7F)
+}
+
+// SquareModule encodes the module whose exported main func calls sq(6) and drops the result, with a
+// global of 7 and memory starting with "hii".
+func SquareModule() []byte {
+	const i32 = 0x7F
+	return Module(
+		Section(1, Vec(FuncType(nil, nil), FuncType([]byte{i32}, []byte{i32}))),
+		Section(3, Vec([]byte{0}, []byte{1})),
+		Section(5, Vec([]byte{0x00, 1})),
+		Section(6, Vec([]byte{i32, 0, 0x41, 7, 0x0B})),
+		Section(7, Vec(Export("main", 0, 0))),
+		Section(10, Vec(Code(nil, 0x41, 6, 0x10, 1, 0x1A), Code(nil, 0x20, 0, 0x20, 0, 0x6C))),
+		Section(11, Vec(Concat([]byte{0, 0x41, 0, 0x0B}, Name("hii")))),
+	)
+}
+
+func Uleb(v uint64) []byte {
+	var out []byte
+	for ; v >= 0x80; v >>= 7 {
+		out = append(out, byte(v)|0x80)
+	}
+	return append(out, byte(v))
+}
+
+// Vec encodes items prefixed by their count.
+func Vec(items ...[]byte) []byte {
+	return Concat(append([][]byte{Uleb(uint64(len(items)))}, items...)...)
+}
@@ -2,10 +2,30 @@ package linker
 
 import "github.com/sammyne/mastering-wasm/wavm/types"
 
+// AtomicMemory is a Memory supporting the atomic instructions of the threads proposal. All
+// accesses are bitWidth wide, and the offset must be aligned to it.
+type AtomicMemory interface {
+	Memory
+	AtomicLoad(offset uint64, bitWidth int) (uint64, error)
+	AtomicStore(offset uint64, bitWidth int, v uint64) error
+	// AtomicRMW replaces the value v at offset with op(v) and returns v.
+	AtomicRMW(offset uint64, bitWidth int, op func(v uint64) uint64) (uint64, error)
+	// AtomicCompareExchange stores replacement if the value at offset equals expected, and returns
+	// the value loaded in either case.
+	AtomicCompareExchange(offset uint64, bitWidth int, expected, replacement uint64) (uint64, error)
+	// AtomicWait blocks until notified if the value at offset equals expected, or until timeout
+	// nanoseconds elapse for a non-negative timeout. It returns 0 if woken, 1 if the value didn't
+	// equal expected and 2 on timeout.
+	AtomicWait(offset uint64, bitWidth int, expected uint64, timeout int64) (uint32, error)
+	// AtomicNotify wakes at most count waiters blocking on offset and returns how many were woken.
+	AtomicNotify(offset uint64, count uint32) (uint32, error)
+}
+
 type Memory interface {
-	Grow(v uint32) uint32
+	// Grow grows the memory by v pages and returns the old size, or math.MaxUint64 on failure.
+	Grow(v uint64) uint64
 	Read(offset uint64, buf []byte) error
-	Size() uint32
+	Size() uint64
 	Type() types.Memory
 	Write(offset uint64, buf []byte) error
 }
@@ -58,6 +58,16 @@ func (m Module) RegisterFunc(nameAndSig string, f GoFunc) {
 	m.exported[name] = Function{type_: sig, f: f}
 }
 
+// RegisterTag exports a tag whose payload is typed by the params of the signature, e.g.
+// "error(i32)->()". The returned tag is for Go functions to throw and catch linker.Exception.
+func (m Module) RegisterTag(nameAndSig string) *Tag {
+	name, sig := parseNameAndSig(nameAndSig)
+	out := &Tag{type_: sig}
+	m.exported[name] = out
+
+	return out
+}
+
 func (m Module) SetGlobalVal(name string, vv types.WasmVal) error {
 	raw, err := m.GetMember(name)
 	if err != nil {
@@ -0,0 +1,11 @@
+package native
+
+import "github.com/sammyne/mastering-wasm/wavm/types"
+
+type Tag struct {
+	type_ types.FuncType
+}
+
+func (t *Tag) Type() types.FuncType {
+	return t.type_
+}
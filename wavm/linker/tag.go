@@ -0,0 +1,25 @@
+package linker
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// Tag is an exception tag. Only handlers catching the very same tag instance catch exceptions
+// thrown with it, so implementations must be comparable, e.g. pointers.
+type Tag interface {
+	// Type returns the function type whose parameters type the payload of exceptions.
+	Type() types.FuncType
+}
+
+// Exception is a wasm exception thrown with Tag. It unwinds as an error through Go host functions,
+// which may throw one by returning it, or catch one with errors.As on errors of calls into wasm.
+type Exception struct {
+	Tag  Tag
+	Args []types.WasmVal
+}
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("uncaught exception of tag %s: %v", e.Tag.Type(), e.Args)
+}
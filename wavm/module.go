@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/sammyne/mastering-wasm/wavm/types"
 )
@@ -17,12 +18,21 @@ type Module struct {
 	Functions []types.TypeIdx
 	Tables    []types.Table
 	Memories  []types.Memory
+	Tags      []types.Tag
 	Globals   []types.Global
 	Exports   []types.Export
 	Start     *types.FuncIdx
 	Elements  []types.Element
+	DataCount *uint32
 	Codes     []types.Code
 	Data      []types.Data
+	// Sections are all sections of the module in order, including custom ones.
+	Sections []types.Section
+	// Names are debug names from the name section, which are all missing if it's absent.
+	Names types.Names
+	// DisabledFeatures are post-MVP features the module may not use, as checked by the validator,
+	// which are none unless set.
+	DisabledFeatures Features
 }
 
 func (m *Module) GetBlockType(t types.BlockType) (types.FuncType, error) {
@@ -40,11 +50,58 @@ func (m *Module) GetBlockType(t types.BlockType) (types.FuncType, error) {
 	default:
 	}
 
-	if int(t) >= len(m.Types) {
+	if t < 0 || int(t) >= len(m.Types) {
 		return types.FuncType{}, errors.New("index out of bound")
 	}
 
-	return types.FuncType{}, errors.New("no such block")
+	return m.Types[t], nil
+}
+
+// GetFuncIdxByOffset finds the function whose code includes offset in the decoded module.
+func (m *Module) GetFuncIdxByOffset(offset uint32) (types.FuncIdx, bool) {
+	var end uint32
+	for _, v := range m.Sections {
+		if v.ID == types.SectionIDCode {
+			end = v.Offset + v.Size
+		}
+	}
+
+	i := sort.Search(len(m.Codes), func(i int) bool { return m.Codes[i].Offset > offset }) - 1
+	if i < 0 || offset >= end {
+		return 0, false
+	}
+
+	var nImportedFuncs int
+	for _, v := range m.Imports {
+		if v.Description.Tag == types.PortTagFunc {
+			nImportedFuncs++
+		}
+	}
+
+	return types.FuncIdx(nImportedFuncs + i), true
+}
+
+// GetFeatures returns post-MVP features the module may use.
+func (m *Module) GetFeatures() Features {
+	return FeaturesAll &^ m.DisabledFeatures
+}
+
+// GetTargetFeatures parses features from the target_features custom section, which is reported
+// missing by a false flag.
+func (m *Module) GetTargetFeatures() (Features, bool, error) {
+	for _, c := range m.Customs {
+		if c.Name != TargetFeaturesSectionName {
+			continue
+		}
+
+		out, err := ParseTargetFeatures(c.Bytes)
+		if err != nil {
+			return 0, true, fmt.Errorf("parse %s section: %w", TargetFeaturesSectionName, err)
+		}
+		return out, true, nil
+	}
+
+	return FeaturesMVP, false, nil
 }
 
 func DecodeModuleFromFile(filename string) (*Module, error) {
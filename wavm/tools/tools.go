@@ -28,3 +28,22 @@ func ParseBlockSig(t types.BlockType, moduleTypes []types.FuncType) types.FuncTy
 
 	return moduleTypes[t]
 }
+
+// WalkInstrs calls f with instructions of expr in the order validators walk them, where each one
+// goes before those nested in it, and then branches go before else ones.
+func WalkInstrs(expr types.Expr, f func(*types.Instruction)) {
+	for i := range expr {
+		f(&expr[i])
+
+		switch args := expr[i].Args.(type) {
+		case *types.Block:
+			WalkInstrs(args.Instructions, f)
+		case *types.BlockIf:
+			WalkInstrs(args.Instructions1, f)
+			WalkInstrs(args.Instructions2, f)
+		case *types.TryTable:
+			WalkInstrs(args.Instructions, f)
+		default:
+		}
+	}
+}
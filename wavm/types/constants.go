@@ -1,5 +1,7 @@
 package types
 
+import "strconv"
+
 const FuncRef = 0x70
 
 const (
@@ -17,14 +19,34 @@ const (
 	BlockTypeEmpty BlockType = -64
 )
 
+// Kinds of catch clauses of try_table. The *Ref ones also pass the caught exception as exnref.
+const (
+	CatchKindTag    byte = 0x00
+	CatchKindTagRef byte = 0x01
+	CatchKindAll    byte = 0x02
+	CatchKindAllRef byte = 0x03
+)
+
+const (
+	LimitsTagMin            byte = 0x00
+	LimitsTagMinMax         byte = 0x01
+	LimitsTagSharedMin      byte = 0x02
+	LimitsTagSharedMinMax   byte = 0x03
+	LimitsTagMin64          byte = 0x04
+	LimitsTagMinMax64       byte = 0x05
+	LimitsTagSharedMin64    byte = 0x06
+	LimitsTagSharedMinMax64 byte = 0x07
+)
+
 const (
 	MutConst byte = 0
 	MutVar   byte = 1
 )
 
 const (
-	PageSize     = 1 << 16
-	MaxPageCount = 1 << 16
+	PageSize       = 1 << 16
+	MaxPageCount   = 1 << 16
+	MaxPageCount64 = 1 << 48
 )
 
 type PortTag = byte
@@ -34,6 +56,7 @@ const (
 	PortTagTable
 	PortTagMemory
 	PortTagGlobal
+	PortTagTag
 )
 
 const (
@@ -49,9 +72,76 @@ const (
 	SectionIDElement
 	SectionIDCode
 	SectionIDData
+	SectionIDDataCount
+	SectionIDTag
+)
+
+// CustomNameSection is the name of the custom section carrying debug names.
+const CustomNameSection = "name"
+
+// Subsection IDs of the name section.
+const (
+	NameSubsectionIDModule = iota
+	NameSubsectionIDFunc
+	NameSubsectionIDLocal
+	NameSubsectionIDLabel
+	NameSubsectionIDType
+	NameSubsectionIDTable
+	NameSubsectionIDMemory
+	NameSubsectionIDGlobal
+	NameSubsectionIDElement
+	NameSubsectionIDData
+)
+
+// TagAttributeException is the only attribute of tags so far.
+const TagAttributeException byte = 0x00
+
+const (
+	DataTagActive         = 0x00
+	DataTagPassive        = 0x01
+	DataTagActiveExplicit = 0x02
+)
+
+// HeapType is the index of a defined type if non-negative, or else an abstract heap type encoded
+// as a s33 byte.
+type HeapType = int32
+
+const (
+	HeapTypeExn      HeapType = -0x17 // exn
+	HeapTypeArray    HeapType = -0x16 // array
+	HeapTypeStruct   HeapType = -0x15 // struct
+	HeapTypeI31      HeapType = -0x14 // i31
+	HeapTypeEq       HeapType = -0x13 // eq
+	HeapTypeAny      HeapType = -0x12 // any
+	HeapTypeExtern   HeapType = -0x11 // extern
+	HeapTypeFunc     HeapType = -0x10 // func
+	HeapTypeNone     HeapType = -0x0F // none
+	HeapTypeNoExtern HeapType = -0x0E // noextern
+	HeapTypeNoFunc   HeapType = -0x0D // nofunc
+	HeapTypeNoExn    HeapType = -0x0C // noexn
 )
 
-type ValueType = byte
+// Packed storage types, which fields of struct and array types may be of besides value types. They
+// are accessed as i32 values.
+const (
+	StorageTypeI8  ValueType = 0x78
+	StorageTypeI16 ValueType = 0x77
+)
+
+// Tags of entries of the type section. Rec groups and sub types wrapping function, struct and array
+// types come from the GC proposal.
+const (
+	TypeTagRec      byte = 0x4E
+	TypeTagSubFinal byte = 0x4F
+	TypeTagSub      byte = 0x50
+	TypeTagArray    byte = 0x5E
+	TypeTagStruct   byte = 0x5F
+	TypeTagFunc     byte = 0x60
+)
+
+// ValueType is the type code of numeric types. For references, it's either ValueTypeRef or
+// ValueTypeRefNull, with the HeapType in the upper 32 bits, as NewRefType makes.
+type ValueType = uint64
 
 const (
 	ValueTypeUnknown ValueType = 0
@@ -59,6 +149,20 @@ const (
 	ValueTypeI64
 	ValueTypeF32
 	ValueTypeF64
+	ValueTypeRef     ValueType = 0x64
+	ValueTypeRefNull ValueType = 0x63
+)
+
+// Abbreviations of nullable references to abstract heap types, i.e. NewRefType(true, HeapType*).
+const (
+	ValueTypeExnRef    = ValueTypeRefNull | 0xFFFFFFE9<<32
+	ValueTypeArrayRef  = ValueTypeRefNull | 0xFFFFFFEA<<32
+	ValueTypeStructRef = ValueTypeRefNull | 0xFFFFFFEB<<32
+	ValueTypeI31Ref    = ValueTypeRefNull | 0xFFFFFFEC<<32
+	ValueTypeEqRef     = ValueTypeRefNull | 0xFFFFFFED<<32
+	ValueTypeAnyRef    = ValueTypeRefNull | 0xFFFFFFEE<<32
+	ValueTypeExternRef = ValueTypeRefNull | 0xFFFFFFEF<<32
+	ValueTypeFuncRef   = ValueTypeRefNull | 0xFFFFFFF0<<32
 )
 
 func StringifyValueType(t ValueType) string {
@@ -71,8 +175,57 @@ func StringifyValueType(t ValueType) string {
 		return "f32"
 	case ValueTypeF64:
 		return "f64"
+	case StorageTypeI8:
+		return "i8"
+	case StorageTypeI16:
+		return "i16"
+	case ValueTypeAnyRef:
+		return "anyref"
+	case ValueTypeArrayRef:
+		return "arrayref"
+	case ValueTypeEqRef:
+		return "eqref"
+	case ValueTypeI31Ref:
+		return "i31ref"
+	case ValueTypeStructRef:
+		return "structref"
+	case ValueTypeExnRef:
+		return "exnref"
+	case ValueTypeExternRef:
+		return "externref"
+	case ValueTypeFuncRef:
+		return "funcref"
 	default:
 	}
 
-	return "unknown"
+	if !IsRefType(t) {
+		return "unknown"
+	}
+
+	ht := GetHeapType(t)
+	heapType, ok := heapTypeNames[ht]
+	if !ok {
+		heapType = strconv.Itoa(int(ht))
+	}
+
+	if IsNullable(t) {
+		return "(ref null " + heapType + ")"
+	}
+
+	return "(ref " + heapType + ")"
+}
+
+var heapTypeNames = map[HeapType]string{
+	HeapTypeExn:      "exn",
+	HeapTypeArray:    "array",
+	HeapTypeStruct:   "struct",
+	HeapTypeI31:      "i31",
+	HeapTypeEq:       "eq",
+	HeapTypeAny:      "any",
+	HeapTypeExtern:   "extern",
+	HeapTypeFunc:     "func",
+	HeapTypeNone:     "none",
+	HeapTypeNoExtern: "noextern",
+	HeapTypeNoFunc:   "nofunc",
+	HeapTypeNoExn:    "noexn",
 }
@@ -1,5 +1,12 @@
 package types
 
+// AtomicArg is the immediate of instructions prefixed by OpcodeAtomic. MemoryArg is zero for
+// atomic.fence.
+type AtomicArg struct {
+	SubOpcode byte
+	MemoryArg MemoryArg
+}
+
 // Block may be block or loop
 type Block struct {
 	BlockType    BlockType
@@ -12,21 +19,70 @@ type BlockIf struct {
 	Instructions2 []Instruction
 }
 
+// BulkArg is the immediate of bulk memory instructions prefixed by OpcodeTruncSat. SrcMemoryIdx is
+// only used by memory.copy, and DataIdx by memory.init and data.drop.
+type BulkArg struct {
+	SubOpcode    byte
+	DataIdx      DataIdx
+	MemoryIdx    MemoryIdx
+	SrcMemoryIdx MemoryIdx
+}
+
 type BreakTable struct {
 	Labels  []LabelIdx
 	Default LabelIdx
 }
 
+// Catch is a catch clause of try_table, which branches to Label with the payload of exceptions
+// thrown with the tag TagIdx. TagIdx is unused by catch_all(_ref).
+type Catch struct {
+	Kind   byte
+	TagIdx TagIdx
+	Label  LabelIdx
+}
+
+// GCArg is the immediate of instructions prefixed by OpcodeGC. Idx is the field of struct.get/set,
+// the length of array.new_fixed or the source array type of array.copy. Casts go from SrcType to
+// DstType, where only br_on_cast(_fail) tells SrcType and Label.
+type GCArg struct {
+	SubOpcode byte
+	TypeIdx   TypeIdx
+	Idx       uint32
+	Label     LabelIdx
+	SrcType   ValueType
+	DstType   ValueType
+}
+
 type Instruction struct {
 	Opcode byte
 	Args   interface{}
+	Offset uint32 // offset of the opcode in the decoded module
 }
 
 type MemoryArg struct {
-	Align  uint32
-	Offset uint32
+	Align     uint32
+	MemoryIdx MemoryIdx
+	Offset    uint64
+}
+
+// TryTable is a block whose catch clauses are tried in order against exceptions escaping it.
+type TryTable struct {
+	BlockType    BlockType
+	Catches      []Catch
+	Instructions []Instruction
 }
 
+// GetOpname returns the name of the instruction, resolving sub-opcodes of prefixed ones.
 func (i Instruction) GetOpname() string {
+	switch v := i.Args.(type) {
+	case AtomicArg:
+		return atomicOpnames[v.SubOpcode]
+	case BulkArg:
+		return bulkOpnames[v.SubOpcode]
+	case GCArg:
+		return gcOpnames[v.SubOpcode]
+	default:
+	}
+
 	return opnames[i.Opcode]
 }
@@ -0,0 +1,175 @@
+package types
+
+// Sub-opcodes following OpcodeAtomic
+const (
+	AtomicNotify           = 0x00 // memory.atomic.notify
+	AtomicWait32           = 0x01 // memory.atomic.wait32
+	AtomicWait64           = 0x02 // memory.atomic.wait64
+	AtomicFence            = 0x03 // atomic.fence
+	AtomicI32Load          = 0x10 // i32.atomic.load
+	AtomicI64Load          = 0x11 // i64.atomic.load
+	AtomicI32Load8U        = 0x12 // i32.atomic.load8_u
+	AtomicI32Load16U       = 0x13 // i32.atomic.load16_u
+	AtomicI64Load8U        = 0x14 // i64.atomic.load8_u
+	AtomicI64Load16U       = 0x15 // i64.atomic.load16_u
+	AtomicI64Load32U       = 0x16 // i64.atomic.load32_u
+	AtomicI32Store         = 0x17 // i32.atomic.store
+	AtomicI64Store         = 0x18 // i64.atomic.store
+	AtomicI32Store8        = 0x19 // i32.atomic.store8
+	AtomicI32Store16       = 0x1A // i32.atomic.store16
+	AtomicI64Store8        = 0x1B // i64.atomic.store8
+	AtomicI64Store16       = 0x1C // i64.atomic.store16
+	AtomicI64Store32       = 0x1D // i64.atomic.store32
+	AtomicI32RmwAdd        = 0x1E // i32.atomic.rmw.add
+	AtomicI64RmwAdd        = 0x1F // i64.atomic.rmw.add
+	AtomicI32Rmw8AddU      = 0x20 // i32.atomic.rmw8.add_u
+	AtomicI32Rmw16AddU     = 0x21 // i32.atomic.rmw16.add_u
+	AtomicI64Rmw8AddU      = 0x22 // i64.atomic.rmw8.add_u
+	AtomicI64Rmw16AddU     = 0x23 // i64.atomic.rmw16.add_u
+	AtomicI64Rmw32AddU     = 0x24 // i64.atomic.rmw32.add_u
+	AtomicI32RmwSub        = 0x25 // i32.atomic.rmw.sub
+	AtomicI64RmwSub        = 0x26 // i64.atomic.rmw.sub
+	AtomicI32Rmw8SubU      = 0x27 // i32.atomic.rmw8.sub_u
+	AtomicI32Rmw16SubU     = 0x28 // i32.atomic.rmw16.sub_u
+	AtomicI64Rmw8SubU      = 0x29 // i64.atomic.rmw8.sub_u
+	AtomicI64Rmw16SubU     = 0x2A // i64.atomic.rmw16.sub_u
+	AtomicI64Rmw32SubU     = 0x2B // i64.atomic.rmw32.sub_u
+	AtomicI32RmwAnd        = 0x2C // i32.atomic.rmw.and
+	AtomicI64RmwAnd        = 0x2D // i64.atomic.rmw.and
+	AtomicI32Rmw8AndU      = 0x2E // i32.atomic.rmw8.and_u
+	AtomicI32Rmw16AndU     = 0x2F // i32.atomic.rmw16.and_u
+	AtomicI64Rmw8AndU      = 0x30 // i64.atomic.rmw8.and_u
+	AtomicI64Rmw16AndU     = 0x31 // i64.atomic.rmw16.and_u
+	AtomicI64Rmw32AndU     = 0x32 // i64.atomic.rmw32.and_u
+	AtomicI32RmwOr         = 0x33 // i32.atomic.rmw.or
+	AtomicI64RmwOr         = 0x34 // i64.atomic.rmw.or
+	AtomicI32Rmw8OrU       = 0x35 // i32.atomic.rmw8.or_u
+	AtomicI32Rmw16OrU      = 0x36 // i32.atomic.rmw16.or_u
+	AtomicI64Rmw8OrU       = 0x37 // i64.atomic.rmw8.or_u
+	AtomicI64Rmw16OrU      = 0x38 // i64.atomic.rmw16.or_u
+	AtomicI64Rmw32OrU      = 0x39 // i64.atomic.rmw32.or_u
+	AtomicI32RmwXor        = 0x3A // i32.atomic.rmw.xor
+	AtomicI64RmwXor        = 0x3B // i64.atomic.rmw.xor
+	AtomicI32Rmw8XorU      = 0x3C // i32.atomic.rmw8.xor_u
+	AtomicI32Rmw16XorU     = 0x3D // i32.atomic.rmw16.xor_u
+	AtomicI64Rmw8XorU      = 0x3E // i64.atomic.rmw8.xor_u
+	AtomicI64Rmw16XorU     = 0x3F // i64.atomic.rmw16.xor_u
+	AtomicI64Rmw32XorU     = 0x40 // i64.atomic.rmw32.xor_u
+	AtomicI32RmwXchg       = 0x41 // i32.atomic.rmw.xchg
+	AtomicI64RmwXchg       = 0x42 // i64.atomic.rmw.xchg
+	AtomicI32Rmw8XchgU     = 0x43 // i32.atomic.rmw8.xchg_u
+	AtomicI32Rmw16XchgU    = 0x44 // i32.atomic.rmw16.xchg_u
+	AtomicI64Rmw8XchgU     = 0x45 // i64.atomic.rmw8.xchg_u
+	AtomicI64Rmw16XchgU    = 0x46 // i64.atomic.rmw16.xchg_u
+	AtomicI64Rmw32XchgU    = 0x47 // i64.atomic.rmw32.xchg_u
+	AtomicI32RmwCmpxchg    = 0x48 // i32.atomic.rmw.cmpxchg
+	AtomicI64RmwCmpxchg    = 0x49 // i64.atomic.rmw.cmpxchg
+	AtomicI32Rmw8CmpxchgU  = 0x4A // i32.atomic.rmw8.cmpxchg_u
+	AtomicI32Rmw16CmpxchgU = 0x4B // i32.atomic.rmw16.cmpxchg_u
+	AtomicI64Rmw8CmpxchgU  = 0x4C // i64.atomic.rmw8.cmpxchg_u
+	AtomicI64Rmw16CmpxchgU = 0x4D // i64.atomic.rmw16.cmpxchg_u
+	AtomicI64Rmw32CmpxchgU = 0x4E // i64.atomic.rmw32.cmpxchg_u
+)
+
+var atomicOpnames = make([]string, 256)
+
+func init() {
+	atomicOpnames[AtomicNotify] = "memory.atomic.notify"
+	atomicOpnames[AtomicWait32] = "memory.atomic.wait32"
+	atomicOpnames[AtomicWait64] = "memory.atomic.wait64"
+	atomicOpnames[AtomicFence] = "atomic.fence"
+	atomicOpnames[AtomicI32Load] = "i32.atomic.load"
+	atomicOpnames[AtomicI64Load] = "i64.atomic.load"
+	atomicOpnames[AtomicI32Load8U] = "i32.atomic.load8_u"
+	atomicOpnames[AtomicI32Load16U] = "i32.atomic.load16_u"
+	atomicOpnames[AtomicI64Load8U] = "i64.atomic.load8_u"
+	atomicOpnames[AtomicI64Load16U] = "i64.atomic.load16_u"
+	atomicOpnames[AtomicI64Load32U] = "i64.atomic.load32_u"
+	atomicOpnames[AtomicI32Store] = "i32.atomic.store"
+	atomicOpnames[AtomicI64Store] = "i64.atomic.store"
+	atomicOpnames[AtomicI32Store8] = "i32.atomic.store8"
+	atomicOpnames[AtomicI32Store16] = "i32.atomic.store16"
+	atomicOpnames[AtomicI64Store8] = "i64.atomic.store8"
+	atomicOpnames[AtomicI64Store16] = "i64.atomic.store16"
+	atomicOpnames[AtomicI64Store32] = "i64.atomic.store32"
+	atomicOpnames[AtomicI32RmwAdd] = "i32.atomic.rmw.add"
+	atomicOpnames[AtomicI64RmwAdd] = "i64.atomic.rmw.add"
+	atomicOpnames[AtomicI32Rmw8AddU] = "i32.atomic.rmw8.add_u"
+	atomicOpnames[AtomicI32Rmw16AddU] = "i32.atomic.rmw16.add_u"
+	atomicOpnames[AtomicI64Rmw8AddU] = "i64.atomic.rmw8.add_u"
+	atomicOpnames[AtomicI64Rmw16AddU] = "i64.atomic.rmw16.add_u"
+	atomicOpnames[AtomicI64Rmw32AddU] = "i64.atomic.rmw32.add_u"
+	atomicOpnames[AtomicI32RmwSub] = "i32.atomic.rmw.sub"
+	atomicOpnames[AtomicI64RmwSub] = "i64.atomic.rmw.sub"
+	atomicOpnames[AtomicI32Rmw8SubU] = "i32.atomic.rmw8.sub_u"
+	atomicOpnames[AtomicI32Rmw16SubU] = "i32.atomic.rmw16.sub_u"
+	atomicOpnames[AtomicI64Rmw8SubU] = "i64.atomic.rmw8.sub_u"
+	atomicOpnames[AtomicI64Rmw16SubU] = "i64.atomic.rmw16.sub_u"
+	atomicOpnames[AtomicI64Rmw32SubU] = "i64.atomic.rmw32.sub_u"
+	atomicOpnames[AtomicI32RmwAnd] = "i32.atomic.rmw.and"
+	atomicOpnames[AtomicI64RmwAnd] = "i64.atomic.rmw.and"
+	atomicOpnames[AtomicI32Rmw8AndU] = "i32.atomic.rmw8.and_u"
+	atomicOpnames[AtomicI32Rmw16AndU] = "i32.atomic.rmw16.and_u"
+	atomicOpnames[AtomicI64Rmw8AndU] = "i64.atomic.rmw8.and_u"
+	atomicOpnames[AtomicI64Rmw16AndU] = "i64.atomic.rmw16.and_u"
+	atomicOpnames[AtomicI64Rmw32AndU] = "i64.atomic.rmw32.and_u"
+	atomicOpnames[AtomicI32RmwOr] = "i32.atomic.rmw.or"
+	atomicOpnames[AtomicI64RmwOr] = "i64.atomic.rmw.or"
+	atomicOpnames[AtomicI32Rmw8OrU] = "i32.atomic.rmw8.or_u"
+	atomicOpnames[AtomicI32Rmw16OrU] = "i32.atomic.rmw16.or_u"
+	atomicOpnames[AtomicI64Rmw8OrU] = "i64.atomic.rmw8.or_u"
+	atomicOpnames[AtomicI64Rmw16OrU] = "i64.atomic.rmw16.or_u"
+	atomicOpnames[AtomicI64Rmw32OrU] = "i64.atomic.rmw32.or_u"
+	atomicOpnames[AtomicI32RmwXor] = "i32.atomic.rmw.xor"
+	atomicOpnames[AtomicI64RmwXor] = "i64.atomic.rmw.xor"
+	atomicOpnames[AtomicI32Rmw8XorU] = "i32.atomic.rmw8.xor_u"
+	atomicOpnames[AtomicI32Rmw16XorU] = "i32.atomic.rmw16.xor_u"
+	atomicOpnames[AtomicI64Rmw8XorU] = "i64.atomic.rmw8.xor_u"
+	atomicOpnames[AtomicI64Rmw16XorU] = "i64.atomic.rmw16.xor_u"
+	atomicOpnames[AtomicI64Rmw32XorU] = "i64.atomic.rmw32.xor_u"
+	atomicOpnames[AtomicI32RmwXchg] = "i32.atomic.rmw.xchg"
+	atomicOpnames[AtomicI64RmwXchg] = "i64.atomic.rmw.xchg"
+	atomicOpnames[AtomicI32Rmw8XchgU] = "i32.atomic.rmw8.xchg_u"
+	atomicOpnames[AtomicI32Rmw16XchgU] = "i32.atomic.rmw16.xchg_u"
+	atomicOpnames[AtomicI64Rmw8XchgU] = "i64.atomic.rmw8.xchg_u"
+	atomicOpnames[AtomicI64Rmw16XchgU] = "i64.atomic.rmw16.xchg_u"
+	atomicOpnames[AtomicI64Rmw32XchgU] = "i64.atomic.rmw32.xchg_u"
+	atomicOpnames[AtomicI32RmwCmpxchg] = "i32.atomic.rmw.cmpxchg"
+	atomicOpnames[AtomicI64RmwCmpxchg] = "i64.atomic.rmw.cmpxchg"
+	atomicOpnames[AtomicI32Rmw8CmpxchgU] = "i32.atomic.rmw8.cmpxchg_u"
+	atomicOpnames[AtomicI32Rmw16CmpxchgU] = "i32.atomic.rmw16.cmpxchg_u"
+	atomicOpnames[AtomicI64Rmw8CmpxchgU] = "i64.atomic.rmw8.cmpxchg_u"
+	atomicOpnames[AtomicI64Rmw16CmpxchgU] = "i64.atomic.rmw16.cmpxchg_u"
+	atomicOpnames[AtomicI64Rmw32CmpxchgU] = "i64.atomic.rmw32.cmpxchg_u"
+}
+
+func GetAtomicOpname(subOpcode byte) (string, bool) {
+	v := atomicOpnames[subOpcode]
+	return v, v != ""
+}
+
+// GetAtomicAccess returns the operand type and bit width of memory accessed by the load, store and
+// read-modify-write atomic sub-opcodes, which cycle through the same 7 shapes in order.
+func GetAtomicAccess(subOpcode byte) (ValueType, int, bool) {
+	if subOpcode < AtomicI32Load || subOpcode > AtomicI64Rmw32CmpxchgU {
+		return ValueTypeUnknown, 0, false
+	}
+
+	switch (subOpcode - AtomicI32Load) % 7 {
+	case 0:
+		return ValueTypeI32, 32, true
+	case 1:
+		return ValueTypeI64, 64, true
+	case 2:
+		return ValueTypeI32, 8, true
+	case 3:
+		return ValueTypeI32, 16, true
+	case 4:
+		return ValueTypeI64, 8, true
+	case 5:
+		return ValueTypeI64, 16, true
+	default:
+	}
+
+	return ValueTypeI64, 32, true
+}
@@ -0,0 +1,18 @@
+package types
+
+// Sub-opcodes of bulk memory instructions following OpcodeTruncSat
+const (
+	BulkMemoryInit = 0x08 // memory.init d m
+	BulkDataDrop   = 0x09 // data.drop d
+	BulkMemoryCopy = 0x0A // memory.copy m m
+	BulkMemoryFill = 0x0B // memory.fill m
+)
+
+var bulkOpnames = make([]string, 256)
+
+func init() {
+	bulkOpnames[BulkMemoryInit] = "memory.init"
+	bulkOpnames[BulkDataDrop] = "data.drop"
+	bulkOpnames[BulkMemoryCopy] = "memory.copy"
+	bulkOpnames[BulkMemoryFill] = "memory.fill"
+}
@@ -0,0 +1,77 @@
+package types
+
+// Sub-opcodes following OpcodeGC
+const (
+	GCStructNew        = 0x00 // struct.new x
+	GCStructNewDefault = 0x01 // struct.new_default x
+	GCStructGet        = 0x02 // struct.get x y
+	GCStructGetS       = 0x03 // struct.get_s x y
+	GCStructGetU       = 0x04 // struct.get_u x y
+	GCStructSet        = 0x05 // struct.set x y
+	GCArrayNew         = 0x06 // array.new x
+	GCArrayNewDefault  = 0x07 // array.new_default x
+	GCArrayNewFixed    = 0x08 // array.new_fixed x n
+	GCArrayNewData     = 0x09 // array.new_data x y
+	GCArrayNewElem     = 0x0A // array.new_elem x y
+	GCArrayGet         = 0x0B // array.get x
+	GCArrayGetS        = 0x0C // array.get_s x
+	GCArrayGetU        = 0x0D // array.get_u x
+	GCArraySet         = 0x0E // array.set x
+	GCArrayLen         = 0x0F // array.len
+	GCArrayFill        = 0x10 // array.fill x
+	GCArrayCopy        = 0x11 // array.copy x y
+	GCArrayInitData    = 0x12 // array.init_data x y
+	GCArrayInitElem    = 0x13 // array.init_elem x y
+	GCRefTest          = 0x14 // ref.test (ref ht)
+	GCRefTestNull      = 0x15 // ref.test (ref null ht)
+	GCRefCast          = 0x16 // ref.cast (ref ht)
+	GCRefCastNull      = 0x17 // ref.cast (ref null ht)
+	GCBrOnCast         = 0x18 // br_on_cast l rt1 rt2
+	GCBrOnCastFail     = 0x19 // br_on_cast_fail l rt1 rt2
+	GCAnyConvertExtern = 0x1A // any.convert_extern
+	GCExternConvertAny = 0x1B // extern.convert_any
+	GCRefI31           = 0x1C // ref.i31
+	GCI31GetS          = 0x1D // i31.get_s
+	GCI31GetU          = 0x1E // i31.get_u
+)
+
+var gcOpnames = make([]string, 256)
+
+func init() {
+	gcOpnames[GCStructNew] = "struct.new"
+	gcOpnames[GCStructNewDefault] = "struct.new_default"
+	gcOpnames[GCStructGet] = "struct.get"
+	gcOpnames[GCStructGetS] = "struct.get_s"
+	gcOpnames[GCStructGetU] = "struct.get_u"
+	gcOpnames[GCStructSet] = "struct.set"
+	gcOpnames[GCArrayNew] = "array.new"
+	gcOpnames[GCArrayNewDefault] = "array.new_default"
+	gcOpnames[GCArrayNewFixed] = "array.new_fixed"
+	gcOpnames[GCArrayNewData] = "array.new_data"
+	gcOpnames[GCArrayNewElem] = "array.new_elem"
+	gcOpnames[GCArrayGet] = "array.get"
+	gcOpnames[GCArrayGetS] = "array.get_s"
+	gcOpnames[GCArrayGetU] = "array.get_u"
+	gcOpnames[GCArraySet] = "array.set"
+	gcOpnames[GCArrayLen] = "array.len"
+	gcOpnames[GCArrayFill] = "array.fill"
+	gcOpnames[GCArrayCopy] = "array.copy"
+	gcOpnames[GCArrayInitData] = "array.init_data"
+	gcOpnames[GCArrayInitElem] = "array.init_elem"
+	gcOpnames[GCRefTest] = "ref.test"
+	gcOpnames[GCRefTestNull] = "ref.test"
+	gcOpnames[GCRefCast] = "ref.cast"
+	gcOpnames[GCRefCastNull] = "ref.cast"
+	gcOpnames[GCBrOnCast] = "br_on_cast"
+	gcOpnames[GCBrOnCastFail] = "br_on_cast_fail"
+	gcOpnames[GCAnyConvertExtern] = "any.convert_extern"
+	gcOpnames[GCExternConvertAny] = "extern.convert_any"
+	gcOpnames[GCRefI31] = "ref.i31"
+	gcOpnames[GCI31GetS] = "i31.get_s"
+	gcOpnames[GCI31GetU] = "i31.get_u"
+}
+
+func GetGCOpname(subOpcode byte) (string, bool) {
+	v := gcOpnames[subOpcode]
+	return v, v != ""
+}
@@ -9,6 +9,8 @@ func init() {
 	opnames[OpcodeLoop] = "loop"
 	opnames[OpcodeIf] = "if"
 	opnames[OpcodeElse] = "else"
+	opnames[OpcodeThrow] = "throw"
+	opnames[OpcodeThrowRef] = "throw_ref"
 	opnames[OpcodeEnd] = "end"
 	opnames[OpcodeBr] = "br"
 	opnames[OpcodeBrIf] = "br_if"
@@ -16,8 +18,13 @@ func init() {
 	opnames[OpcodeReturn] = "return"
 	opnames[OpcodeCall] = "call"
 	opnames[OpcodeCallIndirect] = "call_indirect"
+	opnames[OpcodeReturnCall] = "return_call"
+	opnames[OpcodeReturnCallIndirect] = "return_call_indirect"
+	opnames[OpcodeCallRef] = "call_ref"
+	opnames[OpcodeReturnCallRef] = "return_call_ref"
 	opnames[OpcodeDrop] = "drop"
 	opnames[OpcodeSelect] = "select"
+	opnames[OpcodeTryTable] = "try_table"
 	opnames[OpcodeLocalGet] = "local.get"
 	opnames[OpcodeLocalSet] = "local.set"
 	opnames[OpcodeLocalTee] = "local.tee"
@@ -180,7 +187,16 @@ func init() {
 	opnames[OpcodeI64Extend8S] = "i64.extend8_s"
 	opnames[OpcodeI64Extend16S] = "i64.extend16_s"
 	opnames[OpcodeI64Extend32S] = "i64.extend32_s"
+	opnames[OpcodeRefNull] = "ref.null"
+	opnames[OpcodeRefIsNull] = "ref.is_null"
+	opnames[OpcodeRefFunc] = "ref.func"
+	opnames[OpcodeRefEq] = "ref.eq"
+	opnames[OpcodeRefAsNonNull] = "ref.as_non_null"
+	opnames[OpcodeBrOnNull] = "br_on_null"
+	opnames[OpcodeBrOnNonNull] = "br_on_non_null"
+	opnames[OpcodeGC] = "gc"
 	opnames[OpcodeTruncSat] = "trunc_sat"
+	opnames[OpcodeAtomic] = "atomic"
 }
 
 func GetOpname(opcode byte) (string, bool) {
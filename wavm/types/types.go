@@ -6,16 +6,19 @@ import (
 )
 
 type (
+	DataIdx   = uint32
 	FuncIdx   = uint32
 	GlobalIdx = uint32
 	LabelIdx  = uint32
 	LocalIdx  = uint32
 	MemoryIdx = uint32
 	TableIdx  = uint32
+	TagIdx    = uint32
 	TypeIdx   = uint32
 )
 
 type Code struct {
+	Offset uint32 // offset of the body in the decoded module, which starts with its byte count
 	Locals []Locals
 	Expr   Expr
 }
@@ -25,7 +28,10 @@ type Custom struct {
 	Bytes []byte
 }
 
+// Data is a data segment. Passive segments have no memory index nor offset, and are only copied
+// into memories by memory.init.
 type Data struct {
+	Passive   bool
 	MemoryIdx MemoryIdx
 	Offset    Expr
 	Init      []byte
@@ -49,10 +55,22 @@ type ExportDescription struct {
 
 type Expr = []Instruction
 
+// FieldType is the type of fields of struct types, or elements of array types.
+type FieldType struct {
+	StorageType ValueType
+	Mutable     byte
+}
+
+// FuncType is an entry of the type section, which defines a struct or array type rather than a
+// function type if Tag tells so. Those take Fields instead, exactly one for array types. Types not
+// declared Final may be subtyped by later ones listing them as SuperTypes.
 type FuncType struct {
 	Tag         byte
 	ParamTypes  []ValueType
 	ResultTypes []ValueType
+	Fields      []FieldType
+	Final       bool
+	SuperTypes  []TypeIdx
 }
 
 type Global struct {
@@ -65,6 +83,9 @@ type GlobalType struct {
 	Mutable   byte
 }
 
+// IndirectNameMap maps indices of functions to names of their locals or labels.
+type IndirectNameMap = map[uint32]NameMap
+
 type Import struct {
 	Module      string
 	Name        string
@@ -72,17 +93,18 @@ type Import struct {
 }
 
 type ImportDescription struct {
-	Tag    PortTag
-	Func   TypeIdx
-	Table  Table
-	Memory Memory
-	Global GlobalType
+	Tag     PortTag
+	Func    TypeIdx
+	Table   Table
+	Memory  Memory
+	Global  GlobalType
+	TagType Tag
 }
 
 type Limits struct {
 	Tag byte
-	Min uint32
-	Max uint32
+	Min uint64
+	Max uint64
 }
 
 type Locals struct {
@@ -92,16 +114,249 @@ type Locals struct {
 
 type Memory = Limits
 
+// NameMap maps indices to names.
+type NameMap = map[uint32]string
+
+// Names are debug names decoded from the name custom section, where labels are indexed in the order
+// their blocks begin within the function.
+type Names struct {
+	Module    string
+	Functions NameMap
+	Locals    IndirectNameMap
+	Labels    IndirectNameMap
+	Types     NameMap
+	Tables    NameMap
+	Memories  NameMap
+	Globals   NameMap
+	Elements  NameMap
+	Data      NameMap
+}
+
+// Section locates the payload of a section in the decoded module, following its ID and byte count.
+type Section struct {
+	ID     byte
+	Offset uint32
+	Size   uint32
+}
+
 type Table struct {
 	ElementType byte
 	Limits      Limits
 }
 
+// Tag declares exceptions whose payload are typed as the parameters of the function type Type.
+type Tag struct {
+	Attribute byte
+	Type      TypeIdx
+}
+
 type WasmVal = interface{}
 
+// EqualValueTypes tells whether both lists are of the very same types.
+// DescribeIdx describes idx of the index space named space as "func[3]", suffixed by its name in
+// names if any as "func[3] <main>".
+func DescribeIdx(space string, idx uint32, names NameMap) string {
+	if name, ok := names[idx]; ok {
+		return fmt.Sprintf("%s[%d] <%s>", space, idx, name)
+	}
+
+	return fmt.Sprintf("%s[%d]", space, idx)
+}
+
+func EqualValueTypes(a, b []ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i, v := range a {
+		if v != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetHeapType returns the heap type referred by the reference type t.
+func GetHeapType(t ValueType) HeapType {
+	return HeapType(uint32(t >> 32))
+}
+
+// GetTopHeapType returns the top of the hierarchy ht belongs to, i.e., any, func, extern or exn,
+// where defined resolves type indices.
+func GetTopHeapType(defined []FuncType, ht HeapType) HeapType {
+	switch ht {
+	case HeapTypeAny, HeapTypeEq, HeapTypeI31, HeapTypeStruct, HeapTypeArray, HeapTypeNone:
+		return HeapTypeAny
+	case HeapTypeFunc, HeapTypeNoFunc:
+		return HeapTypeFunc
+	case HeapTypeExtern, HeapTypeNoExtern:
+		return HeapTypeExtern
+	case HeapTypeExn, HeapTypeNoExn:
+		return HeapTypeExn
+	default:
+	}
+
+	if int(ht) < len(defined) && defined[ht].IsFunc() {
+		return HeapTypeFunc
+	}
+
+	return HeapTypeAny
+}
+
+// IsDefaultable tells whether t has a default value, which non-nullable references lack.
+func IsDefaultable(t ValueType) bool {
+	return !IsRefType(t) || IsNullable(t)
+}
+
+// IsNullable tells whether the reference type t admits null.
+func IsNullable(t ValueType) bool {
+	return t&0xFF == ValueTypeRefNull
+}
+
+// IsPackedType tells whether t is a packed storage type, i.e., i8 or i16.
+func IsPackedType(t ValueType) bool {
+	return t == StorageTypeI8 || t == StorageTypeI16
+}
+
+// IsRefType tells whether t is a reference type.
+func IsRefType(t ValueType) bool {
+	code := t & 0xFF
+	return code == ValueTypeRef || code == ValueTypeRefNull
+}
+
+// MatchHeapType tells whether got is a subtype of expected, where defined resolves type indices.
+// Defined types only match their declared super types, rather than structurally equivalent ones.
+func MatchHeapType(defined []FuncType, got, expected HeapType) bool {
+	if got == expected {
+		return true
+	}
+
+	switch got {
+	case HeapTypeNone, HeapTypeNoFunc, HeapTypeNoExtern, HeapTypeNoExn:
+		return GetTopHeapType(defined, got) == GetTopHeapType(defined, expected)
+	default:
+	}
+
+	if got >= 0 {
+		if int(got) >= len(defined) {
+			return false
+		}
+
+		if expected >= 0 {
+			for t := TypeIdx(got); len(defined[t].SuperTypes) > 0 && defined[t].SuperTypes[0] < t; {
+				if t = defined[t].SuperTypes[0]; t == TypeIdx(expected) {
+					return true
+				}
+			}
+			return false
+		}
+
+		switch defined[got].Tag {
+		case TypeTagStruct:
+			got = HeapTypeStruct
+		case TypeTagArray:
+			got = HeapTypeArray
+		default:
+			got = HeapTypeFunc
+		}
+		if got == expected {
+			return true
+		}
+	}
+
+	switch expected {
+	case HeapTypeAny:
+		return got == HeapTypeEq || got == HeapTypeI31 || got == HeapTypeStruct || got == HeapTypeArray
+	case HeapTypeEq:
+		return got == HeapTypeI31 || got == HeapTypeStruct || got == HeapTypeArray
+	default:
+	}
+
+	return false
+}
+
+// MatchValueType tells whether values of type got can be used as ones of type expected, i.e., got is
+// a subtype of expected, where defined resolves type indices of heap types.
+func MatchValueType(defined []FuncType, got, expected ValueType) bool {
+	if got == expected {
+		return true
+	} else if !IsRefType(got) || !IsRefType(expected) {
+		return false
+	} else if IsNullable(got) && !IsNullable(expected) {
+		return false
+	}
+
+	return MatchHeapType(defined, GetHeapType(got), GetHeapType(expected))
+}
+
+// MatchValueTypes tells whether got matches expected one by one as MatchValueType.
+func MatchValueTypes(defined []FuncType, got, expected []ValueType) bool {
+	if len(got) != len(expected) {
+		return false
+	}
+
+	for i, v := range got {
+		if !MatchValueType(defined, v, expected[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewRefType returns the type of references to ht, which admits null if nullable.
+func NewRefType(nullable bool, ht HeapType) ValueType {
+	code := ValueTypeRef
+	if nullable {
+		code = ValueTypeRefNull
+	}
+
+	return code | ValueType(uint32(ht))<<32
+}
+
+// UnpackStorageType returns the type of values read from fields of storage type t, which is i32 for
+// packed types.
+func UnpackStorageType(t ValueType) ValueType {
+	if IsPackedType(t) {
+		return ValueTypeI32
+	}
+
+	return t
+}
+
+func (t FieldType) String() string {
+	if t.Mutable == MutVar {
+		return "mut " + StringifyValueType(t.StorageType)
+	}
+
+	return StringifyValueType(t.StorageType)
+}
+
+// IsFunc tells whether t defines a function type rather than a struct or array one.
+func (t FuncType) IsFunc() bool {
+	return t.Tag != TypeTagStruct && t.Tag != TypeTagArray
+}
+
 func (t FuncType) String() string {
 	var b strings.Builder
 
+	switch t.Tag {
+	case TypeTagStruct:
+		b.WriteString("struct{")
+		for i, v := range t.Fields {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(v.String())
+		}
+		b.WriteByte('}')
+		return b.String()
+	case TypeTagArray:
+		return "array[" + t.Fields[0].String() + "]"
+	default:
+	}
+
 	b.WriteByte('(')
 
 	for i, v := range t.ParamTypes {
@@ -129,6 +384,53 @@ func (g GlobalType) String() string {
 	return fmt.Sprintf("{ type: %s, mut: %d }", StringifyValueType(g.ValueType), g.Mutable)
 }
 
+// AddressType returns the value type of addresses into a memory limited by l.
+func (l Limits) AddressType() ValueType {
+	if l.Is64() {
+		return ValueTypeI64
+	}
+
+	return ValueTypeI32
+}
+
+// HasMax tells whether the limits come with an upper bound.
+func (l Limits) HasMax() bool {
+	return l.Tag&LimitsTagMinMax != 0
+}
+
+// Is64 tells whether the limits are of a memory indexed by i64 rather than i32.
+func (l Limits) Is64() bool {
+	return l.Tag&LimitsTagMin64 != 0
+}
+
+// Shared tells whether the limits are flagged as shared, which is only meaningful for memories.
+func (l Limits) Shared() bool {
+	return l.Tag&LimitsTagSharedMin != 0
+}
+
 func (l Limits) String() string {
-	return fmt.Sprintf("{ min: %d, max: %d }", l.Min, l.Min)
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "{ min: %d, max: %d", l.Min, l.Max)
+	if l.Is64() {
+		b.WriteString(", i64")
+	}
+	if l.Shared() {
+		b.WriteString(", shared")
+	}
+	b.WriteString(" }")
+
+	return b.String()
+}
+
+func (n Names) DescribeFunc(idx FuncIdx) string {
+	return DescribeIdx("func", idx, n.Functions)
+}
+
+func (n Names) DescribeGlobal(idx GlobalIdx) string {
+	return DescribeIdx("global", idx, n.Globals)
+}
+
+func (n Names) DescribeLocal(funcIdx FuncIdx, idx LocalIdx) string {
+	return DescribeIdx("local", idx, n.Locals[funcIdx])
 }
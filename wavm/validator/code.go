@@ -1,10 +1,11 @@
 package validator
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"math"
 
+	"github.com/sammyne/mastering-wasm/wavm"
 	"github.com/sammyne/mastering-wasm/wavm/types"
 )
 
@@ -14,9 +15,13 @@ type codeValidator struct {
 
 	moduleValidator *moduleValidator
 	Idx             int
-	InstructionPath map[int]string
+	InstructionPath []string // opnames from the outermost block down to the current instruction
 
 	localLen int
+	offset   uint32 // of the function body
+	// heights are those of the operand stack before instructions in the order walked, excluding
+	// locals, which are recorded only if not nil.
+	heights []int
 }
 
 func (cv *codeValidator) checkAlign(bitWidth int, args interface{}) error {
@@ -28,6 +33,57 @@ func (cv *codeValidator) checkAlign(bitWidth int, args interface{}) error {
 	return nil
 }
 
+// checkMemoryArg checks the memory referred by arg exists and can be addressed with its offset, and
+// returns the type of addresses into that memory.
+func (cv *codeValidator) checkMemoryArg(arg types.MemoryArg) (types.ValueType, error) {
+	addressType, err := cv.getAddressType(arg.MemoryIdx)
+	if err != nil {
+		return types.ValueTypeUnknown, err
+	}
+
+	if addressType == types.ValueTypeI32 && arg.Offset > math.MaxUint32 {
+		return types.ValueTypeUnknown, fmt.Errorf("offset(%d) oversizes 32-bit memory", arg.Offset)
+	}
+
+	return addressType, nil
+}
+
+// checkTailCallResults checks the callee of return_call(_indirect|_ref) returns what the current
+// function does, since its results are returned in place of the caller's.
+func (cv *codeValidator) checkTailCallResults(instr types.Instruction) error {
+	var (
+		ft types.FuncType
+		ok bool
+	)
+	idx := instr.Args.(uint32)
+	if instr.Opcode == types.OpcodeReturnCall {
+		ft, ok = cv.moduleValidator.getFuncType(int(idx))
+	} else if ok = int(idx) < len(cv.moduleValidator.module.Types); ok {
+		ft = cv.moduleValidator.module.Types[idx]
+	}
+	if !ok {
+		return fmt.Errorf("unknown callee type: %d", idx)
+	}
+
+	// the outermost frame is the function body
+	f, err := cv.getControlFrame(len(cv.ControlStack) - 1)
+	if err != nil {
+		return fmt.Errorf("get control frame: %w", err)
+	}
+
+	if expect, got := f.EndTypes, ft.ResultTypes; !cv.matchValueTypes(got, expect) {
+		return fmt.Errorf("callee results %v mismatch expected %v", got, expect)
+	}
+
+	return nil
+}
+
+// describeLocal describes the idx-th local of the function under validation by its name if any.
+func (cv *codeValidator) describeLocal(idx int) string {
+	funcIdx := uint32(len(cv.moduleValidator.importedFuncs) + cv.Idx)
+	return cv.moduleValidator.module.Names.DescribeLocal(funcIdx, uint32(idx))
+}
+
 func (cv *codeValidator) f32Load(args interface{}, bitWidth int) error {
 	return cv.load(types.ValueTypeF32, bitWidth, args)
 }
@@ -44,16 +100,22 @@ func (cv *codeValidator) f64Store(args interface{}, bits int) error {
 	return cv.store(types.ValueTypeF64, bits, args)
 }
 
+func (cv *codeValidator) getAddressType(memoryIdx types.MemoryIdx) (types.ValueType, error) {
+	mem, ok := cv.moduleValidator.getMemory(int(memoryIdx))
+	if !ok {
+		return types.ValueTypeUnknown, fmt.Errorf("unknown memory: %d", memoryIdx)
+	}
+
+	return mem.AddressType(), nil
+}
+
+// getControlFrame returns the frame idx levels down from the top, as labels count.
 func (cv *codeValidator) getControlFrame(idx int) (ControlFrame, error) {
 	if idx >= len(cv.ControlStack) {
 		return ControlFrame{}, fmt.Errorf("idx bound is %d: %w", len(cv.ControlStack), ErrIndexOutOfBound)
 	}
 
-	return cv.ControlStack[idx], nil
-}
-
-func (cv *codeValidator) hasMemory() bool {
-	return cv.moduleValidator.getMemoryLen() > 0
+	return cv.ControlStack[len(cv.ControlStack)-1-idx], nil
 }
 
 func (cv *codeValidator) i32Load(args interface{}, bitWidth int) error {
@@ -73,20 +135,52 @@ func (cv *codeValidator) i64Store(args interface{}, bits int) error {
 }
 
 func (cv *codeValidator) load(vt types.ValueType, bits int, args interface{}) error {
-	if cv.moduleValidator.getMemoryLen() == 0 {
-		return errors.New("no usable memory")
+	addressType, err := cv.checkMemoryArg(args.(types.MemoryArg))
+	if err != nil {
+		return fmt.Errorf("bad memory arg: %w", err)
 	}
 	if err := cv.checkAlign(bits, args); err != nil {
 		return fmt.Errorf("bad alignment: %w", err)
 	}
-	if err := cv.popI32(); err != nil {
-		return fmt.Errorf("pop i32: %w", err)
+	if _, err := cv.popTypeSpecificOperand(addressType); err != nil {
+		return fmt.Errorf("pop address: %w", err)
 	}
 	cv.pushOperand(vt)
 
 	return nil
 }
 
+func (cv *codeValidator) matchValueType(got, expect types.ValueType) bool {
+	return types.MatchValueType(cv.moduleValidator.module.Types, got, expect)
+}
+
+func (cv *codeValidator) matchValueTypes(got, expect []types.ValueType) bool {
+	return types.MatchValueTypes(cv.moduleValidator.module.Types, got, expect)
+}
+
+// newValidationError locates err at instr, or at the function body if instr is nil.
+func (cv *codeValidator) newValidationError(instr *types.Instruction, err error) *ValidationError {
+	funcIdx := uint32(len(cv.moduleValidator.importedFuncs) + cv.Idx)
+	out := &ValidationError{
+		FuncIdx:  funcIdx,
+		FuncName: cv.moduleValidator.module.Names.Functions[funcIdx],
+		Offset:   cv.offset,
+		Err:      err,
+	}
+
+	if instr != nil {
+		out.Path = append([]string(nil), cv.InstructionPath...)
+		out.Offset, out.Opcode = instr.Offset, instr.Opcode
+	}
+
+	var mismatch *OperandMismatchError
+	if errors.As(err, &mismatch) {
+		out.Expected, out.Actual = mismatch.Expected, mismatch.Actual
+	}
+
+	return out
+}
+
 func (cv *codeValidator) popControlFrame() (ControlFrame, error) {
 	f, err := cv.getControlFrame(0)
 	if err != nil {
@@ -133,7 +227,7 @@ func (cv *codeValidator) popOperand() (types.ValueType, error) {
 			return types.ValueTypeUnknown, nil
 		}
 
-		return types.ValueTypeUnknown, errors.New("type mismatch")
+		return types.ValueTypeUnknown, ErrTypeMismatch
 	}
 
 	r := cv.OperandStack[len(cv.OperandStack)-1]
@@ -141,11 +235,31 @@ func (cv *codeValidator) popOperand() (types.ValueType, error) {
 	return r, nil
 }
 
+// popOperands pops operands of types expected, or else errs with *OperandMismatchError covering
+// all of them on mismatch.
 func (cv *codeValidator) popOperands(expected []types.ValueType) error {
+	top := len(cv.OperandStack)
 	for i := len(expected) - 1; i >= 0; i-- {
-		if _, err := cv.popTypeSpecificOperand(expected[i]); err != nil {
+		_, err := cv.popTypeSpecificOperand(expected[i])
+		if err == nil {
+			continue
+		}
+
+		var mismatch *OperandMismatchError
+		if !errors.As(err, &mismatch) {
 			return fmt.Errorf("pop %d-th operand: %w", i, err)
 		}
+
+		// operands popped so far are still there beyond the length of the stack
+		f, _ := cv.getControlFrame(0)
+		bottom := top - len(expected)
+		if bottom < f.Height {
+			bottom = f.Height
+		}
+		actual := append([]types.ValueType(nil), cv.OperandStack[bottom:top]...)
+
+		mismatch = &OperandMismatchError{Expected: expected, Actual: actual}
+		return fmt.Errorf("pop %d-th operand: %w", i, mismatch)
 	}
 
 	return nil
@@ -174,7 +288,9 @@ func (cv *codeValidator) popThenPush(popType, pushType types.ValueType) error {
 
 func (cv *codeValidator) popTypeSpecificOperand(expect types.ValueType) (types.ValueType, error) {
 	got, err := cv.popOperand()
-	if err != nil {
+	if errors.Is(err, ErrTypeMismatch) {
+		return types.ValueTypeUnknown, &OperandMismatchError{Expected: []types.ValueType{expect}}
+	} else if err != nil {
 		return types.ValueTypeUnknown, fmt.Errorf("pop generic operand: %w", err)
 	}
 
@@ -183,8 +299,9 @@ func (cv *codeValidator) popTypeSpecificOperand(expect types.ValueType) (types.V
 		return expect, nil
 	case expect == types.ValueTypeUnknown:
 		return got, nil
-	case expect != got:
-		return types.ValueTypeUnknown, fmt.Errorf("got type=%d: %w", got, ErrTypeMismatch)
+	case !cv.matchValueType(got, expect):
+		expected, actual := []types.ValueType{expect}, []types.ValueType{got}
+		return types.ValueTypeUnknown, &OperandMismatchError{Expected: expected, Actual: actual}
 	default:
 	}
 
@@ -215,8 +332,9 @@ func (cv *codeValidator) pushOperands(values []types.ValueType) {
 }
 
 func (cv *codeValidator) store(vt types.ValueType, bits int, args interface{}) error {
-	if cv.moduleValidator.getMemoryLen() == 0 {
-		return errors.New("no usable memory")
+	addressType, err := cv.checkMemoryArg(args.(types.MemoryArg))
+	if err != nil {
+		return fmt.Errorf("bad memory arg: %w", err)
 	}
 	if err := cv.checkAlign(bits, args); err != nil {
 		return fmt.Errorf("bad alignment: %w", err)
@@ -225,8 +343,8 @@ func (cv *codeValidator) store(vt types.ValueType, bits int, args interface{}) e
 	if _, err := cv.popTypeSpecificOperand(vt); err != nil {
 		return fmt.Errorf("pop operand: %w", err)
 	}
-	if err := cv.popI32(); err != nil {
-		return fmt.Errorf("pop i32: %w", err)
+	if _, err := cv.popTypeSpecificOperand(addressType); err != nil {
+		return fmt.Errorf("pop address: %w", err)
 	}
 
 	return nil
@@ -245,7 +363,7 @@ func (cv *codeValidator) unreachable() error {
 }
 
 func (cv *codeValidator) validateBlockOrLoop(instr types.Instruction) error {
-	block := instr.Args.(types.Block)
+	block := instr.Args.(*types.Block)
 	bt, err := cv.moduleValidator.module.GetBlockType(block.BlockType)
 	if err != nil {
 		return fmt.Errorf("get block type: %w", err)
@@ -312,7 +430,7 @@ func (cv *codeValidator) validateBreakIf(instr types.Instruction) error {
 }
 
 func (cv *codeValidator) validateBreakTable(instr types.Instruction) error {
-	args := instr.Args.(types.BreakTable)
+	args := instr.Args.(*types.BreakTable)
 	m := int(args.Default)
 	if len(cv.ControlStack) <= m {
 		return fmt.Errorf("default label(%d) larger than max(%d)", m, len(cv.ControlStack))
@@ -333,7 +451,7 @@ func (cv *codeValidator) validateBreakTable(instr types.Instruction) error {
 			return fmt.Errorf("get control frame labeled by %d: %w", n, err)
 		}
 
-		if !bytes.Equal(f1.LabelTypes(), defaultFrame.LabelTypes()) {
+		if !types.EqualValueTypes(f1.LabelTypes(), defaultFrame.LabelTypes()) {
 			return fmt.Errorf("inconsistent label types: %v != %v", f1.LabelTypes(), defaultFrame.LabelTypes())
 		}
 	}
@@ -389,11 +507,18 @@ func (cv *codeValidator) validateCallIndirect(instr types.Instruction) error {
 	return nil
 }
 
+// validateCode checks code of funcType, failing with *ValidationError.
 func (cv *codeValidator) validateCode(code types.Code, funcType types.FuncType) error {
-	cv.popOperands(funcType.ParamTypes)
+	cv.pushOperands(funcType.ParamTypes)
 	cv.localLen = len(funcType.ParamTypes)
+	cv.offset = code.Offset
 
 	for _, v := range code.Locals {
+		// locals start as zero, which is invalid for non-nullable references
+		if types.IsRefType(v.Type) && !types.IsNullable(v.Type) {
+			err := fmt.Errorf("non-defaultable local of type %s", types.StringifyValueType(v.Type))
+			return cv.newValidationError(nil, err)
+		}
 		for i := 0; i < int(v.N); i++ {
 			cv.pushOperand(v.Type)
 			cv.localLen++
@@ -402,12 +527,12 @@ func (cv *codeValidator) validateCode(code types.Code, funcType types.FuncType)
 
 	cv.pushControlFrame(types.OpcodeBlock, nil, funcType.ResultTypes)
 	if err := cv.validateExprs(code.Expr); err != nil {
-		return fmt.Errorf("validate exprs: %w", err)
+		return err
 	}
 
 	cf, err := cv.popControlFrame()
 	if err != nil {
-		return fmt.Errorf("pop control frame: %w", err)
+		return cv.newValidationError(nil, fmt.Errorf("pop control frame: %w", err))
 	}
 
 	cv.pushOperands(cf.EndTypes)
@@ -415,13 +540,24 @@ func (cv *codeValidator) validateCode(code types.Code, funcType types.FuncType)
 	return nil
 }
 
+// validateExprs checks exprs, failing with *ValidationError of the innermost failing instruction.
 func (cv *codeValidator) validateExprs(exprs []types.Instruction) error {
 	depth := len(cv.InstructionPath)
+	cv.InstructionPath = append(cv.InstructionPath, "")
+	defer func() { cv.InstructionPath = cv.InstructionPath[:depth] }()
 
-	for i, v := range exprs {
+	for i := range exprs {
+		v := &exprs[i]
 		cv.InstructionPath[depth] = v.GetOpname()
-		if err := cv.validateInstr(v); err != nil {
-			return fmt.Errorf("valida %d-th instruction: %w", i, err)
+		if cv.heights != nil {
+			cv.heights = append(cv.heights, len(cv.OperandStack)-cv.ControlStack[0].Height)
+		}
+		if err := cv.validateInstr(*v); err != nil {
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				return verr
+			}
+			return cv.newValidationError(v, fmt.Errorf("validate %d-th instruction: %w", i, err))
 		}
 	}
 
@@ -466,7 +602,7 @@ func (cv *codeValidator) validateGlobalSet(instr types.Instruction) error {
 	}
 	gt := cv.moduleValidator.globalTypes[n]
 	if gt.Mutable != 1 {
-		return errors.New("immutable")
+		return fmt.Errorf("%s is immutable", cv.moduleValidator.module.Names.DescribeGlobal(uint32(n)))
 	}
 	if _, err := cv.popTypeSpecificOperand(gt.ValueType); err != nil {
 		return fmt.Errorf("pop operand: %w", err)
@@ -475,7 +611,7 @@ func (cv *codeValidator) validateGlobalSet(instr types.Instruction) error {
 }
 
 func (cv *codeValidator) validateIf(instr types.Instruction) error {
-	blockIf := instr.Args.(types.BlockIf)
+	blockIf := instr.Args.(*types.BlockIf)
 	bt, err := cv.moduleValidator.module.GetBlockType(blockIf.BlockType)
 	if err != nil {
 		return fmt.Errorf("get block type: %w", err)
@@ -516,6 +652,11 @@ func (cv *codeValidator) validateIf(instr types.Instruction) error {
 }
 
 func (cv *codeValidator) validateInstr(instr types.Instruction) error {
+	required := wavm.GetInstructionFeatures(instr)
+	if err := cv.moduleValidator.module.GetFeatures().Require(required, instr.GetOpname()); err != nil {
+		return err
+	}
+
 	switch instr.Opcode {
 	case types.OpcodeUnreachable:
 		cv.unreachable()
@@ -528,6 +669,19 @@ func (cv *codeValidator) validateInstr(instr types.Instruction) error {
 		if err := cv.validateIf(instr); err != nil {
 			return fmt.Errorf("bad if block: %w", err)
 		}
+	case types.OpcodeTryTable:
+		if err := cv.validateTryTable(instr); err != nil {
+			return fmt.Errorf("bad try_table: %w", err)
+		}
+	case types.OpcodeThrow:
+		if err := cv.validateThrow(instr); err != nil {
+			return fmt.Errorf("bad throw: %w", err)
+		}
+	case types.OpcodeThrowRef:
+		if _, err := cv.popTypeSpecificOperand(types.ValueTypeExnRef); err != nil {
+			return fmt.Errorf("bad throw_ref: %w", err)
+		}
+		cv.unreachable()
 	case types.OpcodeBr:
 		if err := cv.validateBreak(instr); err != nil {
 			return fmt.Errorf("bad br: %w", err)
@@ -552,6 +706,27 @@ func (cv *codeValidator) validateInstr(instr types.Instruction) error {
 		if err := cv.validateCallIndirect(instr); err != nil {
 			return fmt.Errorf("bad call indirect: %w", err)
 		}
+	case types.OpcodeReturnCall:
+		if err := cv.validateReturnCall(instr); err != nil {
+			return fmt.Errorf("bad return_call: %w", err)
+		}
+	case types.OpcodeReturnCallIndirect:
+		if err := cv.validateReturnCallIndirect(instr); err != nil {
+			return fmt.Errorf("bad return_call_indirect: %w", err)
+		}
+	case types.OpcodeCallRef:
+		if err := cv.validateCallRef(instr); err != nil {
+			return fmt.Errorf("bad call_ref: %w", err)
+		}
+	case types.OpcodeReturnCallRef:
+		if err := cv.validateReturnCallRef(instr); err != nil {
+			return fmt.Errorf("bad return_call_ref: %w", err)
+		}
+	case types.OpcodeRefNull, types.OpcodeRefIsNull, types.OpcodeRefFunc, types.OpcodeRefEq,
+		types.OpcodeRefAsNonNull, types.OpcodeBrOnNull, types.OpcodeBrOnNonNull:
+		if err := cv.validateRef(instr); err != nil {
+			return fmt.Errorf("bad %s: %w", instr.GetOpname(), err)
+		}
 	case types.OpcodeDrop:
 		if _, err := cv.popOperand(); err != nil {
 			return fmt.Errorf("no operand to drop: %w", err)
@@ -653,10 +828,11 @@ func (cv *codeValidator) validateInstr(instr types.Instruction) error {
 			return fmt.Errorf("bad i64.store32: %w", err)
 		}
 	case types.OpcodeMemorySize:
-		if yes := cv.hasMemory(); !yes {
-			return errors.New("no usable memory")
+		addressType, err := cv.getAddressType(instr.Args.(uint32))
+		if err != nil {
+			return fmt.Errorf("bad memory.size: %w", err)
 		}
-		cv.pushOperand(types.ValueTypeI32)
+		cv.pushOperand(addressType)
 	case types.OpcodeMemoryGrow:
 		if err := cv.validateMemoryGrow(instr); err != nil {
 			return fmt.Errorf("bad memory.grow: %w", err)
@@ -813,7 +989,22 @@ func (cv *codeValidator) validateInstr(instr types.Instruction) error {
 		if err := cv.popThenPush(types.ValueTypeI64, types.ValueTypeI64); err != nil {
 			return fmt.Errorf("bad i64.extend_{8,16,32}s: %w", err)
 		}
+	case types.OpcodeAtomic:
+		if err := cv.validateAtomic(instr); err != nil {
+			return fmt.Errorf("bad atomic: %w", err)
+		}
+	case types.OpcodeGC:
+		if err := cv.validateGC(instr.Args.(types.GCArg)); err != nil {
+			return fmt.Errorf("bad %s: %w", instr.GetOpname(), err)
+		}
 	case types.OpcodeTruncSat:
+		if arg, ok := instr.Args.(types.BulkArg); ok {
+			if err := cv.validateBulk(arg); err != nil {
+				return fmt.Errorf("bad %s: %w", instr.GetOpname(), err)
+			}
+			break
+		}
+
 		var err error
 		subOpcode := instr.Args.(byte)
 		switch subOpcode {
@@ -877,13 +1068,13 @@ func (cv *codeValidator) validateI64Eqz() error {
 }
 
 func (cv *codeValidator) validateMemoryGrow(instr types.Instruction) error {
-	if yes := cv.hasMemory(); !yes {
-		return errors.New("no usable memory")
+	addressType, err := cv.getAddressType(instr.Args.(uint32))
+	if err != nil {
+		return err
 	}
-	if err := cv.popI32(); err != nil {
-		return fmt.Errorf("pop i32: %w", err)
+	if err := cv.popThenPush(addressType, addressType); err != nil {
+		return fmt.Errorf("pop delta: %w", err)
 	}
-	cv.pushOperand(types.ValueTypeI32)
 	return nil
 }
 
@@ -902,7 +1093,7 @@ func (cv *codeValidator) validateLocalSet(instr types.Instruction) error {
 		return fmt.Errorf("bad label(%d)>=%d", n, cv.localLen)
 	}
 	if _, err := cv.popTypeSpecificOperand(cv.OperandStack[n]); err != nil {
-		return fmt.Errorf("pop operand: %w", err)
+		return fmt.Errorf("pop operand for %s: %w", cv.describeLocal(n), err)
 	}
 	return nil
 }
@@ -913,7 +1104,7 @@ func (cv *codeValidator) validateLocalTee(instr types.Instruction) error {
 		return fmt.Errorf("bad label(%d)>=%d", n, cv.localLen)
 	}
 	if _, err := cv.popTypeSpecificOperand(cv.OperandStack[n]); err != nil {
-		return fmt.Errorf("pop stack top: %w", err)
+		return fmt.Errorf("pop stack top for %s: %w", cv.describeLocal(n), err)
 	}
 	cv.pushOperand(cv.OperandStack[n])
 	return nil
@@ -934,6 +1125,28 @@ func (cv *codeValidator) validateReturn(instr types.Instruction) error {
 	return cv.unreachable()
 }
 
+func (cv *codeValidator) validateReturnCall(instr types.Instruction) error {
+	if err := cv.checkTailCallResults(instr); err != nil {
+		return err
+	}
+	if err := cv.validateCall(instr); err != nil {
+		return err
+	}
+
+	return cv.unreachable()
+}
+
+func (cv *codeValidator) validateReturnCallIndirect(instr types.Instruction) error {
+	if err := cv.checkTailCallResults(instr); err != nil {
+		return err
+	}
+	if err := cv.validateCallIndirect(instr); err != nil {
+		return err
+	}
+
+	return cv.unreachable()
+}
+
 func (cv *codeValidator) validateSelect(instr types.Instruction) error {
 	if err := cv.popI32(); err != nil {
 		return fmt.Errorf("pop condition: %w", err)
@@ -0,0 +1,95 @@
+package validator
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+func (cv *codeValidator) checkAtomicAlign(bitWidth int, arg types.MemoryArg) error {
+	if a, b := 1<<arg.Align, bitWidth/8; a != b {
+		return fmt.Errorf("alignment(%d) must equal natural alignment(%d)", a, b)
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) validateAtomic(instr types.Instruction) error {
+	arg := instr.Args.(types.AtomicArg)
+	if arg.SubOpcode == types.AtomicFence {
+		return nil
+	}
+
+	addressType, err := cv.checkMemoryArg(arg.MemoryArg)
+	if err != nil {
+		return fmt.Errorf("bad memory arg: %w", err)
+	}
+
+	switch arg.SubOpcode {
+	case types.AtomicNotify:
+		return cv.validateAtomicNotify(arg, addressType)
+	case types.AtomicWait32:
+		return cv.validateAtomicWait(arg, addressType, types.ValueTypeI32, 32)
+	case types.AtomicWait64:
+		return cv.validateAtomicWait(arg, addressType, types.ValueTypeI64, 64)
+	default:
+	}
+
+	vt, bitWidth, ok := types.GetAtomicAccess(arg.SubOpcode)
+	if !ok {
+		return fmt.Errorf("unknown sub-opcode: 0x%x", arg.SubOpcode)
+	}
+	if err := cv.checkAtomicAlign(bitWidth, arg.MemoryArg); err != nil {
+		return fmt.Errorf("bad alignment: %w", err)
+	}
+
+	switch {
+	case arg.SubOpcode <= types.AtomicI64Load32U:
+		return cv.popThenPush(addressType, vt)
+	case arg.SubOpcode <= types.AtomicI64Store32:
+		if err := cv.popOperands([]types.ValueType{addressType, vt}); err != nil {
+			return fmt.Errorf("pop operands: %w", err)
+		}
+	case arg.SubOpcode < types.AtomicI32RmwCmpxchg:
+		if _, err := cv.popTypeSpecificOperand(vt); err != nil {
+			return fmt.Errorf("pop operand: %w", err)
+		}
+		if err := cv.popThenPush(addressType, vt); err != nil {
+			return fmt.Errorf("pop address: %w", err)
+		}
+	default:
+		if err := cv.popOperands([]types.ValueType{addressType, vt, vt}); err != nil {
+			return fmt.Errorf("pop operands: %w", err)
+		}
+		cv.pushOperand(vt)
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) validateAtomicNotify(arg types.AtomicArg,
+	addressType types.ValueType) error {
+	if err := cv.checkAtomicAlign(32, arg.MemoryArg); err != nil {
+		return fmt.Errorf("bad alignment: %w", err)
+	}
+	if err := cv.popOperands([]types.ValueType{addressType, types.ValueTypeI32}); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+	cv.pushOperand(types.ValueTypeI32)
+
+	return nil
+}
+
+func (cv *codeValidator) validateAtomicWait(arg types.AtomicArg, addressType,
+	vt types.ValueType, bitWidth int) error {
+	if err := cv.checkAtomicAlign(bitWidth, arg.MemoryArg); err != nil {
+		return fmt.Errorf("bad alignment: %w", err)
+	}
+	expected := []types.ValueType{addressType, vt, types.ValueTypeI64}
+	if err := cv.popOperands(expected); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+	cv.pushOperand(types.ValueTypeI32)
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+func (cv *codeValidator) checkDataIdx(idx types.DataIdx) error {
+	n := cv.moduleValidator.module.DataCount
+	if n == nil {
+		return errors.New("missing data count section")
+	} else if idx >= *n {
+		return fmt.Errorf("unknown data: %d", idx)
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) validateBulk(arg types.BulkArg) error {
+	switch arg.SubOpcode {
+	case types.BulkMemoryInit:
+		return cv.validateMemoryInit(arg)
+	case types.BulkDataDrop:
+		return cv.checkDataIdx(arg.DataIdx)
+	case types.BulkMemoryCopy:
+		return cv.validateMemoryCopy(arg)
+	case types.BulkMemoryFill:
+		return cv.validateMemoryFill(arg)
+	default:
+	}
+
+	return fmt.Errorf("unknown sub-opcode: 0x%x", arg.SubOpcode)
+}
+
+func (cv *codeValidator) validateMemoryCopy(arg types.BulkArg) error {
+	dstType, err := cv.getAddressType(arg.MemoryIdx)
+	if err != nil {
+		return fmt.Errorf("bad destination: %w", err)
+	}
+	srcType, err := cv.getAddressType(arg.SrcMemoryIdx)
+	if err != nil {
+		return fmt.Errorf("bad source: %w", err)
+	}
+
+	// the length must fit into the narrower of both memories
+	lenType := dstType
+	if srcType == types.ValueTypeI32 {
+		lenType = srcType
+	}
+
+	if err := cv.popOperands([]types.ValueType{dstType, srcType, lenType}); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) validateMemoryFill(arg types.BulkArg) error {
+	addressType, err := cv.getAddressType(arg.MemoryIdx)
+	if err != nil {
+		return err
+	}
+
+	expected := []types.ValueType{addressType, types.ValueTypeI32, addressType}
+	if err := cv.popOperands(expected); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) validateMemoryInit(arg types.BulkArg) error {
+	if err := cv.checkDataIdx(arg.DataIdx); err != nil {
+		return err
+	}
+
+	addressType, err := cv.getAddressType(arg.MemoryIdx)
+	if err != nil {
+		return err
+	}
+
+	expected := []types.ValueType{addressType, types.ValueTypeI32, types.ValueTypeI32}
+	if err := cv.popOperands(expected); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+
+	return nil
+}
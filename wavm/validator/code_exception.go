@@ -0,0 +1,81 @@
+package validator
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// checkCatch checks the label of c accepts what c passes, which is the payload of the caught
+// exception followed by the exnref for *_ref clauses. c is checked against the control stack
+// before the try_table frame gets pushed, as its label is outside the try_table.
+func (cv *codeValidator) checkCatch(c types.Catch) error {
+	var payload []types.ValueType
+	switch c.Kind {
+	case types.CatchKindTag, types.CatchKindTagRef:
+		t, ok := cv.moduleValidator.getTagType(int(c.TagIdx))
+		if !ok {
+			return fmt.Errorf("unknown tag: %d", c.TagIdx)
+		}
+		payload = append(payload, t.ParamTypes...)
+	default:
+	}
+
+	if c.Kind == types.CatchKindTagRef || c.Kind == types.CatchKindAllRef {
+		payload = append(payload, types.ValueTypeExnRef)
+	}
+
+	f, err := cv.getControlFrame(int(c.Label))
+	if err != nil {
+		return fmt.Errorf("get control frame labeled by %d: %w", c.Label, err)
+	}
+
+	if expect := f.LabelTypes(); !cv.matchValueTypes(payload, expect) {
+		return fmt.Errorf("label types %v mismatch payload %v", expect, payload)
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) validateThrow(instr types.Instruction) error {
+	idx := instr.Args.(uint32)
+	t, ok := cv.moduleValidator.getTagType(int(idx))
+	if !ok {
+		return fmt.Errorf("unknown tag: %d", idx)
+	}
+
+	if err := cv.popOperands(t.ParamTypes); err != nil {
+		return fmt.Errorf("pop payload: %w", err)
+	}
+
+	return cv.unreachable()
+}
+
+func (cv *codeValidator) validateTryTable(instr types.Instruction) error {
+	tryTable := instr.Args.(*types.TryTable)
+	bt, err := cv.moduleValidator.module.GetBlockType(tryTable.BlockType)
+	if err != nil {
+		return fmt.Errorf("get block type: %w", err)
+	}
+
+	for i, c := range tryTable.Catches {
+		if err := cv.checkCatch(c); err != nil {
+			return fmt.Errorf("bad %d-th catch: %w", i, err)
+		}
+	}
+
+	if err := cv.popOperands(bt.ParamTypes); err != nil {
+		return fmt.Errorf("pop operands for try_table: %w", err)
+	}
+	cv.pushControlFrame(instr.Opcode, bt.ParamTypes, bt.ResultTypes)
+	if err := cv.validateExprs(tryTable.Instructions); err != nil {
+		return fmt.Errorf("invalid exprs for try_table: %w", err)
+	}
+	cf, err := cv.popControlFrame()
+	if err != nil {
+		return fmt.Errorf("pop control frame: %w", err)
+	}
+	cv.pushOperands(cf.EndTypes)
+
+	return nil
+}
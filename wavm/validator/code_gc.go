@@ -0,0 +1,257 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// checkPackedAccess checks fields are read by the get_s/get_u variants if and only if they are of
+// packed types, which need extending.
+func checkPackedAccess(f types.FieldType, extending bool) error {
+	if packed := types.IsPackedType(f.StorageType); packed && !extending {
+		return fmt.Errorf("packed field of %s read without extension",
+			types.StringifyValueType(f.StorageType))
+	} else if !packed && extending {
+		return fmt.Errorf("non-packed field of %s read with extension",
+			types.StringifyValueType(f.StorageType))
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) getArrayElement(idx types.TypeIdx) (types.FieldType, error) {
+	t, err := cv.moduleValidator.getCompositeType(idx, types.TypeTagArray)
+	if err != nil {
+		return types.FieldType{}, err
+	}
+
+	return t.Fields[0], nil
+}
+
+func (cv *codeValidator) getStructField(typeIdx types.TypeIdx,
+	idx uint32) (types.FieldType, error) {
+	t, err := cv.moduleValidator.getCompositeType(typeIdx, types.TypeTagStruct)
+	if err != nil {
+		return types.FieldType{}, err
+	} else if int(idx) >= len(t.Fields) {
+		return types.FieldType{}, fmt.Errorf("unknown field: %d", idx)
+	}
+
+	return t.Fields[idx], nil
+}
+
+func (cv *codeValidator) validateArrayCopy(arg types.GCArg) error {
+	dst, err := cv.getArrayElement(arg.TypeIdx)
+	if err != nil {
+		return fmt.Errorf("bad destination: %w", err)
+	} else if dst.Mutable != types.MutVar {
+		return errors.New("immutable destination")
+	}
+
+	src, err := cv.getArrayElement(arg.Idx)
+	if err != nil {
+		return fmt.Errorf("bad source: %w", err)
+	} else if !cv.matchValueType(src.StorageType, dst.StorageType) {
+		return fmt.Errorf("source elements of %s: %w", types.StringifyValueType(src.StorageType),
+			ErrTypeMismatch)
+	}
+
+	operands := []types.ValueType{
+		types.NewRefType(true, types.HeapType(arg.TypeIdx)), types.ValueTypeI32,
+		types.NewRefType(true, types.HeapType(arg.Idx)), types.ValueTypeI32, types.ValueTypeI32,
+	}
+
+	return cv.popOperands(operands)
+}
+
+func (cv *codeValidator) validateArrayFill(arg types.GCArg) error {
+	elem, err := cv.getArrayElement(arg.TypeIdx)
+	if err != nil {
+		return err
+	} else if elem.Mutable != types.MutVar {
+		return errors.New("immutable array")
+	}
+
+	operands := []types.ValueType{
+		types.NewRefType(true, types.HeapType(arg.TypeIdx)), types.ValueTypeI32,
+		types.UnpackStorageType(elem.StorageType), types.ValueTypeI32,
+	}
+
+	return cv.popOperands(operands)
+}
+
+func (cv *codeValidator) validateArrayGet(arg types.GCArg) error {
+	elem, err := cv.getArrayElement(arg.TypeIdx)
+	if err != nil {
+		return err
+	} else if err := checkPackedAccess(elem, arg.SubOpcode != types.GCArrayGet); err != nil {
+		return err
+	}
+
+	operands := []types.ValueType{
+		types.NewRefType(true, types.HeapType(arg.TypeIdx)), types.ValueTypeI32,
+	}
+	if err := cv.popOperands(operands); err != nil {
+		return err
+	}
+	cv.pushOperand(types.UnpackStorageType(elem.StorageType))
+
+	return nil
+}
+
+func (cv *codeValidator) validateArraySet(arg types.GCArg) error {
+	elem, err := cv.getArrayElement(arg.TypeIdx)
+	if err != nil {
+		return err
+	} else if elem.Mutable != types.MutVar {
+		return errors.New("immutable array")
+	}
+
+	operands := []types.ValueType{
+		types.NewRefType(true, types.HeapType(arg.TypeIdx)), types.ValueTypeI32,
+		types.UnpackStorageType(elem.StorageType),
+	}
+
+	return cv.popOperands(operands)
+}
+
+// validateBrOnCast checks br_on_cast(_fail), which branches with the reference if the cast from
+// arg.SrcType to arg.DstType succeeds (fails) and falls through with it otherwise.
+func (cv *codeValidator) validateBrOnCast(arg types.GCArg) error {
+	for _, t := range []types.ValueType{arg.SrcType, arg.DstType} {
+		if err := cv.moduleValidator.checkValueType(t); err != nil {
+			return err
+		}
+	}
+	if !cv.matchValueType(arg.DstType, arg.SrcType) {
+		return fmt.Errorf("target type %s isn't a subtype of %s: %w",
+			types.StringifyValueType(arg.DstType), types.StringifyValueType(arg.SrcType), ErrTypeMismatch)
+	}
+
+	f, err := cv.getControlFrame(int(arg.Label))
+	if err != nil {
+		return fmt.Errorf("unknown label %d: %w", arg.Label, err)
+	}
+
+	labelTypes := f.LabelTypes()
+	n := len(labelTypes)
+	if n == 0 {
+		return errors.New("label without reference to receive")
+	}
+
+	// values failing the cast are only known non-null if the target admits null
+	diff := arg.SrcType
+	if types.IsNullable(arg.DstType) {
+		diff = types.NewRefType(false, types.GetHeapType(arg.SrcType))
+	}
+
+	branched, fallen := arg.DstType, diff
+	if arg.SubOpcode == types.GCBrOnCastFail {
+		branched, fallen = diff, arg.DstType
+	}
+	if !cv.matchValueType(branched, labelTypes[n-1]) {
+		return fmt.Errorf("reference %s mismatches label type %s", types.StringifyValueType(branched),
+			types.StringifyValueType(labelTypes[n-1]))
+	}
+
+	if _, err := cv.popTypeSpecificOperand(arg.SrcType); err != nil {
+		return fmt.Errorf("pop reference: %w", err)
+	}
+	if err := cv.popOperands(labelTypes[:n-1]); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+	cv.pushOperands(labelTypes[:n-1])
+	cv.pushOperand(fallen)
+
+	return nil
+}
+
+func (cv *codeValidator) validateGC(arg types.GCArg) error {
+	switch arg.SubOpcode {
+	case types.GCStructNew, types.GCStructNewDefault, types.GCArrayNew, types.GCArrayNewDefault,
+		types.GCArrayNewFixed:
+		operands, err := cv.moduleValidator.getNewOperands(arg)
+		if err != nil {
+			return err
+		} else if err := cv.popOperands(operands); err != nil {
+			return err
+		}
+		cv.pushOperand(types.NewRefType(false, types.HeapType(arg.TypeIdx)))
+	case types.GCStructGet, types.GCStructGetS, types.GCStructGetU:
+		return cv.validateStructGet(arg)
+	case types.GCStructSet:
+		return cv.validateStructSet(arg)
+	case types.GCArrayGet, types.GCArrayGetS, types.GCArrayGetU:
+		return cv.validateArrayGet(arg)
+	case types.GCArraySet:
+		return cv.validateArraySet(arg)
+	case types.GCArrayLen:
+		return cv.popThenPush(types.ValueTypeArrayRef, types.ValueTypeI32)
+	case types.GCArrayFill:
+		return cv.validateArrayFill(arg)
+	case types.GCArrayCopy:
+		return cv.validateArrayCopy(arg)
+	case types.GCRefTest, types.GCRefTestNull, types.GCRefCast, types.GCRefCastNull:
+		return cv.validateRefCast(arg)
+	case types.GCBrOnCast, types.GCBrOnCastFail:
+		return cv.validateBrOnCast(arg)
+	case types.GCRefI31:
+		return cv.popThenPush(types.ValueTypeI32, types.NewRefType(false, types.HeapTypeI31))
+	case types.GCI31GetS, types.GCI31GetU:
+		return cv.popThenPush(types.ValueTypeI31Ref, types.ValueTypeI32)
+	default:
+		return fmt.Errorf("unsupported sub-opcode: %02x", arg.SubOpcode)
+	}
+
+	return nil
+}
+
+// validateRefCast checks ref.test and ref.cast, which take references of the same hierarchy as the
+// target type.
+func (cv *codeValidator) validateRefCast(arg types.GCArg) error {
+	if err := cv.moduleValidator.checkValueType(arg.DstType); err != nil {
+		return err
+	}
+
+	top := types.GetTopHeapType(cv.moduleValidator.module.Types, types.GetHeapType(arg.DstType))
+	if _, err := cv.popTypeSpecificOperand(types.NewRefType(true, top)); err != nil {
+		return fmt.Errorf("pop reference: %w", err)
+	}
+
+	if arg.SubOpcode == types.GCRefTest || arg.SubOpcode == types.GCRefTestNull {
+		cv.pushOperand(types.ValueTypeI32)
+	} else {
+		cv.pushOperand(arg.DstType)
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) validateStructGet(arg types.GCArg) error {
+	field, err := cv.getStructField(arg.TypeIdx, arg.Idx)
+	if err != nil {
+		return err
+	} else if err := checkPackedAccess(field, arg.SubOpcode != types.GCStructGet); err != nil {
+		return err
+	}
+
+	return cv.popThenPush(types.NewRefType(true, types.HeapType(arg.TypeIdx)),
+		types.UnpackStorageType(field.StorageType))
+}
+
+func (cv *codeValidator) validateStructSet(arg types.GCArg) error {
+	field, err := cv.getStructField(arg.TypeIdx, arg.Idx)
+	if err != nil {
+		return err
+	} else if field.Mutable != types.MutVar {
+		return fmt.Errorf("immutable field: %d", arg.Idx)
+	}
+
+	operands := []types.ValueType{
+		types.NewRefType(true, types.HeapType(arg.TypeIdx)), types.UnpackStorageType(field.StorageType),
+	}
+
+	return cv.popOperands(operands)
+}
@@ -0,0 +1,158 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// popRef pops a reference of any type, which is ValueTypeUnknown if the stack is polymorphic.
+func (cv *codeValidator) popRef() (types.ValueType, error) {
+	t, err := cv.popOperand()
+	if err != nil {
+		return types.ValueTypeUnknown, err
+	} else if t != types.ValueTypeUnknown && !types.IsRefType(t) {
+		return types.ValueTypeUnknown, fmt.Errorf("expect reference, got %s: %w",
+			types.StringifyValueType(t), ErrTypeMismatch)
+	}
+
+	return t, nil
+}
+
+func (cv *codeValidator) pushNonNull(t types.ValueType) {
+	if t == types.ValueTypeUnknown {
+		cv.pushOperand(t)
+		return
+	}
+
+	cv.pushOperand(types.NewRefType(false, types.GetHeapType(t)))
+}
+
+func (cv *codeValidator) validateBrOnNonNull(instr types.Instruction) error {
+	l := instr.Args.(uint32)
+	f, err := cv.getControlFrame(int(l))
+	if err != nil {
+		return fmt.Errorf("unknown label %d: %w", l, err)
+	}
+
+	labelTypes := f.LabelTypes()
+	n := len(labelTypes)
+	if n == 0 {
+		return errors.New("label without reference to receive")
+	}
+
+	t, err := cv.popRef()
+	if err != nil {
+		return fmt.Errorf("pop reference: %w", err)
+	}
+	if t != types.ValueTypeUnknown {
+		nonNull := types.NewRefType(false, types.GetHeapType(t))
+		if !cv.matchValueType(nonNull, labelTypes[n-1]) {
+			return fmt.Errorf("reference %s mismatches label type %s",
+				types.StringifyValueType(nonNull), types.StringifyValueType(labelTypes[n-1]))
+		}
+	}
+
+	if err := cv.popOperands(labelTypes[:n-1]); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+	cv.pushOperands(labelTypes[:n-1])
+
+	return nil
+}
+
+func (cv *codeValidator) validateBrOnNull(instr types.Instruction) error {
+	l := instr.Args.(uint32)
+	f, err := cv.getControlFrame(int(l))
+	if err != nil {
+		return fmt.Errorf("unknown label %d: %w", l, err)
+	}
+
+	t, err := cv.popRef()
+	if err != nil {
+		return fmt.Errorf("pop reference: %w", err)
+	}
+
+	if err := cv.popOperands(f.LabelTypes()); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+	cv.pushOperands(f.LabelTypes())
+	cv.pushNonNull(t)
+
+	return nil
+}
+
+func (cv *codeValidator) validateCallRef(instr types.Instruction) error {
+	typeIdx := instr.Args.(uint32)
+	if int(typeIdx) >= len(cv.moduleValidator.module.Types) {
+		return fmt.Errorf("unknown type: %d", typeIdx)
+	}
+	ft := cv.moduleValidator.module.Types[typeIdx]
+
+	ref := types.NewRefType(true, types.HeapType(typeIdx))
+	if _, err := cv.popTypeSpecificOperand(ref); err != nil {
+		return fmt.Errorf("pop function reference: %w", err)
+	}
+
+	if err := cv.popOperands(ft.ParamTypes); err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+
+	cv.pushOperands(ft.ResultTypes)
+
+	return nil
+}
+
+func (cv *codeValidator) validateRef(instr types.Instruction) error {
+	switch instr.Opcode {
+	case types.OpcodeRefNull:
+		ht := instr.Args.(types.HeapType)
+		if ht >= 0 && int(ht) >= len(cv.moduleValidator.module.Types) {
+			return fmt.Errorf("unknown type: %d", ht)
+		}
+		cv.pushOperand(types.NewRefType(true, ht))
+	case types.OpcodeRefIsNull:
+		if _, err := cv.popRef(); err != nil {
+			return fmt.Errorf("pop reference: %w", err)
+		}
+		cv.pushOperand(types.ValueTypeI32)
+	case types.OpcodeRefFunc:
+		fIdx := instr.Args.(uint32)
+		typeIdx, ok := cv.moduleValidator.getFuncTypeIdx(int(fIdx))
+		if !ok {
+			return fmt.Errorf("unknown function: %d", fIdx)
+		}
+		cv.pushOperand(types.NewRefType(false, types.HeapType(typeIdx)))
+	case types.OpcodeRefEq:
+		operands := []types.ValueType{types.ValueTypeEqRef, types.ValueTypeEqRef}
+		if err := cv.popOperands(operands); err != nil {
+			return fmt.Errorf("pop operands: %w", err)
+		}
+		cv.pushOperand(types.ValueTypeI32)
+	case types.OpcodeRefAsNonNull:
+		t, err := cv.popRef()
+		if err != nil {
+			return fmt.Errorf("pop reference: %w", err)
+		}
+		cv.pushNonNull(t)
+	case types.OpcodeBrOnNull:
+		return cv.validateBrOnNull(instr)
+	case types.OpcodeBrOnNonNull:
+		return cv.validateBrOnNonNull(instr)
+	default:
+	}
+
+	return nil
+}
+
+func (cv *codeValidator) validateReturnCallRef(instr types.Instruction) error {
+	if err := cv.checkTailCallResults(instr); err != nil {
+		return err
+	}
+	if err := cv.validateCallRef(instr); err != nil {
+		return err
+	}
+
+	return cv.unreachable()
+}
@@ -1,8 +1,88 @@
 package validator
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
 
 var (
 	ErrIndexOutOfBound = errors.New("index out of bound")
 	ErrTypeMismatch    = errors.New("type mismatch")
 )
+
+// OperandMismatchError tells operands atop the stack mismatch types expected by an instruction.
+type OperandMismatchError struct {
+	Expected []types.ValueType
+	// Actual are operands atop the stack within the current block, which are fewer than Expected on
+	// stack underflow.
+	Actual []types.ValueType
+}
+
+// ValidationError is an error validating the code of a function, which locates the innermost
+// failing instruction.
+type ValidationError struct {
+	FuncIdx  types.FuncIdx
+	FuncName string // empty if unknown
+	// Path lists opnames of blocks enclosing the failing instruction and then its own, which is empty
+	// if failing outside instructions, e.g. on locals or results of the function.
+	Path []string
+	// Offset is of the failing instruction in the decoded module, or of the function body if Path is
+	// empty.
+	Offset uint32
+	Opcode byte // only valid if Path isn't empty
+	// Expected and Actual are operand types of mismatches, which are both nil otherwise.
+	Expected []types.ValueType
+	Actual   []types.ValueType
+	Err      error
+}
+
+// ValidationErrors collects errors validating codes of all functions.
+type ValidationErrors []*ValidationError
+
+func (e *OperandMismatchError) Error() string {
+	return fmt.Sprintf("expect %s, got %s: %v", stringifyValueTypes(e.Expected),
+		stringifyValueTypes(e.Actual), ErrTypeMismatch)
+}
+
+func (e *OperandMismatchError) Unwrap() error {
+	return ErrTypeMismatch
+}
+
+func (e *ValidationError) Error() string {
+	var names types.NameMap
+	if e.FuncName != "" {
+		names = types.NameMap{e.FuncIdx: e.FuncName}
+	}
+	fn := types.DescribeIdx("func", e.FuncIdx, names)
+
+	if len(e.Path) == 0 {
+		return fmt.Sprintf("%s at 0x%x: %v", fn, e.Offset, e.Err)
+	}
+
+	return fmt.Sprintf("%s at 0x%x(%s): %v", fn, e.Offset, strings.Join(e.Path, " > "), e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Error()
+	}
+
+	return fmt.Sprintf("%d invalid funcs: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func stringifyValueTypes(t []types.ValueType) string {
+	names := make([]string, len(t))
+	for i, v := range t {
+		names[i] = types.StringifyValueType(v)
+	}
+
+	return "[" + strings.Join(names, " ") + "]"
+}
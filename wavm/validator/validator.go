@@ -10,14 +10,25 @@ import (
 type moduleValidator struct {
 	module wavm.Module
 
-	importedFuncs   []types.Import
-	importedTable   *types.Import
-	importedMemory  *types.Import
-	importedGlobals []types.Import
-	globalTypes     []types.GlobalType
+	importedFuncs    []types.Import
+	importedTable    *types.Import
+	importedMemories []types.Import
+	importedGlobals  []types.Import
+	importedTags     []types.Import
+	globalTypes      []types.GlobalType
+
+	collectAll bool // whether to validate codes of all functions rather than stop at the 1st error
+	// heights are those of operand stacks recorded by codes, only if not nil.
+	heights [][]int
 }
 
 func (v *moduleValidator) Validate() error {
+	if err := v.validateFeatures(); err != nil {
+		return fmt.Errorf("bad features: %w", err)
+	}
+	if err := v.validateTypes(); err != nil {
+		return fmt.Errorf("bad types: %w", err)
+	}
 	if err := v.validateImports(); err != nil {
 		return fmt.Errorf("bad imports: %w", err)
 	}
@@ -30,6 +41,9 @@ func (v *moduleValidator) Validate() error {
 	if err := v.validateMemory(); err != nil {
 		return fmt.Errorf("bad memory: %w", err)
 	}
+	if err := v.validateTags(); err != nil {
+		return fmt.Errorf("bad tags: %w", err)
+	}
 	if err := v.validateGlobals(); err != nil {
 		return fmt.Errorf("bad global: %w", err)
 	}
@@ -48,11 +62,35 @@ func (v *moduleValidator) Validate() error {
 	if err := v.validateData(); err != nil {
 		return fmt.Errorf("bad data: %w", err)
 	}
+	if err := v.validateDataCount(); err != nil {
+		return fmt.Errorf("bad data count: %w", err)
+	}
 
 	return nil
 }
 
+// Validate checks m is valid, using no post-MVP features in m.DisabledFeatures. Errors in codes of
+// functions are reported as *ValidationError.
 func Validate(m wavm.Module) error {
 	v := moduleValidator{module: m}
 	return v.Validate()
 }
+
+// ValidateAll works as Validate, but collects errors in codes of all functions as ValidationErrors
+// rather than stop at the 1st one.
+func ValidateAll(m wavm.Module) error {
+	v := moduleValidator{module: m, collectAll: true}
+	return v.Validate()
+}
+
+// StackHeights works as Validate, and returns heights of the operand stack before instructions of
+// each code in the order of tools.WalkInstrs, excluding locals. Heights of unreachable instructions
+// are meaningless.
+func StackHeights(m wavm.Module) ([][]int, error) {
+	v := moduleValidator{module: m, heights: make([][]int, len(m.Codes))}
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+
+	return v.heights, nil
+}
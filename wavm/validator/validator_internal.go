@@ -4,21 +4,52 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/sammyne/mastering-wasm/wavm"
 	"github.com/sammyne/mastering-wasm/wavm/types"
 )
 
+// checkValueType checks t refers to defined types only, if it's a reference type.
+func (v *moduleValidator) checkValueType(t types.ValueType) error {
+	if !types.IsRefType(t) {
+		return nil
+	} else if ht := types.GetHeapType(t); ht >= 0 && int(ht) >= len(v.module.Types) {
+		return fmt.Errorf("unknown type: %d", ht)
+	}
+
+	return nil
+}
+
+// getCompositeType returns the type indexed by idx, which must be a struct or array type as tag
+// tells.
+func (v *moduleValidator) getCompositeType(idx types.TypeIdx, tag byte) (types.FuncType, error) {
+	if int(idx) >= len(v.module.Types) {
+		return types.FuncType{}, fmt.Errorf("unknown type: %d", idx)
+	} else if t := v.module.Types[idx]; t.Tag != tag {
+		return types.FuncType{}, fmt.Errorf("type %d is %s: %w", idx, t, ErrTypeMismatch)
+	}
+
+	return v.module.Types[idx], nil
+}
+
 func (v *moduleValidator) getFuncType(idx int) (types.FuncType, bool) {
+	typeIdx, ok := v.getFuncTypeIdx(idx)
+	if !ok {
+		return types.FuncType{}, false
+	}
+
+	return v.module.Types[typeIdx], true
+}
+
+func (v *moduleValidator) getFuncTypeIdx(idx int) (types.TypeIdx, bool) {
 	switch {
 	case idx < len(v.importedFuncs):
-		fnIdx := v.importedFuncs[idx].Description.Func
-		return v.module.Types[fnIdx], true
+		return v.importedFuncs[idx].Description.Func, true
 	case idx < v.getFuncLen():
-		fnIdx := v.module.Functions[idx-len(v.importedFuncs)]
-		return v.module.Types[fnIdx], true
+		return v.module.Functions[idx-len(v.importedFuncs)], true
 	default:
 	}
 
-	return types.FuncType{}, false
+	return 0, false
 }
 
 func (v *moduleValidator) getFuncLen() int {
@@ -29,13 +60,87 @@ func (v *moduleValidator) getGlobalLen() int {
 	return len(v.importedGlobals) + len(v.module.Globals)
 }
 
+func (v *moduleValidator) getMemory(idx int) (types.Memory, bool) {
+	switch {
+	case idx < len(v.importedMemories):
+		return v.importedMemories[idx].Description.Memory, true
+	case idx < v.getMemoryLen():
+		return v.module.Memories[idx-len(v.importedMemories)], true
+	default:
+	}
+
+	return types.Memory{}, false
+}
+
 func (v *moduleValidator) getMemoryLen() int {
-	ell := len(v.module.Memories)
-	if v.importedMemory != nil {
-		ell++
+	return len(v.importedMemories) + len(v.module.Memories)
+}
+
+// getNewOperands returns types of operands taken by struct.new(_default) and
+// array.new(_default|_fixed), which all produce a non-null reference to arg.TypeIdx.
+func (v *moduleValidator) getNewOperands(arg types.GCArg) ([]types.ValueType, error) {
+	tag := types.TypeTagArray
+	if arg.SubOpcode == types.GCStructNew || arg.SubOpcode == types.GCStructNewDefault {
+		tag = types.TypeTagStruct
 	}
 
-	return ell
+	t, err := v.getCompositeType(arg.TypeIdx, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	switch arg.SubOpcode {
+	case types.GCStructNewDefault, types.GCArrayNewDefault:
+		for i, f := range t.Fields {
+			if !types.IsDefaultable(f.StorageType) {
+				return nil, fmt.Errorf("field %d of type %s isn't defaultable", i,
+					types.StringifyValueType(f.StorageType))
+			}
+		}
+	default:
+	}
+
+	var out []types.ValueType
+	switch arg.SubOpcode {
+	case types.GCStructNew:
+		for _, f := range t.Fields {
+			out = append(out, types.UnpackStorageType(f.StorageType))
+		}
+	case types.GCArrayNew:
+		out = []types.ValueType{types.UnpackStorageType(t.Fields[0].StorageType), types.ValueTypeI32}
+	case types.GCArrayNewDefault:
+		out = []types.ValueType{types.ValueTypeI32}
+	case types.GCArrayNewFixed:
+		out = make([]types.ValueType, arg.Idx)
+		for i := range out {
+			out[i] = types.UnpackStorageType(t.Fields[0].StorageType)
+		}
+	default:
+	}
+
+	return out, nil
+}
+
+func (v *moduleValidator) getTagLen() int {
+	return len(v.importedTags) + len(v.module.Tags)
+}
+
+func (v *moduleValidator) getTagType(idx int) (types.FuncType, bool) {
+	var t types.Tag
+	switch {
+	case idx < len(v.importedTags):
+		t = v.importedTags[idx].Description.TagType
+	case idx < v.getTagLen():
+		t = v.module.Tags[idx-len(v.importedTags)]
+	default:
+		return types.FuncType{}, false
+	}
+
+	if int(t.Type) >= len(v.module.Types) {
+		return types.FuncType{}, false
+	}
+
+	return v.module.Types[t.Type], true
 }
 
 func (v *moduleValidator) getTableLen() int {
@@ -48,10 +153,10 @@ func (v *moduleValidator) getTableLen() int {
 }
 
 func (v *moduleValidator) validateCode(idx int, code types.Code, funcType types.FuncType) error {
-	cv := &codeValidator{
-		moduleValidator: v,
-		Idx:             idx,
-		InstructionPath: make(map[int]string),
+	cv := &codeValidator{moduleValidator: v, Idx: idx}
+	if v.heights != nil {
+		cv.heights = make([]int, 0, len(code.Expr))
+		defer func() { v.heights[idx] = cv.heights }()
 	}
 
 	return cv.validateCode(code, funcType)
@@ -62,67 +167,143 @@ func (v *moduleValidator) validateCodes() error {
 		return fmt.Errorf("#(code)=%d != #(func)=%d", len(v.module.Codes), len(v.module.Functions))
 	}
 
+	var errs ValidationErrors
 	for i, c := range v.module.Codes {
 		fnIdx := v.module.Functions[i]
 		fnType := v.module.Types[fnIdx]
-		if err := v.validateCode(i, c, fnType); err != nil {
-			return fmt.Errorf("validate %d-th code: %w", i, err)
+		err := v.validateCode(i, c, fnType)
+		if err == nil {
+			continue
+		} else if !v.collectAll {
+			return err
 		}
+		errs = append(errs, err.(*ValidationError))
+	}
+
+	if len(errs) > 0 {
+		return errs
 	}
 
 	return nil
 }
 
+// validateConstExpr checks exprs is a constant expression producing a single value of
+// expectedType, where only constants, global.get of immutable globals, ref.null, ref.func, ref.i31, allocations of
+// structs and arrays, and the extended i32/i64 add, sub and mul are allowed.
 func (v *moduleValidator) validateConstExpr(exprs []types.Instruction,
 	expectedType types.ValueType) error {
-	if len(exprs) == 0 {
-		return nil
-	}
+	var stack []types.ValueType
+	for i, instr := range exprs {
+		required := wavm.GetInstructionFeatures(instr)
+		switch instr.Opcode {
+		case types.OpcodeI32Add, types.OpcodeI32Sub, types.OpcodeI32Mul, types.OpcodeI64Add,
+			types.OpcodeI64Sub, types.OpcodeI64Mul:
+			required |= wavm.FeatureExtendedConst
+		default:
+		}
+		if err := v.module.GetFeatures().Require(required, instr.GetOpname()); err != nil {
+			return fmt.Errorf("%d-th instruction: %w", i, err)
+		}
 
-	if len(exprs) > 1 {
-		for i, instr := range exprs {
-			switch instr.Opcode {
-			case types.OpcodeI32Const, types.OpcodeI64Const, types.OpcodeF32Const, types.OpcodeF64Const,
-				types.OpcodeGlobalGet:
-			default:
-				return fmt.Errorf("%d-th instruction has non-constant opcode(%d)", i, instr.Opcode)
+		switch instr.Opcode {
+		case types.OpcodeI32Const:
+			stack = append(stack, types.ValueTypeI32)
+		case types.OpcodeI64Const:
+			stack = append(stack, types.ValueTypeI64)
+		case types.OpcodeF32Const:
+			stack = append(stack, types.ValueTypeF32)
+		case types.OpcodeF64Const:
+			stack = append(stack, types.ValueTypeF64)
+		case types.OpcodeGlobalGet:
+			gIdx := instr.Args.(uint32)
+			if int(gIdx) >= len(v.globalTypes) {
+				return fmt.Errorf("unknown global: %d", gIdx)
+			}
+			if v.globalTypes[gIdx].Mutable == types.MutVar {
+				return fmt.Errorf("%d-th instruction gets mutable global(%d)", i, gIdx)
 			}
+			stack = append(stack, v.globalTypes[gIdx].ValueType)
+		case types.OpcodeRefNull:
+			ht := instr.Args.(types.HeapType)
+			if ht >= 0 && int(ht) >= len(v.module.Types) {
+				return fmt.Errorf("unknown type: %d", ht)
+			}
+			stack = append(stack, types.NewRefType(true, ht))
+		case types.OpcodeRefFunc:
+			typeIdx, ok := v.getFuncTypeIdx(int(instr.Args.(uint32)))
+			if !ok {
+				return fmt.Errorf("unknown function: %d", instr.Args.(uint32))
+			}
+			stack = append(stack, types.NewRefType(false, types.HeapType(typeIdx)))
+		case types.OpcodeGC:
+			if err := v.validateConstGC(&stack, instr.Args.(types.GCArg)); err != nil {
+				return fmt.Errorf("%d-th instruction: %w", i, err)
+			}
+		case types.OpcodeI32Add, types.OpcodeI32Sub, types.OpcodeI32Mul:
+			if err := popConstOperands(&stack, types.ValueTypeI32); err != nil {
+				return fmt.Errorf("%d-th instruction: %w", i, err)
+			}
+			stack = append(stack, types.ValueTypeI32)
+		case types.OpcodeI64Add, types.OpcodeI64Sub, types.OpcodeI64Mul:
+			if err := popConstOperands(&stack, types.ValueTypeI64); err != nil {
+				return fmt.Errorf("%d-th instruction: %w", i, err)
+			}
+			stack = append(stack, types.ValueTypeI64)
+		default:
+			return fmt.Errorf("%d-th instruction has non-constant opcode(%d)", i, instr.Opcode)
+		}
+	}
+
+	if len(stack) != 1 || !types.MatchValueType(v.module.Types, stack[0], expectedType) {
+		return fmt.Errorf("type mismatch: expect [%s], got %v", types.StringifyValueType(expectedType),
+			stack)
+	}
+
+	return nil
+}
+
+func (v *moduleValidator) validateConstGC(stack *[]types.ValueType, arg types.GCArg) error {
+	var (
+		operands []types.ValueType
+		result   = types.NewRefType(false, types.HeapType(arg.TypeIdx))
+		err      error
+	)
+	switch arg.SubOpcode {
+	case types.GCRefI31:
+		operands = []types.ValueType{types.ValueTypeI32}
+		result = types.NewRefType(false, types.HeapTypeI31)
+	case types.GCStructNew, types.GCStructNewDefault, types.GCArrayNew, types.GCArrayNewDefault,
+		types.GCArrayNewFixed:
+		if operands, err = v.getNewOperands(arg); err != nil {
+			return err
 		}
-		return errors.New("type mismatch") // TODO
-	}
-
-	var actualType byte = 0
-	switch exprs[0].Opcode {
-	case types.OpcodeI32Const:
-		actualType = types.ValueTypeI32
-	case types.OpcodeI64Const:
-		actualType = types.ValueTypeI64
-	case types.OpcodeF32Const:
-		actualType = types.ValueTypeF32
-	case types.OpcodeF64Const:
-		actualType = types.ValueTypeF64
-	case types.OpcodeGlobalGet:
-		gIdx := exprs[0].Args.(uint32)
-		if int(gIdx) >= len(v.globalTypes) {
-			return fmt.Errorf("unknown global: %d", gIdx)
-		}
-		actualType = v.globalTypes[gIdx].ValueType
 	default:
-		return errors.New("constant expression required")
+		name, _ := types.GetGCOpname(arg.SubOpcode)
+		return fmt.Errorf("non-constant %s", name)
 	}
-	if actualType != expectedType {
-		return errors.New("type mismatch") // TODO
+
+	n := len(*stack) - len(operands)
+	if n < 0 {
+		return errors.New("missing operands")
+	} else if !types.MatchValueTypes(v.module.Types, (*stack)[n:], operands) {
+		return errors.New("type mismatch")
 	}
 
+	*stack = append((*stack)[:n], result)
 	return nil
 }
 
 func (v *moduleValidator) validateData() error {
 	for i, data := range v.module.Data {
-		if int(data.MemoryIdx) >= v.getMemoryLen() {
+		if data.Passive {
+			continue
+		}
+
+		mem, ok := v.getMemory(int(data.MemoryIdx))
+		if !ok {
 			return fmt.Errorf("data[%d]: unknown memory: %d", i, data.MemoryIdx)
 		}
-		if err := v.validateConstExpr(data.Offset, types.ValueTypeI32); err != nil {
+		if err := v.validateConstExpr(data.Offset, mem.AddressType()); err != nil {
 			return fmt.Errorf("data[%d] has invalid const expr: %w", i, err)
 		}
 	}
@@ -130,6 +311,14 @@ func (v *moduleValidator) validateData() error {
 	return nil
 }
 
+func (v *moduleValidator) validateDataCount() error {
+	if n := v.module.DataCount; n != nil && int(*n) != len(v.module.Data) {
+		return fmt.Errorf("data count(%d) != #(data)=%d", *n, len(v.module.Data))
+	}
+
+	return nil
+}
+
 func (v *moduleValidator) validateElements() error {
 	for i, elem := range v.module.Elements {
 		if int(elem.TableIdx) >= v.getTableLen() {
@@ -173,6 +362,117 @@ func (v *moduleValidator) validateExports() error {
 			if int(w.Description.Idx) >= v.getGlobalLen() {
 				return fmt.Errorf("export[%d] refs unknown global %d", i, w.Description.Idx)
 			}
+		case types.PortTagTag:
+			if int(w.Description.Idx) >= v.getTagLen() {
+				return fmt.Errorf("export[%d] refs unknown tag %d", i, w.Description.Idx)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateFeatures checks the module uses no post-MVP features disabled, except those of
+// instructions, which are checked along with code.
+func (v *moduleValidator) validateFeatures() error {
+	features := v.module.GetFeatures()
+
+	for i, t := range v.module.Types {
+		if err := features.Require(wavm.GetTypeFeatures(t), fmt.Sprintf("type[%d]", i)); err != nil {
+			return err
+		}
+	}
+
+	var globalTypes []types.GlobalType
+	nMemories := len(v.module.Memories)
+	for i, vv := range v.module.Imports {
+		var required wavm.Features
+		switch vv.Description.Tag {
+		case types.PortTagMemory:
+			required = wavm.GetLimitsFeatures(vv.Description.Memory)
+			nMemories++
+		case types.PortTagGlobal:
+			globalTypes = append(globalTypes, vv.Description.Global)
+			required = wavm.GetValueTypeFeatures(vv.Description.Global.ValueType)
+			if vv.Description.Global.Mutable == types.MutVar {
+				required |= wavm.FeatureMutableGlobals
+			}
+		case types.PortTagTag:
+			required = wavm.FeatureExceptionHandling
+		default:
+		}
+		if err := features.Require(required, fmt.Sprintf("import[%d]", i)); err != nil {
+			return err
+		}
+	}
+
+	if nMemories > 1 {
+		if err := features.Require(wavm.FeatureMultiMemory, "multiple memories"); err != nil {
+			return err
+		}
+	}
+	for i, m := range v.module.Memories {
+		if err := features.Require(wavm.GetLimitsFeatures(m), fmt.Sprintf("memory[%d]", i)); err != nil {
+			return err
+		}
+	}
+
+	if len(v.module.Tags) > 0 {
+		if err := features.Require(wavm.FeatureExceptionHandling, "tags"); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range v.module.Globals {
+		what := v.module.Names.DescribeGlobal(uint32(len(globalTypes)))
+		globalTypes = append(globalTypes, g.Type)
+		if err := features.Require(wavm.GetValueTypeFeatures(g.Type.ValueType), what); err != nil {
+			return err
+		}
+	}
+
+	for i, e := range v.module.Exports {
+		var required wavm.Features
+		switch e.Description.Tag {
+		case types.PortTagGlobal:
+			idx := int(e.Description.Idx)
+			if idx < len(globalTypes) && globalTypes[idx].Mutable == types.MutVar {
+				required = wavm.FeatureMutableGlobals
+			}
+		case types.PortTagTag:
+			required = wavm.FeatureExceptionHandling
+		default:
+		}
+		if err := features.Require(required, fmt.Sprintf("export[%d]", i)); err != nil {
+			return err
+		}
+	}
+
+	if v.module.DataCount != nil {
+		if err := features.Require(wavm.FeatureBulkMemory, "data count"); err != nil {
+			return err
+		}
+	}
+	for i, d := range v.module.Data {
+		var required wavm.Features
+		switch {
+		case d.Passive:
+			required = wavm.FeatureBulkMemory
+		case d.MemoryIdx != 0:
+			required = wavm.FeatureMultiMemory
+		default:
+		}
+		if err := features.Require(required, fmt.Sprintf("data[%d]", i)); err != nil {
+			return err
+		}
+	}
+
+	for i, c := range v.module.Codes {
+		for _, l := range c.Locals {
+			required := wavm.GetValueTypeFeatures(l.Type)
+			if err := features.Require(required, fmt.Sprintf("locals of code[%d]", i)); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -194,7 +494,8 @@ func (v *moduleValidator) validateGlobals() error {
 	importedGlobalsLen := len(v.importedGlobals)
 	for i, g := range v.module.Globals {
 		if err := v.validateConstExpr(g.Init, g.Type.ValueType); err != nil {
-			return fmt.Errorf("global[%d]: %w", i+importedGlobalsLen, err)
+			idx := uint32(i + importedGlobalsLen)
+			return fmt.Errorf("%s: %w", v.module.Names.DescribeGlobal(idx), err)
 		}
 		v.globalTypes = append(v.globalTypes, g.Type)
 	}
@@ -219,16 +520,18 @@ func (v *moduleValidator) validateImports() error {
 			}
 			v.importedTable = &v.module.Imports[i]
 		case types.PortTagMemory:
-			if v.importedMemory != nil {
-				return fmt.Errorf("multiple memories")
-			}
 			if err := validateMemoryLimits(vv.Description.Memory); err != nil {
 				return fmt.Errorf("bad memory limits for import[%d]: %s", i, err)
 			}
-			v.importedMemory = &v.module.Imports[i]
+			v.importedMemories = append(v.importedMemories, vv)
 		case types.PortTagGlobal:
 			v.importedGlobals = append(v.importedGlobals, vv)
 			v.globalTypes = append(v.globalTypes, vv.Description.Global)
+		case types.PortTagTag:
+			if err := v.validateTag(vv.Description.TagType); err != nil {
+				return fmt.Errorf("import[%d]: %w", i, err)
+			}
+			v.importedTags = append(v.importedTags, vv)
 		}
 	}
 
@@ -236,17 +539,13 @@ func (v *moduleValidator) validateImports() error {
 }
 
 func (v *moduleValidator) validateMemory() error {
-	memLen := len(v.module.Memories)
-	if v.importedMemory != nil {
-		memLen++
-	}
-	if memLen == 0 {
-		return nil
-	} else if memLen > 1 {
-		return errors.New("multiple memory sections")
+	for i, limits := range v.module.Memories {
+		if err := validateMemoryLimits(limits); err != nil {
+			return fmt.Errorf("memory[%d]: %w", i+len(v.importedMemories), err)
+		}
 	}
 
-	return validateMemoryLimits(v.module.Memories[0])
+	return nil
 }
 
 func (v *moduleValidator) validateStart() error {
@@ -283,13 +582,122 @@ func (v *moduleValidator) validateTable() error {
 	return nil
 }
 
+func (v *moduleValidator) validateTag(t types.Tag) error {
+	if int(t.Type) >= len(v.module.Types) {
+		return fmt.Errorf("unknown type: %d", t.Type)
+	} else if n := len(v.module.Types[t.Type].ResultTypes); n != 0 {
+		return fmt.Errorf("tag type returns %d results", n)
+	}
+
+	return nil
+}
+
+func (v *moduleValidator) validateTags() error {
+	for i, t := range v.module.Tags {
+		if err := v.validateTag(t); err != nil {
+			return fmt.Errorf("tag[%d]: %w", i+len(v.importedTags), err)
+		}
+	}
+
+	return nil
+}
+
+// validateType checks the idx-th type refers to defined types only, and matches its super type
+// if any, which must be declared earlier and not final.
+func (v *moduleValidator) validateType(idx int, t types.FuncType) error {
+	valueTypes := append(append([]types.ValueType{}, t.ParamTypes...), t.ResultTypes...)
+	for _, f := range t.Fields {
+		valueTypes = append(valueTypes, f.StorageType)
+	}
+	for _, vt := range valueTypes {
+		if err := v.checkValueType(vt); err != nil {
+			return err
+		}
+	}
+
+	if len(t.SuperTypes) == 0 {
+		return nil
+	} else if len(t.SuperTypes) > 1 {
+		return fmt.Errorf("%d super types", len(t.SuperTypes))
+	}
+
+	superIdx := t.SuperTypes[0]
+	if int(superIdx) >= idx {
+		return fmt.Errorf("super type %d isn't declared before", superIdx)
+	}
+
+	super, defined := v.module.Types[superIdx], v.module.Types
+	switch {
+	case super.Final:
+		return fmt.Errorf("super type %d is final", superIdx)
+	case super.Tag != t.Tag:
+		return fmt.Errorf("super type %s: %w", super, ErrTypeMismatch)
+	case !types.MatchValueTypes(defined, super.ParamTypes, t.ParamTypes),
+		!types.MatchValueTypes(defined, t.ResultTypes, super.ResultTypes):
+		return fmt.Errorf("super type %s: %w", super, ErrTypeMismatch)
+	case len(t.Fields) < len(super.Fields):
+		return fmt.Errorf("super type %s has more fields", super)
+	default:
+	}
+
+	for i, f := range super.Fields {
+		if !matchFieldType(defined, t.Fields[i], f) {
+			return fmt.Errorf("field %d mismatches super type %s: %w", i, super, ErrTypeMismatch)
+		}
+	}
+
+	return nil
+}
+
+func (v *moduleValidator) validateTypes() error {
+	for i, t := range v.module.Types {
+		if err := v.validateType(i, t); err != nil {
+			return fmt.Errorf("type[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// matchFieldType tells whether field got can be used as expected, where mutable fields must be
+// of the same type since they are written as well as read.
+func matchFieldType(defined []types.FuncType, got, expected types.FieldType) bool {
+	if got.Mutable != expected.Mutable {
+		return false
+	} else if got.Mutable == types.MutVar {
+		return got.StorageType == expected.StorageType
+	}
+
+	return types.MatchValueType(defined, got.StorageType, expected.StorageType)
+}
+
+// popConstOperands pops both operands of a binary operator typed t from stack.
+func popConstOperands(stack *[]types.ValueType, t types.ValueType) error {
+	n := len(*stack)
+	if n < 2 {
+		return errors.New("missing operands")
+	} else if (*stack)[n-2] != t || (*stack)[n-1] != t {
+		return errors.New("type mismatch")
+	}
+
+	*stack = (*stack)[:n-2]
+	return nil
+}
+
 func validateMemoryLimits(limits types.Limits) error {
-	const maxLen = 1 << 16
+	maxLen := uint64(types.MaxPageCount)
+	if limits.Is64() {
+		maxLen = types.MaxPageCount64
+	}
 
 	switch {
 	case limits.Min > maxLen:
 		return fmt.Errorf("min(%d) oversizes", limits.Min)
-	case limits.Tag == 0:
+	case limits.Shared() && !limits.HasMax():
+		return errors.New("shared memory must have maximum")
+	case !limits.HasMax():
+	case limits.Max > maxLen:
+		return fmt.Errorf("max(%d) oversizes", limits.Max)
 	case limits.Min > limits.Max:
 		return fmt.Errorf("wrong limit range(%d, %d)", limits.Min, limits.Max)
 	default:
@@ -299,7 +707,11 @@ func validateMemoryLimits(limits types.Limits) error {
 }
 
 func validateTableLimits(limits types.Limits) error {
-	if limits.Tag == 0 {
+	if limits.Shared() {
+		return errors.New("tables can't be shared")
+	} else if limits.Is64() {
+		return errors.New("tables can't be indexed by i64")
+	} else if !limits.HasMax() {
 		return nil
 	}
 
@@ -0,0 +1,102 @@
+package validator_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/validator"
+)
+
+func TestValidationErrors(t *testing.T) {
+	m := decodeTestModule(t,
+		// i32.eqz of an i64 in a loop in a block
+		[]byte{0x02, 0x40, 0x03, 0x40, 0x42, 1, 0x45, 0x1A, 0x0B, 0x0B, 0x41, 0},
+		// call of an unknown func
+		[]byte{0x10, 9},
+		// no result
+		nil,
+	)
+
+	i32, i64 := types.ValueTypeI32, types.ValueTypeI64
+	expect := []validator.ValidationError{
+		{FuncIdx: 0, FuncName: "f0", Path: []string{"block", "loop", "i32.eqz"}, Offset: 0x20,
+			Opcode: types.OpcodeI32Eqz, Expected: []types.ValueType{i32},
+			Actual: []types.ValueType{i64}},
+		{FuncIdx: 1, FuncName: "f1", Path: []string{"call"}, Offset: 0x29,
+			Opcode: types.OpcodeCall},
+		// located at the body, since the results mismatch at its end
+		{FuncIdx: 2, FuncName: "f2", Offset: 0x2C, Expected: []types.ValueType{i32}},
+	}
+
+	var errs validator.ValidationErrors
+	if err := validator.ValidateAll(*m); !errors.As(err, &errs) {
+		t.Fatalf("expect ValidationErrors, got %v", err)
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("expect %d errors, got %d: %v", len(expect), len(errs), errs)
+	}
+	for i, c := range expect {
+		got := *errs[i]
+		got.Err = nil
+		if !reflect.DeepEqual(c, got) {
+			t.Fatalf("#%d: expect %+v, got %+v", i, c, got)
+		}
+	}
+	if !errors.Is(errs[0], validator.ErrTypeMismatch) || !errors.Is(errs[2], validator.ErrTypeMismatch) {
+		t.Fatalf("expect mismatches to be %v, got %v", validator.ErrTypeMismatch, errs)
+	}
+	if msg := errs[0].Error(); !strings.HasPrefix(msg, "func[0] <f0> at 0x20(block > loop > i32.eqz):") {
+		t.Fatalf("bad message: %s", msg)
+	}
+
+	// Validate stops at the 1st
+	var err *validator.ValidationError
+	if !errors.As(validator.Validate(*m), &err) || err.FuncIdx != 0 {
+		t.Fatalf("expect error of func[0], got %v", err)
+	}
+}
+
+func TestStackHeights(t *testing.T) {
+	// if (result i32) of i32.const 1 with branches of 2, and 3+4
+	m := decodeTestModule(t,
+		[]byte{0x41, 1, 0x04, 0x7F, 0x41, 2, 0x05, 0x41, 3, 0x41, 4, 0x6A, 0x0B})
+
+	heights, err := validator.StackHeights(*m)
+	if err != nil {
+		t.Fatalf("stack heights: %v", err)
+	}
+	if expect := [][]int{{0, 1, 0, 0, 1, 2}}; !reflect.DeepEqual(expect, heights) {
+		t.Fatalf("expect heights %v, got %v", expect, heights)
+	}
+}
+
+// decodeTestModule decodes a module of funcs of ()->(i32) with bodies, which are named by the
+// name section as f0, f1...
+func decodeTestModule(t *testing.T, bodies ...[]byte) *wavm.Module {
+	var funcs, codes, names [][]byte
+	for i, v := range bodies {
+		funcs = append(funcs, []byte{0})
+		codes = append(codes, wasmtest.Code(nil, v...))
+		names = append(names, append(wasmtest.Uleb(uint64(i)), wasmtest.Name(fmt.Sprintf("f%d", i))...))
+	}
+
+	buf := wasmtest.Module(
+		wasmtest.Section(types.SectionIDType, wasmtest.Vec(wasmtest.FuncType(nil, []byte{0x7F}))),
+		wasmtest.Section(types.SectionIDFunc, wasmtest.Vec(funcs...)),
+		wasmtest.Section(types.SectionIDCode, wasmtest.Vec(codes...)),
+		wasmtest.CustomSection(types.CustomNameSection,
+			wasmtest.Section(types.NameSubsectionIDFunc, wasmtest.Vec(names...))),
+	)
+
+	out, err := wavm.NewDecoder(buf).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return out
+}
@@ -0,0 +1,593 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// bcOpcode is an opcode of bytecode, whose operands are on the operand stack as instructions.
+type bcOpcode byte
+
+const (
+	bcNop          bcOpcode = iota // marks block and loop for hooks, or reinterprets operands
+	bcFallback                     // runs by the instruction table
+	bcFallbackCall                 // runs by the instruction table, which may switch call frames
+	bcJump                         // jumps to target.pc
+	bcBr                           // branches to target
+	bcBrIf
+	bcBrUnless // jumps to target.pc on false, for if
+	bcBrTable  // branches to table[i], where the default is the last
+	bcBrOnNull
+	bcBrOnNonNull
+	bcBrOnCast // br_on_cast and br_on_cast_fail by args
+	bcReturn
+	bcCall // calls the imm-th function
+	bcDrop
+	bcSelect
+	bcLocalGet // of the imm-th local
+	bcLocalSet
+	bcLocalTee
+	bcGlobalGet // of the imm-th global
+	bcConst     // pushes imm
+	bcI32Eqz
+	bcI32Eq
+	bcI32Ne
+	bcI32LtS
+	bcI32LtU
+	bcI32GtS
+	bcI32GtU
+	bcI32LeS
+	bcI32LeU
+	bcI32GeS
+	bcI32GeU
+	bcI64Eqz
+	bcI64Eq
+	bcI64Ne
+	bcI64LtS
+	bcI64LtU
+	bcI64GtS
+	bcI64GtU
+	bcI64LeS
+	bcI64LeU
+	bcI64GeS
+	bcI64GeU
+	bcI32Add
+	bcI32Sub
+	bcI32Mul
+	bcI32And
+	bcI32Or
+	bcI32Xor
+	bcI32Shl
+	bcI32ShrS
+	bcI32ShrU
+	bcI64Add
+	bcI64Sub
+	bcI64Mul
+	bcI64And
+	bcI64Or
+	bcI64Xor
+	bcI64Shl
+	bcI64ShrS
+	bcI64ShrU
+	bcI32WrapI64
+	bcI64ExtendI32S
+	bcI64ExtendI32U
+	bcI32Clz
+	bcI32Ctz
+	bcI32PopCnt
+	bcI64Clz
+	bcI64Ctz
+	bcI64PopCnt
+	bcI32Extend8S
+	bcI32Extend16S
+	bcI64Extend8S
+	bcI64Extend16S
+	bcI64Extend32S
+	bcF32Abs
+	bcF32Neg
+	bcF32Ceil
+	bcF32Floor
+	bcF32Trunc
+	bcF32Nearest
+	bcF32Sqrt
+	bcF64Abs
+	bcF64Neg
+	bcF64Ceil
+	bcF64Floor
+	bcF64Trunc
+	bcF64Nearest
+	bcF64Sqrt
+	bcI32TruncF32S
+	bcI32TruncF32U
+	bcI32TruncF64S
+	bcI32TruncF64U
+	bcI64TruncF32S
+	bcI64TruncF32U
+	bcI64TruncF64S
+	bcI64TruncF64U
+	bcI32TruncSatF32S
+	bcI32TruncSatF32U
+	bcI32TruncSatF64S
+	bcI32TruncSatF64U
+	bcI64TruncSatF32S
+	bcI64TruncSatF32U
+	bcI64TruncSatF64S
+	bcI64TruncSatF64U
+	bcF32ConvertI32S
+	bcF32ConvertI32U
+	bcF32ConvertI64S
+	bcF32ConvertI64U
+	bcF32DemoteF64
+	bcF64ConvertI32S
+	bcF64ConvertI32U
+	bcF64ConvertI64S
+	bcF64ConvertI64U
+	bcF64PromoteF32 // the last unary numeric bytecode
+	bcI32Rotl
+	bcI32Rotr
+	bcI64Rotl
+	bcI64Rotr
+	bcF32Eq
+	bcF32Ne
+	bcF32Lt
+	bcF32Gt
+	bcF32Le
+	bcF32Ge
+	bcF64Eq
+	bcF64Ne
+	bcF64Lt
+	bcF64Gt
+	bcF64Le
+	bcF64Ge
+	bcF32Add
+	bcF32Sub
+	bcF32Mul
+	bcF32Div
+	bcF32Min
+	bcF32Max
+	bcF32CopySign
+	bcF64Add
+	bcF64Sub
+	bcF64Mul
+	bcF64Div
+	bcF64Min
+	bcF64Max
+	bcF64CopySign // the last binary numeric bytecode
+	bcI32DivS     // division and remainder, which may trap
+	bcI32DivU
+	bcI32RemS
+	bcI32RemU
+	bcI64DivS
+	bcI64DivU
+	bcI64RemS
+	bcI64RemU
+	bcI32Load // loads from the address plus imm in the mem-th memory, as do loads below
+	bcI64Load
+	bcI32Load8S
+	bcI32Load8U
+	bcI32Load16S
+	bcI32Load16U
+	bcI64Load8S
+	bcI64Load8U
+	bcI64Load16S
+	bcI64Load16U
+	bcI64Load32S
+	bcI64Load32U
+	bcI32Store // stores to the address plus imm in the mem-th memory, as do stores below
+	bcI64Store
+	bcI32Store8
+	bcI32Store16
+	bcI64Store8
+	bcI64Store16
+	bcI64Store32
+	bcGlobalSet  // of the imm-th global
+	bcMemorySize // of the mem-th memory
+	bcMemoryGrow
+)
+
+// bcFunc is bytecode of a function, whose call frames keep locals at BP followed by operands as
+// those of the tree engine.
+type bcFunc struct {
+	code     []bcInstr
+	nLocals  int // including params
+	nResults int
+}
+
+// bcInstr is an instruction of bytecode.
+type bcInstr struct {
+	op     bcOpcode
+	imm    uint64
+	mem    uint32 // index of the memory accessed
+	target bcTarget
+	table  []bcTarget
+	run    RunInstructionFunc // for fallbacks
+	args   interface{}        // for fallbacks and br_on_cast
+	// instr is the instruction compiled, or nil for ones implied by ends of blocks and functions,
+	// which are never hooked.
+	instr *types.Instruction
+}
+
+// bcTarget is where a branch goes, which keeps arity operands atop and drops others down to
+// height above locals.
+type bcTarget struct {
+	pc     int
+	height int
+	arity  int
+}
+
+// offset returns the offset of the instruction compiled, or 0 for implied ones.
+func (in *bcInstr) offset() uint32 {
+	if in.instr != nil {
+		return in.instr.Offset
+	}
+
+	return 0
+}
+
+// branch moves operands kept by t down to its height above base, which is the bottom of operands
+// above locals, then returns the operand stack left.
+func branch(s []uint64, base int, t *bcTarget) []uint64 {
+	if dst, src := base+t.height, len(s)-t.arity; dst != src {
+		copy(s[dst:], s[src:])
+		return s[:dst+t.arity]
+	}
+
+	return s
+}
+
+// enterBytecode pushes the call frame of f compiled to bytecode, whose args are atop the operand
+// stack. The frame leaves BlockType empty, which is never used by bytecode.
+func (vm *VM) enterBytecode(f *Func) {
+	bp := vm.OperandStack.Len() - len(f.type_.ParamTypes)
+	vm.ControlStack.Push(ControlFrame{Opcode: types.OpcodeCall, BP: bp, FuncIdx: f.idx,
+		bytecode: f.bytecode})
+
+	for i := len(f.type_.ParamTypes); i < f.bytecode.nLocals; i++ {
+		vm.OperandStack.slots = append(vm.OperandStack.slots, 0)
+	}
+	vm.local0Idx = uint32(bp)
+}
+
+// exitBytecode pops the call frame of bytecode atop the control stack, leaving its results on the
+// operand stack.
+func (vm *VM) exitBytecode() {
+	frames := vm.ControlStack.frames
+	f := &frames[len(frames)-1]
+
+	s, n := vm.OperandStack.slots, f.bytecode.nResults
+	copy(s[f.BP:], s[len(s)-n:])
+	vm.OperandStack.slots = s[:f.BP+n]
+	vm.ControlStack.frames = frames[:len(frames)-1]
+
+	// frames of bytecode are all call frames
+	if caller := len(frames) - 2; caller >= 0 && frames[caller].bytecode != nil {
+		vm.local0Idx = uint32(frames[caller].BP)
+	} else if caller, _, ok := vm.TopCallFrame(); ok {
+		vm.local0Idx = uint32(caller.BP)
+	}
+}
+
+// hook runs debuggers, tracers, profilers and coverage before executing instr.
+func (vm *VM) hook(instr types.Instruction) error {
+	if vm.debugger != nil {
+		if err := vm.debugger.hook(instr); err != nil {
+			return err
+		}
+	}
+	if vm.tracer != nil {
+		vm.tracer.before(instr)
+	}
+	if vm.profiler != nil {
+		vm.profiler.before(instr)
+	}
+	if vm.coverage != nil {
+		vm.coverage.before(instr)
+	}
+
+	return nil
+}
+
+func (vm *VM) isHooked() bool {
+	return vm.debugger != nil || vm.tracer != nil || vm.profiler != nil || vm.coverage != nil
+}
+
+// runBytecode runs call frames of bytecode atop the control stack for the loop started at depth,
+// until one of the tree engine gets atop or all frames of the loop are done. The operand stack is
+// kept locally, and synced with the VM before instructions which may observe it, while hooks
+// attached meanwhile take effect from the next call or return.
+func (vm *VM) runBytecode(depth int) error {
+	frame, _ := vm.ControlStack.Top()
+	code, pc, bp := frame.bytecode.code, frame.PC, frame.BP
+	base := bp + frame.bytecode.nLocals
+	s := vm.OperandStack.slots
+
+	hooked := vm.isHooked()
+	var after bool // whether the tracer awaits the previous instruction to finish
+	for {
+		in := &code[pc]
+		pc++
+
+		if hooked {
+			vm.OperandStack.slots = s
+			if after && vm.tracer != nil {
+				vm.tracer.after()
+			}
+			if after = in.instr != nil; after {
+				frame.PC = pc
+				if err := vm.hook(*in.instr); err != nil {
+					return vm.trap(depth, err)
+				}
+			}
+		}
+
+		n := len(s)
+		var err error
+		switch in.op {
+		case bcNop:
+			continue
+		case bcJump:
+			pc = in.target.pc
+			continue
+		case bcBr:
+			s, pc = branch(s, base, &in.target), in.target.pc
+			continue
+		case bcBrIf:
+			if s = s[:n-1]; s[:n][n-1] != 0 {
+				s, pc = branch(s, base, &in.target), in.target.pc
+			}
+			continue
+		case bcBrUnless:
+			if s = s[:n-1]; s[:n][n-1] == 0 {
+				pc = in.target.pc
+			}
+			continue
+		case bcBrTable:
+			s = s[:n-1]
+			t := &in.table[len(in.table)-1]
+			if i := uint32(s[:n][n-1]); i < uint32(len(in.table)-1) {
+				t = &in.table[i]
+			}
+			s, pc = branch(s, base, t), t.pc
+			continue
+		case bcBrOnNull:
+			if s[n-1] == 0 {
+				s, pc = branch(s[:n-1], base, &in.target), in.target.pc
+			}
+			continue
+		case bcBrOnNonNull:
+			if s[n-1] == 0 {
+				s = s[:n-1]
+			} else {
+				s, pc = branch(s, base, &in.target), in.target.pc
+			}
+			continue
+		case bcDrop:
+			s = s[:n-1]
+			continue
+		case bcSelect:
+			if s[n-1] == 0 {
+				s[n-3] = s[n-2]
+			}
+			s = s[:n-2]
+			continue
+		case bcLocalGet:
+			s = append(s, s[bp+int(in.imm)])
+			continue
+		case bcLocalSet:
+			s[bp+int(in.imm)] = s[n-1]
+			s = s[:n-1]
+			continue
+		case bcLocalTee:
+			s[bp+int(in.imm)] = s[n-1]
+			continue
+		case bcConst:
+			s = append(s, in.imm)
+			continue
+		case bcGlobalGet:
+			s = append(s, vm.globals[in.imm].GetAsUint64())
+			continue
+		case bcI32Eqz:
+			s[n-1] = boolToUint64(uint32(s[n-1]) == 0)
+			continue
+		case bcI64Eqz:
+			s[n-1] = boolToUint64(s[n-1] == 0)
+			continue
+		case bcI32WrapI64, bcI64ExtendI32U:
+			s[n-1] = uint64(uint32(s[n-1]))
+			continue
+		case bcI64ExtendI32S:
+			s[n-1] = uint64(int64(int32(s[n-1])))
+			continue
+		case bcFallback, bcFallbackCall, bcBrOnCast, bcReturn, bcCall:
+		default:
+			if in.op < bcI32DivS && !in.op.unary() {
+				s[n-2] = runBinaryBytecode(in.op, s[n-2], s[n-1])
+				s = s[:n-1]
+				continue
+			}
+			// traps are raised below
+			if s, err = vm.runTypedBytecode(in, s); err == nil {
+				continue
+			}
+		}
+
+		// instructions below may observe the VM, or switch call frames
+		frame.PC = pc
+		vm.OperandStack.slots = s
+
+		switch in.op {
+		case bcFallback:
+			err = in.run(vm, in.args)
+		case bcBrOnCast:
+			arg := in.args.(types.GCArg)
+			if matchRef(vm, s[n-1], arg.DstType) == (arg.SubOpcode == types.GCBrOnCast) {
+				vm.OperandStack.slots, pc = branch(s, base, &in.target), in.target.pc
+			}
+		case bcFallbackCall:
+			err = in.run(vm, in.args)
+		case bcCall:
+			if f := &vm.funcs[in.imm]; f.bytecode != nil {
+				vm.enterBytecode(f)
+			} else {
+				err = callFunc(vm, *f)
+			}
+		case bcReturn:
+			vm.exitBytecode()
+		}
+		s = vm.OperandStack.slots
+
+		if err != nil {
+			var exn *linker.Exception
+			if !errors.As(err, &exn) {
+				err = fmt.Errorf("exec instruction of PC(%d) at 0x%x in %s: %w", pc-1,
+					in.offset(), vm.describeFunc(), err)
+				return vm.trap(depth, err)
+			}
+			// no frame of bytecode catches, so let the loop go on with the catching one if any
+			return vm.catchException(exn, depth)
+		}
+
+		switch in.op {
+		case bcFallbackCall, bcCall, bcReturn:
+		default:
+			continue
+		}
+
+		if after && vm.tracer != nil {
+			vm.tracer.after()
+		}
+		after = false
+
+		if vm.ControlStack.Len() < depth {
+			return nil
+		}
+		if frame, _ = vm.ControlStack.Top(); frame.bytecode == nil {
+			return nil
+		}
+		code, pc, bp = frame.bytecode.code, frame.PC, frame.BP
+		base = bp + frame.bytecode.nLocals
+		hooked = vm.isHooked()
+	}
+}
+
+// runTypedBytecode runs numeric, memory or global bytecode in, which is bcI32Eqz or after, on
+// operands s, returning those left.
+func (vm *VM) runTypedBytecode(in *bcInstr, s []uint64) ([]uint64, error) {
+	n := len(s)
+	switch op := in.op; {
+	case op.unary():
+		s[n-1] = runUnaryBytecode(op, s[n-1])
+	case op < bcI32DivS:
+		s[n-2] = runBinaryBytecode(op, s[n-2], s[n-1])
+		return s[:n-1], nil
+	case op <= bcI64RemU:
+		v, err := runDivBytecode(op, s[n-2], s[n-1])
+		if err != nil {
+			return s, err
+		}
+		s[n-2] = v
+		return s[:n-1], nil
+	case op <= bcI64Load32U:
+		v, err := vm.loadBytecode(op, in.mem, in.imm, s[n-1])
+		if err != nil {
+			return s, err
+		}
+		s[n-1] = v
+	case op <= bcI64Store32:
+		return s[:n-2], vm.storeBytecode(op, in.mem, in.imm, s[n-2], s[n-1])
+	case op == bcGlobalSet:
+		return s[:n-1], vm.globals[in.imm].SetAsUint64(s[n-1])
+	case op == bcMemorySize:
+		mem := vm.memories[in.mem]
+		return append(s, toAddress(mem, mem.Size())), nil
+	default: // bcMemoryGrow
+		mem := vm.memories[in.mem]
+		s[n-1] = toAddress(mem, mem.Grow(toAddress(mem, s[n-1])))
+	}
+
+	return s, nil
+}
+
+// loadBytecode runs the load op from the address addr plus offset in the memIdx-th memory.
+func (vm *VM) loadBytecode(op bcOpcode, memIdx uint32, offset, addr uint64) (uint64, error) {
+	mem := vm.memories[memIdx]
+	offset, err := effectiveAddress(mem, offset, addr)
+	if err != nil {
+		return 0, err
+	}
+
+	var buf [8]byte
+	if err := mem.Read(offset, buf[:op.accessSize()]); err != nil {
+		return 0, fmt.Errorf("read memory: %w", err)
+	}
+
+	v := byteOrder.Uint64(buf[:])
+	switch op {
+	case bcI32Load8S:
+		return uint64(uint32(int32(int8(v)))), nil
+	case bcI32Load16S:
+		return uint64(uint32(int32(int16(v)))), nil
+	case bcI64Load8S:
+		return uint64(int64(int8(v))), nil
+	case bcI64Load16S:
+		return uint64(int64(int16(v))), nil
+	case bcI64Load32S:
+		return uint64(int64(int32(v))), nil
+	default:
+		return v, nil
+	}
+}
+
+// storeBytecode runs the store op of v to the address addr plus offset in the memIdx-th memory.
+func (vm *VM) storeBytecode(op bcOpcode, memIdx uint32, offset, addr, v uint64) error {
+	mem := vm.memories[memIdx]
+	offset, err := effectiveAddress(mem, offset, addr)
+	if err != nil {
+		return err
+	}
+
+	var buf [8]byte
+	byteOrder.PutUint64(buf[:], v)
+	if err := mem.Write(offset, buf[:op.accessSize()]); err != nil {
+		return fmt.Errorf("write memory: %w", err)
+	}
+
+	return nil
+}
+
+// accessSize returns the bytes accessed by the load or store op.
+func (op bcOpcode) accessSize() int {
+	switch op {
+	case bcI32Load8S, bcI32Load8U, bcI64Load8S, bcI64Load8U, bcI32Store8, bcI64Store8:
+		return 1
+	case bcI32Load16S, bcI32Load16U, bcI64Load16S, bcI64Load16U, bcI32Store16, bcI64Store16:
+		return 2
+	case bcI32Load, bcI64Load32S, bcI64Load32U, bcI32Store, bcI64Store32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// effectiveAddress adds offset to the operand addr of mem as getOffset does.
+func effectiveAddress(mem linker.Memory, offset, addr uint64) (uint64, error) {
+	addr = toAddress(mem, addr)
+	out := offset + addr
+	if out < offset {
+		return 0, fmt.Errorf("offset %d+%d overflows: %w", offset, addr, ErrIndexOutOfBound)
+	}
+
+	return out, nil
+}
+
+// toAddress truncates v to an address of mem, which is of 32 bits unless mem is 64-bit.
+func toAddress(mem linker.Memory, v uint64) uint64 {
+	if mem.Type().Is64() {
+		return v
+	}
+
+	return uint64(uint32(v))
+}
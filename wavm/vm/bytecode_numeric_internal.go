@@ -0,0 +1,337 @@
+package vm
+
+import (
+	"math"
+	"math/bits"
+)
+
+// unary tells whether op is numeric bytecode of a single operand.
+func (op bcOpcode) unary() bool {
+	return op == bcI32Eqz || op == bcI64Eqz || op >= bcI32WrapI64 && op <= bcF64PromoteF32
+}
+
+// runUnaryBytecode runs unary numeric bytecode op on operand v as the tree engine does.
+func runUnaryBytecode(op bcOpcode, v uint64) uint64 {
+	switch op {
+	case bcI32Eqz:
+		return boolToUint64(uint32(v) == 0)
+	case bcI64Eqz:
+		return boolToUint64(v == 0)
+	case bcI32WrapI64, bcI64ExtendI32U:
+		return uint64(uint32(v))
+	case bcI64ExtendI32S:
+		return uint64(int64(int32(v)))
+	case bcI32Clz:
+		return uint64(bits.LeadingZeros32(uint32(v)))
+	case bcI32Ctz:
+		return uint64(bits.TrailingZeros32(uint32(v)))
+	case bcI32PopCnt:
+		return uint64(bits.OnesCount32(uint32(v)))
+	case bcI64Clz:
+		return uint64(bits.LeadingZeros64(v))
+	case bcI64Ctz:
+		return uint64(bits.TrailingZeros64(v))
+	case bcI64PopCnt:
+		return uint64(bits.OnesCount64(v))
+	case bcI32Extend8S:
+		return uint64(uint32(int32(int8(v))))
+	case bcI32Extend16S:
+		return uint64(uint32(int32(int16(v))))
+	case bcI64Extend8S:
+		return uint64(int64(int8(v)))
+	case bcI64Extend16S:
+		return uint64(int64(int16(v)))
+	case bcI64Extend32S:
+		return uint64(int64(int32(v)))
+	case bcF32Abs:
+		return f32Bits(float32(math.Abs(float64(f32(v)))))
+	case bcF32Neg:
+		return f32Bits(-f32(v))
+	case bcF32Ceil:
+		return f32Bits(float32(math.Ceil(float64(f32(v)))))
+	case bcF32Floor:
+		return f32Bits(float32(math.Floor(float64(f32(v)))))
+	case bcF32Trunc:
+		return f32Bits(float32(math.Trunc(float64(f32(v)))))
+	case bcF32Nearest:
+		return f32Bits(float32(math.RoundToEven(float64(f32(v)))))
+	case bcF32Sqrt:
+		return f32Bits(float32(math.Sqrt(float64(f32(v)))))
+	case bcF64Abs:
+		return math.Float64bits(math.Abs(f64(v)))
+	case bcF64Neg:
+		return math.Float64bits(-f64(v))
+	case bcF64Ceil:
+		return math.Float64bits(math.Ceil(f64(v)))
+	case bcF64Floor:
+		return math.Float64bits(math.Floor(f64(v)))
+	case bcF64Trunc:
+		return math.Float64bits(math.Trunc(f64(v)))
+	case bcF64Nearest:
+		return math.Float64bits(math.RoundToEven(f64(v)))
+	case bcF64Sqrt:
+		return math.Float64bits(math.Sqrt(f64(v)))
+	case bcI32TruncF32S:
+		return uint64(uint32(int32(math.Trunc(float64(f32(v))))))
+	case bcI32TruncF32U:
+		return uint64(uint32(math.Trunc(float64(f32(v)))))
+	case bcI32TruncF64S:
+		return uint64(uint32(int32(math.Trunc(f64(v)))))
+	case bcI32TruncF64U:
+		return uint64(uint32(math.Trunc(f64(v))))
+	case bcI64TruncF32S:
+		return uint64(int64(math.Trunc(float64(f32(v)))))
+	case bcI64TruncF32U:
+		return uint64(math.Trunc(float64(f32(v))))
+	case bcI64TruncF64S:
+		return uint64(int64(math.Trunc(f64(v))))
+	case bcI64TruncF64U:
+		return uint64(math.Trunc(f64(v)))
+	case bcI32TruncSatF32S:
+		return uint64(uint32(int32(truncSatS(float64(f32(v)), 32))))
+	case bcI32TruncSatF32U:
+		return uint64(uint32(truncSatU(float64(f32(v)), 32)))
+	case bcI32TruncSatF64S:
+		return uint64(uint32(int32(truncSatS(f64(v), 32))))
+	case bcI32TruncSatF64U:
+		return uint64(uint32(truncSatU(f64(v), 32)))
+	case bcI64TruncSatF32S:
+		return uint64(truncSatS(float64(f32(v)), 64))
+	case bcI64TruncSatF32U:
+		return truncSatU(float64(f32(v)), 64)
+	case bcI64TruncSatF64S:
+		return uint64(truncSatS(f64(v), 64))
+	case bcI64TruncSatF64U:
+		return truncSatU(f64(v), 64)
+	case bcF32ConvertI32S:
+		return f32Bits(float32(int32(v)))
+	case bcF32ConvertI32U:
+		return f32Bits(float32(uint32(v)))
+	case bcF32ConvertI64S:
+		return f32Bits(float32(int64(v)))
+	case bcF32ConvertI64U:
+		return f32Bits(float32(v))
+	case bcF32DemoteF64:
+		return f32Bits(float32(f64(v)))
+	case bcF64ConvertI32S:
+		return math.Float64bits(float64(int32(v)))
+	case bcF64ConvertI32U:
+		return math.Float64bits(float64(uint32(v)))
+	case bcF64ConvertI64S:
+		return math.Float64bits(float64(int64(v)))
+	case bcF64ConvertI64U:
+		return math.Float64bits(float64(v))
+	default: // bcF64PromoteF32
+		return math.Float64bits(float64(f32(v)))
+	}
+}
+
+// runBinaryBytecode runs binary numeric bytecode op on operands v1 and v2 as the tree engine does.
+func runBinaryBytecode(op bcOpcode, v1, v2 uint64) uint64 {
+	switch op {
+	case bcI32Eq:
+		return boolToUint64(uint32(v1) == uint32(v2))
+	case bcI32Ne:
+		return boolToUint64(uint32(v1) != uint32(v2))
+	case bcI32LtS:
+		return boolToUint64(int32(v1) < int32(v2))
+	case bcI32LtU:
+		return boolToUint64(uint32(v1) < uint32(v2))
+	case bcI32GtS:
+		return boolToUint64(int32(v1) > int32(v2))
+	case bcI32GtU:
+		return boolToUint64(uint32(v1) > uint32(v2))
+	case bcI32LeS:
+		return boolToUint64(int32(v1) <= int32(v2))
+	case bcI32LeU:
+		return boolToUint64(uint32(v1) <= uint32(v2))
+	case bcI32GeS:
+		return boolToUint64(int32(v1) >= int32(v2))
+	case bcI32GeU:
+		return boolToUint64(uint32(v1) >= uint32(v2))
+	case bcI64Eq:
+		return boolToUint64(v1 == v2)
+	case bcI64Ne:
+		return boolToUint64(v1 != v2)
+	case bcI64LtS:
+		return boolToUint64(int64(v1) < int64(v2))
+	case bcI64LtU:
+		return boolToUint64(v1 < v2)
+	case bcI64GtS:
+		return boolToUint64(int64(v1) > int64(v2))
+	case bcI64GtU:
+		return boolToUint64(v1 > v2)
+	case bcI64LeS:
+		return boolToUint64(int64(v1) <= int64(v2))
+	case bcI64LeU:
+		return boolToUint64(v1 <= v2)
+	case bcI64GeS:
+		return boolToUint64(int64(v1) >= int64(v2))
+	case bcI64GeU:
+		return boolToUint64(v1 >= v2)
+	case bcI32Add:
+		return uint64(uint32(v1) + uint32(v2))
+	case bcI32Sub:
+		return uint64(uint32(v1) - uint32(v2))
+	case bcI32Mul:
+		return uint64(uint32(v1) * uint32(v2))
+	case bcI32And:
+		return uint64(uint32(v1) & uint32(v2))
+	case bcI32Or:
+		return uint64(uint32(v1) | uint32(v2))
+	case bcI32Xor:
+		return uint64(uint32(v1) ^ uint32(v2))
+	case bcI32Shl:
+		return uint64(uint32(v1) << (v2 % 32))
+	case bcI32ShrS:
+		return uint64(uint32(int32(v1) >> (v2 % 32)))
+	case bcI32ShrU:
+		return uint64(uint32(v1) >> (v2 % 32))
+	case bcI64Add:
+		return v1 + v2
+	case bcI64Sub:
+		return v1 - v2
+	case bcI64Mul:
+		return v1 * v2
+	case bcI64And:
+		return v1 & v2
+	case bcI64Or:
+		return v1 | v2
+	case bcI64Xor:
+		return v1 ^ v2
+	case bcI64Shl:
+		return v1 << (v2 % 64)
+	case bcI64ShrS:
+		return uint64(int64(v1) >> (v2 % 64))
+	case bcI64ShrU:
+		return v1 >> (v2 % 64)
+	case bcI32Rotl:
+		return uint64(bits.RotateLeft32(uint32(v1), int(uint32(v2))))
+	case bcI32Rotr:
+		return uint64(bits.RotateLeft32(uint32(v1), -int(uint32(v2))))
+	case bcI64Rotl:
+		return bits.RotateLeft64(v1, int(v2))
+	case bcI64Rotr:
+		return bits.RotateLeft64(v1, -int(v2))
+	case bcF32Eq:
+		return boolToUint64(f32(v1) == f32(v2))
+	case bcF32Ne:
+		return boolToUint64(f32(v1) != f32(v2))
+	case bcF32Lt:
+		return boolToUint64(f32(v1) < f32(v2))
+	case bcF32Gt:
+		return boolToUint64(f32(v1) > f32(v2))
+	case bcF32Le:
+		return boolToUint64(f32(v1) <= f32(v2))
+	case bcF32Ge:
+		return boolToUint64(f32(v1) >= f32(v2))
+	case bcF64Eq:
+		return boolToUint64(f64(v1) == f64(v2))
+	case bcF64Ne:
+		return boolToUint64(f64(v1) != f64(v2))
+	case bcF64Lt:
+		return boolToUint64(f64(v1) < f64(v2))
+	case bcF64Gt:
+		return boolToUint64(f64(v1) > f64(v2))
+	case bcF64Le:
+		return boolToUint64(f64(v1) <= f64(v2))
+	case bcF64Ge:
+		return boolToUint64(f64(v1) >= f64(v2))
+	case bcF32Add:
+		return f32Bits(f32(v1) + f32(v2))
+	case bcF32Sub:
+		return f32Bits(f32(v1) - f32(v2))
+	case bcF32Mul:
+		return f32Bits(f32(v1) * f32(v2))
+	case bcF32Div:
+		return f32Bits(f32(v1) / f32(v2))
+	case bcF32Min:
+		if f32(v1) < f32(v2) {
+			return uint64(uint32(v1))
+		}
+		return uint64(uint32(v2))
+	case bcF32Max:
+		if f32(v1) > f32(v2) {
+			return uint64(uint32(v1))
+		}
+		return uint64(uint32(v2))
+	case bcF32CopySign:
+		return f32Bits(float32(math.Copysign(float64(f32(v1)), float64(f32(v2)))))
+	case bcF64Add:
+		return math.Float64bits(f64(v1) + f64(v2))
+	case bcF64Sub:
+		return math.Float64bits(f64(v1) - f64(v2))
+	case bcF64Mul:
+		return math.Float64bits(f64(v1) * f64(v2))
+	case bcF64Div:
+		return math.Float64bits(f64(v1) / f64(v2))
+	case bcF64Min:
+		if f64(v1) < f64(v2) {
+			return v1
+		}
+		return v2
+	case bcF64Max:
+		if f64(v1) > f64(v2) {
+			return v1
+		}
+		return v2
+	default: // bcF64CopySign
+		return math.Float64bits(math.Copysign(f64(v1), f64(v2)))
+	}
+}
+
+// runDivBytecode runs the division or remainder op on operands v1 and v2, which traps as the tree
+// engine does.
+func runDivBytecode(op bcOpcode, v1, v2 uint64) (uint64, error) {
+	wide := op >= bcI64DivS
+	if wide && v2 == 0 || !wide && uint32(v2) == 0 {
+		return 0, ErrIntegerDivideByZero
+	}
+
+	switch op {
+	case bcI32DivS:
+		if int32(v1) == math.MinInt32 && int32(v2) == -1 {
+			return 0, ErrIntegerOverflow
+		}
+		return uint64(uint32(int32(v1) / int32(v2))), nil
+	case bcI32DivU:
+		return uint64(uint32(v1) / uint32(v2)), nil
+	case bcI32RemS:
+		return uint64(uint32(int32(v1) % int32(v2))), nil
+	case bcI32RemU:
+		return uint64(uint32(v1) % uint32(v2)), nil
+	case bcI64DivS:
+		if int64(v1) == math.MinInt64 && int64(v2) == -1 {
+			return 0, ErrIntegerOverflow
+		}
+		return uint64(int64(v1) / int64(v2)), nil
+	case bcI64DivU:
+		return v1 / v2, nil
+	case bcI64RemS:
+		return uint64(int64(v1) % int64(v2)), nil
+	default: // bcI64RemU
+		return v1 % v2, nil
+	}
+}
+
+func boolToUint64(v bool) uint64 {
+	if v {
+		return 1
+	}
+
+	return 0
+}
+
+// f32 gets the f32 of operand v.
+func f32(v uint64) float32 {
+	return math.Float32frombits(uint32(v))
+}
+
+// f32Bits makes the operand of the f32 v.
+func f32Bits(v float32) uint64 {
+	return uint64(math.Float32bits(v))
+}
+
+func f64(v uint64) float64 {
+	return math.Float64frombits(v)
+}
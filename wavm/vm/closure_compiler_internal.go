@@ -0,0 +1,315 @@
+package vm
+
+import "github.com/sammyne/mastering-wasm/wavm/types"
+
+// compileClosure compiles bytecode of a function into closures, which capture immediates of
+// instructions. heights are those of the operand stack before instructions as recorded by the
+// validator.
+func compileClosure(bc *bcFunc, heights map[*types.Instruction]int) *clFunc {
+	maxHeight := bc.nResults
+	for _, v := range heights {
+		if v > maxHeight {
+			maxHeight = v
+		}
+	}
+
+	out := &clFunc{
+		code:      make([]clOp, len(bc.code)),
+		instrs:    make([]*types.Instruction, len(bc.code)),
+		nLocals:   bc.nLocals,
+		nResults:  bc.nResults,
+		frameSize: bc.nLocals + maxHeight,
+	}
+	for i := range bc.code {
+		out.code[i], out.instrs[i] = compileClosureOp(&bc.code[i]), bc.code[i].instr
+	}
+
+	return out
+}
+
+func compileClosureOp(in *bcInstr) clOp {
+	switch in.op {
+	case bcNop:
+		return func(st *clState, pc int) int { return pc + 1 }
+	case bcJump:
+		target := in.target.pc
+		return func(st *clState, pc int) int { return target }
+	case bcBr:
+		t := in.target
+		return func(st *clState, pc int) int { return st.branch(&t) }
+	case bcBrIf:
+		t := in.target
+		return func(st *clState, pc int) int {
+			if st.sp--; st.s[st.sp] != 0 {
+				return st.branch(&t)
+			}
+			return pc + 1
+		}
+	case bcBrUnless:
+		target := in.target.pc
+		return func(st *clState, pc int) int {
+			if st.sp--; st.s[st.sp] == 0 {
+				return target
+			}
+			return pc + 1
+		}
+	case bcBrTable:
+		table := in.table
+		return func(st *clState, pc int) int {
+			st.sp--
+			if i := uint32(st.s[st.sp]); i < uint32(len(table)-1) {
+				return st.branch(&table[i])
+			}
+			return st.branch(&table[len(table)-1])
+		}
+	case bcBrOnNull:
+		t := in.target
+		return func(st *clState, pc int) int {
+			if st.s[st.sp-1] == 0 {
+				st.sp--
+				return st.branch(&t)
+			}
+			return pc + 1
+		}
+	case bcBrOnNonNull:
+		t := in.target
+		return func(st *clState, pc int) int {
+			if st.s[st.sp-1] != 0 {
+				return st.branch(&t)
+			}
+			st.sp--
+			return pc + 1
+		}
+	case bcBrOnCast:
+		t, arg := in.target, in.args.(types.GCArg)
+		return func(st *clState, pc int) int {
+			if matchRef(st.vm, st.s[st.sp-1], arg.DstType) == (arg.SubOpcode == types.GCBrOnCast) {
+				return st.branch(&t)
+			}
+			return pc + 1
+		}
+	case bcReturn:
+		return func(st *clState, pc int) int {
+			st.frame.PC = pc + 1
+			st.sync()
+			st.vm.exitClosure()
+			return clSwitch
+		}
+	case bcCall:
+		idx := in.imm
+		return func(st *clState, pc int) int {
+			st.frame.PC = pc + 1
+			st.sync()
+			vm := st.vm
+			if f := &vm.funcs[idx]; f.closure != nil {
+				vm.enterClosure(f)
+			} else {
+				st.err = callFunc(vm, *f)
+			}
+			return clSwitch
+		}
+	case bcFallback:
+		run, args := in.run, in.args
+		return func(st *clState, pc int) int {
+			st.sync()
+			if err := run(st.vm, args); err != nil {
+				st.frame.PC, st.err = pc+1, err
+				return clSwitch
+			}
+			st.reload()
+			return pc + 1
+		}
+	case bcFallbackCall:
+		run, args := in.run, in.args
+		return func(st *clState, pc int) int {
+			st.frame.PC = pc + 1
+			st.sync()
+			st.err = run(st.vm, args)
+			return clSwitch
+		}
+	case bcDrop:
+		return func(st *clState, pc int) int {
+			st.sp--
+			return pc + 1
+		}
+	case bcSelect:
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-2
+			if s[n+1] == 0 {
+				s[n-1] = s[n]
+			}
+			st.sp = n
+			return pc + 1
+		}
+	case bcLocalGet:
+		idx := int(in.imm)
+		return func(st *clState, pc int) int {
+			st.s[st.sp] = st.s[st.bp+idx]
+			st.sp++
+			return pc + 1
+		}
+	case bcLocalSet:
+		idx := int(in.imm)
+		return func(st *clState, pc int) int {
+			st.sp--
+			st.s[st.bp+idx] = st.s[st.sp]
+			return pc + 1
+		}
+	case bcLocalTee:
+		idx := int(in.imm)
+		return func(st *clState, pc int) int {
+			st.s[st.bp+idx] = st.s[st.sp-1]
+			return pc + 1
+		}
+	case bcGlobalGet:
+		idx := in.imm
+		return func(st *clState, pc int) int {
+			st.s[st.sp] = st.vm.globals[idx].GetAsUint64()
+			st.sp++
+			return pc + 1
+		}
+	case bcGlobalSet:
+		idx := in.imm
+		return func(st *clState, pc int) int {
+			st.sp--
+			if err := st.vm.globals[idx].SetAsUint64(st.s[st.sp]); err != nil {
+				return st.fail(pc, err)
+			}
+			return pc + 1
+		}
+	case bcMemorySize:
+		memIdx := in.mem
+		return func(st *clState, pc int) int {
+			mem := st.vm.memories[memIdx]
+			st.s[st.sp] = toAddress(mem, mem.Size())
+			st.sp++
+			return pc + 1
+		}
+	case bcMemoryGrow:
+		memIdx := in.mem
+		return func(st *clState, pc int) int {
+			mem, n := st.vm.memories[memIdx], st.sp-1
+			st.s[n] = toAddress(mem, mem.Grow(toAddress(mem, st.s[n])))
+			return pc + 1
+		}
+	case bcConst:
+		v := in.imm
+		return func(st *clState, pc int) int {
+			st.s[st.sp] = v
+			st.sp++
+			return pc + 1
+		}
+	case bcI32Eqz:
+		return unaryClosure(func(v uint64) uint64 { return boolToUint64(uint32(v) == 0) })
+	case bcI64Eqz:
+		return unaryClosure(func(v uint64) uint64 { return boolToUint64(v == 0) })
+	case bcI32WrapI64, bcI64ExtendI32U:
+		return unaryClosure(func(v uint64) uint64 { return uint64(uint32(v)) })
+	case bcI64ExtendI32S:
+		return unaryClosure(func(v uint64) uint64 { return uint64(int64(int32(v))) })
+	case bcI32Add:
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			s[n-1] = uint64(uint32(s[n-1]) + uint32(s[n]))
+			st.sp = n
+			return pc + 1
+		}
+	case bcI32Sub:
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			s[n-1] = uint64(uint32(s[n-1]) - uint32(s[n]))
+			st.sp = n
+			return pc + 1
+		}
+	case bcI32LtS:
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			s[n-1] = boolToUint64(int32(s[n-1]) < int32(s[n]))
+			st.sp = n
+			return pc + 1
+		}
+	case bcI32LtU:
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			s[n-1] = boolToUint64(uint32(s[n-1]) < uint32(s[n]))
+			st.sp = n
+			return pc + 1
+		}
+	case bcI64Add:
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			s[n-1] += s[n]
+			st.sp = n
+			return pc + 1
+		}
+	case bcI64Sub:
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			s[n-1] -= s[n]
+			st.sp = n
+			return pc + 1
+		}
+	case bcI64Mul:
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			s[n-1] *= s[n]
+			st.sp = n
+			return pc + 1
+		}
+	case bcI32DivS, bcI32DivU, bcI32RemS, bcI32RemU, bcI64DivS, bcI64DivU, bcI64RemS, bcI64RemU:
+		op := in.op
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			v, err := runDivBytecode(op, s[n-1], s[n])
+			if err != nil {
+				return st.fail(pc, err)
+			}
+			s[n-1], st.sp = v, n
+			return pc + 1
+		}
+	case bcI32Load, bcI64Load, bcI32Load8S, bcI32Load8U, bcI32Load16S, bcI32Load16U, bcI64Load8S,
+		bcI64Load8U, bcI64Load16S, bcI64Load16U, bcI64Load32S, bcI64Load32U:
+		op, memIdx, offset := in.op, in.mem, in.imm
+		return func(st *clState, pc int) int {
+			n := st.sp - 1
+			v, err := st.vm.loadBytecode(op, memIdx, offset, st.s[n])
+			if err != nil {
+				return st.fail(pc, err)
+			}
+			st.s[n] = v
+			return pc + 1
+		}
+	case bcI32Store, bcI64Store, bcI32Store8, bcI32Store16, bcI64Store8, bcI64Store16,
+		bcI64Store32:
+		op, memIdx, offset := in.op, in.mem, in.imm
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-2
+			st.sp = n
+			if err := st.vm.storeBytecode(op, memIdx, offset, s[n], s[n+1]); err != nil {
+				return st.fail(pc, err)
+			}
+			return pc + 1
+		}
+	default:
+		op := in.op
+		if op.unary() {
+			return unaryClosure(func(v uint64) uint64 { return runUnaryBytecode(op, v) })
+		}
+
+		// the rest of binary numeric instructions
+		return func(st *clState, pc int) int {
+			s, n := st.s, st.sp-1
+			s[n-1] = runBinaryBytecode(op, s[n-1], s[n])
+			st.sp = n
+			return pc + 1
+		}
+	}
+}
+
+// unaryClosure makes the closure of the unary numeric instruction f.
+func unaryClosure(f func(uint64) uint64) clOp {
+	return func(st *clState, pc int) int {
+		st.s[st.sp-1] = f(st.s[st.sp-1])
+		return pc + 1
+	}
+}
@@ -0,0 +1,179 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// clSwitch is returned by closures instead of the next PC once they switch call frames, or fail
+// with the error of clState.
+const clSwitch = -1
+
+// clFunc is a function compiled into closures, whose call frames keep locals at BP followed by
+// operands as those of the tree engine.
+type clFunc struct {
+	code []clOp
+	// instrs are instructions compiled into code by PCs, which are nil for ones implied by ends of
+	// blocks and functions.
+	instrs    []*types.Instruction
+	nLocals   int // including params
+	nResults  int
+	frameSize int // slots of locals and the deepest operands
+}
+
+// clOp runs the closure at pc, which returns the next PC or clSwitch.
+type clOp func(st *clState, pc int) int
+
+// clState is the state of the call frame running closures, where operands are s[base:sp].
+type clState struct {
+	vm            *VM
+	frame         *ControlFrame
+	fn            *clFunc
+	s             []uint64 // extended to the frame size
+	bp, base, sp  int
+	err           error
+	after, hooked bool // after tells whether the tracer awaits the previous instruction to finish
+}
+
+// branch moves operands kept by t down to its height, then returns t.pc.
+func (st *clState) branch(t *bcTarget) int {
+	dst, src := st.base+t.height, st.sp-t.arity
+	if dst != src {
+		copy(st.s[dst:dst+t.arity], st.s[src:st.sp])
+	}
+	st.sp = dst + t.arity
+
+	return t.pc
+}
+
+// load loads the call frame atop the control stack.
+func (st *clState) load() {
+	vm := st.vm
+	st.frame, _ = vm.ControlStack.Top()
+	st.fn, st.bp = st.frame.closure, st.frame.BP
+	st.base = st.bp + st.fn.nLocals
+	st.reload()
+	st.hooked = vm.isHooked()
+}
+
+// reload reloads operands from the VM, which may reallocate the operand stack.
+func (st *clState) reload() {
+	slots := st.vm.OperandStack.slots
+	st.s, st.sp = growRegisters(slots, st.bp+st.fn.frameSize), len(slots)
+}
+
+// fail fails the closure at pc with the trap err.
+func (st *clState) fail(pc int, err error) int {
+	st.sync()
+	st.frame.PC, st.err = pc+1, err
+	return clSwitch
+}
+
+// sync syncs operands into the VM.
+func (st *clState) sync() {
+	st.vm.OperandStack.slots = st.s[:st.sp]
+}
+
+// enterClosure pushes the call frame of f compiled into closures, whose args are atop the operand
+// stack.
+func (vm *VM) enterClosure(f *Func) {
+	fn := f.closure
+	bp := vm.OperandStack.Len() - len(f.type_.ParamTypes)
+	vm.ControlStack.Push(ControlFrame{Opcode: types.OpcodeCall, BP: bp, FuncIdx: f.idx, closure: fn})
+
+	s := growRegisters(vm.OperandStack.slots, bp+fn.frameSize)
+	for i := len(f.type_.ParamTypes); i < fn.nLocals; i++ {
+		s[bp+i] = 0
+	}
+	vm.OperandStack.slots = s[:bp+fn.nLocals]
+	vm.local0Idx = uint32(bp)
+}
+
+// exitClosure pops the call frame of closures atop the control stack, leaving its results on the
+// operand stack.
+func (vm *VM) exitClosure() {
+	frames := vm.ControlStack.frames
+	f := &frames[len(frames)-1]
+
+	s, n := vm.OperandStack.slots, f.closure.nResults
+	copy(s[f.BP:], s[len(s)-n:])
+	vm.OperandStack.slots = s[:f.BP+n]
+	vm.ControlStack.frames = frames[:len(frames)-1]
+
+	// frames of closures are all call frames
+	if caller := len(frames) - 2; caller >= 0 && frames[caller].closure != nil {
+		vm.local0Idx = uint32(frames[caller].BP)
+	} else if caller, _, ok := vm.TopCallFrame(); ok {
+		vm.local0Idx = uint32(caller.BP)
+	}
+}
+
+// runClosure runs call frames of closures atop the control stack for the loop started at depth,
+// until one of the other engines gets atop or all frames of the loop are done. Hooks attached
+// meanwhile take effect from the next call or return.
+func (vm *VM) runClosure(depth int) error {
+	st := &clState{vm: vm}
+	st.load()
+
+	pc := st.frame.PC
+	for {
+		if st.hooked {
+			if err := st.hook(pc); err != nil {
+				return vm.trap(depth, err)
+			}
+		}
+
+		if pc = st.fn.code[pc](st, pc); pc >= 0 {
+			continue
+		}
+
+		if err := st.err; err != nil {
+			var exn *linker.Exception
+			if !errors.As(err, &exn) {
+				pc := st.frame.PC - 1
+				var offset uint32
+				if instr := st.fn.instrs[pc]; instr != nil {
+					offset = instr.Offset
+				}
+				err = fmt.Errorf("exec instruction of PC(%d) at 0x%x in %s: %w", pc, offset,
+					vm.describeFunc(), err)
+				return vm.trap(depth, err)
+			}
+			// no frame of closures catches, so let the loop go on with the catching one if any
+			return vm.catchException(exn, depth)
+		}
+
+		if st.after && vm.tracer != nil {
+			vm.tracer.after()
+		}
+		st.after = false
+
+		if vm.ControlStack.Len() < depth {
+			return nil
+		}
+		if frame, _ := vm.ControlStack.Top(); frame.closure == nil {
+			return nil
+		}
+		st.load()
+		pc = st.frame.PC
+	}
+}
+
+// hook hooks the instruction at pc.
+func (st *clState) hook(pc int) error {
+	vm := st.vm
+	st.sync()
+	if st.after && vm.tracer != nil {
+		vm.tracer.after()
+	}
+
+	instr := st.fn.instrs[pc]
+	if st.after = instr != nil; !st.after {
+		return nil
+	}
+	st.frame.PC = pc + 1
+	return vm.hook(*instr)
+}
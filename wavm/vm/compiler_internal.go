@@ -0,0 +1,424 @@
+package vm
+
+import (
+	"errors"
+	"math"
+
+	"github.com/sammyne/mastering-wasm/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// errNotCompilable marks functions left to the tree engine.
+var errNotCompilable = errors.New("not compilable")
+
+// bcCompiler lowers the tree of instructions of a function into bytecode.
+type bcCompiler struct {
+	types_  []types.FuncType
+	heights map[*types.Instruction]int // as recorded by the validator
+	code    []bcInstr
+	labels  []bcLabel // innermost last
+}
+
+// bcFixup refers to the target of code[instr], or the entry-th one of its table if entry >= 0.
+type bcFixup struct {
+	instr, entry int
+}
+
+// bcLabel is a label of a block being compiled, whose branches are fixed up once its end is known
+// unless it's of a loop.
+type bcLabel struct {
+	loop   bool
+	target bcTarget
+	fixups []bcFixup
+}
+
+// bcNumericOps maps numeric instructions run natively to their bytecode.
+var bcNumericOps = map[byte]bcOpcode{
+	types.OpcodeI32Eqz:         bcI32Eqz,
+	types.OpcodeI32Eq:          bcI32Eq,
+	types.OpcodeI32Ne:          bcI32Ne,
+	types.OpcodeI32LtS:         bcI32LtS,
+	types.OpcodeI32LtU:         bcI32LtU,
+	types.OpcodeI32GtS:         bcI32GtS,
+	types.OpcodeI32GtU:         bcI32GtU,
+	types.OpcodeI32LeS:         bcI32LeS,
+	types.OpcodeI32LeU:         bcI32LeU,
+	types.OpcodeI32GeS:         bcI32GeS,
+	types.OpcodeI32GeU:         bcI32GeU,
+	types.OpcodeI64Eqz:         bcI64Eqz,
+	types.OpcodeI64Eq:          bcI64Eq,
+	types.OpcodeI64Ne:          bcI64Ne,
+	types.OpcodeI64LtS:         bcI64LtS,
+	types.OpcodeI64LtU:         bcI64LtU,
+	types.OpcodeI64GtS:         bcI64GtS,
+	types.OpcodeI64GtU:         bcI64GtU,
+	types.OpcodeI64LeS:         bcI64LeS,
+	types.OpcodeI64LeU:         bcI64LeU,
+	types.OpcodeI64GeS:         bcI64GeS,
+	types.OpcodeI64GeU:         bcI64GeU,
+	types.OpcodeI32Add:         bcI32Add,
+	types.OpcodeI32Sub:         bcI32Sub,
+	types.OpcodeI32Mul:         bcI32Mul,
+	types.OpcodeI32And:         bcI32And,
+	types.OpcodeI32Or:          bcI32Or,
+	types.OpcodeI32Xor:         bcI32Xor,
+	types.OpcodeI32Shl:         bcI32Shl,
+	types.OpcodeI32ShrS:        bcI32ShrS,
+	types.OpcodeI32ShrU:        bcI32ShrU,
+	types.OpcodeI64Add:         bcI64Add,
+	types.OpcodeI64Sub:         bcI64Sub,
+	types.OpcodeI64Mul:         bcI64Mul,
+	types.OpcodeI64And:         bcI64And,
+	types.OpcodeI64Or:          bcI64Or,
+	types.OpcodeI64Xor:         bcI64Xor,
+	types.OpcodeI64Shl:         bcI64Shl,
+	types.OpcodeI64ShrS:        bcI64ShrS,
+	types.OpcodeI64ShrU:        bcI64ShrU,
+	types.OpcodeI32WrapI64:     bcI32WrapI64,
+	types.OpcodeI64ExtendI32S:  bcI64ExtendI32S,
+	types.OpcodeI64ExtendI32U:  bcI64ExtendI32U,
+	types.OpcodeI32Clz:         bcI32Clz,
+	types.OpcodeI32Ctz:         bcI32Ctz,
+	types.OpcodeI32PopCnt:      bcI32PopCnt,
+	types.OpcodeI64Clz:         bcI64Clz,
+	types.OpcodeI64Ctz:         bcI64Ctz,
+	types.OpcodeI64PopCnt:      bcI64PopCnt,
+	types.OpcodeI32Extend8S:    bcI32Extend8S,
+	types.OpcodeI32Extend16S:   bcI32Extend16S,
+	types.OpcodeI64Extend8S:    bcI64Extend8S,
+	types.OpcodeI64Extend16S:   bcI64Extend16S,
+	types.OpcodeI64Extend32S:   bcI64Extend32S,
+	types.OpcodeF32Abs:         bcF32Abs,
+	types.OpcodeF32Neg:         bcF32Neg,
+	types.OpcodeF32Ceil:        bcF32Ceil,
+	types.OpcodeF32Floor:       bcF32Floor,
+	types.OpcodeF32Trunc:       bcF32Trunc,
+	types.OpcodeF32Nearest:     bcF32Nearest,
+	types.OpcodeF32Sqrt:        bcF32Sqrt,
+	types.OpcodeF64Abs:         bcF64Abs,
+	types.OpcodeF64Neg:         bcF64Neg,
+	types.OpcodeF64Ceil:        bcF64Ceil,
+	types.OpcodeF64Floor:       bcF64Floor,
+	types.OpcodeF64Trunc:       bcF64Trunc,
+	types.OpcodeF64Nearest:     bcF64Nearest,
+	types.OpcodeF64Sqrt:        bcF64Sqrt,
+	types.OpcodeI32TruncF32S:   bcI32TruncF32S,
+	types.OpcodeI32TruncF32U:   bcI32TruncF32U,
+	types.OpcodeI32TruncF64S:   bcI32TruncF64S,
+	types.OpcodeI32TruncF64U:   bcI32TruncF64U,
+	types.OpcodeI64TruncF32S:   bcI64TruncF32S,
+	types.OpcodeI64TruncF32U:   bcI64TruncF32U,
+	types.OpcodeI64TruncF64S:   bcI64TruncF64S,
+	types.OpcodeI64TruncF64U:   bcI64TruncF64U,
+	types.OpcodeF32ConvertI32S: bcF32ConvertI32S,
+	types.OpcodeF32ConvertI32U: bcF32ConvertI32U,
+	types.OpcodeF32ConvertI64S: bcF32ConvertI64S,
+	types.OpcodeF32ConvertI64U: bcF32ConvertI64U,
+	types.OpcodeF32DemoteF64:   bcF32DemoteF64,
+	types.OpcodeF64ConvertI32S: bcF64ConvertI32S,
+	types.OpcodeF64ConvertI32U: bcF64ConvertI32U,
+	types.OpcodeF64ConvertI64S: bcF64ConvertI64S,
+	types.OpcodeF64ConvertI64U: bcF64ConvertI64U,
+	types.OpcodeF64PromoteF32:  bcF64PromoteF32,
+	types.OpcodeI32Rotl:        bcI32Rotl,
+	types.OpcodeI32Rotr:        bcI32Rotr,
+	types.OpcodeI64Rotl:        bcI64Rotl,
+	types.OpcodeI64Rotr:        bcI64Rotr,
+	types.OpcodeF32Eq:          bcF32Eq,
+	types.OpcodeF32Ne:          bcF32Ne,
+	types.OpcodeF32Lt:          bcF32Lt,
+	types.OpcodeF32Gt:          bcF32Gt,
+	types.OpcodeF32Le:          bcF32Le,
+	types.OpcodeF32Ge:          bcF32Ge,
+	types.OpcodeF64Eq:          bcF64Eq,
+	types.OpcodeF64Ne:          bcF64Ne,
+	types.OpcodeF64Lt:          bcF64Lt,
+	types.OpcodeF64Gt:          bcF64Gt,
+	types.OpcodeF64Le:          bcF64Le,
+	types.OpcodeF64Ge:          bcF64Ge,
+	types.OpcodeF32Add:         bcF32Add,
+	types.OpcodeF32Sub:         bcF32Sub,
+	types.OpcodeF32Mul:         bcF32Mul,
+	types.OpcodeF32Div:         bcF32Div,
+	types.OpcodeF32Min:         bcF32Min,
+	types.OpcodeF32Max:         bcF32Max,
+	types.OpcodeF32CopySign:    bcF32CopySign,
+	types.OpcodeF64Add:         bcF64Add,
+	types.OpcodeF64Sub:         bcF64Sub,
+	types.OpcodeF64Mul:         bcF64Mul,
+	types.OpcodeF64Div:         bcF64Div,
+	types.OpcodeF64Min:         bcF64Min,
+	types.OpcodeF64Max:         bcF64Max,
+	types.OpcodeF64CopySign:    bcF64CopySign,
+}
+
+// bcDivOps maps divisions and remainders to their bytecode.
+var bcDivOps = map[byte]bcOpcode{
+	types.OpcodeI32DivS: bcI32DivS,
+	types.OpcodeI32DivU: bcI32DivU,
+	types.OpcodeI32RemS: bcI32RemS,
+	types.OpcodeI32RemU: bcI32RemU,
+	types.OpcodeI64DivS: bcI64DivS,
+	types.OpcodeI64DivU: bcI64DivU,
+	types.OpcodeI64RemS: bcI64RemS,
+	types.OpcodeI64RemU: bcI64RemU,
+}
+
+// bcMemoryOps maps loads and stores to their bytecode, where those of floats are of integers of
+// the same size.
+var bcMemoryOps = map[byte]bcOpcode{
+	types.OpcodeI32Load:    bcI32Load,
+	types.OpcodeI64Load:    bcI64Load,
+	types.OpcodeF32Load:    bcI32Load,
+	types.OpcodeF64Load:    bcI64Load,
+	types.OpcodeI32Load8S:  bcI32Load8S,
+	types.OpcodeI32Load8U:  bcI32Load8U,
+	types.OpcodeI32Load16S: bcI32Load16S,
+	types.OpcodeI32Load16U: bcI32Load16U,
+	types.OpcodeI64Load8S:  bcI64Load8S,
+	types.OpcodeI64Load8U:  bcI64Load8U,
+	types.OpcodeI64Load16S: bcI64Load16S,
+	types.OpcodeI64Load16U: bcI64Load16U,
+	types.OpcodeI64Load32S: bcI64Load32S,
+	types.OpcodeI64Load32U: bcI64Load32U,
+	types.OpcodeI32Store:   bcI32Store,
+	types.OpcodeI64Store:   bcI64Store,
+	types.OpcodeF32Store:   bcI32Store,
+	types.OpcodeF64Store:   bcI64Store,
+	types.OpcodeI32Store8:  bcI32Store8,
+	types.OpcodeI32Store16: bcI32Store16,
+	types.OpcodeI64Store8:  bcI64Store8,
+	types.OpcodeI64Store16: bcI64Store16,
+	types.OpcodeI64Store32: bcI64Store32,
+}
+
+// compileBytecode lowers code of a function typed t, where heights are those of the operand stack
+// before its instructions as recorded by the validator. It fails with errNotCompilable for
+// functions using try_table or unimplemented instructions.
+func compileBytecode(typeDefs []types.FuncType, t types.FuncType, code types.Code,
+	heights map[*types.Instruction]int) (*bcFunc, error) {
+	c := &bcCompiler{types_: typeDefs, heights: heights}
+
+	// the function body is a block, whose end returns
+	c.labels = []bcLabel{{target: bcTarget{arity: len(t.ResultTypes)}}}
+	if err := c.compileExpr(code.Expr); err != nil {
+		return nil, err
+	}
+	c.popLabel()
+	c.emit(bcInstr{op: bcReturn})
+
+	out := &bcFunc{
+		code:     c.code,
+		nLocals:  len(t.ParamTypes) + int(tools.CountLocals(code.Locals)),
+		nResults: len(t.ResultTypes),
+	}
+	return out, nil
+}
+
+func (c *bcCompiler) compileExpr(expr types.Expr) error {
+	for i := range expr {
+		if err := c.compileInstr(&expr[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *bcCompiler) compileInstr(instr *types.Instruction) error {
+	in := bcInstr{instr: instr}
+
+	switch instr.Opcode {
+	case types.OpcodeBlock, types.OpcodeLoop:
+		b := instr.Args.(*types.Block)
+		bt := tools.ParseBlockSig(b.BlockType, c.types_)
+		c.emit(bcInstr{op: bcNop, instr: instr})
+
+		l := bcLabel{target: bcTarget{
+			pc:     len(c.code),
+			height: c.heights[instr] - len(bt.ParamTypes),
+			arity:  len(bt.ResultTypes),
+		}}
+		if instr.Opcode == types.OpcodeLoop {
+			l.loop, l.target.arity = true, len(bt.ParamTypes)
+		}
+		c.labels = append(c.labels, l)
+
+		if err := c.compileExpr(b.Instructions); err != nil {
+			return err
+		}
+		c.popLabel()
+		return nil
+	case types.OpcodeIf:
+		b := instr.Args.(*types.BlockIf)
+		bt := tools.ParseBlockSig(b.BlockType, c.types_)
+
+		// the condition is popped already on branching
+		c.labels = append(c.labels, bcLabel{target: bcTarget{
+			height: c.heights[instr] - 1 - len(bt.ParamTypes),
+			arity:  len(bt.ResultTypes),
+		}})
+		ifIdx := c.emit(bcInstr{op: bcBrUnless, instr: instr})
+		if err := c.compileExpr(b.Instructions1); err != nil {
+			return err
+		}
+
+		if len(b.Instructions2) == 0 {
+			c.addFixup(0, bcFixup{instr: ifIdx, entry: -1})
+		} else {
+			c.addFixup(0, bcFixup{instr: c.emit(bcInstr{op: bcJump}), entry: -1})
+			c.code[ifIdx].target.pc = len(c.code)
+			if err := c.compileExpr(b.Instructions2); err != nil {
+				return err
+			}
+		}
+		c.popLabel()
+		return nil
+	case types.OpcodeBr, types.OpcodeBrIf, types.OpcodeBrOnNull, types.OpcodeBrOnNonNull:
+		switch instr.Opcode {
+		case types.OpcodeBr:
+			in.op = bcBr
+		case types.OpcodeBrIf:
+			in.op = bcBrIf
+		case types.OpcodeBrOnNull:
+			in.op = bcBrOnNull
+		default:
+			in.op = bcBrOnNonNull
+		}
+		c.branch(c.emit(in), -1, instr.Args.(uint32))
+		return nil
+	case types.OpcodeBrTable:
+		table := instr.Args.(*types.BreakTable)
+		in.op, in.table = bcBrTable, make([]bcTarget, len(table.Labels)+1)
+		idx := c.emit(in)
+		for i, v := range append(append([]uint32(nil), table.Labels...), table.Default) {
+			c.branch(idx, i, v)
+		}
+		return nil
+	case types.OpcodeReturn:
+		in.op = bcReturn
+	case types.OpcodeCall:
+		in.op, in.imm = bcCall, uint64(instr.Args.(uint32))
+	case types.OpcodeCallIndirect, types.OpcodeReturnCall, types.OpcodeReturnCallIndirect,
+		types.OpcodeCallRef, types.OpcodeReturnCallRef:
+		in.op, in.run, in.args = bcFallbackCall, instructionTable[instr.Opcode], instr.Args
+	case types.OpcodeTryTable:
+		return errNotCompilable
+	case types.OpcodeDrop:
+		in.op = bcDrop
+	case types.OpcodeSelect:
+		in.op = bcSelect
+	case types.OpcodeLocalGet:
+		in.op, in.imm = bcLocalGet, uint64(instr.Args.(uint32))
+	case types.OpcodeLocalSet:
+		in.op, in.imm = bcLocalSet, uint64(instr.Args.(uint32))
+	case types.OpcodeLocalTee:
+		in.op, in.imm = bcLocalTee, uint64(instr.Args.(uint32))
+	case types.OpcodeGlobalGet:
+		in.op, in.imm = bcGlobalGet, uint64(instr.Args.(uint32))
+	case types.OpcodeGlobalSet:
+		in.op, in.imm = bcGlobalSet, uint64(instr.Args.(uint32))
+	case types.OpcodeMemorySize:
+		in.op, in.mem = bcMemorySize, instr.Args.(uint32)
+	case types.OpcodeMemoryGrow:
+		in.op, in.mem = bcMemoryGrow, instr.Args.(uint32)
+	case types.OpcodeI32ReinterpretF32, types.OpcodeI64ReinterpretF64,
+		types.OpcodeF32ReinterpretI32, types.OpcodeF64ReinterpretI64:
+		in.op = bcNop
+	case types.OpcodeI32Const:
+		in.op, in.imm = bcConst, uint64(uint32(instr.Args.(int32)))
+	case types.OpcodeI64Const:
+		in.op, in.imm = bcConst, uint64(instr.Args.(int64))
+	case types.OpcodeF32Const:
+		in.op, in.imm = bcConst, uint64(math.Float32bits(instr.Args.(float32)))
+	case types.OpcodeF64Const:
+		in.op, in.imm = bcConst, math.Float64bits(instr.Args.(float64))
+	case types.OpcodeGC:
+		arg := instr.Args.(types.GCArg)
+		if arg.SubOpcode != types.GCBrOnCast && arg.SubOpcode != types.GCBrOnCastFail {
+			in.op, in.run, in.args = bcFallback, instructionTable[instr.Opcode], instr.Args
+			break
+		}
+		in.op, in.args = bcBrOnCast, arg
+		c.branch(c.emit(in), -1, arg.Label)
+		return nil
+	case types.OpcodeTruncSat:
+		// bulk memory instructions share the prefix
+		if sub, ok := instr.Args.(byte); ok && sub <= 0x07 {
+			in.op = bcI32TruncSatF32S + bcOpcode(sub)
+			break
+		}
+		in.op, in.run, in.args = bcFallback, instructionTable[instr.Opcode], instr.Args
+	default:
+		if op, ok := bcNumericOps[instr.Opcode]; ok {
+			in.op = op
+			break
+		} else if op, ok := bcDivOps[instr.Opcode]; ok {
+			in.op = op
+			break
+		} else if op, ok := bcMemoryOps[instr.Opcode]; ok {
+			arg := instr.Args.(types.MemoryArg)
+			in.op, in.imm, in.mem = op, arg.Offset, arg.MemoryIdx
+			break
+		}
+
+		// left to the tree engine, which fails on running it as well
+		run := instructionTable[instr.Opcode]
+		if run == nil {
+			return errNotCompilable
+		}
+		in.op, in.run, in.args = bcFallback, run, instr.Args
+	}
+
+	c.emit(in)
+	return nil
+}
+
+// indexHeights maps instructions of expr to heights of the operand stack before them, which are
+// recorded by the validator in the order of tools.WalkInstrs.
+func indexHeights(expr types.Expr, heights []int) map[*types.Instruction]int {
+	out := make(map[*types.Instruction]int, len(heights))
+	tools.WalkInstrs(expr, func(v *types.Instruction) { out[v] = heights[len(out)] })
+	return out
+}
+
+// addFixup adds f to the label at depth, counting from the innermost.
+func (c *bcCompiler) addFixup(depth int, f bcFixup) {
+	l := &c.labels[len(c.labels)-1-depth]
+	l.fixups = append(l.fixups, f)
+}
+
+// branch resolves the target of code[instr], or the entry-th one of its table if entry >= 0, for
+// branching to the label at depth.
+func (c *bcCompiler) branch(instr, entry int, depth uint32) {
+	l := c.labels[len(c.labels)-1-int(depth)]
+
+	target := &c.code[instr].target
+	if entry >= 0 {
+		target = &c.code[instr].table[entry]
+	}
+	*target = l.target
+
+	if !l.loop {
+		c.addFixup(int(depth), bcFixup{instr: instr, entry: entry})
+	}
+}
+
+func (c *bcCompiler) emit(in bcInstr) int {
+	c.code = append(c.code, in)
+	return len(c.code) - 1
+}
+
+// popLabel pops the innermost label, fixing up branches to its end.
+func (c *bcCompiler) popLabel() {
+	l := c.labels[len(c.labels)-1]
+	c.labels = c.labels[:len(c.labels)-1]
+
+	for _, v := range l.fixups {
+		if v.entry >= 0 {
+			c.code[v.instr].table[v.entry].pc = len(c.code)
+		} else {
+			c.code[v.instr].target.pc = len(c.code)
+		}
+	}
+}
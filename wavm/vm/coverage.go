@@ -0,0 +1,44 @@
+package vm
+
+import "github.com/sammyne/mastering-wasm/wavm/types"
+
+// Coverage records instructions executed by a VM per function body, and outcomes of its branches.
+// It isn't safe for concurrent use.
+type Coverage struct {
+	vm *VM
+	// counts are executions of instructions by offsets by their functions.
+	counts map[types.FuncIdx]map[uint32]uint64
+	// branches are outcomes of branch instructions by offsets by their functions.
+	branches map[types.FuncIdx]map[uint32][]uint64
+}
+
+// Branches returns counts of outcomes of if, br_if and br_table by offsets by the functions they
+// belong to. Outcomes of if are then and else, those of br_if are taken and not taken, and those
+// of br_table are its labels followed by the default one.
+func (c *Coverage) Branches() map[types.FuncIdx]map[uint32][]uint64 {
+	return c.branches
+}
+
+// Counts returns executions of instructions by offsets by the functions they belong to.
+func (c *Coverage) Counts() map[types.FuncIdx]map[uint32]uint64 {
+	return c.counts
+}
+
+// Detach detaches c from its VM, which then runs without coverage recorded.
+func (c *Coverage) Detach() {
+	if c.vm.coverage == c {
+		c.vm.coverage = nil
+	}
+}
+
+// NewCoverage attaches a coverage recorder to vm, replacing any previous one.
+func NewCoverage(vm *VM) *Coverage {
+	out := &Coverage{
+		vm:       vm,
+		counts:   make(map[types.FuncIdx]map[uint32]uint64),
+		branches: make(map[types.FuncIdx]map[uint32][]uint64),
+	}
+
+	vm.coverage = out
+	return out
+}
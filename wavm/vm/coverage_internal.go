@@ -0,0 +1,52 @@
+package vm
+
+import "github.com/sammyne/mastering-wasm/wavm/types"
+
+// before records instr about to execute for the function atop the control stack, and the outcome
+// of branches by their operands.
+func (c *Coverage) before(instr types.Instruction) {
+	f, _, ok := c.vm.TopCallFrame()
+	if !ok {
+		return
+	}
+
+	counts, ok := c.counts[f.FuncIdx]
+	if !ok {
+		counts = make(map[uint32]uint64)
+		c.counts[f.FuncIdx] = counts
+	}
+	counts[instr.Offset]++
+
+	var outcome, nOutcomes int
+	switch instr.Opcode {
+	case types.OpcodeIf, types.OpcodeBrIf:
+		if v, ok := c.vm.OperandStack.Get(uint32(c.vm.OperandStack.Len() - 1)); ok && uint32(v) == 0 {
+			outcome = 1
+		}
+		nOutcomes = 2
+	case types.OpcodeBrTable:
+		table, ok := instr.Args.(*types.BreakTable)
+		if !ok {
+			return
+		}
+		nOutcomes = len(table.Labels) + 1
+		if v, ok := c.vm.OperandStack.Get(uint32(c.vm.OperandStack.Len() - 1)); ok &&
+			uint32(v) < uint32(len(table.Labels)) {
+			outcome = int(uint32(v))
+		} else {
+			outcome = len(table.Labels)
+		}
+	default:
+		return
+	}
+
+	branches, ok := c.branches[f.FuncIdx]
+	if !ok {
+		branches = make(map[uint32][]uint64)
+		c.branches[f.FuncIdx] = branches
+	}
+	if branches[instr.Offset] == nil {
+		branches[instr.Offset] = make([]uint64, nOutcomes)
+	}
+	branches[instr.Offset][outcome]++
+}
@@ -0,0 +1,86 @@
+package vm_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestCoverage(t *testing.T) {
+	funcs := []testFunc{
+		{"abs", []byte{i32}, []byte{i32}, nil, []byte{
+			0x20, 0, 0x41, 0, 0x48, // n < 0
+			0x04, i32, 0x41, 0, 0x20, 0, 0x6B, 0x05, 0x20, 0, 0x0B,
+		}},
+		// returns 10, 20 and 30 for 0, 1 and others
+		{"pick", []byte{i32}, []byte{i32}, nil, []byte{
+			0x02, 0x40, 0x02, 0x40, 0x02, 0x40,
+			0x20, 0, 0x0E, 2, 0, 1, 2,
+			0x0B, 0x41, 10, 0x0F,
+			0x0B, 0x41, 20, 0x0F,
+			0x0B, 0x41, 30,
+		}},
+		{"uncalled", nil, nil, nil, nil},
+	}
+	m := testModule{funcs: funcs}
+	module, err := wavm.NewDecoder(m.encode()).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	abs := module.Codes[0].Expr
+	then, else_ := abs[3].Args.(*types.BlockIf).Instructions1, abs[3].Args.(*types.BlockIf).Instructions2
+	brTable := module.Codes[1].Expr[0].Args.(*types.Block).Instructions[0].Args.(*types.Block).
+		Instructions[0].Args.(*types.Block).Instructions[1]
+
+	expectBranches := map[types.FuncIdx]map[uint32][]uint64{
+		1: {abs[3].Offset: {1, 2}},
+		2: {brTable.Offset: {1, 0, 2}},
+	}
+
+	var expectCounts map[types.FuncIdx]map[uint32]uint64
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine).(*vm.VM)
+
+		c := vm.NewCoverage(instance)
+		calls := []struct {
+			name   string
+			arg    int32
+			expect int32
+		}{
+			{"abs", -3, 3}, {"abs", 4, 4}, {"abs", 5, 5},
+			{"pick", 0, 10}, {"pick", 5, 30}, {"pick", 6, 30},
+		}
+		for _, v := range calls {
+			if got, err := instance.InvokeFunc(v.name, v.arg); err != nil || got[0] != v.expect {
+				t.Fatalf("%s: %s(%d): expect %d, got %v, %v", engine, v.name, v.arg, v.expect, got, err)
+			}
+		}
+		c.Detach()
+
+		// detached recorders record nothing
+		if _, err := instance.InvokeFunc("pick", int32(1)); err != nil {
+			t.Fatalf("%s: invoke: %v", engine, err)
+		}
+
+		if !reflect.DeepEqual(expectBranches, c.Branches()) {
+			t.Fatalf("%s: expect branches %v, got %v", engine, expectBranches, c.Branches())
+		}
+
+		if engine == vm.EngineTree {
+			expectCounts = c.Counts()
+			got := []uint64{expectCounts[1][abs[3].Offset], expectCounts[1][then[0].Offset],
+				expectCounts[1][else_[0].Offset]}
+			if expect := []uint64{3, 1, 2}; !reflect.DeepEqual(expect, got) {
+				t.Fatalf("expect if, then and else run %v times, got %v", expect, got)
+			}
+			if _, ok := expectCounts[3]; ok {
+				t.Fatalf("expect uncalled funcs missing from counts, got %v", expectCounts[3])
+			}
+		} else if !reflect.DeepEqual(expectCounts, c.Counts()) {
+			t.Fatalf("%s: expect counts %v, got %v", engine, expectCounts, c.Counts())
+		}
+	}
+}
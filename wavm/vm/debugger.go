@@ -0,0 +1,187 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// Actions resuming execution from stops of debuggers.
+const (
+	ActionContinue Action = iota
+	ActionStepInto        // stop at the next instruction
+	ActionStepOver        // stop at the next instruction not in functions called by the current one
+	ActionStepOut         // stop at the next instruction of the caller of the current function
+	ActionAbort           // abort execution with ErrAborted
+)
+
+// Reasons of stops of debuggers.
+const (
+	StopReasonBreakpoint StopReason = iota
+	StopReasonStep
+	StopReasonPause
+)
+
+var ErrAborted = errors.New("aborted by debugger")
+
+// Action tells how to resume execution from a stop.
+type Action int
+
+// Breakpoint locates an instruction by the index of its function and its offset in the decoded
+// module.
+type Breakpoint struct {
+	FuncIdx types.FuncIdx
+	Offset  uint32
+}
+
+// Debugger stops VMs before instructions at breakpoints, after steps or on pause requests, and
+// calls its handler to inspect the VM, which then resumes by the returned action. Only Pause is
+// safe to call from goroutines other than the one running the VM.
+type Debugger struct {
+	vm          *VM
+	onStop      func(d *Debugger, s Stop) Action
+	breakpoints map[Breakpoint]struct{}
+	paused      int32 // set by Pause atomically
+
+	step      Action // ActionContinue if not stepping
+	stepDepth int    // call depth where the step starts
+}
+
+// Stop tells why and where a debugger stops, right before executing Instruction.
+type Stop struct {
+	Reason      StopReason
+	FuncIdx     types.FuncIdx
+	Instruction types.Instruction
+}
+
+// StopReason tells why a debugger stops.
+type StopReason int
+
+// ClearBreakpoint removes the breakpoint b.
+func (d *Debugger) ClearBreakpoint(b Breakpoint) {
+	delete(d.breakpoints, b)
+}
+
+// ControlFrames returns frames of the control stack from the bottom up.
+func (d *Debugger) ControlFrames() []ControlFrame {
+	return append([]ControlFrame(nil), d.vm.ControlStack.frames...)
+}
+
+// Detach detaches d from its VM, which then runs without stops.
+func (d *Debugger) Detach() {
+	if d.vm.debugger == d {
+		d.vm.debugger = nil
+	}
+}
+
+// Frames returns call frames innermost first, where the offset of the top-most one is of the
+// instruction to execute next.
+func (d *Debugger) Frames() []StackFrame {
+	return d.vm.backtrace(0)
+}
+
+func (d *Debugger) GetGlobal(idx types.GlobalIdx) (types.WasmVal, error) {
+	if int(idx) >= len(d.vm.globals) {
+		return nil, fmt.Errorf("global(%d): %w", idx, ErrIndexOutOfBound)
+	}
+
+	return d.vm.globals[idx].Get()
+}
+
+// GetFrameLocals returns parameters and then locals of the i-th call frame counting innermost
+// first as Frames.
+func (d *Debugger) GetFrameLocals(i int) ([]types.WasmVal, error) {
+	f, ok := d.vm.getCallFrame(i)
+	if !ok {
+		return nil, ErrMissingCallFrame
+	}
+
+	fn := d.vm.funcs[f.FuncIdx]
+	localTypes := append([]types.ValueType(nil), fn.type_.ParamTypes...)
+	for _, v := range fn.code.Locals {
+		for i := uint32(0); i < v.N; i++ {
+			localTypes = append(localTypes, v.Type)
+		}
+	}
+
+	out := make([]types.WasmVal, len(localTypes))
+	for i, t := range localTypes {
+		v, ok := d.vm.OperandStack.Get(uint32(f.BP + i))
+		if !ok {
+			return nil, fmt.Errorf("local(%d): %w", i, ErrIndexOutOfBound)
+		}
+
+		var err error
+		if out[i], err = wrapUint64(t, v); err != nil {
+			return nil, fmt.Errorf("wrap local(%d): %w", i, err)
+		}
+	}
+
+	return out, nil
+}
+
+// GetLocals returns parameters and then locals of the function running atop the control stack.
+func (d *Debugger) GetLocals() ([]types.WasmVal, error) {
+	return d.GetFrameLocals(0)
+}
+
+// GetOperands returns slots of the whole operand stack from the bottom up, including locals.
+func (d *Debugger) GetOperands() []uint64 {
+	return append([]uint64(nil), d.vm.OperandStack.slots...)
+}
+
+// Pause requests the VM to stop before its next instruction.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.paused, 1)
+}
+
+// ReadMemory reads len(buf) bytes at offset of the idx-th memory.
+func (d *Debugger) ReadMemory(idx types.MemoryIdx, offset uint64, buf []byte) error {
+	if int(idx) >= len(d.vm.memories) {
+		return fmt.Errorf("memory(%d): %w", idx, ErrIndexOutOfBound)
+	}
+
+	return d.vm.memories[idx].Read(offset, buf)
+}
+
+// SetBreakpoint adds the breakpoint b, which must locate an instruction of a function defined by
+// the module.
+func (d *Debugger) SetBreakpoint(b Breakpoint) error {
+	if !d.vm.hasInstruction(b.FuncIdx, b.Offset) {
+		return fmt.Errorf("no instruction at 0x%x of func(%d): %w", b.Offset, b.FuncIdx, ErrBadArgs)
+	}
+
+	d.breakpoints[b] = struct{}{}
+	return nil
+}
+
+// WriteMemory writes buf at offset of the idx-th memory.
+func (d *Debugger) WriteMemory(idx types.MemoryIdx, offset uint64, buf []byte) error {
+	if int(idx) >= len(d.vm.memories) {
+		return fmt.Errorf("memory(%d): %w", idx, ErrIndexOutOfBound)
+	}
+
+	return d.vm.memories[idx].Write(offset, buf)
+}
+
+func (r StopReason) String() string {
+	switch r {
+	case StopReasonBreakpoint:
+		return "breakpoint"
+	case StopReasonStep:
+		return "step"
+	case StopReasonPause:
+		return "pause"
+	default:
+		return fmt.Sprintf("StopReason(%d)", int(r))
+	}
+}
+
+// NewDebugger attaches a debugger to vm, replacing any previous one, which calls onStop on stops.
+func NewDebugger(vm *VM, onStop func(d *Debugger, s Stop) Action) *Debugger {
+	out := &Debugger{vm: vm, onStop: onStop, breakpoints: make(map[Breakpoint]struct{})}
+	vm.debugger = out
+	return out
+}
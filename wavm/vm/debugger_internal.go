@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"sync/atomic"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// hook is called before executing instr of the function atop the control stack, which stops for
+// pause requests, breakpoints and finished steps in order.
+func (d *Debugger) hook(instr types.Instruction) error {
+	f, _, ok := d.vm.TopCallFrame()
+	if !ok {
+		return nil
+	}
+
+	var reason StopReason
+	if atomic.CompareAndSwapInt32(&d.paused, 1, 0) {
+		reason = StopReasonPause
+	} else if _, ok := d.breakpoints[Breakpoint{FuncIdx: f.FuncIdx, Offset: instr.Offset}]; ok {
+		reason = StopReasonBreakpoint
+	} else if d.isStepDone() {
+		reason = StopReasonStep
+	} else {
+		return nil
+	}
+
+	action := d.onStop(d, Stop{Reason: reason, FuncIdx: f.FuncIdx, Instruction: instr})
+
+	d.step, d.stepDepth = ActionContinue, 0
+	switch action {
+	case ActionAbort:
+		return ErrAborted
+	case ActionStepInto, ActionStepOver, ActionStepOut:
+		d.step, d.stepDepth = action, d.vm.getCallDepth()
+	default:
+	}
+
+	return nil
+}
+
+func (d *Debugger) isStepDone() bool {
+	switch d.step {
+	case ActionStepInto:
+		return true
+	case ActionStepOver:
+		return d.vm.getCallDepth() <= d.stepDepth
+	case ActionStepOut:
+		return d.vm.getCallDepth() < d.stepDepth
+	default:
+		return false
+	}
+}
+
+// getCallDepth counts call frames on the control stack.
+func (vm *VM) getCallDepth() int {
+	var out int
+	for _, v := range vm.ControlStack.frames {
+		if v.Opcode == types.OpcodeCall {
+			out++
+		}
+	}
+
+	return out
+}
+
+// getCallFrame gets the i-th call frame counting from the top of the control stack.
+func (vm *VM) getCallFrame(i int) (ControlFrame, bool) {
+	for j := vm.ControlStack.Len() - 1; j >= 0; j-- {
+		if f := vm.ControlStack.frames[j]; f.Opcode == types.OpcodeCall {
+			if i == 0 {
+				return f, true
+			}
+			i--
+		}
+	}
+
+	return ControlFrame{}, false
+}
+
+// hasInstruction tells whether the function at funcIdx defined by the module has an instruction at
+// offset, which may be nested in blocks.
+func (vm *VM) hasInstruction(funcIdx types.FuncIdx, offset uint32) bool {
+	if int(funcIdx) >= len(vm.funcs) || vm.funcs[funcIdx].externalFn != nil {
+		return false
+	}
+
+	return hasInstruction(vm.funcs[funcIdx].code.Expr, offset)
+}
+
+func hasInstruction(expr types.Expr, offset uint32) bool {
+	for _, v := range expr {
+		if v.Offset == offset {
+			return true
+		}
+
+		var found bool
+		switch args := v.Args.(type) {
+		case *types.Block:
+			found = hasInstruction(args.Instructions, offset)
+		case *types.BlockIf:
+			found = hasInstruction(args.Instructions1, offset) ||
+				hasInstruction(args.Instructions2, offset)
+		case *types.TryTable:
+			found = hasInstruction(args.Instructions, offset)
+		default:
+		}
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
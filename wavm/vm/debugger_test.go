@@ -0,0 +1,137 @@
+package vm_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestDebugger(t *testing.T) {
+	funcs := []testFunc{
+		// returns sq(n)+1
+		{"main", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x10, 2, 0x41, 1, 0x6A}},
+		{"sq", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 0, 0x6C}},
+	}
+	m := testModule{funcs: funcs, sections: map[byte][]byte{
+		5: wasmtest.Vec([]byte{0x00, 1}),
+		6: wasmtest.Vec([]byte{i32, 0, 0x41, 7, 0x0B}),
+	}}
+	module, err := wavm.NewDecoder(m.encode()).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	type stop struct {
+		reason vm.StopReason
+		funcIdx,
+		instr int // index into the code of the func
+	}
+	at := func(s vm.Stop) stop {
+		for i, v := range module.Codes[s.FuncIdx-1].Expr {
+			if v.Offset == s.Instruction.Offset {
+				return stop{s.Reason, int(s.FuncIdx), i}
+			}
+		}
+		return stop{s.Reason, int(s.FuncIdx), -1}
+	}
+	sq := vm.Breakpoint{FuncIdx: 2, Offset: module.Codes[1].Expr[0].Offset}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine).(*vm.VM)
+
+		// breaks in sq, steps in it and out to main, over the next, then goes on
+		actions := []vm.Action{vm.ActionStepInto, vm.ActionStepOut, vm.ActionStepOver,
+			vm.ActionContinue}
+		var stops []stop
+		d := vm.NewDebugger(instance, func(d *vm.Debugger, s vm.Stop) vm.Action {
+			stops = append(stops, at(s))
+			if s.Reason == vm.StopReasonBreakpoint {
+				inspectTestDebugger(t, d)
+			}
+			return actions[len(stops)-1]
+		})
+		if err := d.SetBreakpoint(vm.Breakpoint{FuncIdx: 2, Offset: sq.Offset + 1}); !errors.Is(err,
+			vm.ErrBadArgs) {
+			t.Fatalf("%s: expect breakpoints amid instructions to fail, got %v", engine, err)
+		}
+		if err := d.SetBreakpoint(sq); err != nil {
+			t.Fatalf("%s: set breakpoint: %v", engine, err)
+		}
+
+		got, err := instance.InvokeFunc("main", int32(5))
+		if err != nil || got[0] != int32(26) {
+			t.Fatalf("%s: expect 26, got %v, %v", engine, got, err)
+		}
+		expect := []stop{
+			{vm.StopReasonBreakpoint, 2, 0},
+			{vm.StopReasonStep, 2, 1},
+			{vm.StopReasonStep, 1, 2},
+			{vm.StopReasonStep, 1, 3},
+		}
+		if !reflect.DeepEqual(expect, stops) {
+			t.Fatalf("%s: expect stops %v, got %v", engine, expect, stops)
+		}
+
+		// pauses at the start of main, steps over the call to sq, then aborts
+		d.ClearBreakpoint(sq)
+		actions = []vm.Action{vm.ActionStepOver, vm.ActionStepOver, vm.ActionStepOver,
+			vm.ActionAbort}
+		stops = nil
+		d.Pause()
+		if _, err := instance.InvokeFunc("main", int32(5)); !errors.Is(err, vm.ErrAborted) {
+			t.Fatalf("%s: expect %v, got %v", engine, vm.ErrAborted, err)
+		}
+		expect = []stop{
+			{vm.StopReasonPause, 1, 0},
+			{vm.StopReasonStep, 1, 1},
+			{vm.StopReasonStep, 1, 2},
+			{vm.StopReasonStep, 1, 3},
+		}
+		if !reflect.DeepEqual(expect, stops) {
+			t.Fatalf("%s: expect stops %v, got %v", engine, expect, stops)
+		}
+
+		d.Detach()
+		if got, err := instance.InvokeFunc("main", int32(5)); err != nil || got[0] != int32(26) {
+			t.Fatalf("%s: expect 26 after detached, got %v, %v", engine, got, err)
+		}
+	}
+}
+
+// inspectTestDebugger inspects the VM stopping at the start of sq called by main with 5.
+func inspectTestDebugger(t *testing.T, d *vm.Debugger) {
+	t.Helper()
+
+	if frames := d.Frames(); len(frames) != 2 || frames[0].FuncIdx != 2 || frames[1].FuncIdx != 1 {
+		t.Fatalf("expect frames of sq and main, got %v", frames)
+	}
+	if locals, err := d.GetLocals(); err != nil || !testEqual(locals, []types.WasmVal{int32(5)}) {
+		t.Fatalf("expect locals of sq [5], got %v, %v", locals, err)
+	}
+	if locals, err := d.GetFrameLocals(1); err != nil || !testEqual(locals,
+		[]types.WasmVal{int32(5)}) {
+		t.Fatalf("expect locals of main [5], got %v, %v", locals, err)
+	}
+	if _, err := d.GetFrameLocals(2); !errors.Is(err, vm.ErrMissingCallFrame) {
+		t.Fatalf("expect %v, got %v", vm.ErrMissingCallFrame, err)
+	}
+	if g, err := d.GetGlobal(0); err != nil || g != int32(7) {
+		t.Fatalf("expect global 7, got %v, %v", g, err)
+	}
+
+	if err := d.WriteMemory(0, 8, []byte{1, 2}); err != nil {
+		t.Fatalf("write memory: %v", err)
+	}
+	buf := make([]byte, 3)
+	if err := d.ReadMemory(0, 7, buf); err != nil || !reflect.DeepEqual(buf, []byte{0, 1, 2}) {
+		t.Fatalf("expect memory [0 1 2], got %v, %v", buf, err)
+	}
+	if err := d.ReadMemory(1, 0, buf); !errors.Is(err, vm.ErrIndexOutOfBound) {
+		t.Fatalf("expect %v, got %v", vm.ErrIndexOutOfBound, err)
+	}
+}
@@ -0,0 +1,63 @@
+package vm
+
+import "fmt"
+
+// Engine executes functions defined by modules.
+type Engine int
+
+const (
+	// EngineTree interprets the decoded tree of instructions directly.
+	EngineTree Engine = iota
+	// EngineBytecode compiles functions into flat bytecode on instantiation, whose branches jump to
+	// targets resolved beforehand, then interprets the bytecode. Functions using try_table are left
+	// to the tree engine.
+	EngineBytecode
+	// EngineRegister compiles functions into code of a register machine on instantiation, whose
+	// instructions read locals and constants in place instead of copying them onto the operand
+	// stack, then interprets the code. Functions using try_table are left to the tree engine. Hooks
+	// see instructions folded into others as run along with the next one, on its operands.
+	EngineRegister
+	// EngineClosure compiles functions into closures on instantiation by way of bytecode, which
+	// capture immediates of instructions and run one after another. Functions using try_table are
+	// left to the tree engine.
+	EngineClosure
+	// EngineJIT compiles functions into machine code on instantiation by way of bytecode, which is
+	// only supported on amd64 Linux. Calls, returns and instructions not run natively exit to Go,
+	// where they run as by bytecode, and so do traps. Functions using try_table are left to the
+	// tree engine, and frames go on by bytecode once hooked.
+	EngineJIT
+)
+
+// Options configures VMs.
+type Options struct {
+	Engine Engine
+}
+
+func (e Engine) String() string {
+	switch e {
+	case EngineTree:
+		return "tree"
+	case EngineBytecode:
+		return "bytecode"
+	case EngineRegister:
+		return "register"
+	case EngineClosure:
+		return "closure"
+	case EngineJIT:
+		return "jit"
+	default:
+		return fmt.Sprintf("Engine(%d)", int(e))
+	}
+}
+
+// ParseEngine parses engines named as Engine.String.
+func ParseEngine(s string) (Engine, error) {
+	for _, v := range []Engine{EngineTree, EngineBytecode, EngineRegister, EngineClosure,
+		EngineJIT} {
+		if v.String() == s {
+			return v, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown engine '%s': %w", s, ErrBadArgs)
+}
@@ -0,0 +1,316 @@
+package vm_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// testEngines are engines compared with the tree engine.
+var testEngines = []vm.Engine{vm.EngineBytecode, vm.EngineRegister, vm.EngineClosure}
+
+func TestEngines(t *testing.T) {
+	m := newEngineTestModule()
+	args := [][2]uint64{
+		{0, 0}, {1, 0}, {7, 3}, {0xFFFFFFF9, 3}, {0x80000000, 0xFFFFFFFF},
+		{1 << 63, math.MaxUint64}, {math.MaxUint64, 2}, {65532, 5}, {12, 65530}, {70000, 1},
+		{0x3FF8000000000000, 0xC004000000000000}, {0x7FF0000000000000, 0x7FF8000000000000},
+		{0x4020000000000000, 0x4004000000000000},
+	}
+
+	// modules built in Go run the same, whose offsets of instructions are all 0
+	offsetless := m
+	offsetless.offsetless = true
+
+	for _, engine := range testEngines {
+		for _, m := range []testModule{m, offsetless} {
+			tree, other := newTestVM(t, m, vm.EngineTree), newTestVM(t, m, engine)
+			for _, f := range m.funcs {
+				for _, v := range args {
+					in := []types.WasmVal{testVal(f.params[0], v[0]), testVal(f.params[1], v[1])}
+					expect, expectErr := tree.InvokeFunc(f.name, in...)
+					got, err := other.InvokeFunc(f.name, in...)
+					if (err == nil) != (expectErr == nil) ||
+						err != nil && testCause(err).Error() != testCause(expectErr).Error() {
+						t.Fatalf("%s: %s%v: expect error %v, got %v", engine, f.name, in, expectErr,
+							err)
+					}
+					if expectErr == nil && !testEqual(expect, got) {
+						t.Fatalf("%s: %s%v: expect %v, got %v", engine, f.name, in, expect, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkEngines(b *testing.B) {
+	m := testModule{funcs: []testFunc{
+		// sums i for i < n
+		{"loop", []byte{i32}, []byte{i64}, []byte{i32, i64}, []byte{
+			0x02, 0x40, 0x03, 0x40,
+			0x20, 1, 0x20, 0, 0x4E, 0x0D, 1,
+			0x20, 2, 0x20, 1, 0xAD, 0x7C, 0x21, 2,
+			0x20, 1, 0x41, 1, 0x6A, 0x21, 1, 0x0C, 0,
+			0x0B, 0x0B, 0x20, 2,
+		}},
+		// factorizes n by trial division, returning the sum of its prime factors
+		{"factorize", []byte{i64}, []byte{i64}, []byte{i64, i64}, []byte{
+			0x42, 2, 0x21, 1,
+			0x02, 0x40, 0x03, 0x40,
+			0x20, 1, 0x20, 1, 0x7E, 0x20, 0, 0x56, 0x0D, 1, // br_if 1 (d*d > n)
+			0x20, 0, 0x20, 1, 0x82, 0x50, 0x04, 0x40, // if n%d == 0
+			0x20, 0, 0x20, 1, 0x80, 0x21, 0, 0x20, 2, 0x20, 1, 0x7C, 0x21, 2,
+			0x05,
+			0x20, 1, 0x42, 1, 0x7C, 0x21, 1,
+			0x0B, 0x0C, 0,
+			0x0B, 0x0B,
+			0x20, 2, 0x20, 0, 0x7C,
+		}},
+		// computes the n-th Fibonacci number recursively
+		{"fib", []byte{i32}, []byte{i32}, nil, []byte{
+			0x20, 0, 0x41, 2, 0x48, 0x04, i32, 0x20, 0,
+			0x05, 0x20, 0, 0x41, 1, 0x6B, 0x10, 3, 0x20, 0, 0x41, 2, 0x6B, 0x10, 3, 0x6A,
+			0x0B,
+		}},
+	}}
+
+	workloads := []struct {
+		name string
+		arg  types.WasmVal
+	}{
+		{"loop", int32(100000)},
+		{"factorize", int64(1000000007 * 3 * 5)},
+		{"fib", int32(20)},
+	}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(b, m, engine)
+		for _, w := range workloads {
+			b.Run(fmt.Sprintf("%s/%s", engine, w.name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := instance.InvokeFunc(w.name, w.arg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// newEngineTestModule makes a module of funcs taking 2 params, which are compiled natively by
+// engines, and then left to the tree engine instruction by instruction or as a whole.
+func newEngineTestModule() testModule {
+	out := []testFunc{
+		{"i32.ops", []byte{i32, i32}, []byte{i32}, nil, testOps(i32, 0x6A, 0x79)},
+		{"i64.ops", []byte{i64, i64}, []byte{i64}, nil, testOps(i64, 0x7C, 0x8B)},
+		{"i32.cmp", []byte{i32, i32}, []byte{i32}, nil, testOps(i32, 0x46, 0x50)},
+		{"i64.cmp", []byte{i64, i64}, []byte{i32}, nil, testOps(i32, 0x51, 0x5B)},
+		{"i32.div_s", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 1, 0x6D}},
+		{"i32.rem_s", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 1, 0x6F}},
+		{"i64.div_u", []byte{i64, i64}, []byte{i64}, nil, []byte{0x20, 0, 0x20, 1, 0x80}},
+		{"i64.rem_s", []byte{i64, i64}, []byte{i64}, nil, []byte{0x20, 0, 0x20, 1, 0x81}},
+		{"conv", []byte{i64, i64}, []byte{i64}, nil, []byte{
+			0x20, 0, 0xA7, 0xAC, 0x20, 1, 0xA7, 0xAD, 0x7C, 0x20, 0, 0x50, 0xAD, 0x7C,
+		}},
+		{"select", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 1, 0x20, 0, 0x1B}},
+		{"f64.ops", []byte{f64, f64}, []byte{f64}, nil, []byte{
+			0x20, 0, 0x20, 1, 0xA0, 0x20, 1, 0xA2, 0x20, 0, 0xA1, 0x20, 1, 0xA3,
+		}},
+		{"f32.ops", []byte{f32, f32}, []byte{f32}, nil, []byte{
+			0x20, 0, 0x20, 1, 0x92, 0x20, 1, 0x94, 0x20, 0, 0x93, 0x20, 1, 0x95,
+		}},
+		// sums i for i < n in a loop running out of fuel
+		{"loop", []byte{i32, i32}, []byte{i64}, []byte{i64}, []byte{
+			0x02, 0x40, 0x03, 0x40,
+			0x20, 1, 0x20, 0, 0x4E, 0x0D, 1, // br_if 1 (i >= n)
+			0x20, 2, 0x20, 1, 0xAD, 0x7C, 0x21, 2,
+			0x20, 1, 0x41, 1, 0x6A, 0x21, 1, 0x0C, 0, // br 0
+			0x0B, 0x0B, 0x20, 2,
+		}},
+		{"br_table", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x02, 0x7F, 0x02, 0x7F, 0x02, 0x7F, 0x41, 10, 0x20, 0, 0x0E, 2, 0, 1, 2,
+			0x0B, 0x20, 1, 0x6A, 0x0B, 0x41, 3, 0x6C, 0x0B,
+		}},
+		// stores a at b, then loads it back by bytes, halves and words
+		{"memory", []byte{i32, i32}, []byte{i64}, nil, []byte{
+			0x20, 1, 0x20, 0, 0x36, 2, 0,
+			0x20, 1, 0x30, 0, 1, 0x20, 1, 0x31, 0, 2, 0x7C, 0x20, 1, 0x32, 1, 0,
+			0x7C, 0x20, 1, 0x35, 2, 0, 0x7C, 0x20, 1, 0x29, 3, 0, 0x7C,
+		}},
+		{"host", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 0, 0x10, 0, 0x20, 1, 0x6A}},
+		{"call", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x20, 0, 0x20, 1, 0x10, 10, 0x20, 1, 0x20, 0, 0x10, 10, 0x6B,
+		}},
+		{"i32.div_u", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 1, 0x6E}},
+		{"i32.rem_u", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 1, 0x70}},
+		{"i64.div_s", []byte{i64, i64}, []byte{i64}, nil, []byte{0x20, 0, 0x20, 1, 0x7F}},
+		{"i64.rem_u", []byte{i64, i64}, []byte{i64}, nil, []byte{0x20, 0, 0x20, 1, 0x82}},
+		{"i32.unary", []byte{i32, i32}, []byte{i32}, nil, testUnaryOps(i32,
+			[]byte{0x45}, []byte{0x67}, []byte{0x68}, []byte{0x69}, []byte{0xC0}, []byte{0xC1})},
+		{"i64.unary", []byte{i64, i64}, []byte{i64}, nil, testUnaryOps(i64,
+			[]byte{0x50, 0xAD}, []byte{0x79}, []byte{0x7A}, []byte{0x7B}, []byte{0xC2}, []byte{0xC3},
+			[]byte{0xC4})},
+		{"i32.rot", []byte{i32, i32}, []byte{i32}, nil, testBinaryOps(i32, 0x77, 0x79)},
+		{"i64.rot", []byte{i64, i64}, []byte{i64}, nil, testBinaryOps(i64, 0x89, 0x8B)},
+		{"f32.unary", []byte{f32, f32}, []byte{i32}, nil, testUnaryOps(f32,
+			[]byte{0x8B}, []byte{0x8C}, []byte{0x8D}, []byte{0x8E}, []byte{0x8F}, []byte{0x90},
+			[]byte{0x91})},
+		{"f64.unary", []byte{f64, f64}, []byte{i64}, nil, testUnaryOps(f64,
+			[]byte{0x99}, []byte{0x9A}, []byte{0x9B}, []byte{0x9C}, []byte{0x9D}, []byte{0x9E},
+			[]byte{0x9F})},
+		{"f32.binary", []byte{f32, f32}, []byte{i32}, nil, testBinaryOps(f32, 0x92, 0x99)},
+		{"f64.binary", []byte{f64, f64}, []byte{i64}, nil, testBinaryOps(f64, 0xA0, 0xA7)},
+		{"f32.cmp", []byte{f32, f32}, []byte{i32}, nil, testBinaryOps(i32, 0x5B, 0x61)},
+		{"f64.cmp", []byte{f64, f64}, []byte{i32}, nil, testBinaryOps(i32, 0x61, 0x67)},
+		{"f32.trunc", []byte{f32, f32}, []byte{i64}, nil, testUnaryOps(i64,
+			[]byte{0xA8, 0xAD}, []byte{0xA9, 0xAD}, []byte{0xAE}, []byte{0xAF},
+			[]byte{0xFC, 0, 0xAD}, []byte{0xFC, 1, 0xAD}, []byte{0xFC, 4}, []byte{0xFC, 5})},
+		{"f64.trunc", []byte{f64, f64}, []byte{i64}, nil, testUnaryOps(i64,
+			[]byte{0xAA, 0xAD}, []byte{0xAB, 0xAD}, []byte{0xB0}, []byte{0xB1},
+			[]byte{0xFC, 2, 0xAD}, []byte{0xFC, 3, 0xAD}, []byte{0xFC, 6}, []byte{0xFC, 7})},
+		// converts a of i32 and b of i64, then reinterprets them back
+		{"f32.convert", []byte{i32, i64}, []byte{i32}, nil, testFold(f32,
+			[]byte{0x20, 0, 0xB2}, []byte{0x20, 0, 0xB3}, []byte{0x20, 1, 0xB4},
+			[]byte{0x20, 1, 0xB5}, []byte{0x20, 1, 0xB9, 0xB6}, []byte{0x20, 0, 0xBE})},
+		{"f64.convert", []byte{i32, i64}, []byte{i64}, nil, testFold(f64,
+			[]byte{0x20, 0, 0xB7}, []byte{0x20, 0, 0xB8}, []byte{0x20, 1, 0xB9},
+			[]byte{0x20, 1, 0xBA}, []byte{0x20, 0, 0xB2, 0xBB}, []byte{0x20, 1, 0xBF})},
+		// stores a at b by all sizes, then loads them back by all sizes
+		{"memory.sizes", []byte{i32, i32}, []byte{i64}, nil, []byte{
+			0x20, 1, 0x20, 0, 0x3A, 0, 0,
+			0x20, 1, 0x20, 0, 0x3B, 1, 1,
+			0x20, 1, 0x20, 0, 0xAD, 0x3C, 0, 3,
+			0x20, 1, 0x20, 0, 0xAC, 0x3D, 1, 4,
+			0x20, 1, 0x20, 0, 0xAC, 0x3E, 2, 6,
+			0x20, 1, 0x20, 0, 0xBE, 0x38, 2, 10,
+			0x20, 1, 0x20, 1, 0x2B, 3, 2, 0x39, 3, 16,
+			0x20, 1, 0x2C, 0, 0, 0xAC,
+			0x20, 1, 0x2D, 0, 1, 0xAD, 0x85,
+			0x20, 1, 0x2E, 1, 2, 0xAC, 0x85,
+			0x20, 1, 0x2F, 1, 3, 0xAD, 0x85,
+			0x20, 1, 0x33, 1, 5, 0x85,
+			0x20, 1, 0x34, 2, 6, 0x85,
+			0x20, 1, 0x2A, 2, 10, 0xBC, 0xAD, 0x85,
+			0x20, 1, 0x29, 3, 16, 0x85,
+		}},
+		{"global", []byte{i64, i64}, []byte{i64}, nil, []byte{
+			0x23, 0, 0x20, 0, 0x7C, 0x20, 1, 0x85, 0x24, 0, 0x23, 0,
+		}},
+		{"memory.grow", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x20, 0, 0x40, 0, 0x3F, 0, 0x6A,
+		}},
+	}
+
+	// funcs below use instructions left to the tree engine, i.e., try_table for the whole func,
+	// and the others one by one
+	out = append(out, []testFunc{
+		// calls double, or i32.div_s of a mismatched type, by the index b
+		{"call_indirect", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 1, 0x11, 0, 0}},
+		{"return_call", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 1, 0x12, 6}},
+		// fills 8 bytes at b with a, then loads them
+		{"memory.fill", []byte{i32, i32}, []byte{i64}, nil, []byte{
+			0x20, 1, 0x20, 0, 0x41, 8, 0xFC, 0x0B, 0, 0x20, 1, 0x29, 3, 0,
+		}},
+		{"atomic", []byte{i32, i32}, []byte{i32}, nil, []byte{0x20, 1, 0x20, 0, 0xFE, 0x1E, 2, 0}},
+		{"i31", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x20, 0, 0xFB, 0x1C, 0xFB, 0x1D, 0x20, 1, 0x6A,
+		}},
+		// throws a uncaught if a < b
+		{"throw", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x20, 0, 0x20, 1, 0x48, 0x04, 0x40, 0x20, 0, 0x08, 0, 0x0B, 0x20, 0,
+		}},
+		// catches a thrown
+		{"try_table", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x02, i32, 0x1F, 0x40, 1, 0x00, 0, 0, 0x20, 0, 0x08, 0, 0x0B, 0x00, 0x0B, 0x20, 1, 0x6A,
+		}},
+		// catches a thrown by the callee if a < b, or thrown by itself otherwise, and adds b
+		{"try_table.rethrow", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x02, i32, 0x1F, 0x40, 1, 0x00, 0, 0,
+			0x20, 0, 0x20, 0, 0x20, 1, 0x10, 44, 0x08, 0, 0x0B, 0x00, 0x0B, 0x20, 1, 0x6A,
+		}},
+		// calls into the func catching exceptions
+		{"call.try_table", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x20, 1, 0x20, 0, 0x10, 45, 0x20, 0, 0x6A,
+		}},
+	}...)
+
+	// the tag carries an i32 typed after funcs
+	tagType := wasmtest.Uleb(uint64(len(out) + 1))
+	return testModule{
+		funcs: out,
+		types: [][]byte{wasmtest.FuncType([]byte{i32}, nil)},
+		sections: map[byte][]byte{
+			4:  wasmtest.Vec([]byte{0x70, 0x00, 2}),
+			5:  wasmtest.Vec([]byte{0x00, 1}),
+			6:  wasmtest.Vec([]byte{i64, 1, 0x42, 0, 0x0B}),
+			9:  wasmtest.Vec([]byte{0x00, 0x41, 0, 0x0B, 2, 0, 5}),
+			13: wasmtest.Vec(append([]byte{0x00}, tagType...)),
+		},
+	}
+}
+
+// testUnaryOps folds results of the unary ops on both params by testFold, where each op is a
+// sequence of instructions resulting in a value typed result.
+func testUnaryOps(result byte, ops ...[]byte) []byte {
+	var exprs [][]byte
+	for _, v := range ops {
+		exprs = append(exprs, append([]byte{0x20, 0}, v...), append([]byte{0x20, 1}, v...))
+	}
+	return testFold(result, exprs...)
+}
+
+// testBinaryOps folds results typed result of the binary ops in [from, to) on params in both
+// orders by testFold.
+func testBinaryOps(result byte, from, to byte) []byte {
+	var exprs [][]byte
+	for op := from; op < to; op++ {
+		exprs = append(exprs, []byte{0x20, 0, 0x20, 1, op}, []byte{0x20, 1, 0x20, 0, op})
+	}
+	return testFold(result, exprs...)
+}
+
+// testFold folds values of exprs typed t by xors, where floats are reinterpreted as integers of
+// the same size first.
+func testFold(t byte, exprs ...[]byte) []byte {
+	reinterpret, xor := []byte(nil), byte(0x73)
+	switch t {
+	case i64:
+		xor = 0x85
+	case f32:
+		reinterpret = []byte{0xBC}
+	case f64:
+		reinterpret, xor = []byte{0xBD}, 0x85
+	}
+
+	var out []byte
+	for i, v := range exprs {
+		out = append(append(out, v...), reinterpret...)
+		if i > 0 {
+			out = append(out, xor)
+		}
+	}
+	return out
+}
+
+// testOps folds results of the binary ops in [from, to) on both params by shifts and xors.
+func testOps(result byte, from, to byte) []byte {
+	fold := []byte{0x41, 1, 0x74, 0x20, 0, 0x20, 1, 0, 0x73}
+	if result == i64 {
+		fold = []byte{0x42, 1, 0x86, 0x20, 0, 0x20, 1, 0, 0x85}
+	}
+
+	out := []byte{0x20, 0, 0x20, 1, from}
+	for op := from + 1; op < to; op++ {
+		if op >= 0x6D && op <= 0x70 || op >= 0x7F && op <= 0x82 {
+			continue // divisions trap
+		}
+		fold[7] = op
+		out = append(out, fold...)
+	}
+	return out
+}
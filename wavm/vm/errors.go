@@ -3,14 +3,24 @@ package vm
 import "errors"
 
 var (
-	ErrBadArgs          = errors.New("bad args")
-	ErrBadSubOpcode     = errors.New("bad sub-opcode saturated trunc")
-	ErrBadValue         = errors.New("bad value")
-	ErrBadValueType     = errors.New("bad value type")
-	ErrIndexOutOfBound  = errors.New("index out of bound")
-	ErrMissingCallFrame = errors.New("miss call frame")
-	ErrNoStartFunc      = errors.New("missing start func")
-	ErrOperandPop       = errors.New("pop operands")
-	ErrUnimplemented    = errors.New("not implemented")
-	ErrVarImmutable     = errors.New("immutable variables")
+	ErrArrayOutOfBound     = errors.New("array index out of bound")
+	ErrArrayTooLarge       = errors.New("array too large")
+	ErrBadArgs             = errors.New("bad args")
+	ErrBadSubOpcode        = errors.New("bad sub-opcode saturated trunc")
+	ErrBadValue            = errors.New("bad value")
+	ErrBadValueType        = errors.New("bad value type")
+	ErrCastFailure         = errors.New("cast failure")
+	ErrExpectedShared      = errors.New("expected shared memory")
+	ErrIndexOutOfBound     = errors.New("index out of bound")
+	ErrIntegerDivideByZero = errors.New("integer divide by zero")
+	ErrIntegerOverflow     = errors.New("integer overflow")
+	ErrMemoryTooLarge      = errors.New("memory too large")
+	ErrMissingCallFrame    = errors.New("miss call frame")
+	ErrNoStartFunc         = errors.New("missing start func")
+	ErrNullReference       = errors.New("null reference")
+	ErrOperandPop          = errors.New("pop operands")
+	ErrUnalignedAtomic     = errors.New("unaligned atomic")
+	ErrUnimplemented       = errors.New("not implemented")
+	ErrUnreachable         = errors.New("unreachable")
+	ErrVarImmutable        = errors.New("immutable variables")
 )
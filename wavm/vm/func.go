@@ -6,10 +6,15 @@ import (
 )
 
 type Func struct {
+	idx        types.FuncIdx // index into funcs of ctx, only for internal functions
 	type_      types.FuncType
 	code       types.Code
 	externalFn linker.Function // efn is an external function
 	ctx        *VM
+	bytecode   *bcFunc  // nil unless compiled to bytecode
+	register   *rgFunc  // nil unless compiled to register code
+	closure    *clFunc  // nil unless compiled into closures
+	jit        *jitFunc // nil unless compiled into machine code
 }
 
 func (f Func) Call(args ...types.WasmVal) ([]types.WasmVal, error) {
@@ -27,6 +27,6 @@ func newExternalFunc(t types.FuncType, f linker.Function, ctx *VM) Func {
 	return Func{type_: t, externalFn: f, ctx: ctx}
 }
 
-func newInternalFunc(t types.FuncType, code types.Code, ctx *VM) Func {
-	return Func{type_: t, code: code, ctx: ctx}
+func newInternalFunc(idx types.FuncIdx, t types.FuncType, code types.Code, ctx *VM) Func {
+	return Func{idx: idx, type_: t, code: code, ctx: ctx}
 }
@@ -0,0 +1,209 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// Structs and arrays allocated by GC instructions live in the heap, as do exceptions caught by *_ref
+// clauses of try_table. References to them are handles
+// of heap.objects shifted left by 1, leaving 0 to null and odd ones to i31 values. The VM drops
+// handles of objects unreachable from wasm on collection, after which the Go runtime reclaims the
+// objects as any other value.
+
+// minHeapThreshold is the least #(live objects) triggering a collection.
+const minHeapThreshold = 1024
+
+// maxArrayLen bounds lengths of arrays to allocate, beyond which allocation traps.
+const maxArrayLen = 1 << 28
+
+type heap struct {
+	objects   []*object
+	free      []uint32 // indices of collected objects for reuse
+	live      int
+	threshold int
+}
+
+type object struct {
+	typeIdx types.TypeIdx
+	fields  []uint64          // fields of structs, or elements of arrays
+	exn     *linker.Exception // the exception exnrefs refer to, if not nil
+}
+
+func (h *heap) alloc(obj *object) uint64 {
+	var idx uint32
+	if n := len(h.free); n > 0 {
+		idx, h.free = h.free[n-1], h.free[:n-1]
+		h.objects[idx] = obj
+	} else {
+		idx = uint32(len(h.objects))
+		h.objects = append(h.objects, obj)
+	}
+	h.live++
+
+	return (uint64(idx) + 1) << 1
+}
+
+func (h *heap) full() bool {
+	return h.live >= h.threshold && h.live >= minHeapThreshold
+}
+
+// get returns the object ref refers to, which fails for null, i31 values and stale handles.
+func (h *heap) get(ref uint64) (*object, bool) {
+	if ref == 0 || ref&1 != 0 {
+		return nil, false
+	} else if idx := ref>>1 - 1; idx < uint64(len(h.objects)) && h.objects[idx] != nil {
+		return h.objects[idx], true
+	}
+
+	return nil, false
+}
+
+// collectGarbage drops objects unreachable from the operand stack and globals.
+// Slots of the operand stack are untyped, and thus scanned conservatively, i.e., any slot looking
+// like a live handle keeps the object alive.
+func (vm *VM) collectGarbage() {
+	h := &vm.heap
+	marked := make([]bool, len(h.objects))
+
+	var pending []*object
+	mark := func(ref uint64) {
+		if obj, ok := h.get(ref); ok && !marked[ref>>1-1] {
+			marked[ref>>1-1] = true
+			pending = append(pending, obj)
+		}
+	}
+
+	for _, v := range vm.OperandStack.slots {
+		mark(v)
+	}
+	for _, g := range vm.globals {
+		mark(g.GetAsUint64())
+	}
+
+	for len(pending) > 0 {
+		obj := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if obj.exn != nil {
+			for _, v := range obj.exn.Args {
+				if ref, ok := v.(uint64); ok {
+					mark(ref)
+				}
+			}
+			continue
+		}
+
+		t := vm.module.Types[obj.typeIdx]
+		for i, v := range obj.fields {
+			f := t.Fields[0]
+			if t.Tag == types.TypeTagStruct {
+				f = t.Fields[i]
+			}
+			if types.IsRefType(f.StorageType) {
+				mark(v)
+			}
+		}
+	}
+
+	h.live = 0
+	for i, obj := range h.objects {
+		if obj == nil {
+			continue
+		} else if marked[i] {
+			h.live++
+			continue
+		}
+		h.objects[i] = nil
+		h.free = append(h.free, uint32(i))
+	}
+	h.threshold = 2 * h.live
+}
+
+// newObject allocates the struct or array created by the allocation instruction arg out of
+// operands. It never collects garbage, since nothing else may refer to references in operands.
+func (vm *VM) newObject(arg types.GCArg, operands []uint64) (uint64, error) {
+	t := vm.module.Types[arg.TypeIdx]
+
+	var fields []uint64
+	switch arg.SubOpcode {
+	case types.GCStructNew:
+		fields = make([]uint64, len(t.Fields))
+		for i, v := range operands {
+			fields[i] = packField(t.Fields[i].StorageType, v)
+		}
+	case types.GCStructNewDefault:
+		fields = make([]uint64, len(t.Fields))
+	case types.GCArrayNew, types.GCArrayNewDefault:
+		n := operands[len(operands)-1]
+		if n > maxArrayLen {
+			return 0, fmt.Errorf("array of %d elements: %w", n, ErrArrayTooLarge)
+		}
+		fields = make([]uint64, n)
+		if arg.SubOpcode == types.GCArrayNew {
+			v := packField(t.Fields[0].StorageType, operands[0])
+			for i := range fields {
+				fields[i] = v
+			}
+		}
+	case types.GCArrayNewFixed:
+		fields = make([]uint64, len(operands))
+		for i, v := range operands {
+			fields[i] = packField(t.Fields[0].StorageType, v)
+		}
+	default:
+		return 0, fmt.Errorf("sub-opcode 0x%x: %w", arg.SubOpcode, ErrBadSubOpcode)
+	}
+
+	return vm.heap.alloc(&object{typeIdx: arg.TypeIdx, fields: fields}), nil
+}
+
+// getNewOperandsLen returns #(operands) taken by the allocation instruction arg.
+func getNewOperandsLen(t types.FuncType, arg types.GCArg) int {
+	switch arg.SubOpcode {
+	case types.GCStructNew:
+		return len(t.Fields)
+	case types.GCArrayNew:
+		return 2
+	case types.GCArrayNewDefault:
+		return 1
+	case types.GCArrayNewFixed:
+		return int(arg.Idx)
+	default:
+	}
+
+	return 0
+}
+
+// newI31 returns the i31 reference to the lower 31 bits of v.
+func newI31(v uint32) uint64 {
+	return uint64(v&0x7FFFFFFF)<<1 | 1
+}
+
+// packField truncates v to fit fields of packed storage type t.
+func packField(t types.ValueType, v uint64) uint64 {
+	switch t {
+	case types.StorageTypeI8:
+		return v & 0xFF
+	case types.StorageTypeI16:
+		return v & 0xFFFF
+	default:
+	}
+
+	return v
+}
+
+// unpackField extends the field v of packed storage type t to i32, by sign if signed.
+func unpackField(t types.ValueType, v uint64, signed bool) uint64 {
+	switch {
+	case t == types.StorageTypeI8 && signed:
+		return uint64(uint32(int32(int8(v))))
+	case t == types.StorageTypeI16 && signed:
+		return uint64(uint32(int32(int16(v))))
+	default:
+	}
+
+	return v
+}
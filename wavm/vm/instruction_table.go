@@ -50,6 +50,20 @@ func init() {
 	// @TODO: sort
 	instructionTable[types.OpcodeCall] = Call // hack!
 	instructionTable[types.OpcodeCallIndirect] = CallIndirect
+	instructionTable[types.OpcodeThrow] = Throw
+	instructionTable[types.OpcodeThrowRef] = ThrowRef
+	instructionTable[types.OpcodeTryTable] = TryTable
+	instructionTable[types.OpcodeCallRef] = CallRef
+	instructionTable[types.OpcodeReturnCallRef] = ReturnCallRef
+	instructionTable[types.OpcodeRefNull] = RefNull
+	instructionTable[types.OpcodeRefIsNull] = RefIsNull
+	instructionTable[types.OpcodeRefFunc] = RefFunc
+	instructionTable[types.OpcodeRefEq] = RefEq
+	instructionTable[types.OpcodeRefAsNonNull] = RefAsNonNull
+	instructionTable[types.OpcodeBrOnNull] = BreakOnNull
+	instructionTable[types.OpcodeBrOnNonNull] = BreakOnNonNull
+	instructionTable[types.OpcodeReturnCall] = ReturnCall
+	instructionTable[types.OpcodeReturnCallIndirect] = ReturnCallIndirect
 	instructionTable[types.OpcodeDrop] = Drop
 	instructionTable[types.OpcodeSelect] = Select
 	instructionTable[types.OpcodeI32Const] = I32Const
@@ -185,4 +199,6 @@ func init() {
 	instructionTable[types.OpcodeI64Extend16S] = I64Extend16S
 	instructionTable[types.OpcodeI64Extend32S] = I64Extend32S
 	instructionTable[types.OpcodeTruncSat] = TruncSat
+	instructionTable[types.OpcodeGC] = GC
+	instructionTable[types.OpcodeAtomic] = Atomic
 }
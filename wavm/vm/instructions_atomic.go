@@ -0,0 +1,51 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+func Atomic(vm *VM, arg interface{}) error {
+	a, ok := arg.(types.AtomicArg)
+	if !ok {
+		return fmt.Errorf("expect types.AtomicArg: %w", ErrBadArgs)
+	}
+
+	if a.SubOpcode == types.AtomicFence {
+		return nil
+	}
+
+	mem, ok := vm.memories[a.MemoryArg.MemoryIdx].(linker.AtomicMemory)
+	if !ok {
+		return fmt.Errorf("memory without atomics: %w", ErrUnimplemented)
+	}
+
+	switch a.SubOpcode {
+	case types.AtomicNotify:
+		return atomicNotify(vm, mem, a.MemoryArg)
+	case types.AtomicWait32:
+		return atomicWait(vm, mem, a.MemoryArg, 32)
+	case types.AtomicWait64:
+		return atomicWait(vm, mem, a.MemoryArg, 64)
+	default:
+	}
+
+	_, bitWidth, ok := types.GetAtomicAccess(a.SubOpcode)
+	if !ok {
+		return fmt.Errorf("sub-opcode 0x%x: %w", a.SubOpcode, ErrBadSubOpcode)
+	}
+
+	switch {
+	case a.SubOpcode <= types.AtomicI64Load32U:
+		return atomicLoad(vm, mem, a.MemoryArg, bitWidth)
+	case a.SubOpcode <= types.AtomicI64Store32:
+		return atomicStore(vm, mem, a.MemoryArg, bitWidth)
+	case a.SubOpcode < types.AtomicI32RmwCmpxchg:
+		return atomicRMW(vm, mem, a.MemoryArg, bitWidth, (a.SubOpcode-types.AtomicI32RmwAdd)/7)
+	default:
+	}
+
+	return atomicCompareExchange(vm, mem, a.MemoryArg, bitWidth)
+}
@@ -0,0 +1,144 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+func atomicCompareExchange(vm *VM, mem linker.AtomicMemory, arg types.MemoryArg,
+	bitWidth int) error {
+	expected, replacement, err := vm.popTowUint64()
+	if err != nil {
+		return fmt.Errorf("pop operands: %w", err)
+	}
+
+	offset, err := getOffset(vm, mem, arg)
+	if err != nil {
+		return fmt.Errorf("get offset: %w", err)
+	}
+
+	old, err := mem.AtomicCompareExchange(offset, bitWidth, expected, replacement)
+	if err != nil {
+		return fmt.Errorf("compare and exchange: %w", err)
+	}
+
+	vm.PushUint64(old)
+	return nil
+}
+
+func atomicLoad(vm *VM, mem linker.AtomicMemory, arg types.MemoryArg, bitWidth int) error {
+	offset, err := getOffset(vm, mem, arg)
+	if err != nil {
+		return fmt.Errorf("get offset: %w", err)
+	}
+
+	v, err := mem.AtomicLoad(offset, bitWidth)
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+
+	vm.PushUint64(v)
+	return nil
+}
+
+func atomicNotify(vm *VM, mem linker.AtomicMemory, arg types.MemoryArg) error {
+	count, ok := vm.PopUint32()
+	if !ok {
+		return fmt.Errorf("pop count: %w", ErrOperandPop)
+	}
+
+	offset, err := getOffset(vm, mem, arg)
+	if err != nil {
+		return fmt.Errorf("get offset: %w", err)
+	}
+
+	n, err := mem.AtomicNotify(offset, count)
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+
+	vm.PushUint32(n)
+	return nil
+}
+
+func atomicRMW(vm *VM, mem linker.AtomicMemory, arg types.MemoryArg, bitWidth int,
+	group byte) error {
+	x, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop operand: %w", ErrOperandPop)
+	}
+
+	offset, err := getOffset(vm, mem, arg)
+	if err != nil {
+		return fmt.Errorf("get offset: %w", err)
+	}
+
+	var op func(uint64) uint64
+	switch group {
+	case 0:
+		op = func(v uint64) uint64 { return v + x }
+	case 1:
+		op = func(v uint64) uint64 { return v - x }
+	case 2:
+		op = func(v uint64) uint64 { return v & x }
+	case 3:
+		op = func(v uint64) uint64 { return v | x }
+	case 4:
+		op = func(v uint64) uint64 { return v ^ x }
+	default:
+		op = func(uint64) uint64 { return x }
+	}
+
+	old, err := mem.AtomicRMW(offset, bitWidth, op)
+	if err != nil {
+		return fmt.Errorf("read-modify-write: %w", err)
+	}
+
+	vm.PushUint64(old)
+	return nil
+}
+
+func atomicStore(vm *VM, mem linker.AtomicMemory, arg types.MemoryArg, bitWidth int) error {
+	v, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop operand: %w", ErrOperandPop)
+	}
+
+	offset, err := getOffset(vm, mem, arg)
+	if err != nil {
+		return fmt.Errorf("get offset: %w", err)
+	}
+
+	if err := mem.AtomicStore(offset, bitWidth, v); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+
+	return nil
+}
+
+func atomicWait(vm *VM, mem linker.AtomicMemory, arg types.MemoryArg, bitWidth int) error {
+	timeout, ok := vm.PopInt64()
+	if !ok {
+		return fmt.Errorf("pop timeout: %w", ErrOperandPop)
+	}
+
+	expected, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop expected value: %w", ErrOperandPop)
+	}
+
+	offset, err := getOffset(vm, mem, arg)
+	if err != nil {
+		return fmt.Errorf("get offset: %w", err)
+	}
+
+	result, err := mem.AtomicWait(offset, bitWidth, expected, timeout)
+	if err != nil {
+		return fmt.Errorf("wait: %w", err)
+	}
+
+	vm.PushUint32(result)
+	return nil
+}
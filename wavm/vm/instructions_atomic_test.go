@@ -0,0 +1,203 @@
+package vm_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestAtomicCompareExchange(t *testing.T) {
+	type cmpxchg struct {
+		op, typ, align byte
+	}
+	ops := []cmpxchg{
+		{types.AtomicI32RmwCmpxchg, i32, 2},
+		{types.AtomicI64RmwCmpxchg, i64, 3},
+		{types.AtomicI32Rmw8CmpxchgU, i32, 0},
+		{types.AtomicI32Rmw16CmpxchgU, i32, 1},
+		{types.AtomicI64Rmw8CmpxchgU, i64, 0},
+		{types.AtomicI64Rmw16CmpxchgU, i64, 1},
+		{types.AtomicI64Rmw32CmpxchgU, i64, 2},
+	}
+
+	funcs := []testFunc{
+		{"load", []byte{i32}, []byte{i64}, nil, []byte{0x20, 0, 0x29, 3, 0}},
+		{"store", []byte{i32, i64}, nil, nil, []byte{0x20, 0, 0x20, 1, 0x37, 3, 0}},
+	}
+	for _, v := range ops {
+		funcs = append(funcs, testFunc{
+			fmt.Sprintf("cmpxchg%#x", v.op), []byte{i32, v.typ, v.typ}, []byte{v.typ}, nil,
+			[]byte{0x20, 0, 0x20, 1, 0x20, 2, 0xFE, v.op, v.align, 0},
+		})
+	}
+
+	const word = 0x1122334455667788
+	testVector := []struct {
+		op                    byte
+		addr                  int32
+		expected, replacement uint64
+		old, word             uint64
+	}{
+		{types.AtomicI32RmwCmpxchg, 8, 0x55667788, 0xAABBCCDD, 0x55667788, 0x11223344AABBCCDD},
+		{types.AtomicI32RmwCmpxchg, 12, 0x11223345, 0xAABBCCDD, 0x11223344, word},
+		{types.AtomicI64RmwCmpxchg, 8, word, 0x0102030405060708, word, 0x0102030405060708},
+		{types.AtomicI64RmwCmpxchg, 8, word + 1, 0, word, word},
+		// expected and replacement wrap to the accessed width
+		{types.AtomicI32Rmw8CmpxchgU, 9, 0x177, 0xFFAB, 0x77, 0x112233445566AB88},
+		{types.AtomicI32Rmw8CmpxchgU, 11, 0x54, 0xAB, 0x55, word},
+		{types.AtomicI32Rmw16CmpxchgU, 14, 0x1122, 0xBEEF, 0x1122, 0xBEEF334455667788},
+		{types.AtomicI32Rmw16CmpxchgU, 10, 0x5566, 0xBEEF, 0x5566, 0x11223344BEEF7788},
+		{types.AtomicI32Rmw16CmpxchgU, 12, 0x3345, 0xBEEF, 0x3344, word},
+		{types.AtomicI64Rmw8CmpxchgU, 15, 0x11, 0x99, 0x11, 0x9922334455667788},
+		{types.AtomicI64Rmw8CmpxchgU, 8, 0x87, 0x99, 0x88, word},
+		{types.AtomicI64Rmw16CmpxchgU, 8, 0x7788, 0x1234, 0x7788, 0x1122334455661234},
+		{types.AtomicI64Rmw32CmpxchgU, 12, 0x11223344, 0xFFFFFFFFCAFEBABE, 0x11223344,
+			0xCAFEBABE55667788},
+		{types.AtomicI64Rmw32CmpxchgU, 8, 0x55667789, 0, 0x55667788, word},
+	}
+
+	memories := map[string][]byte{
+		"unshared": wasmtest.Vec([]byte{0x00, 1}),
+		"shared":   wasmtest.Vec([]byte{0x03, 1, 1}),
+	}
+	for kind, mem := range memories {
+		m := newTestVM(t, testModule{funcs: funcs, sections: map[byte][]byte{5: mem}}, vm.EngineTree)
+		for _, c := range testVector {
+			if _, err := m.InvokeFunc("store", int32(8), int64(word)); err != nil {
+				t.Fatalf("%s: store: %v", kind, err)
+			}
+
+			f := fmt.Sprintf("cmpxchg%#x", c.op)
+			args := []types.WasmVal{int32(c.addr), int32(c.expected), int32(c.replacement)}
+			if c.op == types.AtomicI64RmwCmpxchg || c.op >= types.AtomicI64Rmw8CmpxchgU {
+				args[1], args[2] = int64(c.expected), int64(c.replacement)
+			}
+
+			old, err := m.InvokeFunc(f, args...)
+			if err != nil {
+				t.Fatalf("%s: %s%v: %v", kind, f, args, err)
+			}
+			var got uint64
+			switch v := old[0].(type) {
+			case int32:
+				got = uint64(uint32(v))
+			case int64:
+				got = uint64(v)
+			}
+			if got != c.old {
+				t.Fatalf("%s: %s%v: expect old %#x, got %#x", kind, f, args, c.old, got)
+			}
+
+			w, err := m.InvokeFunc("load", int32(8))
+			if err != nil {
+				t.Fatalf("%s: load: %v", kind, err)
+			}
+			if got := uint64(w[0].(int64)); got != c.word {
+				t.Fatalf("%s: %s%v: expect word %#x, got %#x", kind, f, args, c.word, got)
+			}
+		}
+
+		_, err := m.InvokeFunc(fmt.Sprintf("cmpxchg%#x", types.AtomicI32RmwCmpxchg), int32(10),
+			int32(0), int32(0))
+		if !errors.Is(err, vm.ErrUnalignedAtomic) {
+			t.Fatalf("%s: expect %v, got %v", kind, vm.ErrUnalignedAtomic, err)
+		}
+	}
+}
+
+func TestAtomicWaitNotify(t *testing.T) {
+	funcs := []testFunc{
+		{"wait32", []byte{i32, i32, i64}, []byte{i32}, nil,
+			[]byte{0x20, 0, 0x20, 1, 0x20, 2, 0xFE, types.AtomicWait32, 2, 0}},
+		{"wait64", []byte{i32, i64, i64}, []byte{i32}, nil,
+			[]byte{0x20, 0, 0x20, 1, 0x20, 2, 0xFE, types.AtomicWait64, 3, 0}},
+		{"notify", []byte{i32, i32}, []byte{i32}, nil,
+			[]byte{0x20, 0, 0x20, 1, 0xFE, types.AtomicNotify, 2, 0}},
+	}
+
+	shared := newTestVM(t, testModule{funcs: funcs, sections: map[byte][]byte{
+		5: wasmtest.Vec([]byte{0x03, 1, 1}),
+	}}, vm.EngineTree)
+	testVector := []struct {
+		f      string
+		args   []types.WasmVal
+		expect int32
+		err    error
+	}{
+		// not-equal, then timed-out
+		{"wait32", []types.WasmVal{int32(8), int32(1), int64(-1)}, 1, nil},
+		{"wait32", []types.WasmVal{int32(8), int32(0), int64(1000)}, 2, nil},
+		{"wait64", []types.WasmVal{int32(8), int64(1), int64(-1)}, 1, nil},
+		{"wait64", []types.WasmVal{int32(8), int64(0), int64(0)}, 2, nil},
+		{"wait64", []types.WasmVal{int32(4), int64(0), int64(0)}, 0, vm.ErrUnalignedAtomic},
+		{"notify", []types.WasmVal{int32(8), int32(1)}, 0, nil},
+		{"notify", []types.WasmVal{int32(types.PageSize), int32(1)}, 0, vm.ErrIndexOutOfBound},
+	}
+	for _, c := range testVector {
+		got, err := shared.InvokeFunc(c.f, c.args...)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%s%v: expect error %v, got %v", c.f, c.args, c.err, err)
+		}
+		if err == nil && got[0].(int32) != c.expect {
+			t.Fatalf("%s%v: expect %d, got %d", c.f, c.args, c.expect, got[0])
+		}
+	}
+
+	unshared := newTestVM(t, testModule{funcs: funcs, sections: map[byte][]byte{
+		5: wasmtest.Vec([]byte{0x00, 1}),
+	}}, vm.EngineTree)
+	if _, err := unshared.InvokeFunc("wait32", int32(8), int32(0), int64(0)); !errors.Is(err,
+		vm.ErrExpectedShared) {
+		t.Fatalf("wait on unshared memory: expect %v, got %v", vm.ErrExpectedShared, err)
+	}
+	if got, err := unshared.InvokeFunc("notify", int32(8), int32(1)); err != nil || got[0] != int32(0) {
+		t.Fatalf("notify on unshared memory: expect 0, got %v, %v", got, err)
+	}
+}
+
+func TestSharedMemoryWaitNotify(t *testing.T) {
+	mem, err := vm.NewSharedMemory(types.Memory{Tag: types.LimitsTagSharedMinMax, Min: 1, Max: 1})
+	if err != nil {
+		t.Fatalf("new shared memory: %v", err)
+	}
+
+	const waiters = 3
+	woken := make(chan uint32, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			result, err := mem.AtomicWait(8, 32, 0, -1)
+			if err != nil {
+				t.Errorf("wait: %v", err)
+			}
+			woken <- result
+		}()
+	}
+
+	// notifies until every waiter blocks and gets woken, one at a time
+	for n := 0; n < waiters; {
+		count, err := mem.AtomicNotify(8, 1)
+		if err != nil {
+			t.Fatalf("notify: %v", err)
+		}
+		if count > 1 {
+			t.Fatalf("expect at most 1 waiter woken, got %d", count)
+		}
+		n += int(count)
+		runtime.Gosched()
+	}
+	for i := 0; i < waiters; i++ {
+		if result := <-woken; result != 0 {
+			t.Fatalf("expect waiters woken with 0, got %d", result)
+		}
+	}
+
+	if n, _ := mem.AtomicNotify(8, math.MaxUint32); n != 0 {
+		t.Fatalf("expect no waiters left, got %d", n)
+	}
+}
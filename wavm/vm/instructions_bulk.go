@@ -0,0 +1,110 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+func DataDrop(vm *VM, arg interface{}) error {
+	vm.data[arg.(types.BulkArg).DataIdx] = nil
+	return nil
+}
+
+func MemoryCopy(vm *VM, arg interface{}) error {
+	a := arg.(types.BulkArg)
+	dst, src := vm.memories[a.MemoryIdx], vm.memories[a.SrcMemoryIdx]
+
+	var (
+		n  uint64
+		ok bool
+	)
+	if dst.Type().Is64() && src.Type().Is64() {
+		n, ok = vm.PopUint64()
+	} else {
+		var v uint32
+		v, ok = vm.PopUint32()
+		n = uint64(v)
+	}
+	if !ok {
+		return fmt.Errorf("pop length: %w", ErrOperandPop)
+	}
+
+	s, ok := vm.popAddress(src)
+	if !ok {
+		return fmt.Errorf("pop source: %w", ErrOperandPop)
+	}
+	d, ok := vm.popAddress(dst)
+	if !ok {
+		return fmt.Errorf("pop destination: %w", ErrOperandPop)
+	}
+
+	if err := checkMemoryAccess(src.Size()*types.PageSize, s, n); err != nil {
+		return fmt.Errorf("bad source: %w", err)
+	}
+	if err := checkMemoryAccess(dst.Size()*types.PageSize, d, n); err != nil {
+		return fmt.Errorf("bad destination: %w", err)
+	}
+
+	// go through a buffer so that overlapping ranges are copied as if by memmove
+	buf := make([]byte, n)
+	if err := src.Read(s, buf); err != nil {
+		return fmt.Errorf("read source: %w", err)
+	}
+	if err := dst.Write(d, buf); err != nil {
+		return fmt.Errorf("write destination: %w", err)
+	}
+
+	return nil
+}
+
+func MemoryFill(vm *VM, arg interface{}) error {
+	mem := vm.memories[arg.(types.BulkArg).MemoryIdx]
+
+	n, ok := vm.popAddress(mem)
+	if !ok {
+		return fmt.Errorf("pop length: %w", ErrOperandPop)
+	}
+	v, ok := vm.PopUint32()
+	if !ok {
+		return fmt.Errorf("pop value: %w", ErrOperandPop)
+	}
+	d, ok := vm.popAddress(mem)
+	if !ok {
+		return fmt.Errorf("pop destination: %w", ErrOperandPop)
+	}
+
+	if err := checkMemoryAccess(mem.Size()*types.PageSize, d, n); err != nil {
+		return err
+	}
+
+	return mem.Write(d, bytes.Repeat([]byte{byte(v)}, int(n)))
+}
+
+func MemoryInit(vm *VM, arg interface{}) error {
+	a := arg.(types.BulkArg)
+	mem, data := vm.memories[a.MemoryIdx], vm.data[a.DataIdx]
+
+	n, ok := vm.PopUint32()
+	if !ok {
+		return fmt.Errorf("pop length: %w", ErrOperandPop)
+	}
+	s, ok := vm.PopUint32()
+	if !ok {
+		return fmt.Errorf("pop source: %w", ErrOperandPop)
+	}
+	d, ok := vm.popAddress(mem)
+	if !ok {
+		return fmt.Errorf("pop destination: %w", ErrOperandPop)
+	}
+
+	if err := checkMemoryAccess(uint64(len(data)), uint64(s), uint64(n)); err != nil {
+		return fmt.Errorf("bad data: %w", err)
+	}
+	if err := checkMemoryAccess(mem.Size()*types.PageSize, d, uint64(n)); err != nil {
+		return fmt.Errorf("bad destination: %w", err)
+	}
+
+	return mem.Write(d, data[s:s+n])
+}
@@ -0,0 +1,23 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+func bulk(vm *VM, arg types.BulkArg) error {
+	switch arg.SubOpcode {
+	case types.BulkMemoryInit:
+		return MemoryInit(vm, arg)
+	case types.BulkDataDrop:
+		return DataDrop(vm, arg)
+	case types.BulkMemoryCopy:
+		return MemoryCopy(vm, arg)
+	case types.BulkMemoryFill:
+		return MemoryFill(vm, arg)
+	default:
+	}
+
+	return fmt.Errorf("sub-opcode 0x%x: %w", arg.SubOpcode, ErrBadSubOpcode)
+}
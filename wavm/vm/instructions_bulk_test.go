@@ -0,0 +1,85 @@
+package vm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestMultiMemory(t *testing.T) {
+	bulk := func(subOpcode byte, imm ...byte) []byte {
+		return append([]byte{0x20, 0, 0x20, 1, 0x20, 2, 0xFC, subOpcode}, imm...)
+	}
+	params := []byte{i32, i32, i32}
+	funcs := []testFunc{
+		{"load0", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x28, 2, 0}},
+		// the memory index follows the alignment flagged by 0x40
+		{"load1", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x28, 0x42, 1, 0}},
+		{"size0", nil, []byte{i32}, nil, []byte{0x3F, 0}},
+		{"size1", nil, []byte{i32}, nil, []byte{0x3F, 1}},
+		{"grow1", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x40, 1}},
+		{"copy10", params, nil, nil, bulk(types.BulkMemoryCopy, 0, 1)},
+		{"fill1", params, nil, nil, bulk(types.BulkMemoryFill, 1)},
+		{"init1", params, nil, nil, bulk(types.BulkMemoryInit, 0, 1)},
+		{"drop", nil, nil, nil, []byte{0xFC, types.BulkDataDrop, 0}},
+	}
+	m := testModule{funcs: funcs, sections: map[byte][]byte{
+		5: wasmtest.Vec([]byte{0x00, 1}, []byte{0x01, 1, 2}),
+		// "wasm" is passive, while "go" goes to memory 1
+		11: wasmtest.Vec([]byte{0x01, 4, 'w', 'a', 's', 'm'},
+			[]byte{0x02, 1, 0x41, 0, 0x0B, 2, 'g', 'o'}),
+		12: wasmtest.Uleb(2),
+	}}
+
+	type call struct {
+		f      string
+		args   []int32
+		expect []int32
+		err    error
+	}
+	calls := []call{
+		{"load1", []int32{0}, []int32{0x6F67}, nil},
+		{"load0", []int32{0}, []int32{0}, nil},
+		{"init1", []int32{4, 0, 4}, nil, nil},
+		{"load1", []int32{4}, []int32{0x6D736177}, nil},
+		{"copy10", []int32{8, 4, 4}, nil, nil},
+		{"load0", []int32{8}, []int32{0x6D736177}, nil},
+		{"load1", []int32{8}, []int32{0}, nil},
+		{"fill1", []int32{types.PageSize - 4, 0xAB, 4}, nil, nil},
+		{"load1", []int32{types.PageSize - 4}, []int32{-0x54545455}, nil},
+		{"fill1", []int32{types.PageSize - 3, 0xAB, 4}, nil, vm.ErrIndexOutOfBound},
+		{"copy10", []int32{0, types.PageSize - 3, 4}, nil, vm.ErrIndexOutOfBound},
+		{"grow1", []int32{1}, []int32{1}, nil},
+		{"copy10", []int32{0, types.PageSize - 3, 4}, nil, nil},
+		{"load0", []int32{0}, []int32{0xABABAB}, nil},
+		{"grow1", []int32{1}, []int32{-1}, nil},
+		{"size0", nil, []int32{1}, nil},
+		{"size1", nil, []int32{2}, nil},
+		{"drop", nil, nil, nil},
+		{"init1", []int32{0, 0, 0}, nil, nil},
+		{"init1", []int32{0, 0, 1}, nil, vm.ErrIndexOutOfBound},
+	}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine)
+		for _, c := range calls {
+			var args []types.WasmVal
+			for _, v := range c.args {
+				args = append(args, v)
+			}
+
+			got, err := instance.InvokeFunc(c.f, args...)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("%s: %s%v: expect error %v, got %v", engine, c.f, c.args, c.err, err)
+			}
+			for i, v := range c.expect {
+				if got[i] != v {
+					t.Fatalf("%s: %s%v: expect %#x, got %#x", engine, c.f, c.args, v, got[i])
+				}
+			}
+		}
+	}
+}
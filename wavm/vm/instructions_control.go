@@ -112,24 +112,9 @@ func Call(vm *VM, arg interface{}) error {
 }
 
 func CallIndirect(vm *VM, arg interface{}) error {
-	elemIdx, ok := vm.PopUint32()
-	if !ok {
-		return fmt.Errorf("no elem idx: %w", ErrOperandPop)
-	}
-
-	f, err := vm.table.GetElem(elemIdx)
+	f, err := getIndirectFunc(vm, arg)
 	if err != nil {
-		return fmt.Errorf("miss elem at %d: %w", elemIdx, err)
-	}
-
-	typeIdx, ok := arg.(uint32)
-	if !ok {
-		return fmt.Errorf("arg should be u32: %w", ErrBadArgs)
-	}
-	funcType := vm.module.Types[typeIdx] // bound check
-
-	if expect, got := funcType.String(), f.Type().String(); expect != got {
-		return fmt.Errorf("mismatch func type: expect %s, got %s, %w", expect, got, ErrBadArgs)
+		return err
 	}
 
 	if v, ok := f.(Func); ok {
@@ -164,6 +149,27 @@ func Return(vm *VM, _ interface{}) error {
 	return Break(vm, uint32(labelIdx))
 }
 
+// ReturnCall calls a function in place of the current one, whose call frame is discarded first so
+// that tail calls never deepen the control stack.
+func ReturnCall(vm *VM, arg interface{}) error {
+	idx, ok := arg.(uint32)
+	if !ok || idx >= uint32(len(vm.funcs)) {
+		return ErrBadArgs
+	}
+
+	return returnCallFunc(vm, vm.funcs[idx])
+}
+
+// ReturnCallIndirect is the counterpart of CallIndirect for tail calls as ReturnCall.
+func ReturnCallIndirect(vm *VM, arg interface{}) error {
+	f, err := getIndirectFunc(vm, arg)
+	if err != nil {
+		return err
+	}
+
+	return returnCallFunc(vm, f)
+}
+
 func Unreachable(vm *VM, _ interface{}) error {
-	panic("unreachble")
+	return ErrUnreachable
 }
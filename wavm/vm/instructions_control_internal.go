@@ -2,6 +2,7 @@ package vm
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/sammyne/mastering-wasm/wavm/linker"
 	"github.com/sammyne/mastering-wasm/wavm/tools"
@@ -22,7 +23,14 @@ func callExternalFunc(vm *VM, f linker.Function) error {
 		return fmt.Errorf("pop args: %w", err)
 	}
 
+	var start time.Time
+	if vm.profiler != nil {
+		start = time.Now()
+	}
 	results, err := f.Call(args...)
+	if vm.profiler != nil {
+		vm.profiler.addHostTime(time.Since(start))
+	}
 	if err != nil {
 		return fmt.Errorf("call go func: %w", err)
 	}
@@ -34,8 +42,49 @@ func callExternalFunc(vm *VM, f linker.Function) error {
 	return nil
 }
 
+func getIndirectFunc(vm *VM, arg interface{}) (linker.Function, error) {
+	elemIdx, ok := vm.PopUint32()
+	if !ok {
+		return nil, fmt.Errorf("no elem idx: %w", ErrOperandPop)
+	}
+
+	f, err := vm.table.GetElem(elemIdx)
+	if err != nil {
+		return nil, fmt.Errorf("miss elem at %d: %w", elemIdx, err)
+	}
+
+	typeIdx, ok := arg.(uint32)
+	if !ok {
+		return nil, fmt.Errorf("arg should be u32: %w", ErrBadArgs)
+	}
+	funcType := vm.module.Types[typeIdx] // bound check
+
+	if expect, got := funcType.String(), f.Type().String(); expect != got {
+		return nil, fmt.Errorf("mismatch func type: expect %s, got %s, %w", expect, got, ErrBadArgs)
+	}
+
+	return f, nil
+}
+
 func callInternalFunc(vm *VM, f Func) error {
+	if f.bytecode != nil {
+		vm.enterBytecode(&f)
+		return nil
+	} else if f.register != nil {
+		vm.enterRegister(&f)
+		return nil
+	} else if f.closure != nil {
+		vm.enterClosure(&f)
+		return nil
+	} else if f.jit != nil {
+		vm.enterJIT(&f)
+		return nil
+	}
+
 	vm.enterBlock(types.OpcodeCall, f.type_, f.code.Expr)
+	if frame, ok := vm.ControlStack.Top(); ok {
+		frame.FuncIdx = f.idx
+	}
 
 	for i := tools.CountLocals(f.code.Locals); i > 0; i-- {
 		vm.PushUint64(0)
@@ -43,3 +92,33 @@ func callInternalFunc(vm *VM, f Func) error {
 
 	return nil
 }
+
+// returnCallFunc unwinds the call frame of the current function down to its base pointer, then
+// calls f with the arguments atop the operand stack at the very same depth.
+func returnCallFunc(vm *VM, f linker.Function) error {
+	args, ok := vm.PopUint64s(len(f.Type().ParamTypes))
+	if !ok {
+		return fmt.Errorf("pop args: %w", ErrOperandPop)
+	}
+
+	frame, depth, ok := vm.TopCallFrame()
+	if !ok {
+		return ErrMissingCallFrame
+	}
+	for i := 0; i <= depth; i++ {
+		vm.ControlStack.Pop()
+	}
+	vm.PopUint64s(vm.OperandStack.Len() - frame.BP)
+
+	// host functions run without a frame, so locals must refer to the caller in the meantime
+	if caller, _, ok := vm.TopCallFrame(); ok {
+		vm.local0Idx = uint32(caller.BP)
+	}
+
+	vm.PushUint64s(args...)
+	if v, ok := f.(Func); ok {
+		return callFunc(vm, v)
+	}
+
+	return callExternalFunc(vm, f)
+}
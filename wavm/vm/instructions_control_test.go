@@ -0,0 +1,65 @@
+package vm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestReturnCall(t *testing.T) {
+	// counts n down to 0, then traps
+	countDown := func(call ...byte) []byte {
+		return append([]byte{0x20, 0, 0x45, 0x04, 0x40, 0x00, 0x0B, 0x20, 0, 0x41, 1, 0x6B}, call...)
+	}
+	funcs := []testFunc{
+		{"count", []byte{i32}, []byte{i32}, nil, countDown(0x12, 1)},
+		{"count.call", []byte{i32}, []byte{i32}, nil, countDown(0x10, 2)},
+		// calls itself by the table with its own type
+		{"count.indirect", []byte{i32}, []byte{i32}, nil, countDown(0x41, 0, 0x13, 3, 0)},
+		// sums up n, n-1, ..., 1 to acc
+		{"sum", []byte{i32, i32}, []byte{i32}, nil, []byte{
+			0x20, 0, 0x45, 0x04, 0x40, 0x20, 1, 0x0F, 0x0B,
+			0x20, 0, 0x41, 1, 0x6B, 0x20, 1, 0x20, 0, 0x6A, 0x12, 4,
+		}},
+	}
+	m := testModule{funcs: funcs, sections: map[byte][]byte{
+		4: wasmtest.Vec([]byte{0x70, 0x00, 1}),
+		9: wasmtest.Vec([]byte{0x00, 0x41, 0, 0x0B, 1, 3}),
+	}}
+
+	testVector := []struct {
+		f      string
+		frames int
+	}{
+		{"count", 1},
+		{"count.call", 1001},
+		{"count.indirect", 1},
+	}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine)
+
+		// frames of tail calls are replaced, so only the trapping one is left
+		for _, c := range testVector {
+			_, err := instance.InvokeFunc(c.f, int32(1000))
+			var trap *vm.Trap
+			if !errors.As(err, &trap) || !errors.Is(err, vm.ErrUnreachable) {
+				t.Fatalf("%s: %s: expect trap of %v, got %v", engine, c.f, vm.ErrUnreachable, err)
+			}
+			if len(trap.Frames) != c.frames {
+				t.Fatalf("%s: %s: expect %d frames, got %d", engine, c.f, c.frames, len(trap.Frames))
+			}
+		}
+
+		n := uint64(100000)
+		got, err := instance.InvokeFunc("sum", int32(n), int32(0))
+		if err != nil {
+			t.Fatalf("%s: sum: %v", engine, err)
+		}
+		if expect := int32(n * (n + 1) / 2); got[0] != expect {
+			t.Fatalf("%s: sum: expect %d, got %d", engine, expect, got[0])
+		}
+	}
+}
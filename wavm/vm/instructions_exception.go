@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// Throw returns the thrown exception as an error, which is caught by VM.loop.
+func Throw(vm *VM, arg interface{}) error {
+	idx, ok := arg.(uint32)
+	if !ok || idx >= uint32(len(vm.tags)) {
+		return ErrBadArgs
+	}
+
+	tag := vm.tags[idx]
+	args, err := vm.popArgs(tag.Type())
+	if err != nil {
+		return fmt.Errorf("pop payload: %w", err)
+	}
+
+	return &linker.Exception{Tag: tag, Args: args}
+}
+
+func ThrowRef(vm *VM, _ interface{}) error {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop exnref: %w", ErrOperandPop)
+	} else if ref == 0 {
+		return ErrNullReference
+	}
+
+	obj, ok := vm.heap.get(ref)
+	if !ok || obj.exn == nil {
+		return fmt.Errorf("exnref %d: %w", ref, ErrBadValue)
+	}
+	return obj.exn
+}
+
+func TryTable(vm *VM, arg interface{}) error {
+	a, ok := arg.(*types.TryTable)
+	if !ok {
+		return fmt.Errorf("expect *types.TryTable: %w", ErrBadArgs)
+	}
+
+	blockType := tools.ParseBlockSig(a.BlockType, vm.module.Types)
+	vm.enterBlock(types.OpcodeTryTable, blockType, a.Instructions)
+
+	f, _ := vm.ControlStack.Top()
+	f.Catches = a.Catches
+
+	return nil
+}
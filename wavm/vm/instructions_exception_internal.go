@@ -0,0 +1,67 @@
+package vm
+
+import (
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// catchException looks for the innermost try_table frame catching exn among frames executed by the
+// loop started at depth, then unwinds to it and branches to the label of the catch clause. If none
+// matches, all frames of the loop are unwound and exn is returned for outer loops to catch.
+func (vm *VM) catchException(exn *linker.Exception, depth int) error {
+	for i := vm.ControlStack.Len() - 1; i >= depth-1; i-- {
+		c, ok := vm.matchCatch(vm.ControlStack.frames[i], exn)
+		if !ok {
+			continue
+		}
+
+		vm.unwindTo(i)
+
+		if c.Kind == types.CatchKindTag || c.Kind == types.CatchKindTagRef {
+			if err := vm.pushArgs(exn.Tag.Type().ParamTypes, exn.Args); err != nil {
+				return err
+			}
+		}
+		if c.Kind == types.CatchKindTagRef || c.Kind == types.CatchKindAllRef {
+			// collect once the exnref is on the stack to keep references in the payload alive
+			vm.PushUint64(vm.heap.alloc(&object{exn: exn}))
+			if vm.heap.full() {
+				vm.collectGarbage()
+			}
+		}
+
+		return Break(vm, c.Label)
+	}
+
+	vm.unwindTo(depth - 1)
+	return exn
+}
+
+func (vm *VM) matchCatch(f ControlFrame, exn *linker.Exception) (types.Catch, bool) {
+	for _, c := range f.Catches {
+		switch c.Kind {
+		case types.CatchKindAll, types.CatchKindAllRef:
+			return c, true
+		default:
+		}
+
+		if vm.tags[c.TagIdx] == exn.Tag {
+			return c, true
+		}
+	}
+
+	return types.Catch{}, false
+}
+
+// unwindTo pops the control frame at idx together with all above it, and drops their operands.
+func (vm *VM) unwindTo(idx int) {
+	bp := vm.ControlStack.frames[idx].BP
+	for vm.ControlStack.Len() > idx {
+		vm.ControlStack.Pop()
+	}
+	vm.PopUint64s(vm.OperandStack.Len() - bp)
+
+	if f, _, ok := vm.TopCallFrame(); ok {
+		vm.local0Idx = uint32(f.BP)
+	}
+}
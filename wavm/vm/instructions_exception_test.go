@@ -0,0 +1,113 @@
+package vm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestCatchRef(t *testing.T) {
+	const exnref byte = 0x69
+
+	// keeps the exnref of 42 thrown, catches and drops n more exnrefs, then rethrows the kept one
+	// to catch its payload
+	keep := testFunc{"keep", []byte{i32}, []byte{i32}, []byte{exnref, i32}, []byte{
+		0x02, 4, 0x1F, 0x40, 1, 0x03, 0, 0x41, 42, 0x08, 0, 0x0B, 0x00, 0x0B, 0x21, 1,
+		0x02, 0x40, 0x03, 0x40,
+		0x20, 2, 0x20, 0, 0x4E, 0x0D, 1,
+		0x02, 4, 0x1F, 0x40, 1, 0x03, 0, 0x20, 2, 0x08, 0, 0x0B, 0x00, 0x0B, 0x1A,
+		0x20, 2, 0x41, 1, 0x6A, 0x21, 2, 0x0C, 0,
+		0x0B, 0x0B,
+		0x02, i32, 0x1F, 0x40, 1, 0x00, 0, 0, 0x20, 1, 0x0A, 0x0B, 0x00, 0x0B,
+	}}
+	// throws the null exnref
+	null := testFunc{"null", nil, nil, nil, []byte{0xD0, exnref, 0x0A}}
+
+	m := testModule{
+		funcs: []testFunc{keep, null},
+		// the tag carries an i32, and blocks catching exnrefs are typed by the second
+		types:    [][]byte{wasmtest.FuncType([]byte{i32}, nil), wasmtest.FuncType(nil, []byte{exnref})},
+		sections: map[byte][]byte{13: wasmtest.Vec([]byte{0x00, 3})},
+	}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine)
+
+		for _, n := range []int32{0, 1, 5000} {
+			got, err := instance.InvokeFunc("keep", n)
+			if err != nil {
+				t.Fatalf("%s: keep %d: %v", engine, n, err)
+			}
+			if got[0].(int32) != 42 {
+				t.Fatalf("%s: keep %d: expect 42, got %d", engine, n, got[0])
+			}
+		}
+
+		if _, err := instance.InvokeFunc("null"); err == nil {
+			t.Fatalf("%s: expect throwing null exnref to trap", engine)
+		}
+	}
+}
+
+func TestTryTable(t *testing.T) {
+	funcs := []testFunc{
+		// returns the payload of tag 0, -2 for other tags, or -1 if raise returns
+		{"catch", []byte{i32}, []byte{i32}, nil, []byte{
+			0x02, i32,
+			0x02, 0x40,
+			0x1F, 0x40, 2, 0x00, 0, 1, 0x02, 0, 0x20, 0, 0x10, 2, 0x0B,
+			0x41, 0x7F, 0x0F,
+			0x0B,
+			0x41, 0x7E, 0x0F,
+			0x0B,
+		}},
+		// returns if n is 0, throws 42 of tag 0 if 1, tag 1 if 2, or traps otherwise
+		{"raise", []byte{i32}, nil, nil, []byte{
+			0x20, 0, 0x45, 0x04, 0x40, 0x0F, 0x0B,
+			0x20, 0, 0x41, 1, 0x46, 0x04, 0x40, 0x41, 42, 0x08, 0, 0x0B,
+			0x20, 0, 0x41, 2, 0x46, 0x04, 0x40, 0x08, 1, 0x0B,
+			0x00,
+		}},
+	}
+	m := testModule{
+		funcs:    funcs,
+		types:    [][]byte{wasmtest.FuncType([]byte{i32}, nil), wasmtest.FuncType(nil, nil)},
+		sections: map[byte][]byte{13: wasmtest.Vec([]byte{0x00, 3}, []byte{0x00, 4})},
+	}
+
+	testVector := []struct {
+		n      int32
+		expect int32
+	}{
+		{0, -1},
+		{1, 42},
+		{2, -2},
+	}
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine)
+
+		for _, c := range testVector {
+			got, err := instance.InvokeFunc("catch", c.n)
+			if err != nil {
+				t.Fatalf("%s: catch %d: %v", engine, c.n, err)
+			}
+			if got[0] != c.expect {
+				t.Fatalf("%s: catch %d: expect %d, got %d", engine, c.n, c.expect, got[0])
+			}
+		}
+
+		// traps are never caught
+		if _, err := instance.InvokeFunc("catch", int32(3)); !errors.Is(err, vm.ErrUnreachable) {
+			t.Fatalf("%s: expect %v, got %v", engine, vm.ErrUnreachable, err)
+		}
+
+		var exn *linker.Exception
+		_, err := instance.InvokeFunc("raise", int32(1))
+		if !errors.As(err, &exn) || len(exn.Args) != 1 || exn.Args[0] != int32(42) {
+			t.Fatalf("%s: expect uncaught exception of 42, got %v", engine, err)
+		}
+	}
+}
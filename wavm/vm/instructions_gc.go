@@ -0,0 +1,45 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+func GC(vm *VM, arg interface{}) error {
+	a, ok := arg.(types.GCArg)
+	if !ok {
+		return fmt.Errorf("expect types.GCArg: %w", ErrBadArgs)
+	}
+
+	switch a.SubOpcode {
+	case types.GCStructNew, types.GCStructNewDefault, types.GCArrayNew, types.GCArrayNewDefault,
+		types.GCArrayNewFixed:
+		return gcNew(vm, a)
+	case types.GCStructGet, types.GCStructGetS, types.GCStructGetU:
+		return structGet(vm, a)
+	case types.GCStructSet:
+		return structSet(vm, a)
+	case types.GCArrayGet, types.GCArrayGetS, types.GCArrayGetU:
+		return arrayGet(vm, a)
+	case types.GCArraySet:
+		return arraySet(vm, a)
+	case types.GCArrayLen:
+		return arrayLen(vm)
+	case types.GCArrayFill:
+		return arrayFill(vm, a)
+	case types.GCArrayCopy:
+		return arrayCopy(vm)
+	case types.GCRefTest, types.GCRefTestNull, types.GCRefCast, types.GCRefCastNull:
+		return refCast(vm, a)
+	case types.GCBrOnCast, types.GCBrOnCastFail:
+		return breakOnCast(vm, a)
+	case types.GCRefI31:
+		return refI31(vm)
+	case types.GCI31GetS, types.GCI31GetU:
+		return i31Get(vm, a.SubOpcode == types.GCI31GetS)
+	default:
+	}
+
+	return fmt.Errorf("sub-opcode 0x%x: %w", a.SubOpcode, ErrBadSubOpcode)
+}
@@ -0,0 +1,277 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+func arrayCopy(vm *VM) error {
+	operands, ok := vm.PopUint64s(5)
+	if !ok {
+		return fmt.Errorf("pop operands: %w", ErrOperandPop)
+	}
+	dstRef, dstOffset, srcRef, srcOffset, n := operands[0], operands[1], operands[2], operands[3],
+		operands[4]
+
+	dst, err := getObject(vm, dstRef)
+	if err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+	src, err := getObject(vm, srcRef)
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+
+	if err := checkArrayAccess(dst, dstOffset, n); err != nil {
+		return fmt.Errorf("destination: %w", err)
+	} else if err := checkArrayAccess(src, srcOffset, n); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+
+	copy(dst.fields[dstOffset:dstOffset+n], src.fields[srcOffset:srcOffset+n])
+	return nil
+}
+
+func arrayFill(vm *VM, arg types.GCArg) error {
+	operands, ok := vm.PopUint64s(4)
+	if !ok {
+		return fmt.Errorf("pop operands: %w", ErrOperandPop)
+	}
+	ref, offset, v, n := operands[0], operands[1], operands[2], operands[3]
+
+	obj, err := getObject(vm, ref)
+	if err != nil {
+		return err
+	} else if err := checkArrayAccess(obj, offset, n); err != nil {
+		return err
+	}
+
+	v = packField(vm.module.Types[arg.TypeIdx].Fields[0].StorageType, v)
+	for i := offset; i < offset+n; i++ {
+		obj.fields[i] = v
+	}
+
+	return nil
+}
+
+func arrayGet(vm *VM, arg types.GCArg) error {
+	idx, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop index: %w", ErrOperandPop)
+	}
+
+	obj, err := popObject(vm)
+	if err != nil {
+		return err
+	} else if err := checkArrayAccess(obj, idx, 1); err != nil {
+		return err
+	}
+
+	t := vm.module.Types[arg.TypeIdx].Fields[0].StorageType
+	vm.PushUint64(unpackField(t, obj.fields[idx], arg.SubOpcode == types.GCArrayGetS))
+
+	return nil
+}
+
+func arrayLen(vm *VM) error {
+	obj, err := popObject(vm)
+	if err != nil {
+		return err
+	}
+
+	vm.PushUint32(uint32(len(obj.fields)))
+	return nil
+}
+
+func arraySet(vm *VM, arg types.GCArg) error {
+	operands, ok := vm.PopUint64s(3)
+	if !ok {
+		return fmt.Errorf("pop operands: %w", ErrOperandPop)
+	}
+	ref, idx, v := operands[0], operands[1], operands[2]
+
+	obj, err := getObject(vm, ref)
+	if err != nil {
+		return err
+	} else if err := checkArrayAccess(obj, idx, 1); err != nil {
+		return err
+	}
+
+	obj.fields[idx] = packField(vm.module.Types[arg.TypeIdx].Fields[0].StorageType, v)
+	return nil
+}
+
+// breakOnCast branches with the reference on top if casting it to arg.DstType succeeds, or fails
+// for br_on_cast_fail.
+func breakOnCast(vm *VM, arg types.GCArg) error {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop reference: %w", ErrOperandPop)
+	}
+	vm.PushUint64(ref)
+
+	if matchRef(vm, ref, arg.DstType) == (arg.SubOpcode == types.GCBrOnCast) {
+		return Break(vm, arg.Label)
+	}
+
+	return nil
+}
+
+// checkArrayAccess checks the n elements of obj starting from offset are in bound.
+func checkArrayAccess(obj *object, offset, n uint64) error {
+	if l := uint64(len(obj.fields)); offset > l || n > l-offset {
+		return fmt.Errorf("access [%d, %d+%d) of %d elements: %w", offset, offset, n, l,
+			ErrArrayOutOfBound)
+	}
+
+	return nil
+}
+
+// gcNew allocates structs and arrays, collecting garbage beforehand if the heap is full, while
+// operands are still on the stack to keep their references alive.
+func gcNew(vm *VM, arg types.GCArg) error {
+	if vm.heap.full() {
+		vm.collectGarbage()
+	}
+
+	n := getNewOperandsLen(vm.module.Types[arg.TypeIdx], arg)
+	operands, ok := vm.PopUint64s(n)
+	if !ok {
+		return fmt.Errorf("pop %d operands: %w", n, ErrOperandPop)
+	}
+
+	ref, err := vm.newObject(arg, operands)
+	if err != nil {
+		return err
+	}
+
+	vm.PushUint64(ref)
+	return nil
+}
+
+func getObject(vm *VM, ref uint64) (*object, error) {
+	if ref == 0 {
+		return nil, ErrNullReference
+	}
+
+	obj, ok := vm.heap.get(ref)
+	if !ok {
+		return nil, fmt.Errorf("stale reference %d: %w", ref, ErrBadValue)
+	}
+
+	return obj, nil
+}
+
+func i31Get(vm *VM, signed bool) error {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop reference: %w", ErrOperandPop)
+	} else if ref == 0 {
+		return ErrNullReference
+	}
+
+	v := uint32(ref >> 1)
+	if signed {
+		v = uint32(int32(v<<1) >> 1)
+	}
+
+	vm.PushUint32(v)
+	return nil
+}
+
+// matchRef tells whether the reference ref is of type t at runtime. Functions match defined types
+// structurally since they may come from other modules.
+func matchRef(vm *VM, ref uint64, t types.ValueType) bool {
+	if ref == 0 {
+		return types.IsNullable(t)
+	}
+
+	defined, ht := vm.module.Types, types.GetHeapType(t)
+	switch types.GetTopHeapType(defined, ht) {
+	case types.HeapTypeAny:
+		if ref&1 != 0 {
+			return types.MatchHeapType(defined, types.HeapTypeI31, ht)
+		}
+		obj, ok := vm.heap.get(ref)
+		return ok && types.MatchHeapType(defined, types.HeapType(obj.typeIdx), ht)
+	case types.HeapTypeFunc:
+		if ht < 0 || ref > uint64(len(vm.funcs)) {
+			return ht == types.HeapTypeFunc
+		}
+		got, expected := vm.funcs[ref-1].type_, defined[ht]
+		return types.EqualValueTypes(got.ParamTypes, expected.ParamTypes) &&
+			types.EqualValueTypes(got.ResultTypes, expected.ResultTypes)
+	default:
+	}
+
+	return ht == types.GetTopHeapType(defined, ht)
+}
+
+func popObject(vm *VM) (*object, error) {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return nil, fmt.Errorf("pop reference: %w", ErrOperandPop)
+	}
+
+	return getObject(vm, ref)
+}
+
+// refCast serves ref.test and ref.cast, the latter of which traps if the cast fails.
+func refCast(vm *VM, arg types.GCArg) error {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop reference: %w", ErrOperandPop)
+	}
+
+	matched := matchRef(vm, ref, arg.DstType)
+	switch arg.SubOpcode {
+	case types.GCRefTest, types.GCRefTestNull:
+		vm.PushBool(matched)
+	case types.GCRefCast, types.GCRefCastNull:
+		if !matched {
+			return fmt.Errorf("cast to %s: %w", types.StringifyValueType(arg.DstType), ErrCastFailure)
+		}
+		vm.PushUint64(ref)
+	default:
+	}
+
+	return nil
+}
+
+func refI31(vm *VM) error {
+	v, ok := vm.PopUint32()
+	if !ok {
+		return fmt.Errorf("pop i32: %w", ErrOperandPop)
+	}
+
+	vm.PushUint64(newI31(v))
+	return nil
+}
+
+func structGet(vm *VM, arg types.GCArg) error {
+	obj, err := popObject(vm)
+	if err != nil {
+		return err
+	}
+
+	t := vm.module.Types[arg.TypeIdx].Fields[arg.Idx].StorageType
+	vm.PushUint64(unpackField(t, obj.fields[arg.Idx], arg.SubOpcode == types.GCStructGetS))
+
+	return nil
+}
+
+func structSet(vm *VM, arg types.GCArg) error {
+	v, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop value: %w", ErrOperandPop)
+	}
+
+	obj, err := popObject(vm)
+	if err != nil {
+		return err
+	}
+
+	obj.fields[arg.Idx] = packField(vm.module.Types[arg.TypeIdx].Fields[arg.Idx].StorageType, v)
+	return nil
+}
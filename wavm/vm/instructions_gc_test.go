@@ -0,0 +1,114 @@
+package vm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestGC(t *testing.T) {
+	const (
+		anyref, i8 byte = 0x6E, 0x78
+		// heap types of i31 and eq
+		i31, eq byte = 0x6C, 0x6D
+		// indices of extra types, i.e., struct {i32, i8}, array i32 and struct {i32, anyref}
+		s, a, node = 6, 7, 8
+	)
+
+	gc := func(code ...byte) []byte {
+		return append([]byte{0xFB}, code...)
+	}
+	// sets local 1 to a struct if param 0 is 0, an array if 1, an i31 if 2, or null if 3
+	pick := []byte{
+		0x41, 1, 0x41, 2, 0xFB, types.GCStructNew, s, 0x21, 1,
+		0x20, 0, 0x41, 1, 0x46, 0x04, 0x40, 0x41, 0, 0x41, 1, 0xFB, types.GCArrayNew, a, 0x21, 1, 0x0B,
+		0x20, 0, 0x41, 2, 0x46, 0x04, 0x40, 0x20, 0, 0xFB, types.GCRefI31, 0x21, 1, 0x0B,
+		0x20, 0, 0x41, 3, 0x46, 0x04, 0x40, 0xD0, anyref, 0x21, 1, 0x0B,
+	}
+
+	funcs := []testFunc{
+		// returns 2a + the i8 b sign-extended + the i8 b zero-extended
+		{"struct", []byte{i32, i32}, []byte{i32}, []byte{anyref}, wasmtest.Concat(
+			[]byte{0x20, 0, 0x20, 1}, gc(types.GCStructNew, s), []byte{0x22, 2},
+			gc(types.GCRefCast, s), []byte{0x20, 0, 0x41, 2, 0x6C}, gc(types.GCStructSet, s, 0),
+			[]byte{0x20, 2}, gc(types.GCRefCast, s), gc(types.GCStructGet, s, 0),
+			[]byte{0x20, 2}, gc(types.GCRefCast, s), gc(types.GCStructGetS, s, 1), []byte{0x6A},
+			[]byte{0x20, 2}, gc(types.GCRefCast, s), gc(types.GCStructGetU, s, 1), []byte{0x6A},
+		)},
+		// returns the i-th of n elements, the first n and others 7, plus n
+		{"array", []byte{i32, i32}, []byte{i32}, []byte{anyref}, wasmtest.Concat(
+			[]byte{0x41, 7, 0x20, 0}, gc(types.GCArrayNew, a), []byte{0x21, 2},
+			[]byte{0x20, 2}, gc(types.GCRefCast, a), []byte{0x41, 0, 0x20, 0}, gc(types.GCArraySet, a),
+			[]byte{0x20, 2}, gc(types.GCRefCast, a), []byte{0x20, 1}, gc(types.GCArrayGet, a),
+			[]byte{0x20, 2}, gc(types.GCRefCast, a), gc(types.GCArrayLen), []byte{0x6A},
+		)},
+		// returns bits of whether the picked is a struct, an array, an i31 or a nullable eq
+		{"test", []byte{i32}, []byte{i32}, []byte{anyref}, wasmtest.Concat(pick,
+			[]byte{0x20, 1}, gc(types.GCRefTest, s),
+			[]byte{0x20, 1}, gc(types.GCRefTest, a), []byte{0x41, 1, 0x74, 0x72},
+			[]byte{0x20, 1}, gc(types.GCRefTest, i31), []byte{0x41, 2, 0x74, 0x72},
+			[]byte{0x20, 1}, gc(types.GCRefTestNull, eq), []byte{0x41, 3, 0x74, 0x72},
+		)},
+		// returns the first field of the picked cast to the struct
+		{"cast", []byte{i32}, []byte{i32}, []byte{anyref}, wasmtest.Concat(pick,
+			[]byte{0x20, 1}, gc(types.GCRefCast, s), gc(types.GCStructGet, s, 0),
+		)},
+		// links n nodes of 0..n-1 with garbage allocated along, then sums them up
+		{"list", []byte{i32}, []byte{i32}, []byte{i32, anyref}, wasmtest.Concat(
+			[]byte{0x02, 0x40, 0x03, 0x40, 0x20, 1, 0x20, 0, 0x4E, 0x0D, 1},
+			[]byte{0x41, 0, 0x41, 0}, gc(types.GCStructNew, s), []byte{0x1A},
+			[]byte{0x20, 1, 0x20, 2}, gc(types.GCStructNew, node), []byte{0x21, 2},
+			[]byte{0x20, 1, 0x41, 1, 0x6A, 0x21, 1, 0x0C, 0, 0x0B, 0x0B},
+			[]byte{0x41, 0, 0x21, 1},
+			[]byte{0x02, 0x40, 0x03, 0x40, 0x20, 2, 0xD1, 0x0D, 1},
+			[]byte{0x20, 1, 0x20, 2}, gc(types.GCRefCast, node), gc(types.GCStructGet, node, 0),
+			[]byte{0x6A, 0x21, 1},
+			[]byte{0x20, 2}, gc(types.GCRefCast, node), gc(types.GCStructGet, node, 1),
+			[]byte{0x21, 2, 0x0C, 0, 0x0B, 0x0B, 0x20, 1},
+		)},
+	}
+	m := testModule{funcs: funcs, types: [][]byte{
+		{0x5F, 2, i32, 1, i8, 1},
+		{0x5E, i32, 1},
+		{0x5F, 2, i32, 0, anyref, 0},
+	}}
+
+	testVector := []struct {
+		f      string
+		args   []types.WasmVal
+		expect int32
+		err    error
+	}{
+		{"struct", []types.WasmVal{int32(5), int32(0xFF)}, 10 - 1 + 0xFF, nil},
+		{"struct", []types.WasmVal{int32(5), int32(0x17F)}, 10 + 0x7F + 0x7F, nil},
+		{"array", []types.WasmVal{int32(3), int32(0)}, 3 + 3, nil},
+		{"array", []types.WasmVal{int32(3), int32(2)}, 7 + 3, nil},
+		{"array", []types.WasmVal{int32(3), int32(3)}, 0, vm.ErrArrayOutOfBound},
+		{"array", []types.WasmVal{int32(0), int32(0)}, 0, vm.ErrArrayOutOfBound},
+		{"test", []types.WasmVal{int32(0)}, 1 | 8, nil},
+		{"test", []types.WasmVal{int32(1)}, 2 | 8, nil},
+		{"test", []types.WasmVal{int32(2)}, 4 | 8, nil},
+		{"test", []types.WasmVal{int32(3)}, 8, nil},
+		{"cast", []types.WasmVal{int32(0)}, 1, nil},
+		{"cast", []types.WasmVal{int32(1)}, 0, vm.ErrCastFailure},
+		{"cast", []types.WasmVal{int32(2)}, 0, vm.ErrCastFailure},
+		{"cast", []types.WasmVal{int32(3)}, 0, vm.ErrCastFailure},
+		// enough to collect garbage a few times
+		{"list", []types.WasmVal{int32(5000)}, 5000 * 4999 / 2, nil},
+	}
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine)
+		for _, c := range testVector {
+			got, err := instance.InvokeFunc(c.f, c.args...)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("%s: %s%v: expect error %v, got %v", engine, c.f, c.args, c.err, err)
+			}
+			if err == nil && got[0] != c.expect {
+				t.Fatalf("%s: %s%v: expect %d, got %d", engine, c.f, c.args, c.expect, got[0])
+			}
+		}
+	}
+}
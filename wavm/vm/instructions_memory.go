@@ -1,16 +1,20 @@
 package vm
 
-func MemoryGrow(vm *VM, _ interface{}) error {
-	n, ok := vm.PopUint32()
+func MemoryGrow(vm *VM, arg interface{}) error {
+	mem := vm.memories[arg.(uint32)]
+
+	n, ok := vm.popAddress(mem)
 	if !ok {
 		return ErrOperandPop
 	}
 
-	vm.PushUint32(vm.memory.Grow(n))
+	vm.pushAddress(mem, mem.Grow(n))
 	return nil
 }
 
-func MemorySize(vm *VM, _ interface{}) error {
-	vm.PushUint32(vm.memory.Size())
+func MemorySize(vm *VM, arg interface{}) error {
+	mem := vm.memories[arg.(uint32)]
+
+	vm.pushAddress(mem, mem.Size())
 	return nil
 }
@@ -4,71 +4,92 @@ import (
 	"encoding/binary"
 	"fmt"
 
+	"github.com/sammyne/mastering-wasm/wavm/linker"
 	"github.com/sammyne/mastering-wasm/wavm/types"
 )
 
 var byteOrder = binary.LittleEndian
 
-func getOffset(vm *VM, arg interface{}) (uint64, error) {
+func getMemoryAndOffset(vm *VM, arg interface{}) (linker.Memory, uint64, error) {
+	mem := vm.memories[arg.(types.MemoryArg).MemoryIdx]
+
+	offset, err := getOffset(vm, mem, arg)
+	return mem, offset, err
+}
+
+func getOffset(vm *VM, mem linker.Memory, arg interface{}) (uint64, error) {
 	offset1 := arg.(types.MemoryArg).Offset
 
-	offset2, ok := vm.PopUint32()
+	offset2, ok := vm.popAddress(mem)
 	if !ok {
 		return 0, fmt.Errorf("pop operand offset: %w", ErrOperandPop)
 	}
 
-	return uint64(offset1) + uint64(offset2), nil
+	out := offset1 + offset2
+	if out < offset1 {
+		return 0, fmt.Errorf("offset %d+%d overflows: %w", offset1, offset2, ErrIndexOutOfBound)
+	}
+
+	return out, nil
 }
 
 func readUint16(vm *VM, arg interface{}) (uint16, error) {
-	offset, err := getOffset(vm, arg)
+	mem, offset, err := getMemoryAndOffset(vm, arg)
 	if err != nil {
-		return 0, fmt.Errorf("get offset: %d", offset)
+		return 0, fmt.Errorf("get offset: %w", err)
 	}
 
 	var buf [2]byte
-	vm.memory.Read(offset, buf[:])
+	if err := mem.Read(offset, buf[:]); err != nil {
+		return 0, fmt.Errorf("read memory: %w", err)
+	}
 
 	return byteOrder.Uint16(buf[:]), nil
 }
 
 func readUint32(vm *VM, arg interface{}) (uint32, error) {
-	offset, err := getOffset(vm, arg)
+	mem, offset, err := getMemoryAndOffset(vm, arg)
 	if err != nil {
-		return 0, fmt.Errorf("get offset: %d", offset)
+		return 0, fmt.Errorf("get offset: %w", err)
 	}
 
 	var buf [4]byte
-	vm.memory.Read(offset, buf[:])
+	if err := mem.Read(offset, buf[:]); err != nil {
+		return 0, fmt.Errorf("read memory: %w", err)
+	}
 
 	return byteOrder.Uint32(buf[:]), nil
 }
 
 func readUint64(vm *VM, arg interface{}) (uint64, error) {
-	offset, err := getOffset(vm, arg)
+	mem, offset, err := getMemoryAndOffset(vm, arg)
 	if err != nil {
-		return 0, fmt.Errorf("get offset: %d", offset)
+		return 0, fmt.Errorf("get offset: %w", err)
 	}
 
 	var buf [8]byte
-	vm.memory.Read(offset, buf[:])
+	if err := mem.Read(offset, buf[:]); err != nil {
+		return 0, fmt.Errorf("read memory: %w", err)
+	}
 
 	return byteOrder.Uint64(buf[:]), nil
 }
 
 func readUint8(vm *VM, arg interface{}) (byte, error) {
-	offset, err := getOffset(vm, arg)
+	mem, offset, err := getMemoryAndOffset(vm, arg)
 	if err != nil {
-		return 0, fmt.Errorf("get offset: %d", offset)
+		return 0, fmt.Errorf("get offset: %w", err)
 	}
 
 	var buf [1]byte
-	vm.memory.Read(offset, buf[:])
+	if err := mem.Read(offset, buf[:]); err != nil {
+		return 0, fmt.Errorf("read memory: %w", err)
+	}
 	return buf[0], nil
 }
 
 func writeUint16(vm *VM, arg interface{}, v uint16) error {
-	offset, err := getOffset(vm, arg)
+	mem, offset, err := getMemoryAndOffset(vm, arg)
 	if err != nil {
 		return fmt.Errorf("get offset: %w", err)
 	}
@@ -76,12 +97,15 @@ func writeUint16(vm *VM, arg interface{}, v uint16) error {
 	var buf [2]byte
 	byteOrder.PutUint16(buf[:], v)
 
-	vm.memory.Write(offset, buf[:])
+	if err := mem.Write(offset, buf[:]); err != nil {
+		return fmt.Errorf("write memory: %w", err)
+	}
+
 	return nil
 }
 
 func writeUint32(vm *VM, arg interface{}, v uint32) error {
-	offset, err := getOffset(vm, arg)
+	mem, offset, err := getMemoryAndOffset(vm, arg)
 	if err != nil {
 		return fmt.Errorf("get offset: %w", err)
 	}
@@ -89,12 +113,15 @@ func writeUint32(vm *VM, arg interface{}, v uint32) error {
 	var buf [4]byte
 	byteOrder.PutUint32(buf[:], v)
 
-	vm.memory.Write(offset, buf[:])
+	if err := mem.Write(offset, buf[:]); err != nil {
+		return fmt.Errorf("write memory: %w", err)
+	}
+
 	return nil
 }
 
 func writeUint64(vm *VM, arg interface{}, v uint64) error {
-	offset, err := getOffset(vm, arg)
+	mem, offset, err := getMemoryAndOffset(vm, arg)
 	if err != nil {
 		return fmt.Errorf("get offset: %w", err)
 	}
@@ -102,17 +129,23 @@ func writeUint64(vm *VM, arg interface{}, v uint64) error {
 	var buf [8]byte
 	byteOrder.PutUint64(buf[:], v)
 
-	vm.memory.Write(offset, buf[:])
+	if err := mem.Write(offset, buf[:]); err != nil {
+		return fmt.Errorf("write memory: %w", err)
+	}
+
 	return nil
 }
 
 func writeUint8(vm *VM, arg interface{}, v byte) error {
-	offset, err := getOffset(vm, arg)
+	mem, offset, err := getMemoryAndOffset(vm, arg)
 	if err != nil {
 		return fmt.Errorf("get offset: %w", err)
 	}
 
 	buf := [...]byte{v}
-	vm.memory.Write(offset, buf[:])
+	if err := mem.Write(offset, buf[:]); err != nil {
+		return fmt.Errorf("write memory: %w", err)
+	}
+
 	return nil
 }
@@ -1,8 +1,16 @@
 package vm
 
-import "math"
+import (
+	"math"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
 
 func TruncSat(vm *VM, subOpcode interface{}) error {
+	if arg, ok := subOpcode.(types.BulkArg); ok {
+		return bulk(vm, arg)
+	}
+
 	if vm.OperandStack.Len() == 0 {
 		return ErrOperandPop
 	}
@@ -56,6 +56,12 @@ func I32DivS(vm *VM, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return ErrIntegerDivideByZero
+	}
+	if v1 == math.MinInt32 && v2 == -1 {
+		return ErrIntegerOverflow
+	}
 
 	vm.PushInt32(v1 / v2)
 	return nil
@@ -66,6 +72,9 @@ func I32DivU(vm *VM, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return ErrIntegerDivideByZero
+	}
 
 	vm.PushUint32(v1 / v2)
 	return nil
@@ -117,7 +126,7 @@ func I32GeS(vm *VM, _ interface{}) error {
 		return err
 	}
 
-	vm.PushBool(v1 > v2)
+	vm.PushBool(v1 >= v2)
 	return nil
 }
 
@@ -127,7 +136,7 @@ func I32GeU(vm *VM, _ interface{}) error {
 		return err
 	}
 
-	vm.PushBool(v1 > v2)
+	vm.PushBool(v1 >= v2)
 	return nil
 }
 
@@ -286,6 +295,9 @@ func I32RemS(vm *VM, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return ErrIntegerDivideByZero
+	}
 
 	vm.PushInt32(v1 % v2)
 	return nil
@@ -296,6 +308,9 @@ func I32RemU(vm *VM, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return ErrIntegerDivideByZero
+	}
 
 	vm.PushUint32(v1 % v2)
 	return nil
@@ -337,7 +352,7 @@ func I32ShrS(vm *VM, _ interface{}) error {
 		return err
 	}
 
-	vm.PushInt32(v1 >> (v2 % 32))
+	vm.PushInt32(v1 >> (uint32(v2) % 32))
 	return nil
 }
 
@@ -56,6 +56,12 @@ func I64DivS(vm *VM, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return ErrIntegerDivideByZero
+	}
+	if v1 == math.MinInt64 && v2 == -1 {
+		return ErrIntegerOverflow
+	}
 
 	vm.PushInt64(v1 / v2)
 	return nil
@@ -66,6 +72,9 @@ func I64DivU(vm *VM, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return ErrIntegerDivideByZero
+	}
 
 	vm.PushUint64(v1 / v2)
 	return nil
@@ -157,7 +166,7 @@ func I64GeS(vm *VM, _ interface{}) error {
 		return fmt.Errorf("pop 2nd operand: %w", ErrOperandPop)
 	}
 
-	vm.PushBool(v1 > v2)
+	vm.PushBool(v1 >= v2)
 	return nil
 }
 
@@ -172,7 +181,7 @@ func I64GeU(vm *VM, _ interface{}) error {
 		return fmt.Errorf("pop 2nd operand: %w", ErrOperandPop)
 	}
 
-	vm.PushBool(v1 > v2)
+	vm.PushBool(v1 >= v2)
 	return nil
 }
 
@@ -386,6 +395,9 @@ func I64RemS(vm *VM, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return ErrIntegerDivideByZero
+	}
 
 	vm.PushInt64(v1 % v2)
 	return nil
@@ -396,6 +408,9 @@ func I64RemU(vm *VM, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	if v2 == 0 {
+		return ErrIntegerDivideByZero
+	}
 
 	vm.PushUint64(v1 % v2)
 	return nil
@@ -447,7 +462,7 @@ func I64ShrS(vm *VM, _ interface{}) error {
 		return err
 	}
 
-	vm.PushInt64(v1 >> (v2 % 64))
+	vm.PushInt64(v1 >> (uint64(v2) % 64))
 	return nil
 }
 
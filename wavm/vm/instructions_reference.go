@@ -0,0 +1,95 @@
+package vm
+
+import "fmt"
+
+// Function references are indices into VM.funcs plus 1, leaving 0 as null for all references.
+
+func BreakOnNonNull(vm *VM, arg interface{}) error {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop reference: %w", ErrOperandPop)
+	} else if ref == 0 {
+		return nil
+	}
+
+	vm.PushUint64(ref)
+	return Break(vm, arg)
+}
+
+func BreakOnNull(vm *VM, arg interface{}) error {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop reference: %w", ErrOperandPop)
+	} else if ref == 0 {
+		return Break(vm, arg)
+	}
+
+	vm.PushUint64(ref)
+	return nil
+}
+
+func CallRef(vm *VM, _ interface{}) error {
+	f, err := popFuncRef(vm)
+	if err != nil {
+		return err
+	}
+
+	return callFunc(vm, f)
+}
+
+func RefAsNonNull(vm *VM, _ interface{}) error {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop reference: %w", ErrOperandPop)
+	} else if ref == 0 {
+		return ErrNullReference
+	}
+
+	vm.PushUint64(ref)
+	return nil
+}
+
+func RefEq(vm *VM, _ interface{}) error {
+	ref1, ref2, err := vm.popTowUint64()
+	if err != nil {
+		return fmt.Errorf("pop references: %w", err)
+	}
+
+	vm.PushBool(ref1 == ref2)
+	return nil
+}
+
+func RefFunc(vm *VM, arg interface{}) error {
+	idx, ok := arg.(uint32)
+	if !ok || idx >= uint32(len(vm.funcs)) {
+		return ErrBadArgs
+	}
+
+	vm.PushUint64(uint64(idx) + 1)
+	return nil
+}
+
+func RefIsNull(vm *VM, _ interface{}) error {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return fmt.Errorf("pop reference: %w", ErrOperandPop)
+	}
+
+	vm.PushBool(ref == 0)
+	return nil
+}
+
+func RefNull(vm *VM, _ interface{}) error {
+	vm.PushUint64(0)
+	return nil
+}
+
+// ReturnCallRef is the counterpart of CallRef for tail calls as ReturnCall.
+func ReturnCallRef(vm *VM, _ interface{}) error {
+	f, err := popFuncRef(vm)
+	if err != nil {
+		return err
+	}
+
+	return returnCallFunc(vm, f)
+}
@@ -0,0 +1,18 @@
+package vm
+
+import "fmt"
+
+// popFuncRef pops a function reference, whose type needs no check at runtime since validation
+// ensures it's the type of call_ref.
+func popFuncRef(vm *VM) (Func, error) {
+	ref, ok := vm.PopUint64()
+	if !ok {
+		return Func{}, fmt.Errorf("pop function reference: %w", ErrOperandPop)
+	} else if ref == 0 {
+		return Func{}, ErrNullReference
+	} else if ref > uint64(len(vm.funcs)) {
+		return Func{}, fmt.Errorf("function reference %d: %w", ref, ErrIndexOutOfBound)
+	}
+
+	return vm.funcs[ref-1], nil
+}
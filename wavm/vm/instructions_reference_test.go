@@ -0,0 +1,67 @@
+package vm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestCallRef(t *testing.T) {
+	const (
+		refNull, ref byte = 0x63, 0x64
+		inc               = 1 // index of func inc
+	)
+
+	funcs := []testFunc{
+		{"inc", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x41, 1, 0x6A}},
+		{"apply", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0xD2, inc, 0x14, inc}},
+		{"call_null", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0xD0, inc, 0x14, inc}},
+		{"as_non_null", nil, nil, nil, []byte{0xD0, inc, 0xD4, 0x1A}},
+		// returns inc(7) if n is non-zero, or 7 as the ref is null otherwise
+		{"br_on_null", []byte{i32}, []byte{i32}, nil, []byte{
+			0x02, i32, 0x41, 7, 0x20, 0, 0x04, 9, 0xD2, inc, 0x05, 0xD0, inc, 0x0B, 0xD5, 0, 0x14, inc,
+			0x0B,
+		}},
+		// returns 1 if n is non-zero, or 7 as the ref is null otherwise
+		{"br_on_non_null", []byte{i32}, []byte{i32}, nil, []byte{
+			0x02, 8, 0x20, 0, 0x04, 9, 0xD2, inc, 0x05, 0xD0, inc, 0x0B, 0xD6, 0, 0x41, 7, 0x0F, 0x0B,
+			0x1A, 0x41, 1,
+		}},
+		{"return_call_ref", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0xD2, inc, 0x15, inc}},
+	}
+	m := testModule{
+		funcs: funcs,
+		// blocks of refs to inc are typed by these
+		types: [][]byte{{0x60, 0, 1, ref, inc}, {0x60, 0, 1, refNull, inc}},
+	}
+
+	testVector := []struct {
+		f      string
+		args   []types.WasmVal
+		expect types.WasmVal
+		err    error
+	}{
+		{"apply", []types.WasmVal{int32(5)}, int32(6), nil},
+		{"call_null", []types.WasmVal{int32(5)}, nil, vm.ErrNullReference},
+		{"as_non_null", nil, nil, vm.ErrNullReference},
+		{"br_on_null", []types.WasmVal{int32(1)}, int32(8), nil},
+		{"br_on_null", []types.WasmVal{int32(0)}, int32(7), nil},
+		{"br_on_non_null", []types.WasmVal{int32(1)}, int32(1), nil},
+		{"br_on_non_null", []types.WasmVal{int32(0)}, int32(7), nil},
+		{"return_call_ref", []types.WasmVal{int32(41)}, int32(42), nil},
+	}
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine)
+		for _, c := range testVector {
+			got, err := instance.InvokeFunc(c.f, c.args...)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("%s: %s%v: expect error %v, got %v", engine, c.f, c.args, c.err, err)
+			}
+			if err == nil && got[0] != c.expect {
+				t.Fatalf("%s: %s%v: expect %v, got %v", engine, c.f, c.args, c.expect, got[0])
+			}
+		}
+	}
+}
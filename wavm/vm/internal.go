@@ -7,6 +7,25 @@ import (
 	"github.com/sammyne/mastering-wasm/wavm/types"
 )
 
+// evalConstBinaryOp evaluates the arithmetic instructions allowed in constant expressions.
+func evalConstBinaryOp(opcode byte, v1, v2 uint64) uint64 {
+	switch opcode {
+	case types.OpcodeI32Add:
+		return uint64(uint32(v1) + uint32(v2))
+	case types.OpcodeI32Sub:
+		return uint64(uint32(v1) - uint32(v2))
+	case types.OpcodeI32Mul:
+		return uint64(uint32(v1) * uint32(v2))
+	case types.OpcodeI64Add:
+		return v1 + v2
+	case types.OpcodeI64Sub:
+		return v1 - v2
+	default:
+	}
+
+	return v1 * v2
+}
+
 func getMainFuncIdx(exports []types.Export) (uint32, error) {
 	for _, v := range exports {
 		if v.Description.Tag == types.PortTagFunc && v.Name == "main" {
@@ -17,6 +36,33 @@ func getMainFuncIdx(exports []types.Export) (uint32, error) {
 	return 0, fmt.Errorf("'main' is not found")
 }
 
+// memoryPageLimit is the implementation limit on pages of memories, which is 4GiB, or less if int
+// can't index as many bytes.
+var memoryPageLimit = func() uint64 {
+	if n := uint64(math.MaxInt) / types.PageSize; n < types.MaxPageCount {
+		return n
+	}
+	return types.MaxPageCount
+}()
+
+// getMaxPageCount returns the number of pages a memory of type t can grow up to, within the
+// implementation limit.
+func getMaxPageCount(t types.Memory) uint64 {
+	out := uint64(types.MaxPageCount)
+	if t.Is64() {
+		out = types.MaxPageCount64
+	}
+	if out > memoryPageLimit {
+		out = memoryPageLimit
+	}
+
+	if t.HasMax() && t.Max < out {
+		out = t.Max
+	}
+
+	return out
+}
+
 func unwrapUint64(t types.ValueType, v types.WasmVal) (uint64, error) {
 	switch t {
 	case types.ValueTypeI32:
@@ -46,6 +92,14 @@ func unwrapUint64(t types.ValueType, v types.WasmVal) (uint64, error) {
 	default:
 	}
 
+	if types.IsRefType(t) {
+		vv, ok := v.(uint64)
+		if !ok {
+			return 0, fmt.Errorf("value %v isn't a reference: %w", v, ErrBadValue)
+		}
+		return vv, nil
+	}
+
 	return 0, ErrBadValueType
 }
 
@@ -62,5 +116,9 @@ func wrapUint64(t types.ValueType, v uint64) (types.WasmVal, error) {
 	default:
 	}
 
+	if types.IsRefType(t) {
+		return v, nil // opaque handle only meaningful to the VM producing it
+	}
+
 	return nil, ErrBadValueType
 }
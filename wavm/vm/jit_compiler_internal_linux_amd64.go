@@ -0,0 +1,462 @@
+package vm
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// registers of x86-64 used by machine code besides RSI at locals, RDI at jitCtx, R8 at linear
+// memory and R9 of its length
+const (
+	jitRAX byte = iota
+	jitRCX
+	jitRDX
+)
+
+// condition codes of x86-64
+const (
+	jitCondB  byte = 0x2
+	jitCondAE byte = 0x3
+	jitCondE  byte = 0x4
+	jitCondNE byte = 0x5
+	jitCondBE byte = 0x6
+	jitCondA  byte = 0x7
+	jitCondL  byte = 0xC
+	jitCondGE byte = 0xD
+	jitCondLE byte = 0xE
+	jitCondG  byte = 0xF
+)
+
+// jitALUOps maps binary numeric bytecode run natively to opcodes of x86-64 computing RAX with the
+// slot of the 2nd operand.
+var jitALUOps = map[bcOpcode][]byte{
+	bcI32Add: {0x03}, bcI32Sub: {0x2B}, bcI32Mul: {0x0F, 0xAF},
+	bcI32And: {0x23}, bcI32Or: {0x0B}, bcI32Xor: {0x33},
+	bcI64Add: {0x03}, bcI64Sub: {0x2B}, bcI64Mul: {0x0F, 0xAF},
+	bcI64And: {0x23}, bcI64Or: {0x0B}, bcI64Xor: {0x33},
+}
+
+// jitCompareOps maps comparisons of bytecode to condition codes of x86-64.
+var jitCompareOps = map[bcOpcode]byte{
+	bcI32Eq: jitCondE, bcI32Ne: jitCondNE,
+	bcI32LtS: jitCondL, bcI32LtU: jitCondB, bcI32GtS: jitCondG, bcI32GtU: jitCondA,
+	bcI32LeS: jitCondLE, bcI32LeU: jitCondBE, bcI32GeS: jitCondGE, bcI32GeU: jitCondAE,
+	bcI64Eq: jitCondE, bcI64Ne: jitCondNE,
+	bcI64LtS: jitCondL, bcI64LtU: jitCondB, bcI64GtS: jitCondG, bcI64GtU: jitCondA,
+	bcI64LeS: jitCondLE, bcI64LeU: jitCondBE, bcI64GeS: jitCondGE, bcI64GeU: jitCondAE,
+}
+
+// jitShiftOps maps shifts of bytecode to extensions of the opcode 0xD3 of x86-64.
+var jitShiftOps = map[bcOpcode]byte{
+	bcI32Shl: 4, bcI32ShrU: 5, bcI32ShrS: 7,
+	bcI64Shl: 4, bcI64ShrU: 5, bcI64ShrS: 7,
+}
+
+// jitMemOp is a load or store of size bytes, whose opcode of x86-64 moves between RAX and the
+// bytes ending at RDX.
+type jitMemOp struct {
+	size  byte
+	store bool
+	op    []byte
+}
+
+// jitMemOps maps loads and stores of bytecode to their machine code.
+var jitMemOps = map[bcOpcode]jitMemOp{
+	bcI32Load:    {4, false, []byte{0x8B}},
+	bcI64Load:    {8, false, []byte{0x48, 0x8B}},
+	bcI32Load8S:  {1, false, []byte{0x0F, 0xBE}},
+	bcI32Load8U:  {1, false, []byte{0x0F, 0xB6}},
+	bcI32Load16S: {2, false, []byte{0x0F, 0xBF}},
+	bcI32Load16U: {2, false, []byte{0x0F, 0xB7}},
+	bcI64Load8S:  {1, false, []byte{0x48, 0x0F, 0xBE}},
+	bcI64Load8U:  {1, false, []byte{0x0F, 0xB6}},
+	bcI64Load16S: {2, false, []byte{0x48, 0x0F, 0xBF}},
+	bcI64Load16U: {2, false, []byte{0x0F, 0xB7}},
+	bcI64Load32S: {4, false, []byte{0x48, 0x63}},
+	bcI64Load32U: {4, false, []byte{0x8B}},
+	bcI32Store:   {4, true, []byte{0x89}},
+	bcI64Store:   {8, true, []byte{0x48, 0x89}},
+	bcI32Store8:  {1, true, []byte{0x88}},
+	bcI32Store16: {2, true, []byte{0x66, 0x89}},
+	bcI64Store8:  {1, true, []byte{0x88}},
+	bcI64Store16: {2, true, []byte{0x66, 0x89}},
+	bcI64Store32: {4, true, []byte{0x89}},
+}
+
+// jitFloatOps maps floating-point arithmetic of bytecode run natively to opcodes of SSE computing
+// XMM0 with the slot of the 2nd operand.
+var jitFloatOps = map[bcOpcode][]byte{
+	bcF32Add: {0xF3, 0x0F, 0x58}, bcF32Sub: {0xF3, 0x0F, 0x5C},
+	bcF32Mul: {0xF3, 0x0F, 0x59}, bcF32Div: {0xF3, 0x0F, 0x5E},
+	bcF64Add: {0xF2, 0x0F, 0x58}, bcF64Sub: {0xF2, 0x0F, 0x5C},
+	bcF64Mul: {0xF2, 0x0F, 0x59}, bcF64Div: {0xF2, 0x0F, 0x5E},
+}
+
+// jitAsm assembles machine code of x86-64 from bytecode of a function, which keeps locals and
+// operands in their slots of the call frame.
+type jitAsm struct {
+	buf     []byte
+	nLocals int
+	offsets []int32
+	jumps   []jitFixup // to PCs
+	exits   []jitFixup // to stubs exiting to Go with PCs
+}
+
+// jitFixup is the rel32 at buf[at:] to be resolved to pc.
+type jitFixup struct {
+	at, pc int
+}
+
+// compileJIT compiles bytecode of a function into machine code, where heights are those of the
+// operand stack before its instructions as recorded by the validator. Instructions not run
+// natively exit to Go, and so do traps, which are raised as by bytecode.
+func compileJIT(bc *bcFunc, heights map[*types.Instruction]int) *jitFunc {
+	out := &jitFunc{bc: bc, heights: make([]int, len(bc.code))}
+
+	maxHeight := bc.nResults
+	for _, v := range heights {
+		if v > maxHeight {
+			maxHeight = v
+		}
+	}
+	// a slot at least, so that frames of no locals and operands have registers to point at
+	out.frameSize = bc.nLocals + maxHeight + 1
+
+	for i, v := range bc.code {
+		if v.instr != nil {
+			out.heights[i] = heights[v.instr]
+		} else if v.op == bcReturn {
+			// the end of the function
+			out.heights[i] = bc.nResults
+		}
+	}
+
+	a := &jitAsm{nLocals: bc.nLocals, offsets: make([]int32, len(bc.code))}
+	for i := range bc.code {
+		a.offsets[i] = int32(len(a.buf))
+		a.compile(&bc.code[i], i, out.heights[i])
+	}
+	a.link()
+
+	out.asm, out.offsets = a.buf, a.offsets
+	return out
+}
+
+func (a *jitAsm) compile(in *bcInstr, pc, h int) {
+	switch in.op {
+	case bcNop:
+	case bcJump:
+		a.jump(in.target.pc)
+	case bcBr:
+		a.branch(pc, h, &in.target)
+	case bcBrIf:
+		a.load(jitRAX, a.operand(h-1), false)
+		a.emit(0x85, 0xC0) // test eax, eax
+		skip := a.jcc(jitCondE)
+		a.branch(pc, h-1, &in.target)
+		a.patch(skip, len(a.buf))
+	case bcBrUnless:
+		a.load(jitRAX, a.operand(h-1), false)
+		a.emit(0x85, 0xC0) // test eax, eax
+		a.jumps = append(a.jumps, jitFixup{at: a.jcc(jitCondE), pc: in.target.pc})
+	case bcBrTable:
+		a.brTable(in, pc, h)
+	case bcDrop:
+	case bcSelect:
+		x := a.operand(h - 3)
+		a.load(jitRAX, x, true)
+		a.load(jitRCX, a.operand(h-2), true)
+		a.rm(false, 7, a.operand(h-1), 0x83) // cmp dword [c], 0
+		a.emit(0x00)
+		a.emit(0x48, 0x0F, 0x44, 0xC1) // cmovz rax, rcx
+		a.store(x, jitRAX)
+	case bcLocalGet:
+		a.load(jitRAX, a.local(in.imm), true)
+		a.store(a.operand(h), jitRAX)
+	case bcLocalSet:
+		a.load(jitRAX, a.operand(h-1), true)
+		a.store(a.local(in.imm), jitRAX)
+	case bcLocalTee:
+		a.load(jitRAX, a.operand(h-1), true)
+		a.store(a.local(in.imm), jitRAX)
+	case bcConst:
+		if v := int64(in.imm); v >= math.MinInt32 && v <= math.MaxInt32 {
+			a.rm(true, 0, a.operand(h), 0xC7) // mov qword [x], imm32
+			a.emit32(uint32(v))
+		} else {
+			a.emit(0x48, 0xB8) // mov rax, imm64
+			a.emit64(in.imm)
+			a.store(a.operand(h), jitRAX)
+		}
+	case bcI32Eqz, bcI64Eqz:
+		x := a.operand(h - 1)
+		a.load(jitRAX, x, in.op == bcI64Eqz)
+		if in.op == bcI64Eqz {
+			a.emit(0x48)
+		}
+		a.emit(0x85, 0xC0) // test rax, rax
+		a.setcc(jitCondE)
+		a.store(x, jitRAX)
+	case bcI32WrapI64, bcI64ExtendI32U:
+		x := a.operand(h - 1)
+		a.load(jitRAX, x, false)
+		a.store(x, jitRAX)
+	case bcI64ExtendI32S:
+		x := a.operand(h - 1)
+		a.rm(true, jitRAX, x, 0x63) // movsxd rax, dword [x]
+		a.store(x, jitRAX)
+	case bcCall, bcReturn, bcFallback, bcFallbackCall, bcGlobalGet, bcBrOnNull, bcBrOnNonNull,
+		bcBrOnCast:
+		a.exit(pc)
+	case bcI32DivS, bcI32DivU, bcI32RemS, bcI32RemU, bcI64DivS, bcI64DivU, bcI64RemS, bcI64RemU:
+		a.divide(in.op, pc, h)
+	default:
+		if m, ok := jitMemOps[in.op]; ok {
+			a.memory(in, &m, pc, h)
+			return
+		} else if f, ok := jitFloatOps[in.op]; ok {
+			a.float(in.op, f, h)
+			return
+		}
+
+		x, y := a.operand(h-2), a.operand(h-1)
+		wide := in.op >= bcI64Eqz && in.op <= bcI64GeU || in.op >= bcI64Add && in.op <= bcI64ShrU
+		if op, ok := jitALUOps[in.op]; ok {
+			a.load(jitRAX, x, wide)
+			a.rm(wide, jitRAX, y, op...)
+		} else if cc, ok := jitCompareOps[in.op]; ok {
+			a.load(jitRAX, x, wide)
+			a.rm(wide, jitRAX, y, 0x3B) // cmp rax, [y]
+			a.setcc(cc)
+		} else if ext, ok := jitShiftOps[in.op]; ok {
+			a.load(jitRAX, x, wide)
+			a.load(jitRCX, y, false)
+			if wide {
+				a.emit(0x48)
+			}
+			a.emit(0xD3, 0xC0|ext<<3) // shift rax, cl
+		} else {
+			a.exit(pc)
+			return
+		}
+		a.store(x, jitRAX)
+	}
+}
+
+// float compiles the floating-point arithmetic op by the opcode f of SSE.
+func (a *jitAsm) float(op bcOpcode, f []byte, h int) {
+	x := a.operand(h - 2)
+	mov := byte(0xF3) // movss
+	if op >= bcF64Add {
+		mov = 0xF2 // movsd
+	}
+	a.rm(false, 0, x, mov, 0x0F, 0x10) // mov xmm0, [x]
+	a.rm(false, 0, a.operand(h-1), f...)
+	a.rm(false, 0, x, mov, 0x0F, 0x11) // mov [x], xmm0
+	if mov == 0xF3 {
+		a.rm(false, 0, x+4, 0xC7) // mov dword [x+4], 0
+		a.emit32(0)
+	}
+}
+
+// memory compiles the load or store in by m, which exits to Go unless it accesses linear memory
+// 0 at an offset of 32 bits, as well as for out-of-bound accesses to be trapped there.
+func (a *jitAsm) memory(in *bcInstr, m *jitMemOp, pc, h int) {
+	if in.mem != 0 || in.imm > math.MaxUint32 {
+		a.exit(pc)
+		return
+	}
+
+	addr := a.operand(h - 1)
+	if m.store {
+		addr = a.operand(h - 2)
+	}
+	a.load(jitRAX, addr, false)
+	a.emit(0x48, 0xBA) // mov rdx, offset+size
+	a.emit64(in.imm + uint64(m.size))
+	a.emit(0x48, 0x01, 0xC2) // add rdx, rax
+	a.emit(0x4C, 0x39, 0xCA) // cmp rdx, r9
+	a.exits = append(a.exits, jitFixup{at: a.jcc(jitCondA), pc: pc})
+	a.emit(0x4C, 0x01, 0xC2) // add rdx, r8
+
+	if m.store {
+		a.load(jitRAX, a.operand(h-1), true)
+	}
+	a.emit(m.op...)
+	a.emit(0x42, -m.size) // [rdx-size]
+	if !m.store {
+		a.store(addr, jitRAX)
+	}
+}
+
+// divide compiles the division or remainder op, which exits to Go for the divisor 0 as well as -1
+// if signed, to be trapped or computed there.
+func (a *jitAsm) divide(op bcOpcode, pc, h int) {
+	x := a.operand(h - 2)
+	wide := op >= bcI64DivS
+	signed := op == bcI32DivS || op == bcI32RemS || op == bcI64DivS || op == bcI64RemS
+	rem := op == bcI32RemS || op == bcI32RemU || op == bcI64RemS || op == bcI64RemU
+
+	rex := func() {
+		if wide {
+			a.emit(0x48)
+		}
+	}
+
+	a.load(jitRAX, x, wide)
+	a.load(jitRCX, a.operand(h-1), wide)
+	rex()
+	a.emit(0x85, 0xC9) // test rcx, rcx
+	a.exits = append(a.exits, jitFixup{at: a.jcc(jitCondE), pc: pc})
+	if signed {
+		rex()
+		a.emit(0x83, 0xF9, 0xFF) // cmp rcx, -1
+		a.exits = append(a.exits, jitFixup{at: a.jcc(jitCondE), pc: pc})
+		rex()
+		a.emit(0x99) // cqo
+		rex()
+		a.emit(0xF7, 0xF9) // idiv rcx
+	} else {
+		a.emit(0x31, 0xD2) // xor edx, edx
+		rex()
+		a.emit(0xF7, 0xF1) // div rcx
+	}
+
+	if rem {
+		a.store(x, jitRDX)
+	} else {
+		a.store(x, jitRAX)
+	}
+}
+
+// brTable compiles br_table by a table of jumps to stubs branching to each target.
+func (a *jitAsm) brTable(in *bcInstr, pc, h int) {
+	n := len(in.table)
+	a.load(jitRAX, a.operand(h-1), false)
+	a.emit(0xB9) // mov ecx, n-1
+	a.emit32(uint32(n - 1))
+	a.emit(0x39, 0xC8)       // cmp eax, ecx
+	a.emit(0x0F, 0x43, 0xC1) // cmovae eax, ecx
+	a.emit(0x48, 0x8D, 0x0D) // lea rcx, [rip+table]
+	lea := len(a.buf)
+	a.emit32(0)
+	a.emit(0x48, 0x63, 0x04, 0x81) // movsxd rax, dword [rcx+rax*4]
+	a.emit(0x48, 0x01, 0xC8)       // add rax, rcx
+	a.emit(0xFF, 0xE0)             // jmp rax
+
+	table := len(a.buf)
+	a.patch(lea, table)
+	a.buf = append(a.buf, make([]byte, 4*n)...)
+	for i := range in.table {
+		binary.LittleEndian.PutUint32(a.buf[table+4*i:], uint32(len(a.buf)-table))
+		a.branch(pc, h-1, &in.table[i])
+	}
+}
+
+// branch compiles the branch at pc to t with operands below h, which exits to Go on running out
+// of fuel if going backwards.
+func (a *jitAsm) branch(pc, h int, t *bcTarget) {
+	if t.pc <= pc {
+		a.emit(0x48, 0x83, 0x6F, 0x20, 0x01) // sub qword [rdi+32], 1
+		a.exits = append(a.exits, jitFixup{at: a.jcc(jitCondE), pc: pc})
+	}
+
+	if src := h - t.arity; src != t.height {
+		for i := 0; i < t.arity; i++ {
+			a.load(jitRAX, a.operand(src+i), true)
+			a.store(a.operand(t.height+i), jitRAX)
+		}
+	}
+	a.jump(t.pc)
+}
+
+// exit exits to Go with pc.
+func (a *jitAsm) exit(pc int) {
+	a.emit(0x48, 0xC7, 0x47, 0x18) // mov qword [rdi+24], pc
+	a.emit32(uint32(pc))
+	a.emit(0xC3) // ret
+}
+
+// link resolves jumps, and appends stubs of exits.
+func (a *jitAsm) link() {
+	for _, v := range a.jumps {
+		a.patch(v.at, int(a.offsets[v.pc]))
+	}
+
+	stubs := make(map[int]int)
+	for _, v := range a.exits {
+		stub, ok := stubs[v.pc]
+		if !ok {
+			stub = len(a.buf)
+			stubs[v.pc] = stub
+			a.exit(v.pc)
+		}
+		a.patch(v.at, stub)
+	}
+}
+
+func (a *jitAsm) emit(b ...byte) {
+	a.buf = append(a.buf, b...)
+}
+
+func (a *jitAsm) emit32(v uint32) {
+	a.buf = append(a.buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (a *jitAsm) emit64(v uint64) {
+	a.emit32(uint32(v))
+	a.emit32(uint32(v >> 32))
+}
+
+// jcc emits the conditional jump on cc, returning where its rel32 is.
+func (a *jitAsm) jcc(cc byte) int {
+	a.emit(0x0F, 0x80|cc)
+	a.emit32(0)
+	return len(a.buf) - 4
+}
+
+func (a *jitAsm) jump(pc int) {
+	a.emit(0xE9)
+	a.emit32(0)
+	a.jumps = append(a.jumps, jitFixup{at: len(a.buf) - 4, pc: pc})
+}
+
+// patch resolves the rel32 at buf[at:] to offset.
+func (a *jitAsm) patch(at, offset int) {
+	binary.LittleEndian.PutUint32(a.buf[at:], uint32(offset-(at+4)))
+}
+
+// rm emits op on register reg and the slot at disp from RSI, which is of 64 bits if wide.
+func (a *jitAsm) rm(wide bool, reg byte, disp int32, op ...byte) {
+	if wide {
+		a.emit(0x48)
+	}
+	a.emit(op...)
+	a.emit(0x86 | reg<<3)
+	a.emit32(uint32(disp))
+}
+
+// load loads reg from the slot at disp, which zero-extends 32 bits unless wide.
+func (a *jitAsm) load(reg byte, disp int32, wide bool) {
+	a.rm(wide, reg, disp, 0x8B)
+}
+
+func (a *jitAsm) store(disp int32, reg byte) {
+	a.rm(true, reg, disp, 0x89)
+}
+
+// setcc sets RAX to 1 on cc, or 0 otherwise.
+func (a *jitAsm) setcc(cc byte) {
+	a.emit(0x0F, 0x90|cc, 0xC0) // setcc al
+	a.emit(0x0F, 0xB6, 0xC0)    // movzx eax, al
+}
+
+func (a *jitAsm) local(idx uint64) int32 {
+	return int32(8 * idx)
+}
+
+func (a *jitAsm) operand(h int) int32 {
+	return int32(8 * (a.nLocals + h))
+}
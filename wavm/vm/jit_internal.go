@@ -0,0 +1,189 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// jitFuel is the number of branches backwards taken by machine code before it exits to Go, which
+// lets the runtime preempt the goroutine.
+const jitFuel = 1 << 16
+
+// jitCtx is shared with machine code, which finds locals and operands at regs and linear memory
+// at memBase, then exits with pc of the instruction of bytecode to run by Go. Its layout is fixed.
+type jitCtx struct {
+	regs    uintptr
+	memBase uintptr
+	memLen  uint64 // 0 if linear memory isn't accessed by machine code
+	pc      uint64
+	fuel    uint64
+}
+
+// jitCode is executable memory holding machine code of functions, which is unmapped once
+// unreachable.
+type jitCode struct {
+	mem []byte
+}
+
+// jitFunc is machine code compiled from bytecode of a function, whose call frames are those of
+// bytecode, so that they can go on by either any time between instructions.
+type jitFunc struct {
+	bc        *bcFunc
+	asm       []byte  // machine code before loaded
+	offsets   []int32 // of machine code by PCs of bytecode
+	heights   []int   // of operands before instructions of bytecode by PCs
+	frameSize int     // slots of locals and the deepest operands
+	code      *jitCode
+	entry     uintptr // address of the machine code once loaded
+}
+
+// enterJIT pushes the call frame of f compiled into machine code, whose args are atop the operand
+// stack. The frame goes on by bytecode if hooked.
+func (vm *VM) enterJIT(f *Func) {
+	frame := ControlFrame{Opcode: types.OpcodeCall, FuncIdx: f.idx, jit: f.jit}
+	if vm.isHooked() {
+		frame.bytecode, frame.jit = f.jit.bc, nil
+	}
+
+	frame.BP = vm.OperandStack.Len() - len(f.type_.ParamTypes)
+	vm.ControlStack.Push(frame)
+
+	for i := len(f.type_.ParamTypes); i < f.jit.bc.nLocals; i++ {
+		vm.OperandStack.slots = append(vm.OperandStack.slots, 0)
+	}
+	vm.local0Idx = uint32(frame.BP)
+}
+
+// exitJIT pops the call frame of machine code atop the control stack, leaving its results on the
+// operand stack.
+func (vm *VM) exitJIT() {
+	frames := vm.ControlStack.frames
+	f := &frames[len(frames)-1]
+
+	s, n := vm.OperandStack.slots, f.jit.bc.nResults
+	copy(s[f.BP:], s[len(s)-n:])
+	vm.OperandStack.slots = s[:f.BP+n]
+	vm.ControlStack.frames = frames[:len(frames)-1]
+
+	if caller, _, ok := vm.TopCallFrame(); ok {
+		vm.local0Idx = uint32(caller.BP)
+	}
+}
+
+// jitMemory returns linear memory to be accessed by machine code, which is none unless it's the
+// 32-bit one of this package.
+func (vm *VM) jitMemory() (uintptr, uint64) {
+	if len(vm.memories) == 0 {
+		return 0, 0
+	}
+
+	m, ok := vm.memories[0].(*Memory)
+	if !ok || m.Type_.Is64() || len(m.Data) == 0 {
+		return 0, 0
+	}
+	return uintptr(unsafe.Pointer(&m.Data[0])), uint64(len(m.Data))
+}
+
+// runJIT runs call frames of machine code atop the control stack for the loop started at depth,
+// until one of the other engines gets atop or all frames of the loop are done. Machine code exits
+// to Go for instructions it doesn't run, which are run as by bytecode. Frames go on by bytecode
+// once hooked.
+func (vm *VM) runJIT(depth int) error {
+	var ctx jitCtx
+	for {
+		frame, _ := vm.ControlStack.Top()
+		fn := frame.jit
+		if vm.isHooked() {
+			frame.bytecode, frame.jit = fn.bc, nil
+			return nil
+		}
+
+		bp := frame.BP
+		base := bp + fn.bc.nLocals
+		s := growRegisters(vm.OperandStack.slots, bp+fn.frameSize)
+		ctx.regs = uintptr(unsafe.Pointer(&s[bp]))
+		ctx.memBase, ctx.memLen = vm.jitMemory()
+		ctx.fuel = jitFuel
+		jitCall(fn.entry+uintptr(fn.offsets[frame.PC]), &ctx)
+
+		pc := int(ctx.pc)
+		in := &fn.bc.code[pc]
+		frame.PC = pc + 1
+		s = s[:base+fn.heights[pc]]
+		vm.OperandStack.slots = s
+
+		var err error
+		n := len(s)
+		switch in.op {
+		case bcFallback, bcFallbackCall:
+			err = in.run(vm, in.args)
+		case bcBr:
+			// out of fuel
+			vm.OperandStack.slots, frame.PC = branch(s, base, &in.target), in.target.pc
+		case bcBrIf:
+			vm.OperandStack.slots = s[:n-1]
+			if s[n-1] != 0 {
+				vm.OperandStack.slots, frame.PC = branch(s[:n-1], base, &in.target), in.target.pc
+			}
+		case bcBrTable:
+			t := &in.table[len(in.table)-1]
+			if i := uint32(s[n-1]); i < uint32(len(in.table)-1) {
+				t = &in.table[i]
+			}
+			vm.OperandStack.slots, frame.PC = branch(s[:n-1], base, t), t.pc
+		case bcGlobalGet:
+			vm.OperandStack.slots = append(s, vm.globals[in.imm].GetAsUint64())
+		case bcBrOnNull:
+			if s[n-1] == 0 {
+				vm.OperandStack.slots, frame.PC = branch(s[:n-1], base, &in.target), in.target.pc
+			}
+		case bcBrOnNonNull:
+			if s[n-1] == 0 {
+				vm.OperandStack.slots = s[:n-1]
+			} else {
+				vm.OperandStack.slots, frame.PC = branch(s, base, &in.target), in.target.pc
+			}
+		case bcBrOnCast:
+			arg := in.args.(types.GCArg)
+			if matchRef(vm, s[n-1], arg.DstType) == (arg.SubOpcode == types.GCBrOnCast) {
+				vm.OperandStack.slots, frame.PC = branch(s, base, &in.target), in.target.pc
+			}
+		case bcCall:
+			if f := &vm.funcs[in.imm]; f.jit != nil {
+				vm.enterJIT(f)
+			} else {
+				err = callFunc(vm, *f)
+			}
+		case bcReturn:
+			vm.exitJIT()
+		default:
+			if in.op < bcI32Eqz {
+				err = fmt.Errorf("exit by bytecode %d: %w", in.op, ErrUnimplemented)
+				break
+			}
+			vm.OperandStack.slots, err = vm.runTypedBytecode(in, s)
+		}
+
+		if err != nil {
+			var exn *linker.Exception
+			if !errors.As(err, &exn) {
+				err = fmt.Errorf("exec instruction of PC(%d) at 0x%x in %s: %w", pc, in.offset(),
+					vm.describeFunc(), err)
+				return vm.trap(depth, err)
+			}
+			// no frame of machine code catches, so let the loop go on with the catching one if any
+			return vm.catchException(exn, depth)
+		}
+
+		if vm.ControlStack.Len() < depth {
+			return nil
+		}
+		if frame, _ = vm.ControlStack.Top(); frame.jit == nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// jitSupported tells whether EngineJIT is supported on this platform.
+const jitSupported = true
+
+// jitCall runs machine code at code with ctx until it exits.
+//
+//go:noescape
+func jitCall(code uintptr, ctx *jitCtx)
+
+// loadJIT loads machine code of funcs into executable memory.
+func loadJIT(funcs []*jitFunc) error {
+	var size int
+	for _, v := range funcs {
+		size += len(v.asm)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return fmt.Errorf("mmap: %w", err)
+	}
+	code := &jitCode{mem: mem}
+	runtime.SetFinalizer(code, func(c *jitCode) { syscall.Munmap(c.mem) })
+
+	var offset int
+	for _, v := range funcs {
+		copy(mem[offset:], v.asm)
+		v.code, v.entry = code, uintptr(unsafe.Pointer(&mem[offset]))
+		offset, v.asm = offset+len(v.asm), nil
+	}
+
+	if err := syscall.Mprotect(mem, syscall.PROT_READ|syscall.PROT_EXEC); err != nil {
+		return fmt.Errorf("mprotect: %w", err)
+	}
+
+	return nil
+}
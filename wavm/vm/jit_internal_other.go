@@ -0,0 +1,25 @@
+//go:build !linux || !amd64
+// +build !linux !amd64
+
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// jitSupported tells whether EngineJIT is supported on this platform.
+const jitSupported = false
+
+func compileJIT(bc *bcFunc, heights map[*types.Instruction]int) *jitFunc {
+	return nil
+}
+
+func jitCall(code uintptr, ctx *jitCtx) {
+	panic("jit unsupported")
+}
+
+func loadJIT(funcs []*jitFunc) error {
+	return fmt.Errorf("jit: %w", ErrUnimplemented)
+}
@@ -0,0 +1,11 @@
+#include "textflag.h"
+
+// func jitCall(code uintptr, ctx *jitCtx)
+TEXT ·jitCall(SB), NOSPLIT, $0-16
+	MOVQ code+0(FP), AX
+	MOVQ ctx+8(FP), DI
+	MOVQ 0(DI), SI
+	MOVQ 8(DI), R8
+	MOVQ 16(DI), R9
+	CALL AX
+	RET
@@ -0,0 +1,7 @@
+package vm_test
+
+import "github.com/sammyne/mastering-wasm/wavm/vm"
+
+func init() {
+	testEngines = append(testEngines, vm.EngineJIT)
+}
@@ -1,28 +1,97 @@
 package vm
 
-import "github.com/sammyne/mastering-wasm/wavm/types"
+import (
+	"fmt"
+	"math"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
 
 type Memory struct {
 	Type_ types.Memory
 	Data  []byte
 }
 
-func (m *Memory) Grow(n uint32) uint32 {
+func (m *Memory) AtomicCompareExchange(offset uint64, bitWidth int,
+	expected, replacement uint64) (uint64, error) {
+	if err := checkAtomicAccess(uint64(len(m.Data)), offset, bitWidth); err != nil {
+		return 0, err
+	}
+
+	mask := atomicValueMask(bitWidth)
+	old := atomicLoadBytes(m.Data, offset, bitWidth)
+	if old == expected&mask {
+		atomicStoreBytes(m.Data, offset, bitWidth, replacement&mask)
+	}
+
+	return old, nil
+}
+
+func (m *Memory) AtomicLoad(offset uint64, bitWidth int) (uint64, error) {
+	if err := checkAtomicAccess(uint64(len(m.Data)), offset, bitWidth); err != nil {
+		return 0, err
+	}
+
+	return atomicLoadBytes(m.Data, offset, bitWidth), nil
+}
+
+// AtomicNotify always returns 0 since nothing can wait on an unshared memory.
+func (m *Memory) AtomicNotify(offset uint64, count uint32) (uint32, error) {
+	if err := checkAtomicAccess(uint64(len(m.Data)), offset, 32); err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+func (m *Memory) AtomicRMW(offset uint64, bitWidth int,
+	op func(uint64) uint64) (uint64, error) {
+	if err := checkAtomicAccess(uint64(len(m.Data)), offset, bitWidth); err != nil {
+		return 0, err
+	}
+
+	return atomicRMWBytes(m.Data, offset, bitWidth, op), nil
+}
+
+func (m *Memory) AtomicStore(offset uint64, bitWidth int, v uint64) error {
+	if err := checkAtomicAccess(uint64(len(m.Data)), offset, bitWidth); err != nil {
+		return err
+	}
+
+	atomicStoreBytes(m.Data, offset, bitWidth, v&atomicValueMask(bitWidth))
+	return nil
+}
+
+// AtomicWait always fails since waiting on an unshared memory would block forever.
+func (m *Memory) AtomicWait(offset uint64, bitWidth int, expected uint64,
+	timeout int64) (uint32, error) {
+	return 0, ErrExpectedShared
+}
+
+func (m *Memory) Grow(n uint64) uint64 {
 	oldSize := m.Size()
+	if n > getMaxPageCount(m.Type_)-oldSize {
+		return math.MaxUint64
+	}
+
 	old := m.Data
 	m.Data = append(m.Data, make([]byte, n*types.PageSize)...)
 	copy(m.Data, old)
 
-	return uint32(oldSize)
+	return oldSize
 }
 
 func (m *Memory) Read(offset uint64, buf []byte) error {
+	if err := checkMemoryAccess(uint64(len(m.Data)), offset, uint64(len(buf))); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
 	copy(buf, m.Data[offset:])
-	return nil // TODO: bound check
+	return nil
 }
 
-func (m *Memory) Size() uint32 {
-	return uint32(len(m.Data) / types.PageSize)
+func (m *Memory) Size() uint64 {
+	return uint64(len(m.Data) / types.PageSize)
 }
 
 func (m *Memory) Type() types.Memory {
@@ -30,10 +99,20 @@ func (m *Memory) Type() types.Memory {
 }
 
 func (m *Memory) Write(offset uint64, data []byte) error {
+	if err := checkMemoryAccess(uint64(len(m.Data)), offset, uint64(len(data))); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
 	copy(m.Data[offset:], data)
-	return nil // TODO: bound check
+	return nil
 }
 
-func NewMemory(t types.Memory) *Memory {
-	return &Memory{Type_: t, Data: make([]byte, t.Min*types.PageSize)}
+// NewMemory makes a memory of t.Min pages, failing with ErrMemoryTooLarge if they pass the
+// implementation limit.
+func NewMemory(t types.Memory) (*Memory, error) {
+	if t.Min > getMaxPageCount(t) {
+		return nil, fmt.Errorf("%d pages: %w", t.Min, ErrMemoryTooLarge)
+	}
+
+	return &Memory{Type_: t, Data: make([]byte, t.Min*types.PageSize)}, nil
 }
@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Accesses narrower than 32 bits are done on the enclosing aligned 32-bit word, assuming a
+// little-endian host as the wasm memory itself is.
+
+type waitQueues struct {
+	mu      sync.Mutex
+	waiters map[uint64][]chan struct{}
+}
+
+func (q *waitQueues) notify(offset uint64, count uint32) uint32 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiters := q.waiters[offset]
+	n := uint32(len(waiters))
+	if n > count {
+		n = count
+	}
+	if n == 0 {
+		return 0
+	}
+
+	for _, v := range waiters[:n] {
+		close(v)
+	}
+	if q.waiters[offset] = waiters[n:]; len(q.waiters[offset]) == 0 {
+		delete(q.waiters, offset)
+	}
+
+	return n
+}
+
+// wait blocks if the value loaded by load equals expected. The load happens while holding the queue
+// lock, so no notify issued after the value changed can be missed.
+func (q *waitQueues) wait(offset uint64, load func() uint64, expected uint64,
+	timeout int64) uint32 {
+	q.mu.Lock()
+	if load() != expected {
+		q.mu.Unlock()
+		return 1
+	}
+
+	if q.waiters == nil {
+		q.waiters = make(map[uint64][]chan struct{})
+	}
+	woken := make(chan struct{})
+	q.waiters[offset] = append(q.waiters[offset], woken)
+	q.mu.Unlock()
+
+	if timeout < 0 {
+		<-woken
+		return 0
+	}
+
+	timer := time.NewTimer(time.Duration(timeout))
+	defer timer.Stop()
+
+	select {
+	case <-woken:
+		return 0
+	case <-timer.C:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiters := q.waiters[offset]
+	for i, v := range waiters {
+		if v == woken {
+			q.waiters[offset] = append(waiters[:i:i], waiters[i+1:]...)
+			return 2
+		}
+	}
+
+	// notified after the timer fired but before the lock is taken
+	return 0
+}
+
+func atomicCompareAndSwapBytes(data []byte, offset uint64, bitWidth int, old, new uint64) bool {
+	switch bitWidth {
+	case 64:
+		return atomic.CompareAndSwapUint64((*uint64)(unsafe.Pointer(&data[offset])), old, new)
+	case 32:
+		return atomic.CompareAndSwapUint32((*uint32)(unsafe.Pointer(&data[offset])), uint32(old),
+			uint32(new))
+	default:
+	}
+
+	word := (*uint32)(unsafe.Pointer(&data[offset&^3]))
+	shift, mask := atomicSubWordShiftAndMask(offset, bitWidth)
+	for {
+		w := atomic.LoadUint32(word)
+		if (w&mask)>>shift != uint32(old) {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(word, w, w&^mask|uint32(new)<<shift) {
+			return true
+		}
+	}
+}
+
+func atomicLoadBytes(data []byte, offset uint64, bitWidth int) uint64 {
+	switch bitWidth {
+	case 64:
+		return atomic.LoadUint64((*uint64)(unsafe.Pointer(&data[offset])))
+	case 32:
+		return uint64(atomic.LoadUint32((*uint32)(unsafe.Pointer(&data[offset]))))
+	default:
+	}
+
+	shift, mask := atomicSubWordShiftAndMask(offset, bitWidth)
+	w := atomic.LoadUint32((*uint32)(unsafe.Pointer(&data[offset&^3])))
+	return uint64((w & mask) >> shift)
+}
+
+func atomicRMWBytes(data []byte, offset uint64, bitWidth int, op func(uint64) uint64) uint64 {
+	mask := atomicValueMask(bitWidth)
+	for {
+		old := atomicLoadBytes(data, offset, bitWidth)
+		if atomicCompareAndSwapBytes(data, offset, bitWidth, old, op(old)&mask) {
+			return old
+		}
+	}
+}
+
+func atomicStoreBytes(data []byte, offset uint64, bitWidth int, v uint64) {
+	switch bitWidth {
+	case 64:
+		atomic.StoreUint64((*uint64)(unsafe.Pointer(&data[offset])), v)
+	case 32:
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(&data[offset])), uint32(v))
+	default:
+		atomicRMWBytes(data, offset, bitWidth, func(uint64) uint64 { return v })
+	}
+}
+
+func atomicSubWordShiftAndMask(offset uint64, bitWidth int) (uint32, uint32) {
+	shift := uint32(offset&3) * 8
+	return shift, uint32(atomicValueMask(bitWidth)) << shift
+}
+
+func atomicValueMask(bitWidth int) uint64 {
+	if bitWidth == 64 {
+		return ^uint64(0)
+	}
+
+	return 1<<bitWidth - 1
+}
+
+func checkAtomicAccess(memLen, offset uint64, bitWidth int) error {
+	if offset%uint64(bitWidth/8) != 0 {
+		return fmt.Errorf("offset %d: %w", offset, ErrUnalignedAtomic)
+	}
+
+	return checkMemoryAccess(memLen, offset, uint64(bitWidth/8))
+}
+
+func checkMemoryAccess(memLen, offset, n uint64) error {
+	if offset > memLen || n > memLen-offset {
+		return fmt.Errorf("access [%d, %d+%d) beyond %d: %w", offset, offset, n, memLen,
+			ErrIndexOutOfBound)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// SharedMemory is a memory flagged as shared, which instances running on different goroutines may
+// access concurrently. Its address space is reserved up to the maximum at once and committed as it
+// grows, so growing never moves the data under other accessors' feet. The address space is given
+// back by Close, or by the finalizer if it's never closed.
+type SharedMemory struct {
+	type_   types.Memory
+	data    []byte
+	release func() // nil once closed
+	size    uint64 // in pages, accessed atomically
+	growMu  sync.Mutex
+	waiters waitQueues
+}
+
+func (m *SharedMemory) AtomicCompareExchange(offset uint64, bitWidth int,
+	expected, replacement uint64) (uint64, error) {
+	if err := checkAtomicAccess(m.byteLen(), offset, bitWidth); err != nil {
+		return 0, err
+	}
+
+	mask := atomicValueMask(bitWidth)
+	expected, replacement = expected&mask, replacement&mask
+	for {
+		old := atomicLoadBytes(m.data, offset, bitWidth)
+		if old != expected || atomicCompareAndSwapBytes(m.data, offset, bitWidth, old, replacement) {
+			return old, nil
+		}
+	}
+}
+
+func (m *SharedMemory) AtomicLoad(offset uint64, bitWidth int) (uint64, error) {
+	if err := checkAtomicAccess(m.byteLen(), offset, bitWidth); err != nil {
+		return 0, err
+	}
+
+	return atomicLoadBytes(m.data, offset, bitWidth), nil
+}
+
+func (m *SharedMemory) AtomicNotify(offset uint64, count uint32) (uint32, error) {
+	if err := checkAtomicAccess(m.byteLen(), offset, 32); err != nil {
+		return 0, err
+	}
+
+	return m.waiters.notify(offset, count), nil
+}
+
+func (m *SharedMemory) AtomicRMW(offset uint64, bitWidth int,
+	op func(uint64) uint64) (uint64, error) {
+	if err := checkAtomicAccess(m.byteLen(), offset, bitWidth); err != nil {
+		return 0, err
+	}
+
+	return atomicRMWBytes(m.data, offset, bitWidth, op), nil
+}
+
+func (m *SharedMemory) AtomicStore(offset uint64, bitWidth int, v uint64) error {
+	if err := checkAtomicAccess(m.byteLen(), offset, bitWidth); err != nil {
+		return err
+	}
+
+	atomicStoreBytes(m.data, offset, bitWidth, v&atomicValueMask(bitWidth))
+	return nil
+}
+
+func (m *SharedMemory) AtomicWait(offset uint64, bitWidth int, expected uint64,
+	timeout int64) (uint32, error) {
+	if err := checkAtomicAccess(m.byteLen(), offset, bitWidth); err != nil {
+		return 0, err
+	}
+
+	load := func() uint64 { return atomicLoadBytes(m.data, offset, bitWidth) }
+	return m.waiters.wait(offset, load, expected, timeout), nil
+}
+
+// Close gives back the reserved address space, after which all accesses are out of bounds. It
+// mustn't be called while accessed by instances, i.e. they must have returned.
+func (m *SharedMemory) Close() error {
+	m.growMu.Lock()
+	defer m.growMu.Unlock()
+
+	if m.release == nil {
+		return nil
+	}
+
+	atomic.StoreUint64(&m.size, 0)
+	m.data = nil
+	m.release()
+	m.release = nil
+	runtime.SetFinalizer(m, nil)
+	return nil
+}
+
+func (m *SharedMemory) Grow(n uint64) uint64 {
+	m.growMu.Lock()
+	defer m.growMu.Unlock()
+
+	oldSize := atomic.LoadUint64(&m.size)
+	if m.release == nil || n > getMaxPageCount(m.type_)-oldSize {
+		return math.MaxUint64
+	}
+
+	if err := commitBytes(m.data[oldSize*types.PageSize : (oldSize+n)*types.PageSize]); err != nil {
+		return math.MaxUint64
+	}
+
+	atomic.StoreUint64(&m.size, oldSize+n)
+	return oldSize
+}
+
+func (m *SharedMemory) Read(offset uint64, buf []byte) error {
+	if err := checkMemoryAccess(m.byteLen(), offset, uint64(len(buf))); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	copy(buf, m.data[offset:])
+	return nil
+}
+
+func (m *SharedMemory) Size() uint64 {
+	return atomic.LoadUint64(&m.size)
+}
+
+func (m *SharedMemory) Type() types.Memory {
+	return m.type_
+}
+
+func (m *SharedMemory) Write(offset uint64, data []byte) error {
+	if err := checkMemoryAccess(m.byteLen(), offset, uint64(len(data))); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	copy(m.data[offset:], data)
+	return nil
+}
+
+func (m *SharedMemory) byteLen() uint64 {
+	return m.Size() * types.PageSize
+}
+
+// NewSharedMemory makes a shared memory of t.Min pages, failing with ErrMemoryTooLarge if they pass
+// the implementation limit, above which its maximum is cut down.
+func NewSharedMemory(t types.Memory) (*SharedMemory, error) {
+	maxPages := getMaxPageCount(t)
+	if t.Min > maxPages {
+		return nil, fmt.Errorf("%d pages: %w", t.Min, ErrMemoryTooLarge)
+	}
+
+	data, release, err := reserveBytes(int(maxPages * types.PageSize))
+	if err != nil {
+		return nil, fmt.Errorf("reserve %d pages: %w", maxPages, err)
+	}
+	if err := commitBytes(data[:t.Min*types.PageSize]); err != nil {
+		release()
+		return nil, fmt.Errorf("commit %d pages: %w", t.Min, err)
+	}
+
+	out := &SharedMemory{type_: t, data: data, release: release, size: t.Min}
+	runtime.SetFinalizer(out, (*SharedMemory).Close)
+	return out, nil
+}
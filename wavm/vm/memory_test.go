@@ -0,0 +1,188 @@
+package vm_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestMemoryGrow64(t *testing.T) {
+	funcs := []testFunc{
+		{"grow", []byte{i64}, []byte{i64}, nil, []byte{0x20, 0, 0x40, 0}},
+		{"size", nil, []byte{i64}, nil, []byte{0x3F, 0}},
+		// stores v at the last byte of memory
+		{"store", []byte{i64}, nil, nil, []byte{0x3F, 0, 0x42, 16, 0x86, 0x42, 1, 0x7D, 0x20, 0,
+			0x3C, 0, 0}},
+	}
+	m := newTestVM(t, testModule{funcs: funcs, sections: map[byte][]byte{
+		5: wasmtest.Vec([]byte{types.LimitsTagMin64, 1}),
+	}}, vm.EngineTree)
+
+	testVector := []struct {
+		delta uint64
+		old   int64
+	}{
+		{1, 1},
+		{math.MaxUint64, -1},
+		{1 << 48, -1},
+		{1 << 64 / types.PageSize, -1},
+		{types.MaxPageCount, -1},
+		{0, 2},
+		{3, 2},
+	}
+	for _, c := range testVector {
+		got, err := m.InvokeFunc("grow", int64(c.delta))
+		if err != nil {
+			t.Fatalf("grow %d: %v", c.delta, err)
+		}
+		if got[0].(int64) != c.old {
+			t.Fatalf("grow %d: expect %d, got %d", c.delta, c.old, got[0])
+		}
+	}
+
+	if size, err := m.InvokeFunc("size"); err != nil || size[0].(int64) != 5 {
+		t.Fatalf("expect 5 pages, got %v, %v", size, err)
+	}
+	if _, err := m.InvokeFunc("store", int64(0xFF)); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+}
+
+func TestNewMemory(t *testing.T) {
+	testVector := []struct {
+		t   types.Memory
+		err error
+	}{
+		{types.Memory{Tag: types.LimitsTagMin, Min: 2}, nil},
+		{types.Memory{Tag: types.LimitsTagMin64, Min: 2}, nil},
+		{types.Memory{Tag: types.LimitsTagMin64, Min: 1 << 48}, vm.ErrMemoryTooLarge},
+		{types.Memory{Tag: types.LimitsTagMinMax64, Min: math.MaxUint64 / types.PageSize,
+			Max: math.MaxUint64 / types.PageSize}, vm.ErrMemoryTooLarge},
+	}
+
+	for _, c := range testVector {
+		mem, err := vm.NewMemory(c.t)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%s: expect error %v, got %v", c.t, c.err, err)
+		}
+		if err == nil && mem.Size() != c.t.Min {
+			t.Fatalf("%s: expect %d pages, got %d", c.t, c.t.Min, mem.Size())
+		}
+	}
+}
+
+func TestNewSharedMemory(t *testing.T) {
+	mem, err := vm.NewSharedMemory(types.Memory{Tag: types.LimitsTagSharedMinMax, Min: 1,
+		Max: types.MaxPageCount})
+	if err != nil {
+		t.Fatalf("new shared memory: %v", err)
+	}
+
+	if err := mem.Write(types.PageSize, []byte{1}); err == nil {
+		t.Fatal("expect write past the size to fail")
+	}
+	if old := mem.Grow(1); old != 1 {
+		t.Fatalf("expect grow from 1 page, got %d", old)
+	}
+	if err := mem.Write(2*types.PageSize-1, []byte{1}); err != nil {
+		t.Fatalf("write grown page: %v", err)
+	}
+	if old := mem.Grow(types.MaxPageCount - 1); old != math.MaxUint64 {
+		t.Fatalf("expect grow past the max to fail, got %d", old)
+	}
+
+	_, err = vm.NewSharedMemory(types.Memory{Tag: types.LimitsTagSharedMinMax64, Min: 1 << 40,
+		Max: 1 << 40})
+	if !errors.Is(err, vm.ErrMemoryTooLarge) {
+		t.Fatalf("expect error %v, got %v", vm.ErrMemoryTooLarge, err)
+	}
+
+	mem, err = vm.NewSharedMemory(types.Memory{Tag: types.LimitsTagSharedMinMax64, Min: 1,
+		Max: 1 << 40})
+	if err != nil {
+		t.Fatalf("new shared memory of max cut down: %v", err)
+	}
+	if old := mem.Grow(1 << 30); old != math.MaxUint64 {
+		t.Fatalf("expect grow past the limit to fail, got %d", old)
+	}
+}
+
+func TestSharedMemoryClose(t *testing.T) {
+	mem, err := vm.NewSharedMemory(types.Memory{Tag: types.LimitsTagSharedMinMax, Min: 1, Max: 2})
+	if err != nil {
+		t.Fatalf("new shared memory: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := mem.Close(); err != nil {
+			t.Fatalf("#%d close: %v", i, err)
+		}
+	}
+	if err := mem.Read(0, make([]byte, 1)); !errors.Is(err, vm.ErrIndexOutOfBound) {
+		t.Fatalf("expect reading closed memories to fail with %v, got %v", vm.ErrIndexOutOfBound, err)
+	}
+	if old := mem.Grow(1); old != math.MaxUint64 {
+		t.Fatalf("expect growing closed memories to fail, got %d", old)
+	}
+
+	// closing VMs closes memories they made
+	m := testModule{
+		funcs:    []testFunc{{"load", nil, []byte{i32}, nil, []byte{0x41, 0, 0xFE, 0x10, 2, 0}}},
+		sections: map[byte][]byte{5: wasmtest.Vec([]byte{0x03, 1, 2})},
+	}
+	instance := newTestVM(t, m, vm.EngineTree).(*vm.VM)
+	if _, err := instance.InvokeFunc("load"); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := instance.Close(); err != nil {
+		t.Fatalf("close VM: %v", err)
+	}
+	if _, err := instance.InvokeFunc("load"); !errors.Is(err, vm.ErrIndexOutOfBound) {
+		t.Fatalf("expect loading closed memories to fail with %v, got %v", vm.ErrIndexOutOfBound, err)
+	}
+}
+
+func TestMemory64Access(t *testing.T) {
+	funcs := []testFunc{
+		{"store", []byte{i64, i64}, nil, nil, []byte{0x20, 0, 0x20, 1, 0x37, 3, 0}},
+		{"load", []byte{i64}, []byte{i64}, nil, []byte{0x20, 0, 0x29, 3, 0}},
+		// loads at an offset of 1<<32 above the address
+		{"load.offset", []byte{i64}, []byte{i64}, nil, []byte{0x20, 0, 0x29, 3, 0x80, 0x80, 0x80,
+			0x80, 0x10}},
+	}
+	m := newTestVM(t, testModule{funcs: funcs, sections: map[byte][]byte{
+		5: wasmtest.Vec([]byte{types.LimitsTagMin64, 1}),
+	}}, vm.EngineTree)
+
+	if _, err := m.InvokeFunc("store", int64(types.PageSize-8), int64(42)); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	if got, err := m.InvokeFunc("load", int64(types.PageSize-8)); err != nil || got[0] != int64(42) {
+		t.Fatalf("load: expect 42, got %v, %v", got, err)
+	}
+
+	testVector := []struct {
+		f    string
+		addr int64
+	}{
+		{"load", types.PageSize - 7},
+		{"load", 1 << 32},
+		{"load", -8},
+		{"load.offset", 0},
+		{"load.offset", -1 << 32},
+		{"store", 1<<32 + 8},
+	}
+	for _, c := range testVector {
+		args := []types.WasmVal{c.addr}
+		if c.f == "store" {
+			args = append(args, int64(0))
+		}
+		if _, err := m.InvokeFunc(c.f, args...); !errors.Is(err, vm.ErrIndexOutOfBound) {
+			t.Fatalf("%s %#x: expect %v, got %v", c.f, c.addr, vm.ErrIndexOutOfBound, err)
+		}
+	}
+}
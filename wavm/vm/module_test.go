@@ -0,0 +1,160 @@
+package vm_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+// type codes of numeric values
+const i32, i64, f32, f64 byte = 0x7F, 0x7E, 0x7D, 0x7C
+
+type testFunc struct {
+	name            string
+	params, results []byte
+	locals          []byte
+	body            []byte
+}
+
+// testModule is a handcrafted module importing env.double as func 0, whose funcs follow with types
+// of their own and are exported by names.
+type testModule struct {
+	funcs []testFunc
+	// types are extra types indexed after those of funcs.
+	types [][]byte
+	// sections are bodies of other sections by IDs, such as memories, tables and globals.
+	sections map[byte][]byte
+	// offsetless zeroes offsets of instructions decoded, as modules built in Go leave them.
+	offsetless bool
+}
+
+func (m testModule) encode() []byte {
+	typeSec := [][]byte{wasmtest.FuncType([]byte{i32}, []byte{i32})}
+	var funcSec, exportSec, codeSec [][]byte
+	for i, f := range m.funcs {
+		typeSec = append(typeSec, wasmtest.FuncType(f.params, f.results))
+		funcSec = append(funcSec, wasmtest.Uleb(uint64(i+1)))
+		exportSec = append(exportSec, wasmtest.Export(f.name, 0, uint32(i+1)))
+		codeSec = append(codeSec, wasmtest.Code(f.locals, f.body...))
+	}
+	typeSec = append(typeSec, m.types...)
+
+	out := wasmtest.Module(
+		wasmtest.Section(1, wasmtest.Vec(typeSec...)),
+		wasmtest.Section(2, wasmtest.Vec(wasmtest.Concat(wasmtest.Name("env"), wasmtest.Name("double"),
+			[]byte{0, 0}))),
+		wasmtest.Section(3, wasmtest.Vec(funcSec...)),
+	)
+	// the rest follow the order of sections, where tags go between memories and globals, and the
+	// custom section goes last
+	for _, id := range []byte{4, 5, 13, 6, 7, 8, 9, 12, 10, 11, 0} {
+		switch body, ok := m.sections[id]; {
+		case id == 7:
+			out = append(out, wasmtest.Section(7, wasmtest.Vec(exportSec...))...)
+		case id == 10:
+			out = append(out, wasmtest.Section(10, wasmtest.Vec(codeSec...))...)
+		case ok:
+			out = append(out, wasmtest.Section(id, body)...)
+		}
+	}
+	return out
+}
+
+// testHost is the module env, whose double doubles an i32.
+type testHost struct{}
+
+func (testHost) GetGlobalVal(name string) (types.WasmVal, error) {
+	return nil, errors.New("no globals")
+}
+
+func (testHost) GetMember(name string) (interface{}, error) {
+	return testDouble{}, nil
+}
+
+func (testHost) InvokeFunc(name string, args ...types.WasmVal) ([]types.WasmVal, error) {
+	return testDouble{}.Call(args...)
+}
+
+func (testHost) SetGlobalVal(name string, val types.WasmVal) error {
+	return errors.New("no globals")
+}
+
+type testDouble struct{}
+
+func (testDouble) Type() types.FuncType {
+	return types.FuncType{ParamTypes: []types.ValueType{types.ValueTypeI32},
+		ResultTypes: []types.ValueType{types.ValueTypeI32}}
+}
+
+func (testDouble) Call(args ...types.WasmVal) ([]types.WasmVal, error) {
+	return []types.WasmVal{2 * args[0].(int32)}, nil
+}
+
+func newTestVM(t testing.TB, m testModule, engine vm.Engine) linker.Module {
+	t.Helper()
+
+	module, err := wavm.NewDecoder(m.encode()).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode module: %v", err)
+	}
+	for _, v := range module.Codes {
+		if m.offsetless {
+			tools.WalkInstrs(v.Expr, func(instr *types.Instruction) { instr.Offset = 0 })
+		}
+	}
+
+	out, err := vm.NewVMWithOptions(module, map[string]linker.Module{"env": testHost{}},
+		vm.Options{Engine: engine})
+	if err != nil {
+		t.Fatalf("new %s VM: %v", engine, err)
+	}
+	return out
+}
+
+func testVal(t byte, v uint64) types.WasmVal {
+	switch t {
+	case i32:
+		return int32(v)
+	case i64:
+		return int64(v)
+	case f32:
+		return math.Float32frombits(uint32(v >> 32))
+	default:
+		return math.Float64frombits(v)
+	}
+}
+
+// testCause returns the innermost error of err.
+func testCause(err error) error {
+	for err != nil && errors.Unwrap(err) != nil {
+		err = errors.Unwrap(err)
+	}
+	return err
+}
+
+func testEqual(a, b []types.WasmVal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if x, ok := a[i].(float64); ok {
+			if y := b[i].(float64); math.Float64bits(x) != math.Float64bits(y) {
+				return false
+			}
+		} else if x, ok := a[i].(float32); ok {
+			if y := b[i].(float32); math.Float32bits(x) != math.Float32bits(y) {
+				return false
+			}
+		} else if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,241 @@
+package vm
+
+import (
+	"sort"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// Fields of messages in profile.proto of pprof used by profilers.
+const (
+	pprofProfileSampleType        = 1
+	pprofProfileSample            = 2
+	pprofProfileMapping           = 3
+	pprofProfileLocation          = 4
+	pprofProfileFunction          = 5
+	pprofProfileStringTable       = 6
+	pprofProfileTimeNanos         = 9
+	pprofProfileDurationNanos     = 10
+	pprofProfilePeriodType        = 11
+	pprofProfilePeriod            = 12
+	pprofProfileDefaultSampleType = 14
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+
+	pprofMappingID           = 1
+	pprofMappingFilename     = 5
+	pprofMappingHasFunctions = 7
+
+	pprofLocationID        = 1
+	pprofLocationMappingID = 2
+	pprofLocationAddress   = 3
+	pprofLocationLine      = 4
+
+	pprofLineFunctionID = 1
+
+	pprofFunctionID   = 1
+	pprofFunctionName = 2
+)
+
+// Wire types of protobuf.
+const (
+	protoVarint = 0
+	protoBytes  = 2
+)
+
+// pprofBuilder builds a profile, interning strings, functions and locations.
+type pprofBuilder struct {
+	names     func(types.FuncIdx) string
+	strings   []string
+	stringIDs map[string]int64
+	funcIDs   map[string]uint64
+	funcs     protoBuffer
+	locIDs    map[StackFrame]uint64
+	locs      protoBuffer
+}
+
+// protoBuffer encodes fields of a protobuf message.
+type protoBuffer []byte
+
+func (p *Profiler) encodePprof() []byte {
+	b := &pprofBuilder{
+		names:     p.vm.module.Names.DescribeFunc,
+		strings:   []string{""},
+		stringIDs: map[string]int64{"": 0},
+		funcIDs:   make(map[string]uint64),
+		locIDs:    make(map[StackFrame]uint64),
+	}
+
+	var out protoBuffer
+	for _, v := range [][2]string{{"instructions", "count"}, {"samples", "count"}, {"wall", "nanoseconds"}} {
+		out.message(pprofProfileSampleType, b.valueType(v[0], v[1]))
+	}
+
+	// exact counts by instructions
+	funcIdxes := make([]types.FuncIdx, 0, len(p.counts))
+	for v := range p.counts {
+		funcIdxes = append(funcIdxes, v)
+	}
+	sort.Slice(funcIdxes, func(i, j int) bool { return funcIdxes[i] < funcIdxes[j] })
+	for _, funcIdx := range funcIdxes {
+		offsets := make([]uint32, 0, len(p.counts[funcIdx]))
+		for v := range p.counts[funcIdx] {
+			offsets = append(offsets, v)
+		}
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+		for _, offset := range offsets {
+			var s protoBuffer
+			s.packed(pprofSampleLocationID, b.location(StackFrame{FuncIdx: funcIdx, Offset: offset}))
+			s.packed(pprofSampleValue, uint64(p.counts[funcIdx][offset]), 0, 0)
+			out.message(pprofProfileSample, s)
+		}
+	}
+
+	// wall-clock samples by call stacks
+	for _, v := range p.sortedSamples() {
+		var locs []uint64
+		if v.host != "" {
+			locs = append(locs, b.location(StackFrame{FuncName: v.host, External: true}))
+		}
+		for _, f := range v.frames {
+			locs = append(locs, b.location(StackFrame{FuncIdx: f.FuncIdx, Offset: f.Offset}))
+		}
+
+		var s protoBuffer
+		s.packed(pprofSampleLocationID, locs...)
+		s.packed(pprofSampleValue, 0, uint64(v.samples), uint64(v.nanos))
+		out.message(pprofProfileSample, s)
+	}
+
+	var mapping protoBuffer
+	mapping.varint(pprofMappingID, 1)
+	mapping.varint(pprofMappingFilename, uint64(b.string(p.vm.module.Names.Module)))
+	mapping.varint(pprofMappingHasFunctions, 1)
+	out.message(pprofProfileMapping, mapping)
+
+	out = append(out, b.locs...)
+	out = append(out, b.funcs...)
+
+	periodType := b.valueType("wall", "nanoseconds")
+	defaultSampleType := b.string("wall")
+	for _, v := range b.strings {
+		out.bytes(pprofProfileStringTable, []byte(v))
+	}
+
+	duration := p.duration
+	if p.vm.profiler == p {
+		duration = 0 // still running
+	}
+	out.varint(pprofProfileTimeNanos, uint64(p.start.UnixNano()))
+	out.varint(pprofProfileDurationNanos, uint64(duration))
+	out.message(pprofProfilePeriodType, periodType)
+	out.varint(pprofProfilePeriod, uint64(p.opts.SampleInterval))
+	out.varint(pprofProfileDefaultSampleType, uint64(defaultSampleType))
+
+	return out
+}
+
+// function interns the function named name.
+func (b *pprofBuilder) function(name string) uint64 {
+	if id, ok := b.funcIDs[name]; ok {
+		return id
+	}
+
+	id := uint64(len(b.funcIDs) + 1)
+	b.funcIDs[name] = id
+
+	var f protoBuffer
+	f.varint(pprofFunctionID, id)
+	f.varint(pprofFunctionName, uint64(b.string(name)))
+	b.funcs.message(pprofProfileFunction, f)
+
+	return id
+}
+
+// location interns the location of f, where external ones are named by FuncName.
+func (b *pprofBuilder) location(f StackFrame) uint64 {
+	if id, ok := b.locIDs[f]; ok {
+		return id
+	}
+
+	id := uint64(len(b.locIDs) + 1)
+	b.locIDs[f] = id
+
+	name := f.FuncName
+	if !f.External {
+		name = b.names(f.FuncIdx)
+	}
+
+	var line protoBuffer
+	line.varint(pprofLineFunctionID, b.function(name))
+
+	var loc protoBuffer
+	loc.varint(pprofLocationID, id)
+	loc.varint(pprofLocationMappingID, 1)
+	loc.varint(pprofLocationAddress, uint64(f.Offset))
+	loc.message(pprofLocationLine, line)
+	b.locs.message(pprofProfileLocation, loc)
+
+	return id
+}
+
+// string interns s into the string table.
+func (b *pprofBuilder) string(s string) int64 {
+	if id, ok := b.stringIDs[s]; ok {
+		return id
+	}
+
+	id := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIDs[s] = id
+
+	return id
+}
+
+func (b *pprofBuilder) valueType(typ, unit string) protoBuffer {
+	var out protoBuffer
+	out.varint(pprofValueTypeType, uint64(b.string(typ)))
+	out.varint(pprofValueTypeUnit, uint64(b.string(unit)))
+	return out
+}
+
+func (p *protoBuffer) bytes(field int, v []byte) {
+	p.tag(field, protoBytes)
+	p.uvarint(uint64(len(v)))
+	*p = append(*p, v...)
+}
+
+func (p *protoBuffer) message(field int, v protoBuffer) {
+	p.bytes(field, v)
+}
+
+// packed encodes repeated scalars as a packed field.
+func (p *protoBuffer) packed(field int, v ...uint64) {
+	var data protoBuffer
+	for _, vv := range v {
+		data.uvarint(vv)
+	}
+	p.bytes(field, data)
+}
+
+func (p *protoBuffer) tag(field int, wireType int) {
+	p.uvarint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) uvarint(v uint64) {
+	for v >= 0x80 {
+		*p = append(*p, byte(v)|0x80)
+		v >>= 7
+	}
+	*p = append(*p, byte(v))
+}
+
+func (p *protoBuffer) varint(field int, v uint64) {
+	p.tag(field, protoVarint)
+	p.uvarint(v)
+}
@@ -0,0 +1,152 @@
+package vm
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// ProfileOptions configures profilers.
+type ProfileOptions struct {
+	// SampleInterval is the interval of wall-clock samples, which are off if 0.
+	SampleInterval time.Duration
+}
+
+// Profiler counts instructions executed by a VM exactly, and samples its call stacks by wall-clock
+// time, attributing time spent in external functions to them. It isn't safe for concurrent use.
+type Profiler struct {
+	vm   *VM
+	opts ProfileOptions
+
+	start    time.Time
+	duration time.Duration // set by Stop
+	// counts are executions of instructions by offsets by their functions.
+	counts map[types.FuncIdx]map[uint32]uint64
+
+	ticked     int32 // set by the ticker atomically
+	quit       chan struct{}
+	lastSample time.Time
+	// samples are keyed by their stacks.
+	samples map[string]*profileSample
+	// instr is the instruction executing.
+	instr types.Instruction
+}
+
+// Counts returns executions of instructions by offsets by the functions they belong to.
+func (p *Profiler) Counts() map[types.FuncIdx]map[uint32]uint64 {
+	return p.counts
+}
+
+// FuncCounts returns instructions executed by each function, excluding those of its callees.
+func (p *Profiler) FuncCounts() map[types.FuncIdx]uint64 {
+	out := make(map[types.FuncIdx]uint64, len(p.counts))
+	for funcIdx, v := range p.counts {
+		for _, n := range v {
+			out[funcIdx] += n
+		}
+	}
+
+	return out
+}
+
+// Stop stops sampling and detaches p from its VM.
+func (p *Profiler) Stop() {
+	if p.vm.profiler != p {
+		return
+	}
+
+	p.vm.profiler = nil
+	p.duration = time.Since(p.start)
+	close(p.quit)
+}
+
+// WriteFolded writes wall-clock samples as folded stacks for flame graphs, one stack of function
+// names per line outermost first followed by nanoseconds spent there.
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	var stacks []string
+	nanos := make(map[string]int64)
+	for _, v := range p.samples {
+		names := make([]string, 0, len(v.frames)+1)
+		for i := len(v.frames) - 1; i >= 0; i-- {
+			names = append(names, p.vm.module.Names.DescribeFunc(v.frames[i].FuncIdx))
+		}
+		if v.host != "" {
+			names = append(names, v.host)
+		}
+
+		stack := strings.Join(names, ";")
+		if _, ok := nanos[stack]; !ok {
+			stacks = append(stacks, stack)
+		}
+		nanos[stack] += v.nanos
+	}
+	sort.Strings(stacks)
+
+	bw := bufio.NewWriter(w)
+	for _, v := range stacks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", v, nanos[v]); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	return nil
+}
+
+// WritePprof writes a gzipped pprof profile, whose sample types are instructions/count by
+// instructions, and samples/count and wall/nanoseconds by call stacks. Addresses of locations are
+// offsets of instructions in the decoded module.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(p.encodePprof()); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("close gzip: %w", err)
+	}
+
+	return nil
+}
+
+// NewProfiler attaches a profiler to vm, replacing any previous one, which profiles until Stop.
+func NewProfiler(vm *VM, opts ProfileOptions) *Profiler {
+	out := &Profiler{
+		vm:      vm,
+		opts:    opts,
+		start:   time.Now(),
+		counts:  make(map[types.FuncIdx]map[uint32]uint64),
+		quit:    make(chan struct{}),
+		samples: make(map[string]*profileSample),
+	}
+	out.lastSample = out.start
+
+	if prev := vm.profiler; prev != nil {
+		prev.Stop()
+	}
+	vm.profiler = out
+
+	if opts.SampleInterval > 0 {
+		go out.tick()
+	}
+
+	return out
+}
+
+func (p *Profiler) sortedSamples() []*profileSample {
+	out := make([]*profileSample, 0, len(p.samples))
+	for _, v := range p.samples {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key < out[j].key })
+
+	return out
+}
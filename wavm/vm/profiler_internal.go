@@ -0,0 +1,99 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// hostFuncName names external functions called indirectly in profiles.
+const hostFuncName = "<external>"
+
+// profileSample accumulates wall-clock samples of a call stack, which is innermost first and topped
+// by the external function named host if not empty.
+type profileSample struct {
+	key     string
+	frames  []StackFrame
+	host    string
+	samples int64
+	nanos   int64
+}
+
+// addHostTime attributes d spent in the external function called by the instruction executing.
+func (p *Profiler) addHostTime(d time.Duration) {
+	if p.opts.SampleInterval <= 0 {
+		return
+	}
+
+	host := hostFuncName
+	switch p.instr.Opcode {
+	case types.OpcodeCall, types.OpcodeReturnCall:
+		if idx, ok := p.instr.Args.(uint32); ok {
+			host = p.vm.module.Names.DescribeFunc(idx)
+		}
+	default:
+	}
+
+	p.addSample(p.vm.backtrace(0), host, 1, d)
+	// keep time in the external function from the next sample of wasm code
+	p.lastSample = p.lastSample.Add(d)
+}
+
+func (p *Profiler) addSample(frames []StackFrame, host string, n int64, d time.Duration) {
+	var b strings.Builder
+	for _, v := range frames {
+		fmt.Fprintf(&b, "%d:%x;", v.FuncIdx, v.Offset)
+	}
+	b.WriteString(host)
+	key := b.String()
+
+	s, ok := p.samples[key]
+	if !ok {
+		s = &profileSample{key: key, frames: frames, host: host}
+		p.samples[key] = s
+	}
+	s.samples += n
+	s.nanos += int64(d)
+}
+
+// before counts instr about to execute for the function atop the control stack, and samples the
+// call stack if the ticker ticks.
+func (p *Profiler) before(instr types.Instruction) {
+	p.instr = instr
+
+	f, _, ok := p.vm.TopCallFrame()
+	if !ok {
+		return
+	}
+
+	counts, ok := p.counts[f.FuncIdx]
+	if !ok {
+		counts = make(map[uint32]uint64)
+		p.counts[f.FuncIdx] = counts
+	}
+	counts[instr.Offset]++
+
+	if atomic.CompareAndSwapInt32(&p.ticked, 1, 0) {
+		now := time.Now()
+		p.addSample(p.vm.backtrace(0), "", 1, now.Sub(p.lastSample))
+		p.lastSample = now
+	}
+}
+
+// tick marks ticks by the sample interval until p stops.
+func (p *Profiler) tick() {
+	t := time.NewTicker(p.opts.SampleInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			atomic.StoreInt32(&p.ticked, 1)
+		case <-p.quit:
+			return
+		}
+	}
+}
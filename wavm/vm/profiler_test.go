@@ -0,0 +1,116 @@
+package vm_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestProfiler(t *testing.T) {
+	funcs := []testFunc{
+		// returns double(sq(n))+1
+		{"main", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x10, 2, 0x10, 0, 0x41, 1, 0x6A}},
+		{"sq", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 0, 0x6C}},
+	}
+	m := testModule{funcs: funcs}
+	module, err := wavm.NewDecoder(m.encode()).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	// every instruction runs twice
+	expect := make(map[types.FuncIdx]map[uint32]uint64)
+	for i, code := range module.Codes {
+		expect[types.FuncIdx(i+1)] = make(map[uint32]uint64)
+		for _, v := range code.Expr {
+			expect[types.FuncIdx(i+1)][v.Offset] = 2
+		}
+	}
+	expectFuncs := map[types.FuncIdx]uint64{1: 10, 2: 6}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine).(*vm.VM)
+
+		p := vm.NewProfiler(instance, vm.ProfileOptions{})
+		for i := 0; i < 2; i++ {
+			if got, err := instance.InvokeFunc("main", int32(5)); err != nil || got[0] != int32(51) {
+				t.Fatalf("%s: expect 51, got %v, %v", engine, got, err)
+			}
+		}
+		p.Stop()
+		if !reflect.DeepEqual(expect, p.Counts()) {
+			t.Fatalf("%s: expect counts %v, got %v", engine, expect, p.Counts())
+		}
+		if !reflect.DeepEqual(expectFuncs, p.FuncCounts()) {
+			t.Fatalf("%s: expect counts by funcs %v, got %v", engine, expectFuncs, p.FuncCounts())
+		}
+
+		// stopped profilers count nothing
+		if _, err := instance.InvokeFunc("main", int32(5)); err != nil {
+			t.Fatalf("%s: invoke: %v", engine, err)
+		}
+		if !reflect.DeepEqual(expect, p.Counts()) {
+			t.Fatalf("%s: expect counts %v after stopped, got %v", engine, expect, p.Counts())
+		}
+	}
+}
+
+func TestProfilerSamples(t *testing.T) {
+	m := testModule{funcs: []testFunc{
+		// doubles n for n times
+		{"spin", []byte{i32}, nil, nil, []byte{
+			0x03, 0x40,
+			0x20, 0, 0x10, 0, 0x1A, // calls double
+			0x20, 0, 0x41, 1, 0x6B, 0x22, 0, 0x0D, 0,
+			0x0B,
+		}},
+	}}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine).(*vm.VM)
+
+		p := vm.NewProfiler(instance, vm.ProfileOptions{SampleInterval: 50 * time.Microsecond})
+		for start := time.Now(); time.Since(start) < 20*time.Millisecond; {
+			if _, err := instance.InvokeFunc("spin", int32(10000)); err != nil {
+				t.Fatalf("%s: invoke: %v", engine, err)
+			}
+		}
+		p.Stop()
+
+		// samples are of spin, or double called by it
+		var folded bytes.Buffer
+		if err := p.WriteFolded(&folded); err != nil {
+			t.Fatalf("%s: write folded: %v", engine, err)
+		}
+		pattern := regexp.MustCompile(`^(func\[1\](;func\[0\])? \d+\n)+$`)
+		if !pattern.Match(folded.Bytes()) {
+			t.Fatalf("%s: bad folded stacks\n%s", engine, folded.Bytes())
+		}
+
+		var pprof bytes.Buffer
+		if err := p.WritePprof(&pprof); err != nil {
+			t.Fatalf("%s: write pprof: %v", engine, err)
+		}
+		r, err := gzip.NewReader(&pprof)
+		if err != nil {
+			t.Fatalf("%s: gunzip: %v", engine, err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: read pprof: %v", engine, err)
+		}
+		for _, v := range []string{"instructions", "samples", "wall", "func[1]"} {
+			if !bytes.Contains(data, []byte(v)) {
+				t.Fatalf("%s: expect '%s' in the pprof profile", engine, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/sammyne/mastering-wasm/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// rgCompiler lowers the tree of instructions of a function into register code. Operands are
+// tracked by the registers holding them, so that locals and constants are read in place until an
+// operand must be in its home register, which is done at latest by ends of straight-line code.
+type rgCompiler struct {
+	types_    []types.FuncType
+	heights   map[*types.Instruction]int // as recorded by the validator
+	consts    map[uint64]int32
+	stackBase int32
+	stack     []int32 // registers of operands
+	maxHeight int
+	code      []rgInstr
+	labels    []rgLabel // innermost last
+	// barrier is where the last label ends, before which code never gets its destination changed.
+	barrier     int
+	unreachable bool // whether the rest of the current block is unreachable
+
+	instr *types.Instruction // being compiled
+	hook  *rgHook            // for the next instruction emitted
+}
+
+// rgLabel is a label of a block being compiled, whose branches are fixed up once its end is known
+// unless it's of a loop. Operands are end high at the end of the block.
+type rgLabel struct {
+	loop   bool
+	target bcTarget
+	end    int
+	fixups []bcFixup
+}
+
+// compileRegister lowers code of a function typed t, where heights are those of the operand stack
+// before its instructions as recorded by the validator. It fails with errNotCompilable for
+// functions using try_table or unimplemented instructions.
+func compileRegister(typeDefs []types.FuncType, t types.FuncType, code types.Code,
+	heights map[*types.Instruction]int) (*rgFunc, error) {
+	nLocals := len(t.ParamTypes) + int(tools.CountLocals(code.Locals))
+	c := &rgCompiler{types_: typeDefs, heights: heights, consts: make(map[uint64]int32)}
+
+	var consts []uint64
+	collectConsts(code.Expr, func(v uint64) {
+		if _, ok := c.consts[v]; !ok {
+			c.consts[v] = int32(nLocals + len(consts))
+			consts = append(consts, v)
+		}
+	})
+	c.stackBase = int32(nLocals + len(consts))
+
+	// the function body is a block, whose end returns
+	nResults := len(t.ResultTypes)
+	c.labels = []rgLabel{{target: bcTarget{arity: nResults}, end: nResults}}
+	if err := c.compileExpr(code.Expr); err != nil {
+		return nil, err
+	}
+	c.popLabel()
+	c.emit(rgInstr{op: rgReturn, height: nResults})
+
+	out := &rgFunc{
+		code:      c.code,
+		consts:    consts,
+		nParams:   len(t.ParamTypes),
+		nLocals:   nLocals,
+		stackBase: int(c.stackBase),
+		frameSize: int(c.stackBase) + c.maxHeight,
+		nResults:  nResults,
+	}
+	return out, nil
+}
+
+// collectConsts calls f with bits of constants in expr.
+func collectConsts(expr types.Expr, f func(uint64)) {
+	for _, v := range expr {
+		switch args := v.Args.(type) {
+		case *types.Block:
+			collectConsts(args.Instructions, f)
+		case *types.BlockIf:
+			collectConsts(args.Instructions1, f)
+			collectConsts(args.Instructions2, f)
+		}
+
+		if bits, ok := constBits(v); ok {
+			f(bits)
+		}
+	}
+}
+
+func constBits(instr types.Instruction) (uint64, bool) {
+	switch instr.Opcode {
+	case types.OpcodeI32Const:
+		return uint64(uint32(instr.Args.(int32))), true
+	case types.OpcodeI64Const:
+		return uint64(instr.Args.(int64)), true
+	case types.OpcodeF32Const:
+		return uint64(math.Float32bits(instr.Args.(float32))), true
+	case types.OpcodeF64Const:
+		return math.Float64bits(instr.Args.(float64)), true
+	default:
+		return 0, false
+	}
+}
+
+func (c *rgCompiler) compileExpr(expr types.Expr) error {
+	for i := range expr {
+		// operands after instructions are known by the validator, except for the last one
+		after := c.labels[len(c.labels)-1].end
+		if i+1 < len(expr) {
+			after = c.heights[&expr[i+1]]
+		}
+
+		if err := c.compileInstr(&expr[i], after); err != nil {
+			return err
+		}
+		if c.unreachable {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// compileInstr compiles instr, after which operands are after high unless it's unreachable.
+func (c *rgCompiler) compileInstr(instr *types.Instruction, after int) error {
+	c.instr = instr
+	if c.hook == nil {
+		c.hook = &rgHook{}
+	}
+	c.hook.instrs = append(c.hook.instrs, instr)
+	c.hook.spills = append(c.hook.spills, c.pendingSpills())
+	c.hook.heights = append(c.hook.heights, len(c.stack))
+
+	switch instr.Opcode {
+	case types.OpcodeBlock, types.OpcodeLoop:
+		b := instr.Args.(*types.Block)
+		bt := tools.ParseBlockSig(b.BlockType, c.types_)
+		c.spillAll()
+		if c.hook != nil {
+			c.emit(rgInstr{op: rgNop})
+		}
+
+		height := len(c.stack) - len(bt.ParamTypes)
+		l := rgLabel{
+			target: bcTarget{pc: len(c.code), height: height, arity: len(bt.ResultTypes)},
+			end:    height + len(bt.ResultTypes),
+		}
+		if instr.Opcode == types.OpcodeLoop {
+			l.loop, l.target.arity, c.barrier = true, len(bt.ParamTypes), len(c.code)
+		}
+		c.labels = append(c.labels, l)
+
+		if err := c.compileExpr(b.Instructions); err != nil {
+			return err
+		}
+		c.popLabel()
+	case types.OpcodeIf:
+		b := instr.Args.(*types.BlockIf)
+		bt := tools.ParseBlockSig(b.BlockType, c.types_)
+		cond := c.pop()
+		c.spillAll()
+
+		height := len(c.stack) - len(bt.ParamTypes)
+		c.labels = append(c.labels, rgLabel{
+			target: bcTarget{height: height, arity: len(bt.ResultTypes)},
+			end:    height + len(bt.ResultTypes),
+		})
+		ifIdx := c.emit(rgInstr{op: rgBrUnless, a: cond, height: len(c.stack) + 1})
+		if err := c.compileExpr(b.Instructions1); err != nil {
+			return err
+		}
+
+		if len(b.Instructions2) == 0 {
+			c.addFixup(0, bcFixup{instr: ifIdx, entry: -1})
+		} else {
+			if !c.unreachable {
+				c.spillAll()
+				c.addFixup(0, bcFixup{instr: c.emit(rgInstr{op: rgJump}), entry: -1})
+			}
+			c.code[ifIdx].target.pc, c.barrier, c.unreachable = len(c.code), len(c.code), false
+			c.resetStack(height + len(bt.ParamTypes))
+			if err := c.compileExpr(b.Instructions2); err != nil {
+				return err
+			}
+		}
+		c.popLabel()
+	case types.OpcodeBr:
+		c.spillAll()
+		c.branch(c.emit(rgInstr{op: rgBr, height: len(c.stack)}), -1, instr.Args.(uint32))
+		c.unreachable = true
+	case types.OpcodeBrIf:
+		cond := c.pop()
+		c.spillAll()
+		idx := c.emit(rgInstr{op: rgBrIf, a: cond, height: len(c.stack) + 1})
+		c.branch(idx, -1, instr.Args.(uint32))
+	case types.OpcodeBrTable:
+		table := instr.Args.(*types.BreakTable)
+		i := c.pop()
+		c.spillAll()
+		idx := c.emit(rgInstr{op: rgBrTable, a: i, height: len(c.stack) + 1,
+			table: make([]bcTarget, len(table.Labels)+1)})
+		for j, v := range append(append([]uint32(nil), table.Labels...), table.Default) {
+			c.branch(idx, j, v)
+		}
+		c.unreachable = true
+	case types.OpcodeBrOnNull, types.OpcodeBrOnNonNull:
+		op := rgBrOnNull
+		if instr.Opcode == types.OpcodeBrOnNonNull {
+			op = rgBrOnNonNull
+		}
+		c.spillAll()
+		c.branch(c.emit(rgInstr{op: op, height: len(c.stack)}), -1, instr.Args.(uint32))
+		c.resetStack(after)
+	case types.OpcodeReturn:
+		c.spillAll()
+		c.emit(rgInstr{op: rgReturn, height: len(c.stack)})
+		c.unreachable = true
+	case types.OpcodeCall:
+		c.spillAll()
+		c.emit(rgInstr{op: rgCall, imm: uint64(instr.Args.(uint32)), height: len(c.stack)})
+		c.resetStack(after)
+	case types.OpcodeCallIndirect, types.OpcodeCallRef:
+		c.fallback(rgFallbackCall, instr, after)
+	case types.OpcodeReturnCall, types.OpcodeReturnCallIndirect, types.OpcodeReturnCallRef:
+		c.fallback(rgFallbackCall, instr, after)
+		c.unreachable = true
+	case types.OpcodeUnreachable, types.OpcodeThrow, types.OpcodeThrowRef:
+		c.fallback(rgFallback, instr, after)
+		c.unreachable = true
+	case types.OpcodeTryTable:
+		return errNotCompilable
+	case types.OpcodeDrop:
+		c.pop()
+	case types.OpcodeSelect:
+		cond, v2, v1 := c.pop(), c.pop(), c.pop()
+		c.emit(rgInstr{op: rgSelect, d: c.push(), a: v1, b: v2, c: cond})
+	case types.OpcodeLocalGet:
+		c.pushReg(int32(instr.Args.(uint32)))
+	case types.OpcodeLocalSet, types.OpcodeLocalTee:
+		local := int32(instr.Args.(uint32))
+		v := c.pop()
+		if v != local {
+			c.setLocal(local, v)
+		}
+		if instr.Opcode == types.OpcodeLocalTee {
+			c.pushReg(local)
+		}
+	case types.OpcodeGlobalGet:
+		c.emit(rgInstr{op: rgGlobalGet, d: c.push(), imm: uint64(instr.Args.(uint32))})
+	case types.OpcodeI32Const, types.OpcodeI64Const, types.OpcodeF32Const, types.OpcodeF64Const:
+		bits, _ := constBits(*instr)
+		c.pushReg(c.consts[bits])
+	case types.OpcodeGC:
+		arg := instr.Args.(types.GCArg)
+		if arg.SubOpcode != types.GCBrOnCast && arg.SubOpcode != types.GCBrOnCastFail {
+			c.fallback(rgFallback, instr, after)
+			break
+		}
+		c.spillAll()
+		c.branch(c.emit(rgInstr{op: rgBrOnCast, args: arg, height: len(c.stack)}), -1, arg.Label)
+	default:
+		if op, ok := bcNumericOps[instr.Opcode]; ok {
+			c.numeric(op)
+			break
+		}
+
+		// left to the tree engine, which fails on running it as well
+		if instructionTable[instr.Opcode] == nil {
+			return errNotCompilable
+		}
+		c.fallback(rgFallback, instr, after)
+	}
+
+	return nil
+}
+
+// addFixup adds f to the label at depth, counting from the innermost.
+func (c *rgCompiler) addFixup(depth int, f bcFixup) {
+	l := &c.labels[len(c.labels)-1-depth]
+	l.fixups = append(l.fixups, f)
+}
+
+// branch resolves the target of code[instr], or the entry-th one of its table if entry >= 0, for
+// branching to the label at depth.
+func (c *rgCompiler) branch(instr, entry int, depth uint32) {
+	l := c.labels[len(c.labels)-1-int(depth)]
+
+	target := &c.code[instr].target
+	if entry >= 0 {
+		target = &c.code[instr].table[entry]
+	}
+	*target = l.target
+
+	if !l.loop {
+		c.addFixup(int(depth), bcFixup{instr: instr, entry: entry})
+	}
+}
+
+// emit appends in, which hooks instructions compiled since the last one emitted.
+func (c *rgCompiler) emit(in rgInstr) int {
+	in.instr, in.hook, c.hook = c.instr, c.hook, nil
+	c.code = append(c.code, in)
+	return len(c.code) - 1
+}
+
+// fallback emits instr run by the instruction table on operands in their home registers.
+func (c *rgCompiler) fallback(op rgOpcode, instr *types.Instruction, after int) {
+	c.spillAll()
+	c.emit(rgInstr{op: op, run: instructionTable[instr.Opcode], args: instr.Args,
+		height: len(c.stack)})
+	c.resetStack(after)
+}
+
+func (c *rgCompiler) home(i int) int32 {
+	return c.stackBase + int32(i)
+}
+
+// numeric emits the numeric instruction op, whose result goes to its home register.
+func (c *rgCompiler) numeric(op bcOpcode) {
+	if op.unary() {
+		v := c.pop()
+		c.emit(rgInstr{op: rgUnary, num: op, d: c.push(), a: v})
+		return
+	}
+
+	v2, v1 := c.pop(), c.pop()
+	in := rgInstr{op: rgBinary, num: op, d: c.push(), a: v1, b: v2}
+	switch op {
+	case bcI32Add:
+		in.op = rgI32Add
+	case bcI32Sub:
+		in.op = rgI32Sub
+	case bcI64Add:
+		in.op = rgI64Add
+	case bcI64Sub:
+		in.op = rgI64Sub
+	}
+	c.emit(in)
+}
+
+// pendingSpills returns moves bringing operands into their home registers.
+func (c *rgCompiler) pendingSpills() []rgSpill {
+	var out []rgSpill
+	for i, v := range c.stack {
+		if home := c.home(i); v != home {
+			out = append(out, rgSpill{dst: home, src: v})
+		}
+	}
+
+	return out
+}
+
+func (c *rgCompiler) pop() int32 {
+	out := c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+	return out
+}
+
+// popLabel pops the innermost label, fixing up branches to its end, where operands are all in
+// their home registers.
+func (c *rgCompiler) popLabel() {
+	if !c.unreachable {
+		c.spillAll()
+	}
+	if c.hook != nil {
+		c.emit(rgInstr{op: rgNop})
+	}
+
+	l := c.labels[len(c.labels)-1]
+	c.labels = c.labels[:len(c.labels)-1]
+
+	for _, v := range l.fixups {
+		if v.entry >= 0 {
+			c.code[v.instr].table[v.entry].pc = len(c.code)
+		} else {
+			c.code[v.instr].target.pc = len(c.code)
+		}
+	}
+
+	c.barrier, c.unreachable = len(c.code), false
+	c.resetStack(l.end)
+}
+
+// push pushes an operand into its home register, which is returned.
+func (c *rgCompiler) push() int32 {
+	out := c.home(len(c.stack))
+	c.pushReg(out)
+	return out
+}
+
+// pushReg pushes an operand held by the register r.
+func (c *rgCompiler) pushReg(r int32) {
+	c.stack = append(c.stack, r)
+	if len(c.stack) > c.maxHeight {
+		c.maxHeight = len(c.stack)
+	}
+}
+
+// resetStack leaves height operands all in their home registers.
+func (c *rgCompiler) resetStack(height int) {
+	c.stack = c.stack[:0]
+	for len(c.stack) < height {
+		c.push()
+	}
+}
+
+// setLocal sets the local to v, moving operands read from the local into their home registers
+// beforehand. The instruction computing v writes the local directly if v is its result.
+func (c *rgCompiler) setLocal(local, v int32) {
+	var moved bool
+	for i, w := range c.stack {
+		if w == local {
+			c.emit(rgInstr{op: rgMove, d: c.home(i), a: w})
+			c.stack[i], moved = c.home(i), true
+		}
+	}
+
+	if last := len(c.code) - 1; !moved && last >= c.barrier && v == c.home(len(c.stack)) &&
+		c.code[last].writes(v) {
+		c.code[last].d = local
+		return
+	}
+	c.emit(rgInstr{op: rgMove, d: local, a: v})
+}
+
+// spillAll moves all operands into their home registers.
+func (c *rgCompiler) spillAll() {
+	for i, v := range c.stack {
+		if home := c.home(i); v != home {
+			c.emit(rgInstr{op: rgMove, d: home, a: v})
+			c.stack[i] = home
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/linker"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// rgOpcode is an opcode of register code, whose operands are registers of the call frame.
+type rgOpcode byte
+
+const (
+	rgNop          rgOpcode = iota // carries hooks only
+	rgFallback                     // runs by the instruction table on operands below height
+	rgFallbackCall                 // runs by the instruction table, which may switch call frames
+	rgJump                         // jumps to target.pc
+	rgBr                           // branches to target with operands below height
+	rgBrIf                         // branches on a, with operands below height-1
+	rgBrUnless                     // jumps to target.pc on false a, for if
+	rgBrTable                      // branches to table[a], where the default is the last
+	rgBrOnNull
+	rgBrOnNonNull
+	rgBrOnCast // br_on_cast and br_on_cast_fail by args
+	rgReturn   // returns operands below height
+	rgCall     // calls the imm-th function with operands below height
+	rgMove     // d = a
+	rgSelect   // d = c != 0 ? a : b
+	rgGlobalGet
+	rgUnary  // d = num(a)
+	rgBinary // d = num(a, b)
+	rgI32Add
+	rgI32Sub
+	rgI64Add
+	rgI64Sub
+)
+
+// rgFunc is register code of a function, whose call frames keep registers at BP, which are locals
+// followed by constants then operands as those of the tree engine. Operands are kept in their home
+// registers at stackBase, except those read from locals or constants in place meanwhile.
+type rgFunc struct {
+	code      []rgInstr
+	consts    []uint64
+	nParams   int
+	nLocals   int // including params
+	stackBase int
+	frameSize int // registers excluding operands beyond the deepest one of code
+	nResults  int
+}
+
+// rgHook lists instructions to hook before an instruction of register code, where the i-th one
+// sees operands heights[i] high after spilling spills[i] not in their home registers.
+type rgHook struct {
+	instrs  []*types.Instruction
+	spills  [][]rgSpill
+	heights []int
+}
+
+// rgInstr is an instruction of register code, where height is that of operands before it.
+type rgInstr struct {
+	op         rgOpcode
+	num        bcOpcode // for rgUnary and rgBinary
+	d, a, b, c int32
+	imm        uint64
+	height     int
+	target     bcTarget
+	table      []bcTarget
+	run        RunInstructionFunc // for fallbacks
+	args       interface{}        // for fallbacks and br_on_cast
+	instr      *types.Instruction // the instruction compiled into it
+	hook       *rgHook            // nil if hooking nothing
+}
+
+// rgSpill copies register src to dst.
+type rgSpill struct {
+	dst, src int32
+}
+
+// offset returns the offset of the instruction compiled, or 0 for implied ones.
+func (in *rgInstr) offset() uint32 {
+	if in.instr != nil {
+		return in.instr.Offset
+	}
+
+	return 0
+}
+
+// writes tells whether in writes its result to register r, which may be changed.
+func (in *rgInstr) writes(r int32) bool {
+	switch in.op {
+	case rgMove, rgSelect, rgGlobalGet, rgUnary, rgBinary, rgI32Add, rgI32Sub, rgI64Add, rgI64Sub:
+		return in.d == r
+	default:
+		return false
+	}
+}
+
+// branchRegisters moves operands kept by t from below height down to its height.
+func branchRegisters(operands []uint64, height int, t *bcTarget) {
+	if src := height - t.arity; src != t.height {
+		copy(operands[t.height:], operands[src:height])
+	}
+}
+
+// growRegisters returns s extended to n, whose slots beyond the length are kept.
+func growRegisters(s []uint64, n int) []uint64 {
+	if n > cap(s) {
+		s = append(s[:cap(s)], make([]uint64, n-cap(s))...)
+	}
+
+	return s[:n]
+}
+
+// enterRegister pushes the call frame of f compiled to register code, whose args are atop the
+// operand stack.
+func (vm *VM) enterRegister(f *Func) {
+	fn := f.register
+	bp := vm.OperandStack.Len() - fn.nParams
+	vm.ControlStack.Push(ControlFrame{Opcode: types.OpcodeCall, BP: bp, FuncIdx: f.idx,
+		register: fn})
+
+	s := growRegisters(vm.OperandStack.slots, bp+fn.frameSize)
+	r := s[bp:]
+	for i := fn.nParams; i < fn.nLocals; i++ {
+		r[i] = 0
+	}
+	copy(r[fn.nLocals:], fn.consts)
+
+	vm.OperandStack.slots = s[:bp+fn.stackBase]
+	vm.local0Idx = uint32(bp)
+}
+
+// exitRegister pops the call frame of register code atop the control stack, whose results are
+// below height of operands in s, leaving them on the operand stack.
+func (vm *VM) exitRegister(s []uint64, height int) {
+	frames := vm.ControlStack.frames
+	f := &frames[len(frames)-1]
+
+	n, top := f.register.nResults, f.BP+f.register.stackBase+height
+	copy(s[f.BP:], s[top-n:top])
+	vm.OperandStack.slots = s[:f.BP+n]
+	vm.ControlStack.frames = frames[:len(frames)-1]
+
+	// frames of register code are all call frames
+	if caller := len(frames) - 2; caller >= 0 && frames[caller].register != nil {
+		vm.local0Idx = uint32(frames[caller].BP)
+	} else if caller, _, ok := vm.TopCallFrame(); ok {
+		vm.local0Idx = uint32(caller.BP)
+	}
+}
+
+// hookRegister hooks instructions of h, whose operands are brought into their home registers in
+// s from bp. after tells whether the tracer awaits the previous instruction to finish.
+func (vm *VM) hookRegister(s []uint64, bp int, fn *rgFunc, h *rgHook, after *bool) error {
+	r := s[bp:]
+	for i, v := range h.instrs {
+		for _, vv := range h.spills[i] {
+			r[vv.dst] = r[vv.src]
+		}
+		vm.OperandStack.slots = s[:bp+fn.stackBase+h.heights[i]]
+
+		if *after && vm.tracer != nil {
+			vm.tracer.after()
+		}
+		*after = true
+		if err := vm.hook(*v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runRegister runs call frames of register code atop the control stack for the loop started at
+// depth, until one of the other engines gets atop or all frames of the loop are done. Registers
+// are kept locally, and synced with the operand stack of the VM before instructions which may
+// observe it, while hooks attached meanwhile take effect from the next call or return.
+func (vm *VM) runRegister(depth int) error {
+	frame, _ := vm.ControlStack.Top()
+	fn, pc, bp := frame.register, frame.PC, frame.BP
+	code := fn.code
+	s := growRegisters(vm.OperandStack.slots, bp+fn.frameSize)
+	r, operands := s[bp:], s[bp+fn.stackBase:]
+
+	hooked := vm.isHooked()
+	var after bool // whether the tracer awaits the previous instruction to finish
+	for {
+		in := &code[pc]
+		pc++
+
+		if hooked && in.hook != nil {
+			frame.PC = pc
+			if err := vm.hookRegister(s, bp, fn, in.hook, &after); err != nil {
+				return vm.trap(depth, err)
+			}
+		}
+
+		switch in.op {
+		case rgNop:
+			continue
+		case rgMove:
+			r[in.d] = r[in.a]
+			continue
+		case rgJump:
+			pc = in.target.pc
+			continue
+		case rgBr:
+			branchRegisters(operands, in.height, &in.target)
+			pc = in.target.pc
+			continue
+		case rgBrIf:
+			if r[in.a] != 0 {
+				branchRegisters(operands, in.height-1, &in.target)
+				pc = in.target.pc
+			}
+			continue
+		case rgBrUnless:
+			if r[in.a] == 0 {
+				pc = in.target.pc
+			}
+			continue
+		case rgBrTable:
+			t := &in.table[len(in.table)-1]
+			if i := uint32(r[in.a]); i < uint32(len(in.table)-1) {
+				t = &in.table[i]
+			}
+			branchRegisters(operands, in.height-1, t)
+			pc = t.pc
+			continue
+		case rgBrOnNull:
+			if operands[in.height-1] == 0 {
+				branchRegisters(operands, in.height-1, &in.target)
+				pc = in.target.pc
+			}
+			continue
+		case rgBrOnNonNull:
+			if operands[in.height-1] != 0 {
+				branchRegisters(operands, in.height, &in.target)
+				pc = in.target.pc
+			}
+			continue
+		case rgSelect:
+			if r[in.c] != 0 {
+				r[in.d] = r[in.a]
+			} else {
+				r[in.d] = r[in.b]
+			}
+			continue
+		case rgGlobalGet:
+			r[in.d] = vm.globals[in.imm].GetAsUint64()
+			continue
+		case rgUnary:
+			r[in.d] = runUnaryBytecode(in.num, r[in.a])
+			continue
+		case rgBinary:
+			r[in.d] = runBinaryBytecode(in.num, r[in.a], r[in.b])
+			continue
+		case rgI32Add:
+			r[in.d] = uint64(uint32(r[in.a]) + uint32(r[in.b]))
+			continue
+		case rgI32Sub:
+			r[in.d] = uint64(uint32(r[in.a]) - uint32(r[in.b]))
+			continue
+		case rgI64Add:
+			r[in.d] = r[in.a] + r[in.b]
+			continue
+		case rgI64Sub:
+			r[in.d] = r[in.a] - r[in.b]
+			continue
+		}
+
+		// instructions below may observe the VM, or switch call frames
+		frame.PC = pc
+		top := bp + fn.stackBase + in.height
+		vm.OperandStack.slots = s[:top]
+
+		var err error
+		switch in.op {
+		case rgFallback, rgFallbackCall:
+			err = in.run(vm, in.args)
+		case rgBrOnCast:
+			arg := in.args.(types.GCArg)
+			if matchRef(vm, s[top-1], arg.DstType) == (arg.SubOpcode == types.GCBrOnCast) {
+				branchRegisters(operands, in.height, &in.target)
+				pc = in.target.pc
+			}
+		case rgCall:
+			if f := &vm.funcs[in.imm]; f.register != nil {
+				vm.enterRegister(f)
+			} else {
+				err = callFunc(vm, *f)
+			}
+		default: // rgReturn
+			// the returning function is gone after, so the last instruction of it finishes here
+			if after && vm.tracer != nil {
+				vm.tracer.after()
+			}
+			after = false
+			vm.exitRegister(s, in.height)
+		}
+
+		if err != nil {
+			var exn *linker.Exception
+			if !errors.As(err, &exn) {
+				err = fmt.Errorf("exec instruction of PC(%d) at 0x%x in %s: %w", pc-1,
+					in.offset(), vm.describeFunc(), err)
+				return vm.trap(depth, err)
+			}
+			// no frame of register code catches, so let the loop go on with the catching one if any
+			return vm.catchException(exn, depth)
+		}
+
+		switch in.op {
+		case rgFallbackCall, rgCall, rgReturn:
+		default:
+			// the operand stack may be reallocated by fallbacks
+			s = growRegisters(vm.OperandStack.slots, bp+fn.frameSize)
+			r, operands = s[bp:], s[bp+fn.stackBase:]
+			continue
+		}
+
+		if after && vm.tracer != nil {
+			vm.tracer.after()
+		}
+		after = false
+
+		if vm.ControlStack.Len() < depth {
+			return nil
+		}
+		if frame, _ = vm.ControlStack.Top(); frame.register == nil {
+			return nil
+		}
+		fn, pc, bp = frame.register, frame.PC, frame.BP
+		code = fn.code
+		s = growRegisters(vm.OperandStack.slots, bp+fn.frameSize)
+		r, operands = s[bp:], s[bp+fn.stackBase:]
+		hooked = vm.isHooked()
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package vm
+
+// commitBytes is a no-op, since reserveBytes allocates all bytes at once.
+func commitBytes(data []byte) error {
+	return nil
+}
+
+// reserveBytes allocates n bytes at once, leaving pages untouched to the OS.
+func reserveBytes(n int) ([]byte, func(), error) {
+	return make([]byte, n), func() {}, nil
+}
@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package vm
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// commitBytes makes data reserved by reserveBytes accessible.
+func commitBytes(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := syscall.Mprotect(data, syscall.PROT_READ|syscall.PROT_WRITE); err != nil {
+		return fmt.Errorf("mprotect: %w", err)
+	}
+	return nil
+}
+
+// reserveBytes reserves n bytes of address space, which stays inaccessible and unbacked until
+// committed, and is given back by release.
+func reserveBytes(n int) ([]byte, func(), error) {
+	if n == 0 {
+		return nil, func() {}, nil
+	}
+
+	data, err := syscall.Mmap(-1, 0, n, syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mmap: %w", err)
+	}
+	return data, func() { syscall.Munmap(data) }, nil
+}
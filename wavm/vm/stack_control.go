@@ -8,6 +8,12 @@ type ControlFrame struct {
 	Expr      []types.Instruction
 	BP        int
 	PC        int
+	Catches   []types.Catch // only for try_table
+	FuncIdx   types.FuncIdx // only for call frames
+	bytecode  *bcFunc       // only for call frames of functions compiled to bytecode
+	register  *rgFunc       // only for call frames of functions compiled to register code
+	closure   *clFunc       // only for call frames of functions compiled into closures
+	jit       *jitFunc      // only for call frames of functions compiled into machine code
 }
 
 type ControlStack struct {
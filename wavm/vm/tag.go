@@ -0,0 +1,15 @@
+package vm
+
+import "github.com/sammyne/mastering-wasm/wavm/types"
+
+type Tag struct {
+	Type_ types.FuncType
+}
+
+func (t *Tag) Type() types.FuncType {
+	return t.Type_
+}
+
+func NewTag(t types.FuncType) *Tag {
+	return &Tag{Type_: t}
+}
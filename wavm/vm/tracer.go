@@ -0,0 +1,122 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// Formats of trace records.
+const (
+	// TraceFormatJSONLines encodes each record as a JSON object of TraceRecord in a line.
+	TraceFormatJSONLines TraceFormat = iota
+	// TraceFormatBinary encodes each record as uvarints of FuncIdx and Offset, bytes of the opcode,
+	// the sub-opcode (0 if none) and flags telling whether the stack tops follow by bits 0 and 1,
+	// then present tops before and after as 64-bit little endian numbers. Immediates are omitted,
+	// which are found in the module by offsets.
+	TraceFormatBinary
+)
+
+// TraceFormat tells how trace records are encoded.
+type TraceFormat int
+
+// TraceOptions configures tracers.
+type TraceOptions struct {
+	Format TraceFormat
+	// Funcs are indices of functions whose instructions are traced, which are all if empty.
+	Funcs []types.FuncIdx
+	// MaxRecords caps records to emit, after which the tracer detaches. It's unlimited if 0.
+	MaxRecords int
+}
+
+// TraceRecord tells an executed instruction, where the stack tops are nil if its function has no
+// operands then, and instructions finishing in callees, i.e. calls, see those of callees.
+type TraceRecord struct {
+	FuncIdx    types.FuncIdx `json:"func"`
+	Offset     uint32        `json:"pc"` // in the decoded module
+	Opname     string        `json:"op"`
+	Immediates string        `json:"imm,omitempty"`
+	Before     *uint64       `json:"before,omitempty"`
+	After      *uint64       `json:"after,omitempty"`
+
+	opcode    byte
+	subOpcode byte
+}
+
+// Tracer writes a record for every instruction executed by a VM, which is buffered until Flush.
+// It isn't safe for concurrent use.
+type Tracer struct {
+	vm      *VM
+	w       *bufio.Writer
+	opts    TraceOptions
+	funcs   map[types.FuncIdx]struct{} // nil if all
+	n       int                        // records emitted
+	pending *TraceRecord               // waiting for the instruction to finish
+	err     error                      // the 1st error of writing
+}
+
+// Detach flushes t and detaches it from its VM, which then runs without tracing.
+func (t *Tracer) Detach() error {
+	if t.vm.tracer == t {
+		t.vm.tracer = nil
+	}
+
+	return t.Flush()
+}
+
+// Flush writes buffered records, including the one of the instruction executing, and reports the
+// 1st error writing records if any.
+func (t *Tracer) Flush() error {
+	t.emitPending()
+	if t.err != nil {
+		return t.err
+	}
+
+	if err := t.w.Flush(); err != nil {
+		t.err = fmt.Errorf("flush: %w", err)
+	}
+	return t.err
+}
+
+// Records returns the number of records emitted.
+func (t *Tracer) Records() int {
+	return t.n
+}
+
+func (f TraceFormat) String() string {
+	switch f {
+	case TraceFormatJSONLines:
+		return "jsonl"
+	case TraceFormatBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("TraceFormat(%d)", int(f))
+	}
+}
+
+// NewTracer attaches a tracer writing records to w to vm, replacing any previous one.
+func NewTracer(vm *VM, w io.Writer, opts TraceOptions) *Tracer {
+	out := &Tracer{vm: vm, w: bufio.NewWriter(w), opts: opts}
+	if len(opts.Funcs) > 0 {
+		out.funcs = make(map[types.FuncIdx]struct{}, len(opts.Funcs))
+		for _, v := range opts.Funcs {
+			out.funcs[v] = struct{}{}
+		}
+	}
+
+	vm.tracer = out
+	return out
+}
+
+// ParseTraceFormat parses formats named as TraceFormat.String.
+func ParseTraceFormat(s string) (TraceFormat, error) {
+	for _, v := range []TraceFormat{TraceFormatJSONLines, TraceFormatBinary} {
+		if v.String() == s {
+			return v, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown trace format '%s': %w", s, ErrBadArgs)
+}
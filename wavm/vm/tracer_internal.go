@@ -0,0 +1,158 @@
+package vm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sammyne/mastering-wasm/wavm/tools"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// Flags of records in TraceFormatBinary.
+const (
+	traceFlagBefore = 1 << iota
+	traceFlagAfter
+)
+
+// after completes the record of the instruction just executed.
+func (t *Tracer) after() {
+	if t.pending == nil {
+		return
+	}
+
+	t.pending.After = t.vm.getOperandTop()
+	t.emitPending()
+}
+
+// before starts the record of instr about to execute for the function atop the control stack.
+func (t *Tracer) before(instr types.Instruction) {
+	// the previous instruction never finished, e.g. for throwing an exception
+	t.emitPending()
+
+	f, _, ok := t.vm.TopCallFrame()
+	if !ok || t.vm.tracer != t {
+		return
+	} else if _, traced := t.funcs[f.FuncIdx]; t.funcs != nil && !traced {
+		return
+	}
+
+	t.pending = &TraceRecord{
+		FuncIdx:    f.FuncIdx,
+		Offset:     instr.Offset,
+		Opname:     instr.GetOpname(),
+		Immediates: formatImmediates(instr.Args),
+		Before:     t.vm.getOperandTop(),
+		opcode:     instr.Opcode,
+		subOpcode:  getSubOpcode(instr.Args),
+	}
+}
+
+func (t *Tracer) emitPending() {
+	r := t.pending
+	if r == nil {
+		return
+	}
+	t.pending = nil
+
+	if t.err == nil {
+		t.err = t.write(r)
+	}
+
+	if t.n++; t.opts.MaxRecords > 0 && t.n >= t.opts.MaxRecords && t.vm.tracer == t {
+		t.vm.tracer = nil
+	}
+}
+
+func (t *Tracer) write(r *TraceRecord) error {
+	if t.opts.Format == TraceFormatJSONLines {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal record: %w", err)
+		}
+		if _, err := t.w.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+		return nil
+	}
+
+	var buf [2*binary.MaxVarintLen32 + 3 + 16]byte
+	n := binary.PutUvarint(buf[:], uint64(r.FuncIdx))
+	n += binary.PutUvarint(buf[n:], uint64(r.Offset))
+
+	var flags byte
+	if r.Before != nil {
+		flags |= traceFlagBefore
+	}
+	if r.After != nil {
+		flags |= traceFlagAfter
+	}
+	buf[n], buf[n+1], buf[n+2] = r.opcode, r.subOpcode, flags
+	n += 3
+
+	for _, v := range []*uint64{r.Before, r.After} {
+		if v != nil {
+			binary.LittleEndian.PutUint64(buf[n:], *v)
+			n += 8
+		}
+	}
+
+	if _, err := t.w.Write(buf[:n]); err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+
+	return nil
+}
+
+// getOperandTop gets the slot atop operands of the function atop the control stack, which is nil
+// if it has none. Locals and registers of constants below operands aren't operands.
+func (vm *VM) getOperandTop() *uint64 {
+	f, _, ok := vm.TopCallFrame()
+	if !ok {
+		return nil
+	}
+
+	var base int
+	if f.register != nil {
+		base = f.BP + f.register.stackBase
+	} else {
+		fn := vm.funcs[f.FuncIdx]
+		base = f.BP + len(fn.type_.ParamTypes) + int(tools.CountLocals(fn.code.Locals))
+	}
+	if vm.OperandStack.Len() <= base {
+		return nil
+	}
+
+	out := vm.OperandStack.slots[vm.OperandStack.Len()-1]
+	return &out
+}
+
+// formatImmediates formats immediates of instructions, where those of blocks exclude nested
+// instructions.
+func formatImmediates(args interface{}) string {
+	switch v := args.(type) {
+	case nil:
+		return ""
+	case *types.Block:
+		return fmt.Sprint(v.BlockType)
+	case *types.BlockIf:
+		return fmt.Sprint(v.BlockType)
+	case *types.TryTable:
+		return fmt.Sprint(v.BlockType, v.Catches)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func getSubOpcode(args interface{}) byte {
+	switch v := args.(type) {
+	case types.AtomicArg:
+		return v.SubOpcode
+	case types.BulkArg:
+		return v.SubOpcode
+	case types.GCArg:
+		return v.SubOpcode
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,168 @@
+package vm_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestTracer(t *testing.T) {
+	funcs := []testFunc{
+		// returns sq(n)+1
+		{"main", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x10, 2, 0x41, 1, 0x6A}},
+		{"sq", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x20, 0, 0x6C}},
+	}
+	m := testModule{funcs: funcs}
+	module, err := wavm.NewDecoder(m.encode()).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	main, sq := module.Codes[0].Expr, module.Codes[1].Expr
+
+	// calls are recorded once their callees start with no operands
+	slot := func(v uint64) *uint64 { return &v }
+	expect := []vm.TraceRecord{
+		{FuncIdx: 1, Offset: main[0].Offset, Opname: "local.get", Immediates: "0", After: slot(5)},
+		{FuncIdx: 1, Offset: main[1].Offset, Opname: "call", Immediates: "2", Before: slot(5)},
+		{FuncIdx: 2, Offset: sq[0].Offset, Opname: "local.get", Immediates: "0", After: slot(5)},
+		{FuncIdx: 2, Offset: sq[1].Offset, Opname: "local.get", Immediates: "0", Before: slot(5),
+			After: slot(5)},
+		{FuncIdx: 2, Offset: sq[2].Offset, Opname: "i32.mul", Before: slot(5), After: slot(25)},
+		{FuncIdx: 1, Offset: main[2].Offset, Opname: "i32.const", Immediates: "1", Before: slot(25),
+			After: slot(1)},
+		{FuncIdx: 1, Offset: main[3].Offset, Opname: "i32.add", Before: slot(1), After: slot(26)},
+	}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine).(*vm.VM)
+
+		var buf bytes.Buffer
+		tracer := vm.NewTracer(instance, &buf, vm.TraceOptions{})
+		if got, err := instance.InvokeFunc("main", int32(5)); err != nil || got[0] != int32(26) {
+			t.Fatalf("%s: expect 26, got %v, %v", engine, got, err)
+		}
+		if err := tracer.Detach(); err != nil {
+			t.Fatalf("%s: detach: %v", engine, err)
+		}
+		if got := decodeTestTrace(t, &buf); !reflect.DeepEqual(expect, got) {
+			t.Fatalf("%s: expect records\n%s\ngot\n%s", engine, formatTestTrace(expect),
+				formatTestTrace(got))
+		}
+		if tracer.Records() != len(expect) {
+			t.Fatalf("%s: expect %d records, got %d", engine, len(expect), tracer.Records())
+		}
+
+		// detached tracers record nothing
+		if _, err := instance.InvokeFunc("main", int32(5)); err != nil || buf.Len() != 0 {
+			t.Fatalf("%s: expect nothing traced after detached, got %d bytes, %v", engine, buf.Len(),
+				err)
+		}
+
+		// only records of sq, up to 2
+		tracer = vm.NewTracer(instance, &buf, vm.TraceOptions{Funcs: []types.FuncIdx{2}, MaxRecords: 2})
+		if _, err := instance.InvokeFunc("main", int32(5)); err != nil {
+			t.Fatalf("%s: invoke: %v", engine, err)
+		}
+		if err := tracer.Flush(); err != nil {
+			t.Fatalf("%s: flush: %v", engine, err)
+		}
+		if got := decodeTestTrace(t, &buf); !reflect.DeepEqual(expect[2:4], got) {
+			t.Fatalf("%s: expect records\n%s\ngot\n%s", engine, formatTestTrace(expect[2:4]),
+				formatTestTrace(got))
+		}
+
+		// the call in binary
+		tracer = vm.NewTracer(instance, &buf,
+			vm.TraceOptions{Format: vm.TraceFormatBinary, MaxRecords: 2})
+		if _, err := instance.InvokeFunc("main", int32(5)); err != nil {
+			t.Fatalf("%s: invoke: %v", engine, err)
+		}
+		if err := tracer.Flush(); err != nil {
+			t.Fatalf("%s: flush: %v", engine, err)
+		}
+		r := bufio.NewReader(&buf)
+		if _, err := readTestBinaryTrace(r); err != nil {
+			t.Fatalf("%s: read the 1st record: %v", engine, err)
+		}
+		call, err := readTestBinaryTrace(r)
+		if err != nil {
+			t.Fatalf("%s: read the 2nd record: %v", engine, err)
+		}
+		expectCall := []uint64{1, uint64(main[1].Offset), 0x10, 0, 1, 5}
+		if !reflect.DeepEqual(expectCall, call) || r.Buffered() != 0 {
+			t.Fatalf("%s: expect %v, got %v with %d bytes left", engine, expectCall, call, r.Buffered())
+		}
+	}
+}
+
+func TestParseTraceFormat(t *testing.T) {
+	for _, v := range []vm.TraceFormat{vm.TraceFormatJSONLines, vm.TraceFormatBinary} {
+		if got, err := vm.ParseTraceFormat(v.String()); err != nil || got != v {
+			t.Fatalf("%s: got %s, %v", v, got, err)
+		}
+	}
+
+	if _, err := vm.ParseTraceFormat("csv"); !errors.Is(err, vm.ErrBadArgs) {
+		t.Fatalf("expect %v, got %v", vm.ErrBadArgs, err)
+	}
+}
+
+func decodeTestTrace(t *testing.T, buf *bytes.Buffer) []vm.TraceRecord {
+	t.Helper()
+
+	var out []vm.TraceRecord
+	for d := json.NewDecoder(buf); d.More(); {
+		var r vm.TraceRecord
+		if err := d.Decode(&r); err != nil {
+			t.Fatalf("decode record: %v", err)
+		}
+		out = append(out, r)
+	}
+
+	return out
+}
+
+func formatTestTrace(records []vm.TraceRecord) string {
+	data, _ := json.MarshalIndent(records, "", "  ")
+	return string(data)
+}
+
+// readTestBinaryTrace reads a record of TraceFormatBinary as the func, offset, opcode, sub-opcode,
+// flags and present stack tops.
+func readTestBinaryTrace(r *bufio.Reader) ([]uint64, error) {
+	funcIdx, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	var b [3]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+
+	out := []uint64{funcIdx, offset, uint64(b[0]), uint64(b[1]), uint64(b[2])}
+	for flags := b[2]; flags != 0; flags >>= 1 {
+		if flags&1 == 0 {
+			continue
+		}
+		var v uint64
+		if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+
+	return out, nil
+}
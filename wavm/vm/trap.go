@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sammyne/mastering-wasm/wavm/types"
+)
+
+// StackFrame is a frame of wasm backtraces.
+type StackFrame struct {
+	FuncIdx  types.FuncIdx
+	FuncName string // empty if unknown
+	Offset   uint32 // of the executing instruction in the decoded module
+	// External tells the frame is of an external function, i.e. a host function or one imported from
+	// another VM, which is unknown beyond its boundary.
+	External bool
+}
+
+// Trap is an error trapping execution, with the wasm backtrace at the time of trapping.
+type Trap struct {
+	Frames []StackFrame // innermost first
+	Err    error
+}
+
+func (f StackFrame) String() string {
+	if f.External {
+		return "<external>"
+	}
+
+	var names types.NameMap
+	if f.FuncName != "" {
+		names = types.NameMap{f.FuncIdx: f.FuncName}
+	}
+
+	return fmt.Sprintf("0x%x - %s", f.Offset, types.DescribeIdx("func", f.FuncIdx, names))
+}
+
+// Backtrace formats frames one per line, innermost first.
+func (t *Trap) Backtrace() string {
+	return t.FormatBacktrace(nil)
+}
+
+// FormatBacktrace formats frames as Backtrace, each followed by lines from annotate if not nil,
+// e.g. source locations of the frame.
+func (t *Trap) FormatBacktrace(annotate func(f StackFrame) []string) string {
+	var b strings.Builder
+
+	b.WriteString("wasm backtrace:")
+	for i, v := range t.Frames {
+		fmt.Fprintf(&b, "\n  %3d: %s", i, v)
+		if annotate == nil {
+			continue
+		}
+		for _, line := range annotate(v) {
+			fmt.Fprintf(&b, "\n         %s", line)
+		}
+	}
+
+	return b.String()
+}
+
+func (t *Trap) Error() string {
+	return t.Err.Error()
+}
+
+func (t *Trap) Unwrap() error {
+	return t.Err
+}
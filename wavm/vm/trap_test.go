@@ -0,0 +1,62 @@
+package vm_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestTrapBacktrace(t *testing.T) {
+	funcs := []testFunc{
+		{"outer", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x10, 0, 0x10, 2}},
+		{"middle", []byte{i32}, []byte{i32}, nil, []byte{0x41, 1, 0x1A, 0x20, 0, 0x10, 3}},
+		// divides 2 by n
+		{"inner", []byte{i32}, []byte{i32}, nil, []byte{0x41, 2, 0x20, 0, 0x6D}},
+	}
+	// names func 1 by the name section
+	names := wasmtest.Section(1, wasmtest.Vec(append([]byte{1}, wasmtest.Name("outer")...)))
+	m := testModule{funcs: funcs,
+		sections: map[byte][]byte{0: append(wasmtest.Name("name"), names...)}}
+
+	module, err := wavm.NewDecoder(m.encode()).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// frames are at the call of outer, the call of middle and the division of inner
+	offset := func(f, i int) uint32 {
+		return module.Codes[f].Expr[i].Offset
+	}
+	expect := []vm.StackFrame{
+		{FuncIdx: 3, Offset: offset(2, 2)},
+		{FuncIdx: 2, Offset: offset(1, 3)},
+		{FuncIdx: 1, FuncName: "outer", Offset: offset(0, 2)},
+	}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine)
+
+		if got, err := instance.InvokeFunc("outer", int32(1)); err != nil || got[0] != int32(1) {
+			t.Fatalf("%s: expect 1, got %v, %v", engine, got, err)
+		}
+
+		_, err := instance.InvokeFunc("outer", int32(0))
+		var trap *vm.Trap
+		if !errors.As(err, &trap) || !errors.Is(err, vm.ErrIntegerDivideByZero) {
+			t.Fatalf("%s: expect trap of %v, got %v", engine, vm.ErrIntegerDivideByZero, err)
+		}
+		if !reflect.DeepEqual(expect, trap.Frames) {
+			t.Fatalf("%s: expect frames %v, got %v", engine, expect, trap.Frames)
+		}
+
+		lines := strings.Split(trap.Backtrace(), "\n")
+		if len(lines) != 4 || lines[1] != "    0: "+expect[0].String() ||
+			!strings.HasSuffix(lines[3], " - func[1] <outer>") {
+			t.Fatalf("%s: bad backtrace:\n%s", engine, trap.Backtrace())
+		}
+	}
+}
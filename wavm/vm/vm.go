@@ -2,6 +2,7 @@ package vm
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/sammyne/mastering-wasm/wavm"
 	"github.com/sammyne/mastering-wasm/wavm/linker"
@@ -15,10 +16,40 @@ type VM struct {
 
 	globals   []linker.Global
 	local0Idx uint32 // operand stack index for first operand
-	memory    linker.Memory
+	memories  []linker.Memory
 	module    *wavm.Module
+	data      [][]byte // segments still available to memory.init, nil once dropped
 	funcs     []Func
 	table     linker.Table
+	tags      []linker.Tag
+	heap      heap
+	debugger  *Debugger // nil if not debugged
+	tracer    *Tracer   // nil if not traced
+	profiler  *Profiler // nil if not profiled
+	coverage  *Coverage // nil if coverage isn't recorded
+}
+
+// Close releases the memories the VM made, which mustn't be accessed afterwards, even by other VMs
+// importing them. Imported memories are left to their makers.
+func (vm *VM) Close() error {
+	var nImported int
+	for _, v := range vm.module.Imports {
+		if v.Description.Tag == types.PortTagMemory {
+			nImported++
+		}
+	}
+	if len(vm.memories) <= nImported {
+		return nil
+	}
+
+	for i, v := range vm.memories[nImported:] {
+		if c, ok := v.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				return fmt.Errorf("close memory[%d]: %w", nImported+i, err)
+			}
+		}
+	}
+	return nil
 }
 
 func (vm *VM) ExecuteCode(idx int) error {
@@ -29,7 +60,9 @@ func (vm *VM) ExecuteCode(idx int) error {
 		//fmt.Println(i, opname)
 		if err := vm.ExecuteInstruction(v); err != nil {
 			opname, _ := types.GetOpname(v.Opcode)
-			return fmt.Errorf("exec %d-th instruction(%s): %w", i, opname, err)
+			funcIdx := uint32(len(vm.funcs) - len(vm.module.Codes) + idx)
+			return fmt.Errorf("exec %d-th instruction(%s) at 0x%x of %s: %w", i, opname, v.Offset,
+				vm.module.Names.DescribeFunc(funcIdx), err)
 		}
 	}
 
@@ -72,9 +105,11 @@ func (vm *VM) GetMember(name string) (interface{}, error) {
 		case types.PortTagTable:
 			return vm.table, nil
 		case types.PortTagMemory:
-			return vm.memory, nil
+			return vm.memories[idx], nil
 		case types.PortTagGlobal:
 			return vm.globals[idx], nil
+		case types.PortTagTag:
+			return vm.tags[idx], nil
 		default:
 			return nil, ErrUnimplemented
 		}
@@ -112,30 +147,36 @@ func (vm *VM) SetGlobalVal(name string, val types.WasmVal) error {
 }
 
 func NewVM(m *wavm.Module, externals map[string]linker.Module) (linker.Module, error) {
-	if err := validator.Validate(*m); err != nil {
+	return NewVMWithOptions(m, externals, Options{})
+}
+
+// NewVMWithOptions works as NewVM, but executes functions by the engine in opts.
+func NewVMWithOptions(m *wavm.Module, externals map[string]linker.Module,
+	opts Options) (linker.Module, error) {
+	var heights [][]int
+	var err error
+	switch opts.Engine {
+	case EngineTree:
+		err = validator.Validate(*m)
+	case EngineBytecode, EngineRegister, EngineClosure:
+		heights, err = validator.StackHeights(*m)
+	case EngineJIT:
+		if !jitSupported {
+			return nil, fmt.Errorf("engine %s on this platform: %w", opts.Engine, ErrUnimplemented)
+		}
+		heights, err = validator.StackHeights(*m)
+	default:
+		return nil, fmt.Errorf("unknown engine %s: %w", opts.Engine, ErrBadArgs)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("invalid main module: %w", err)
 	}
 
 	vm := &VM{module: m}
 
-	if err := vm.linkImports(externals); err != nil {
-		return nil, fmt.Errorf("link imports: %w", err)
-	}
-	if err := vm.initMemory(); err != nil {
-		return nil, fmt.Errorf("init memory: %w", err)
-	}
-	if err := vm.initGlobals(); err != nil {
-		return nil, fmt.Errorf("init globals: %w", err)
-	}
-	if err := vm.initFuncs(); err != nil {
-		return nil, fmt.Errorf("init funcs: %w", err)
-	}
-	if err := vm.initTable(); err != nil {
-		return nil, fmt.Errorf("init table: %w", err)
-	}
-
-	if err := vm.execStartFunc(); err != nil {
-		return nil, fmt.Errorf("exec start func: %w", err)
+	if err := vm.instantiate(externals, opts.Engine, heights); err != nil {
+		vm.Close()
+		return nil, err
 	}
 
 	return vm, nil
@@ -153,14 +194,18 @@ func Run(m *wavm.Module) error {
 	}
 
 	vm := &VM{module: m}
+	defer vm.Close()
 
+	if err := vm.initGlobals(); err != nil {
+		return fmt.Errorf("init globals: %w", err)
+	}
 	if err := vm.initMemory(); err != nil {
 		return fmt.Errorf("init memory: %w", err)
 	}
-	if err := vm.initGlobals(); err != nil {
-		return fmt.Errorf("init globals: %w", err)
+	if err := vm.initTags(); err != nil {
+		return fmt.Errorf("init tags: %w", err)
 	}
-	if err := vm.initFuncs(); err != nil {
+	if err := vm.initFuncs(EngineTree, nil); err != nil {
 		return fmt.Errorf("init funcs: %w", err)
 	}
 	if err := vm.initTable(); err != nil {
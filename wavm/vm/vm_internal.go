@@ -3,11 +3,48 @@ package vm
 import (
 	"errors"
 	"fmt"
+	"math"
 
 	"github.com/sammyne/mastering-wasm/wavm/linker"
 	"github.com/sammyne/mastering-wasm/wavm/types"
 )
 
+// backtrace walks call frames from the top of the control stack down to the depth-th one, where the
+// executing instruction of each function is the last one run by its top-most frame.
+func (vm *VM) backtrace(depth int) []StackFrame {
+	var out []StackFrame
+
+	var offset uint32
+	activationTop := true
+	for i := vm.ControlStack.Len() - 1; i >= depth-1 && i >= 0; i-- {
+		f := vm.ControlStack.frames[i]
+		if activationTop {
+			offset, activationTop = 0, false
+			if f.PC > 0 && f.bytecode != nil {
+				offset = f.bytecode.code[f.PC-1].offset()
+			} else if f.PC > 0 && f.register != nil {
+				offset = f.register.code[f.PC-1].offset()
+			} else if f.PC > 0 && f.closure != nil {
+				if instr := f.closure.instrs[f.PC-1]; instr != nil {
+					offset = instr.Offset
+				}
+			} else if f.PC > 0 && f.jit != nil {
+				offset = f.jit.bc.code[f.PC-1].offset()
+			} else if f.PC > 0 {
+				offset = f.Expr[f.PC-1].Offset
+			}
+		}
+
+		if f.Opcode == types.OpcodeCall {
+			name := vm.module.Names.Functions[f.FuncIdx]
+			out = append(out, StackFrame{FuncIdx: f.FuncIdx, FuncName: name, Offset: offset})
+			activationTop = true
+		}
+	}
+
+	return out
+}
+
 func (vm *VM) clearBlock(f ControlFrame) error {
 	results, ok := vm.PopUint64s(len(f.BlockType.ResultTypes))
 	if !ok {
@@ -30,6 +67,16 @@ func (vm *VM) clearBlock(f ControlFrame) error {
 	return nil
 }
 
+// describeFunc describes the function running atop the control stack by its name if any.
+func (vm *VM) describeFunc() string {
+	f, _, ok := vm.TopCallFrame()
+	if !ok {
+		return "no func"
+	}
+
+	return vm.module.Names.DescribeFunc(f.FuncIdx)
+}
+
 func (vm *VM) enterBlock(opcode byte, type_ types.FuncType, expr []types.Instruction) {
 	bp := vm.OperandStack.Len() - len(type_.ParamTypes)
 	frame := NewControlFrame(opcode, type_, expr, bp)
@@ -40,6 +87,56 @@ func (vm *VM) enterBlock(opcode byte, type_ types.FuncType, expr []types.Instruc
 	}
 }
 
+// evalConstExpr evaluates constant expressions of initializers and segment offsets on a stack of
+// its own, which is bounded by the length of expr since constant instructions never branch.
+func (vm *VM) evalConstExpr(expr types.Expr) (uint64, error) {
+	stack := make([]uint64, 0, len(expr))
+	for i, v := range expr {
+		switch v.Opcode {
+		case types.OpcodeI32Const:
+			stack = append(stack, uint64(uint32(v.Args.(int32))))
+		case types.OpcodeI64Const:
+			stack = append(stack, uint64(v.Args.(int64)))
+		case types.OpcodeF32Const:
+			stack = append(stack, uint64(math.Float32bits(v.Args.(float32))))
+		case types.OpcodeF64Const:
+			stack = append(stack, math.Float64bits(v.Args.(float64)))
+		case types.OpcodeGlobalGet:
+			idx := v.Args.(uint32)
+			if idx >= uint32(len(vm.globals)) {
+				return 0, fmt.Errorf("global %d: %w", idx, ErrIndexOutOfBound)
+			}
+			stack = append(stack, vm.globals[idx].GetAsUint64())
+		case types.OpcodeRefNull:
+			stack = append(stack, 0)
+		case types.OpcodeRefFunc:
+			stack = append(stack, uint64(v.Args.(uint32))+1)
+		case types.OpcodeGC:
+			ref, err := vm.evalConstGC(v.Args.(types.GCArg), &stack)
+			if err != nil {
+				return 0, fmt.Errorf("%d-th instruction: %w", i, err)
+			}
+			stack = append(stack, ref)
+		case types.OpcodeI32Add, types.OpcodeI32Sub, types.OpcodeI32Mul, types.OpcodeI64Add,
+			types.OpcodeI64Sub, types.OpcodeI64Mul:
+			n := len(stack)
+			if n < 2 {
+				return 0, fmt.Errorf("%d-th instruction: %w", i, ErrOperandPop)
+			}
+			stack = append(stack[:n-2], evalConstBinaryOp(v.Opcode, stack[n-2], stack[n-1]))
+		default:
+			return 0, fmt.Errorf("%d-th instruction(%s) isn't constant: %w", i, v.GetOpname(),
+				ErrBadArgs)
+		}
+	}
+
+	if len(stack) != 1 {
+		return 0, fmt.Errorf("expect 1 value, got %d: %w", len(stack), ErrOperandPop)
+	}
+
+	return stack[0], nil
+}
+
 func (vm *VM) exitBlock() error {
 	frame, ok := vm.ControlStack.Pop()
 	if !ok {
@@ -59,79 +156,178 @@ func (vm *VM) execStartFunc() error {
 	return err
 }
 
-func (vm *VM) initFuncs() error {
+// initFuncs makes functions defined by the module, which are compiled for engine by heights of
+// operand stacks recorded by the validator unless it's EngineTree.
+func (vm *VM) initFuncs(engine Engine, heights [][]int) error {
+	var jits []*jitFunc
 	for i, v := range vm.module.Functions {
 		t := vm.module.Types[v]
 		code := vm.module.Codes[i]
-		vm.funcs = append(vm.funcs, newInternalFunc(t, code, vm))
+		idx := uint32(len(vm.funcs))
+		f := newInternalFunc(idx, t, code, vm)
+
+		var h map[*types.Instruction]int
+		if heights != nil {
+			h = indexHeights(code.Expr, heights[i])
+		}
+
+		var err error
+		switch engine {
+		case EngineBytecode:
+			f.bytecode, err = compileBytecode(vm.module.Types, t, code, h)
+		case EngineRegister:
+			f.register, err = compileRegister(vm.module.Types, t, code, h)
+		case EngineClosure:
+			var bc *bcFunc
+			if bc, err = compileBytecode(vm.module.Types, t, code, h); err == nil {
+				f.closure = compileClosure(bc, h)
+			}
+		case EngineJIT:
+			var bc *bcFunc
+			if bc, err = compileBytecode(vm.module.Types, t, code, h); err == nil {
+				f.jit = compileJIT(bc, h)
+				jits = append(jits, f.jit)
+			}
+		}
+		if err != nil && !errors.Is(err, errNotCompilable) {
+			return fmt.Errorf("compile %s: %w", vm.module.Names.DescribeFunc(idx), err)
+		}
+
+		vm.funcs = append(vm.funcs, f)
+	}
+
+	if err := loadJIT(jits); err != nil {
+		return fmt.Errorf("load machine code: %w", err)
 	}
 
 	return nil
 }
 
-func (vm *VM) initGlobals() error {
-	for i, v := range vm.module.Globals {
-		for j, w := range v.Init {
-			if err := vm.ExecuteInstruction(w); err != nil {
-				return fmt.Errorf("exec %d-th instruction for %d-th global: %w", j, i, err)
-			}
+// evalConstGC evaluates ref.i31 and allocations of structs and arrays, popping their operands off
+// stack.
+func (vm *VM) evalConstGC(arg types.GCArg, stack *[]uint64) (uint64, error) {
+	n := 1
+	if arg.SubOpcode != types.GCRefI31 {
+		if int(arg.TypeIdx) >= len(vm.module.Types) {
+			return 0, fmt.Errorf("type %d: %w", arg.TypeIdx, ErrIndexOutOfBound)
+		}
+		n = getNewOperandsLen(vm.module.Types[arg.TypeIdx], arg)
+	}
 
-			vv, ok := vm.OperandStack.PopUint64()
-			if !ok {
-				return fmt.Errorf("expect global at stack top: %w", ErrOperandPop)
-			}
+	m := len(*stack) - n
+	if m < 0 {
+		return 0, ErrOperandPop
+	}
+	operands := (*stack)[m:]
+	*stack = (*stack)[:m]
 
-			vm.globals = append(vm.globals, NewGlobalVar(v.Type, vv))
+	if arg.SubOpcode == types.GCRefI31 {
+		return newI31(uint32(operands[0])), nil
+	}
+
+	return vm.newObject(arg, operands)
+}
+
+// instantiate links externals and initializes the VM, whose functions run on engine, before
+// calling its start function.
+func (vm *VM) instantiate(externals map[string]linker.Module, engine Engine,
+	heights [][]int) error {
+	if err := vm.linkImports(externals); err != nil {
+		return fmt.Errorf("link imports: %w", err)
+	}
+	if err := vm.initGlobals(); err != nil {
+		return fmt.Errorf("init globals: %w", err)
+	}
+	if err := vm.initMemory(); err != nil {
+		return fmt.Errorf("init memory: %w", err)
+	}
+	if err := vm.initTags(); err != nil {
+		return fmt.Errorf("init tags: %w", err)
+	}
+	if err := vm.initFuncs(engine, heights); err != nil {
+		return fmt.Errorf("init funcs: %w", err)
+	}
+	if err := vm.initTable(); err != nil {
+		return fmt.Errorf("init table: %w", err)
+	}
+
+	if err := vm.execStartFunc(); err != nil {
+		return fmt.Errorf("exec start func: %w", err)
+	}
+
+	return nil
+}
+
+func (vm *VM) initGlobals() error {
+	for i, v := range vm.module.Globals {
+		vv, err := vm.evalConstExpr(v.Init)
+		if err != nil {
+			return fmt.Errorf("eval init for %d-th global: %w", i, err)
 		}
+
+		vm.globals = append(vm.globals, NewGlobalVar(v.Type, vv))
 	}
 
 	return nil
 }
 
 func (vm *VM) initMemory() error {
-	if len(vm.module.Memories) <= 0 {
-		return nil
-	}
+	for i, t := range vm.module.Memories {
+		var mem linker.Memory
+		var err error
+		if t.Shared() {
+			mem, err = NewSharedMemory(t)
+		} else {
+			mem, err = NewMemory(t)
+		}
+		if err != nil {
+			return fmt.Errorf("new memory[%d]: %w", i, err)
+		}
 
-	vm.memory = NewMemory(vm.module.Memories[0])
+		vm.memories = append(vm.memories, mem)
+	}
 
+	vm.data = make([][]byte, len(vm.module.Data))
 	for i, v := range vm.module.Data {
-		for j, vv := range v.Offset {
-			if err := vm.ExecuteInstruction(vv); err != nil {
-				return fmt.Errorf("eval %d-th offset for data[%d]: %w", j, i, err)
-			}
+		if v.Passive {
+			vm.data[i] = v.Init
+			continue
+		}
 
-			offset, ok := vm.PopUint64()
-			if !ok {
-				return fmt.Errorf("pop %d-th offset for data[%d]: %w", j, i, ErrOperandPop)
-			}
+		offset, err := vm.evalConstExpr(v.Offset)
+		if err != nil {
+			return fmt.Errorf("eval offset for data[%d]: %w", i, err)
+		}
 
-			vm.memory.Write(offset, v.Init)
+		if err := vm.memories[v.MemoryIdx].Write(offset, v.Init); err != nil {
+			return fmt.Errorf("write data[%d]: %w", i, err)
 		}
 	}
 
 	return nil
 }
 
+func (vm *VM) initTags() error {
+	for _, v := range vm.module.Tags {
+		vm.tags = append(vm.tags, NewTag(vm.module.Types[v.Type]))
+	}
+
+	return nil
+}
+
 func (vm *VM) initTable() error {
 	if len(vm.module.Tables) > 0 {
 		vm.table = newTable(vm.module.Tables[0])
 	}
 
 	for i, v := range vm.module.Elements {
-		for k, vv := range v.Offset {
-			if err := vm.ExecuteInstruction(vv); err != nil {
-				return fmt.Errorf("eval %d-th offset for %d-th elem: %w", k, i, err)
-			}
-		}
-
-		offset, ok := vm.PopUint32()
-		if !ok {
-			return fmt.Errorf("eval offset for %d-th elem: %w", i, ErrOperandPop)
+		offset, err := vm.evalConstExpr(v.Offset)
+		if err != nil {
+			return fmt.Errorf("eval offset for %d-th elem: %w", i, err)
 		}
 
 		for j, funcIdx := range v.Init {
-			vm.table.SetElem(offset+uint32(j), vm.funcs[funcIdx])
+			vm.table.SetElem(uint32(offset)+uint32(j), vm.funcs[funcIdx])
 		}
 	}
 
@@ -150,9 +346,11 @@ func (vm *VM) linkImport(m linker.Module, i types.Import) error {
 	case linker.Global:
 		vm.globals = append(vm.globals, x)
 	case linker.Memory:
-		vm.memory = x
+		vm.memories = append(vm.memories, x)
 	case linker.Table:
 		vm.table = x
+	case linker.Tag:
+		vm.tags = append(vm.tags, x)
 	default:
 		return fmt.Errorf("unknown member type: %T", x)
 	}
@@ -182,6 +380,28 @@ func (vm *VM) loop() error {
 			return errors.New("miss control frame")
 		}
 
+		if f.bytecode != nil {
+			if err := vm.runBytecode(depth); err != nil {
+				return err
+			}
+			continue
+		} else if f.register != nil {
+			if err := vm.runRegister(depth); err != nil {
+				return err
+			}
+			continue
+		} else if f.closure != nil {
+			if err := vm.runClosure(depth); err != nil {
+				return err
+			}
+			continue
+		} else if f.jit != nil {
+			if err := vm.runJIT(depth); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if f.PC == len(f.Expr) {
 			if err := vm.exitBlock(); err != nil {
 				return fmt.Errorf("exit block: %w", err)
@@ -191,14 +411,37 @@ func (vm *VM) loop() error {
 
 		instruction := f.Expr[f.PC]
 		f.PC++
+		if err := vm.hook(instruction); err != nil {
+			return vm.trap(depth, err)
+		}
 		if err := vm.ExecuteInstruction(instruction); err != nil {
-			return fmt.Errorf("exec instruction of PC(%d): %w", f.PC-1, err)
+			var exn *linker.Exception
+			if !errors.As(err, &exn) {
+				err = fmt.Errorf("exec instruction of PC(%d) at 0x%x in %s: %w", f.PC-1,
+					instruction.Offset, vm.describeFunc(), err)
+				return vm.trap(depth, err)
+			}
+			if err := vm.catchException(exn, depth); err != nil {
+				return err
+			}
+		} else if vm.tracer != nil {
+			vm.tracer.after()
 		}
 	}
 
 	return nil
 }
 
+// popAddress pops an address sized according to the index type of mem.
+func (vm *VM) popAddress(mem linker.Memory) (uint64, bool) {
+	if mem.Type().Is64() {
+		return vm.PopUint64()
+	}
+
+	v, ok := vm.PopUint32()
+	return uint64(v), ok
+}
+
 func (vm *VM) popArgs(t types.FuncType) ([]types.WasmVal, error) {
 	out := make([]types.WasmVal, len(t.ParamTypes))
 	for i := len(t.ParamTypes) - 1; i >= 0; i-- {
@@ -316,6 +559,16 @@ func (vm *VM) popTowUint64() (uint64, uint64, error) {
 	return v1, v2, nil
 }
 
+// pushAddress pushes an address sized according to the index type of mem, which also maps
+// math.MaxUint64 to -1 for 32-bit memories.
+func (vm *VM) pushAddress(mem linker.Memory, v uint64) {
+	if mem.Type().Is64() {
+		vm.PushUint64(v)
+	} else {
+		vm.PushUint32(uint32(v))
+	}
+}
+
 func (vm *VM) pushArgs(paramTypes []types.ValueType, args []types.WasmVal) error {
 	if len(paramTypes) != len(args) {
 		return fmt.Errorf("len(paramTypes)=%d != len(args)=%d", len(paramTypes), len(args))
@@ -358,3 +611,31 @@ func (vm *VM) resetBlock(f *ControlFrame) error {
 
 	return nil
 }
+
+// trap wraps err as a *Trap carrying frames of the loop at depth, after those of traps from nested
+// loops behind external functions, then unwinds these frames as the loop exits.
+func (vm *VM) trap(depth int, err error) *Trap {
+	frames := vm.backtrace(depth)
+
+	var inner *Trap
+	if errors.As(err, &inner) {
+		frames = append(append(append([]StackFrame(nil), inner.Frames...), StackFrame{External: true}),
+			frames...)
+	}
+
+	if depth > 0 && vm.ControlStack.Len() >= depth {
+		var bottom ControlFrame
+		for vm.ControlStack.Len() >= depth {
+			bottom, _ = vm.ControlStack.Pop()
+		}
+		if n := vm.OperandStack.Len() - bottom.BP; n > 0 {
+			vm.PopUint64s(n)
+		}
+
+		if caller, _, ok := vm.TopCallFrame(); ok {
+			vm.local0Idx = uint32(caller.BP)
+		}
+	}
+
+	return &Trap{Frames: frames, Err: err}
+}
@@ -0,0 +1,77 @@
+package vm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sammyne/mastering-wasm/wavm"
+	"github.com/sammyne/mastering-wasm/wavm/internal/wasmtest"
+	"github.com/sammyne/mastering-wasm/wavm/types"
+	"github.com/sammyne/mastering-wasm/wavm/validator"
+	"github.com/sammyne/mastering-wasm/wavm/vm"
+)
+
+func TestExtendedConst(t *testing.T) {
+	funcs := []testFunc{
+		{"get", nil, []byte{i32, i64}, nil, []byte{0x23, 1, 0x23, 2}},
+		{"load", []byte{i32}, []byte{i32}, nil, []byte{0x20, 0, 0x28, 2, 0}},
+	}
+	m := testModule{funcs: funcs, sections: map[byte][]byte{
+		5: wasmtest.Vec([]byte{0x00, 1}),
+		6: wasmtest.Vec(
+			[]byte{i32, 0, 0x41, 10, 0x0B},
+			// 10*3-1
+			[]byte{i32, 0, 0x23, 0, 0x41, 3, 0x6C, 0x41, 1, 0x6B, 0x0B},
+			// 2*3+(-7)
+			[]byte{i64, 0, 0x42, 2, 0x42, 3, 0x7E, 0x42, 0x79, 0x7C, 0x0B},
+		),
+		// "wasm" goes to global 1 plus 4
+		11: wasmtest.Vec([]byte{0x00, 0x23, 1, 0x41, 4, 0x6A, 0x0B, 4, 'w', 'a', 's', 'm'}),
+	}}
+
+	module, err := wavm.NewDecoder(m.encode()).DecodeModule()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	module.DisabledFeatures = wavm.FeatureExtendedConst
+	if err := validator.Validate(*module); !errors.Is(err, wavm.ErrFeatureDisabled) {
+		t.Fatalf("validate without extended constants: expect %v, got %v", wavm.ErrFeatureDisabled,
+			err)
+	}
+
+	// global 1 can't get global 0 once it's mutable
+	mutable := testModule{funcs: funcs, sections: map[byte][]byte{
+		5: m.sections[5],
+		6: wasmtest.Vec(
+			[]byte{i32, 1, 0x41, 10, 0x0B},
+			[]byte{i32, 0, 0x23, 0, 0x41, 3, 0x6C, 0x41, 1, 0x6B, 0x0B},
+			[]byte{i64, 0, 0x42, 2, 0x0B},
+		),
+	}}
+	if module, err = wavm.NewDecoder(mutable.encode()).DecodeModule(); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if err := validator.Validate(*module); err == nil {
+		t.Fatal("expect constant expressions getting mutable globals to be invalid")
+	}
+
+	for _, engine := range append([]vm.Engine{vm.EngineTree}, testEngines...) {
+		instance := newTestVM(t, m, engine)
+
+		got, err := instance.InvokeFunc("get")
+		if err != nil {
+			t.Fatalf("%s: get: %v", engine, err)
+		}
+		if expect := []types.WasmVal{int32(29), int64(-1)}; !testEqual(expect, got) {
+			t.Fatalf("%s: expect globals %v, got %v", engine, expect, got)
+		}
+
+		got, err = instance.InvokeFunc("load", int32(33))
+		if err != nil {
+			t.Fatalf("%s: load: %v", engine, err)
+		}
+		if got[0] != int32(0x6D736177) {
+			t.Fatalf("%s: expect \"wasm\" at 33, got %#x", engine, got[0])
+		}
+	}
+}